@@ -0,0 +1,185 @@
+package stow
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrNotCompressedCodec indicates a Rebalance of a store whose Codec isn't a CompressedCodec.
+var ErrNotCompressedCodec = errors.New("store codec is not a CompressedCodec")
+
+// rebalanceBatchSize is the number of objects Rebalance rewrites per transaction.
+const rebalanceBatchSize = 1000
+
+// AdaptivePolicy configures WithAdaptiveCompression.
+type AdaptivePolicy struct {
+	// HotReads is the number of reads between two rebalances which make an object hot.
+	// 1 if zero.
+	HotReads int
+
+	// Cold is the compression of cold objects, GzipCompression if zero. Hot objects
+	// are stored uncompressed.
+	Cold Compression
+}
+
+// WithAdaptiveCompression counts the reads of each object, so that Rebalance can keep the
+// objects which are read often (hot) uncompressed for speed, and compress the others (cold)
+// to save space. The store's Codec must be a CompressedCodec, whose per-value flag lets hot
+// and cold objects share the bucket, its Compression is used for new objects until the next
+// Rebalance. Read counts are kept in memory only, so reads stay read-only transactions.
+func WithAdaptiveCompression(policy AdaptivePolicy) Option {
+	return func(s *Store) {
+		if policy.HotReads <= 0 {
+			policy.HotReads = 1
+		}
+		if policy.Cold == NoCompression {
+			policy.Cold = GzipCompression
+		}
+		s.opts.adaptive = &policy
+	}
+}
+
+// accessCounts counts the reads of each key since the last Rebalance.
+type accessCounts struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func (a *accessCounts) record(key []byte) {
+	a.mu.Lock()
+	if a.counts == nil {
+		a.counts = make(map[string]int)
+	}
+	a.counts[string(key)]++
+	a.mu.Unlock()
+}
+
+// reset returns the counts and starts counting again from zero.
+func (a *accessCounts) reset() map[string]int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	counts := a.counts
+	a.counts = nil
+	return counts
+}
+
+// Rebalance rewrites the objects which became hot since the last Rebalance uncompressed,
+// and those which became cold compressed, as configured with WithAdaptiveCompression, and
+// starts counting reads again. Values are recompressed without being decoded, in batches
+// each written in its own transaction. It returns how many objects were made hot (promoted)
+// and how many were made cold (demoted).
+func (s *Store) Rebalance() (promoted, demoted int, err error) {
+	policy := s.opts.adaptive
+	if policy == nil {
+		return 0, 0, nil
+	}
+	if _, ok := s.codec.(CompressedCodec); !ok {
+		return 0, 0, ErrNotCompressedCodec
+	}
+	counts := s.access.reset()
+
+	var after []byte
+	for {
+		type change struct{ key, old, data []byte }
+		var changes []change
+		var last []byte
+		err := s.db.View(func(tx BackendTx) error {
+			objects := s.bucket.get(tx)
+			if objects == nil {
+				return nil
+			}
+			c := objects.Cursor()
+			k, v := c.First()
+			if after != nil {
+				if k, v = c.Seek(after); k != nil && bytes.Equal(k, after) {
+					k, v = c.Next()
+				}
+			}
+			for ; k != nil && len(changes) < rebalanceBatchSize; k, v = c.Next() {
+				last = append(last[:0], k...)
+				if len(v) == 0 {
+					continue
+				}
+				want := policy.Cold
+				if counts[string(k)] >= policy.HotReads {
+					want = NoCompression
+				}
+				if Compression(v[0]) == want {
+					continue
+				}
+				value, err := s.verifyChecksum(k, v)
+				var data []byte
+				if err == nil {
+					data, err = recompress(value, want)
+				}
+				if err != nil {
+					return s.decodeError(k, v, err)
+				}
+				data = s.addChecksum(data)
+				changes = append(changes, change{append([]byte(nil), k...), append([]byte(nil), v...), data})
+			}
+			return nil
+		})
+		if err != nil || last == nil {
+			return promoted, demoted, err
+		}
+		after = last
+
+		err = s.update(func(tx BackendTx) error {
+			objects := s.bucket.get(tx)
+			if objects == nil {
+				return nil
+			}
+			for _, c := range changes {
+				// Leave objects which were rewritten meanwhile.
+				if !bytes.Equal(objects.Get(c.key), c.old) {
+					continue
+				}
+				s.forget(c.key)
+				if err := objects.Put(c.key, c.data); err != nil {
+					return err
+				}
+				if Compression(c.data[0]) == NoCompression {
+					promoted++
+				} else {
+					demoted++
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return promoted, demoted, err
+		}
+	}
+}
+
+// StartRebalancer runs Rebalance every interval in a new goroutine, until the returned
+// stop func is called.
+func (s *Store) StartRebalancer(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.Rebalance()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			wg.Wait()
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package stow
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRebalance(t *testing.T) {
+	codec := CompressedCodec{Codec: JSONCodec{}, Compression: GzipCompression}
+	s := NewCustomStore(db, []byte("adaptive"), codec, WithAdaptiveCompression(AdaptivePolicy{HotReads: 2}))
+	defer s.DeleteAll()
+
+	value := strings.Repeat("value ", 50)
+	s.Put("hot", value)
+	s.Put("cold", value)
+
+	var v string
+	s.Get("hot", &v)
+	s.Get("hot", &v)
+	s.Get("cold", &v)
+
+	if promoted, demoted, err := s.Rebalance(); err != nil || promoted != 1 || demoted != 0 {
+		t.Errorf("expected 1 promoted object: %d %d %v", promoted, demoted, err)
+	}
+	if flag := compressionOf(s, "hot"); flag != NoCompression {
+		t.Errorf("hot object should be uncompressed, got %d", flag)
+	}
+	if err := s.Get("hot", &v); err != nil || v != value {
+		t.Errorf("unexpected hot value %v", err)
+	}
+
+	if promoted, demoted, err := s.Rebalance(); err != nil || promoted != 0 || demoted != 1 {
+		t.Errorf("expected 1 demoted object: %d %d %v", promoted, demoted, err)
+	}
+	if flag := compressionOf(s, "hot"); flag != GzipCompression {
+		t.Errorf("cold object should be compressed, got %d", flag)
+	}
+
+	plain := NewJSONStore(db, []byte("adaptive_plain"), WithAdaptiveCompression(AdaptivePolicy{}))
+	if _, _, err := plain.Rebalance(); err != ErrNotCompressedCodec {
+		t.Errorf("expected ErrNotCompressedCodec, got %v", err)
+	}
+}
+
+func compressionOf(s *Store, key string) (c Compression) {
+	s.db.View(func(tx BackendTx) error {
+		c = Compression(s.bucket.get(tx).Get([]byte(key))[0])
+		return nil
+	})
+	return c
+}
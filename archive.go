@@ -0,0 +1,275 @@
+package stow
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"time"
+)
+
+// writtenBucket is the meta bucket which maps keys to the time they were last written,
+// for stores using WithWriteTimes.
+var writtenBucket = []byte("\x00written")
+
+// archiveBatchSize is the number of objects ArchiveOlderThan moves per transaction.
+const archiveBatchSize = 1000
+
+// WithWriteTimes records the time each object was last written, which ArchiveOlderThan uses
+// to find old objects.
+func WithWriteTimes() Option {
+	return func(s *Store) {
+		s.opts.writeTimes = true
+	}
+}
+
+// ArchiveOlderThan moves the objects which were last written more than d ago into the archive
+// store dst, and returns how many were moved. Each object is sealed with the bundle format
+// using opts (see NewBundleWriter), so the archive can be compressed and/or encrypted, and
+// nothing is left behind in the store. Objects are moved in batches, the archive is written
+// before objects are removed from the store so a failure never loses any.
+//
+// Write times are only recorded for stores using WithWriteTimes, objects without a recorded
+// write time are considered old. Expired objects are left for Sweep. dst should only be used
+// as an archive, its values can only be read back by Unarchive.
+func (s *Store) ArchiveOlderThan(d time.Duration, dst *Store, opts BundleOptions) (n int, err error) {
+	cutoff := time.Now().Add(-d)
+
+	var after []byte
+	for {
+		batch, last, err := s.archiveCandidates(cutoff, after)
+		if err != nil || len(batch) == 0 {
+			return n, err
+		}
+		after = last
+
+		sealed := make([][]byte, len(batch))
+		for i, obj := range batch {
+			if sealed[i], err = sealArchived(obj, opts); err != nil {
+				return n, err
+			}
+		}
+
+		err = dst.update(func(tx BackendTx) error {
+			archive, err := dst.bucket.createOrGet(tx)
+			if err != nil {
+				return err
+			}
+			for i, obj := range batch {
+				if err := archive.Put(obj.key, sealed[i]); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return n, err
+		}
+
+		err = s.update(func(tx BackendTx) error {
+			objects := s.bucket.get(tx)
+			if objects == nil {
+				return nil
+			}
+			for _, obj := range batch {
+				// Leave objects which were rewritten since they were archived.
+				if !bytes.Equal(objects.Get(obj.key), obj.data) {
+					continue
+				}
+				if err := s.deleteKey(tx, objects, obj.key); err != nil {
+					return err
+				}
+				n++
+			}
+			return nil
+		})
+		if err != nil {
+			return n, err
+		}
+	}
+}
+
+// Unarchive moves the object at key "key" back from the archive store src, where it was put
+// by ArchiveOlderThan, into the store. bundleKey is the Key of the BundleOptions it was archived
+// with. It returns ErrNotFound if the key isn't in the archive, and ErrConflict if the store
+// already has an object at key. The object's index entries are restored as they were when it
+// was archived, unique indexes aren't checked again since the archive doesn't know the type.
+func (s *Store) Unarchive(key interface{}, src *Store, bundleKey []byte) error {
+	keyBytes, err := s.toBytes(key)
+	if err != nil {
+		return err
+	}
+
+	var sealed []byte
+	err = src.db.View(func(tx BackendTx) error {
+		archive := src.bucket.get(tx)
+		if archive == nil {
+			return ErrNotFound
+		}
+		data := archive.Get(keyBytes)
+		if data == nil {
+			return ErrNotFound
+		}
+		sealed = append(sealed, data...)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	obj, err := openArchived(keyBytes, sealed, bundleKey)
+	if err != nil {
+		return err
+	}
+
+	defer s.forget(keyBytes)
+	err = s.update(func(tx BackendTx) error {
+		objects, err := s.bucket.createOrGet(tx)
+		if err != nil {
+			return err
+		}
+		if objects.Get(keyBytes) != nil && !s.expiryCheck(tx)(keyBytes) {
+			return ErrConflict
+		}
+		if err := objects.Put(keyBytes, obj.data); err != nil {
+			return err
+		}
+		entries, err := decodeIndexEntries(obj.entries)
+		if err != nil {
+			return err
+		}
+		if err := s.setIndexes(tx, keyBytes, entries); err != nil {
+			return err
+		}
+		if err := s.setWritten(tx, keyBytes); err != nil {
+			return err
+		}
+		return s.clearExpiry(tx, keyBytes)
+	})
+	if err != nil {
+		return err
+	}
+
+	return src.update(func(tx BackendTx) error {
+		if archive := src.bucket.get(tx); archive != nil {
+			return archive.Delete(keyBytes)
+		}
+		return nil
+	})
+}
+
+type archivedObject struct {
+	key     []byte
+	data    []byte
+	entries []byte // as kept in the reverse index
+}
+
+// archiveCandidates returns up to archiveBatchSize objects after key "after" which were last
+// written before cutoff, and the last key it looked at.
+func (s *Store) archiveCandidates(cutoff time.Time, after []byte) (batch []archivedObject, last []byte, err error) {
+	err = s.db.View(func(tx BackendTx) error {
+		objects := s.bucket.get(tx)
+		if objects == nil {
+			return nil
+		}
+		meta := s.bucket.meta()
+		written := meta.child(writtenBucket).get(tx)
+		indexKeys := meta.child(indexKeysBucket).get(tx)
+		isExpired := s.expiryCheck(tx)
+
+		c := objects.Cursor()
+		k, v := c.First()
+		if after != nil {
+			if k, v = c.Seek(after); k != nil && bytes.Equal(k, after) {
+				k, v = c.Next()
+			}
+		}
+		for ; k != nil && len(batch) < archiveBatchSize; k, v = c.Next() {
+			last = append(last[:0], k...)
+			if v == nil || isExpired(k) {
+				continue
+			}
+			if written != nil {
+				if t := written.Get(k); t != nil && decodeTime(t).After(cutoff) {
+					continue
+				}
+			}
+
+			obj := archivedObject{
+				key:  append([]byte(nil), k...),
+				data: append([]byte(nil), v...),
+			}
+			if indexKeys != nil {
+				if data := indexKeys.Get(k); data != nil {
+					obj.entries = append([]byte(nil), data...)
+				}
+			}
+			batch = append(batch, obj)
+		}
+		return nil
+	})
+	return batch, last, err
+}
+
+// sealArchived encodes obj's index entries and data, and seals them into a bundle.
+func sealArchived(obj archivedObject, opts BundleOptions) ([]byte, error) {
+	var record bytes.Buffer
+	var n [binary.MaxVarintLen64]byte
+	record.Write(n[:binary.PutUvarint(n[:], uint64(len(obj.entries)))])
+	record.Write(obj.entries)
+	record.Write(obj.data)
+
+	var sealed bytes.Buffer
+	w, err := NewBundleWriter(&sealed, opts)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(record.Bytes()); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return sealed.Bytes(), nil
+}
+
+// openArchived reverses sealArchived.
+func openArchived(key, sealed, bundleKey []byte) (obj archivedObject, err error) {
+	r, err := NewBundleReader(bytes.NewReader(sealed), bundleKey)
+	if err != nil {
+		return obj, err
+	}
+	defer r.Close()
+	record, err := ioutil.ReadAll(r)
+	if err != nil {
+		return obj, err
+	}
+
+	n, size := binary.Uvarint(record)
+	if size <= 0 || uint64(len(record)-size) < n {
+		return obj, ErrBadBundle
+	}
+	record = record[size:]
+	obj.entries, record = record[:n], record[n:]
+	obj.key, obj.data = key, record
+	return obj, nil
+}
+
+// setWritten records the current time as the last write of key, for stores using WithWriteTimes.
+func (s *Store) setWritten(tx BackendTx, key []byte) error {
+	if !s.opts.writeTimes {
+		return nil
+	}
+	written, err := s.bucket.meta().child(writtenBucket).createOrGetUntracked(tx)
+	if err != nil {
+		return err
+	}
+	return written.Put(key, encodeTime(time.Now()))
+}
+
+// clearWritten removes the write time recorded for key.
+func (s *Store) clearWritten(tx BackendTx, key []byte) error {
+	if written := s.bucket.meta().child(writtenBucket).get(tx); written != nil {
+		return written.Delete(key)
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package stow
+
+import (
+	"testing"
+	"time"
+)
+
+func TestArchiveOlderThan(t *testing.T) {
+	s := NewJSONStore(db, []byte("archive_hot"), WithWriteTimes())
+	defer s.DeleteAll()
+	archive := NewJSONStore(db, []byte("archive_cold"))
+	defer archive.DeleteAll()
+	opts := BundleOptions{Compress: true, Key: []byte("0123456789abcdef")}
+
+	s.Put("old", indexedUser{Name: "old", Email: "old@example.com", Age: 90})
+	time.Sleep(20 * time.Millisecond)
+	s.Put("new", indexedUser{Name: "new", Email: "new@example.com", Age: 1})
+
+	if n, err := s.ArchiveOlderThan(10*time.Millisecond, archive, opts); err != nil || n != 1 {
+		t.Fatalf("expected 1 archived object: %d %v", n, err)
+	}
+	if has, _ := s.Has("old"); has {
+		t.Errorf("archived object should be removed from the store")
+	}
+	if has, _ := s.Has("new"); !has {
+		t.Errorf("recent object should stay in the store")
+	}
+	var users []indexedUser
+	if err := s.Find("Email", "old@example.com", &users); err != nil || len(users) != 0 {
+		t.Errorf("archived object should be removed from indexes: %v %v", users, err)
+	}
+
+	if err := s.Unarchive("old", archive, []byte("wrong key 123456")); err == nil {
+		t.Errorf("expected Unarchive with the wrong key to fail")
+	}
+	if err := s.Unarchive("missing", archive, opts.Key); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	if err := s.Unarchive("old", archive, opts.Key); err != nil {
+		t.Fatal(err)
+	}
+
+	var u indexedUser
+	if err := s.Get("old", &u); err != nil || u.Name != "old" {
+		t.Errorf("unexpected unarchived object %v %v", u, err)
+	}
+	if err := s.Find("Email", "old@example.com", &users); err != nil || len(users) != 1 {
+		t.Errorf("unarchived object should be indexed again: %v %v", users, err)
+	}
+	if has, _ := archive.Has("old"); has {
+		t.Errorf("unarchived object should be removed from the archive")
+	}
+	if n, err := s.ArchiveOlderThan(time.Hour, archive, opts); err != nil || n != 0 {
+		t.Errorf("unarchived object should count as recently written: %d %v", n, err)
+	}
+
+	if n, err := s.ArchiveOlderThan(0, archive, opts); err != nil || n != 2 {
+		t.Errorf("expected 2 archived objects: %d %v", n, err)
+	}
+	s.Put("new", indexedUser{Name: "rewritten"})
+	if err := s.Unarchive("new", archive, opts.Key); err != ErrConflict {
+		t.Errorf("expected ErrConflict, got %v", err)
+	}
+}
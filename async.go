@@ -0,0 +1,262 @@
+package stow
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrWriterClosed is returned by an AsyncWriter which was closed.
+var ErrWriterClosed = errors.New("async writer is closed")
+
+// AsyncPolicy configures an AsyncWriter.
+type AsyncPolicy struct {
+	// Interval is how long a write waits to be committed at most, 1 second if zero. It bounds
+	// the writes lost if the process dies.
+	Interval time.Duration
+
+	// MaxPending is the number of waiting writes which are committed without waiting for
+	// Interval, 1000 if zero. Put and Delete block while MaxPending writes wait on top of
+	// those being committed.
+	MaxPending int
+
+	// OnError, if set, is called by the writer's goroutine with the error of each write
+	// which failed to commit.
+	OnError func(key []byte, err error)
+}
+
+// AsyncWriter writes to a Store from a goroutine of its own, which groups the writes it's
+// given into one transaction every Interval, trading a bounded window of writes which can
+// be lost for much higher throughput than a transaction per write. Writes are encoded
+// right away, so encoding errors are still returned by Put, but the other errors of a
+// write are only reported once it commits: to OnError, and by the next Flush or Close.
+//
+// Reads of the Store see writes once they're committed. Writes run the Store's Hooks,
+// the After hooks once they're committed.
+type AsyncWriter struct {
+	s      *Store
+	policy AsyncPolicy
+	writes chan asyncWrite
+	done   chan struct{}
+
+	mu     sync.RWMutex
+	closed bool
+
+	// err is the first error since the last Flush, it's only used by the writer's goroutine
+	// and by Close once that's done.
+	err error
+}
+
+type asyncWrite struct {
+	key, data []byte
+	val       interface{}
+	entries   []indexEntry
+	delete    bool
+	start     time.Time
+
+	// flushed is set for the requests of Flush, it's sent the result of the writes made before.
+	flushed chan error
+}
+
+// NewAsyncWriter starts an AsyncWriter writing to s. It must be closed to commit the last
+// writes and stop its goroutine.
+func (s *Store) NewAsyncWriter(policy AsyncPolicy) *AsyncWriter {
+	if policy.Interval <= 0 {
+		policy.Interval = time.Second
+	}
+	if policy.MaxPending <= 0 {
+		policy.MaxPending = 1000
+	}
+	w := &AsyncWriter{
+		s:      s,
+		policy: policy,
+		writes: make(chan asyncWrite, policy.MaxPending),
+		done:   make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// Put queues b to be stored with key "key", see Store.Put.
+func (w *AsyncWriter) Put(key interface{}, b interface{}) error {
+	keyBytes, err := w.s.toBytes(key)
+	if err != nil {
+		return err
+	}
+	if err := w.s.beforePut(keyBytes, b); err != nil {
+		return err
+	}
+	write := asyncWrite{key: keyBytes, val: b, start: time.Now()}
+	write.data, err = w.s.marshalValue(b)
+	if err == nil {
+		write.entries, err = w.s.indexEntries(b)
+	}
+	if err == nil {
+		err = w.send(write)
+	}
+	if err != nil {
+		w.s.afterPut(keyBytes, b, err)
+		w.s.observe(OpPut, write.start, len(write.data), err)
+	}
+	return err
+}
+
+// Delete queues the removal of the object with key "key", see Store.Delete.
+func (w *AsyncWriter) Delete(key interface{}) error {
+	keyBytes, err := w.s.toBytes(key)
+	if err != nil {
+		return err
+	}
+	if err := w.s.beforeDelete(keyBytes); err != nil {
+		return err
+	}
+	write := asyncWrite{key: keyBytes, delete: true, start: time.Now()}
+	if err := w.send(write); err != nil {
+		w.s.afterDelete(keyBytes, err)
+		w.s.observe(OpDelete, write.start, 0, err)
+		return err
+	}
+	return nil
+}
+
+// Flush commits the writes queued so far, and returns the first error of the writes which
+// failed since the last Flush.
+func (w *AsyncWriter) Flush() error {
+	flushed := make(chan error, 1)
+	if err := w.send(asyncWrite{flushed: flushed}); err != nil {
+		return err
+	}
+	return <-flushed
+}
+
+// Close commits the writes queued so far and stops the writer, it returns the first error
+// of the writes which failed since the last Flush. Later writes fail with ErrWriterClosed.
+func (w *AsyncWriter) Close() error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		<-w.done
+		return nil
+	}
+	w.closed = true
+	close(w.writes)
+	w.mu.Unlock()
+
+	<-w.done
+	return w.takeErr()
+}
+
+// send queues write, unless w is closed.
+func (w *AsyncWriter) send(write asyncWrite) error {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		return ErrWriterClosed
+	}
+	w.writes <- write
+	return nil
+}
+
+func (w *AsyncWriter) run() {
+	defer close(w.done)
+	ticker := time.NewTicker(w.policy.Interval)
+	defer ticker.Stop()
+
+	var pending []asyncWrite
+	for {
+		select {
+		case write, ok := <-w.writes:
+			if !ok {
+				w.commit(pending)
+				return
+			}
+			if write.flushed != nil {
+				w.commit(pending)
+				pending = pending[:0]
+				write.flushed <- w.takeErr()
+				continue
+			}
+			if pending = append(pending, write); len(pending) >= w.policy.MaxPending {
+				w.commit(pending)
+				pending = pending[:0]
+			}
+		case <-ticker.C:
+			w.commit(pending)
+			pending = pending[:0]
+		}
+	}
+}
+
+func (w *AsyncWriter) commit(writes []asyncWrite) {
+	w.s.commitWrites(writes, w.fail)
+}
+
+// commitWrites writes writes in one transaction. If that fails, each is retried in a
+// transaction of its own so only the failing writes are lost, and passed to fail.
+func (s *Store) commitWrites(writes []asyncWrite, fail func(key []byte, err error)) {
+	if len(writes) == 0 {
+		return
+	}
+	errs := make([]error, len(writes))
+	err := s.update(func(tx BackendTx) error {
+		for _, write := range writes {
+			if err := s.applyWrite(tx, write); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		for i, write := range writes {
+			errs[i] = s.update(func(tx BackendTx) error {
+				return s.applyWrite(tx, write)
+			})
+		}
+	}
+
+	for i, write := range writes {
+		s.forget(write.key)
+		if write.delete {
+			s.afterDelete(write.key, errs[i])
+			s.observe(OpDelete, write.start, 0, errs[i])
+		} else {
+			s.afterPut(write.key, write.val, errs[i])
+			s.observe(OpPut, write.start, len(write.data), errs[i])
+		}
+		if errs[i] != nil {
+			fail(write.key, errs[i])
+		}
+	}
+}
+
+func (s *Store) applyWrite(tx BackendTx, write asyncWrite) error {
+	if write.delete {
+		objects := s.bucket.get(tx)
+		if objects == nil {
+			return nil
+		}
+		return s.deleteKey(tx, objects, write.key)
+	}
+	objects, err := s.bucket.createOrGet(tx)
+	if err != nil {
+		return err
+	}
+	return s.writeEntries(tx, objects, write.key, write.data, write.entries, s.opts.ttl)
+}
+
+// fail records err, the error of the write of key.
+func (w *AsyncWriter) fail(key []byte, err error) {
+	if w.err == nil {
+		w.err = err
+	}
+	if w.policy.OnError != nil {
+		w.policy.OnError(key, err)
+	}
+}
+
+// takeErr returns the error recorded since it was last called.
+func (w *AsyncWriter) takeErr() error {
+	err := w.err
+	w.err = nil
+	return err
+}
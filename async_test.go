@@ -0,0 +1,77 @@
+package stow
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestAsyncWriter(t *testing.T) {
+	s := NewJSONStore(db, []byte("async_writer"))
+	defer s.DeleteAll()
+
+	w := s.NewAsyncWriter(AsyncPolicy{Interval: time.Hour})
+	for i := 0; i < 100; i++ {
+		if err := w.Put(fmt.Sprint(i), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if has, _ := s.Has("0"); has {
+		t.Errorf("expected writes to wait for Flush")
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if keys, _ := s.Keys(); len(keys) != 100 {
+		t.Errorf("expected 100 objects after Flush, got %d", len(keys))
+	}
+
+	w.Delete("0")
+	w.Put("1", -1)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var v int
+	if err := s.Get("0", &v); err != ErrNotFound {
+		t.Errorf("expected 0 to be deleted, got %v", err)
+	}
+	if err := s.Get("1", &v); err != nil || v != -1 {
+		t.Errorf("expected 1 to be replaced, got %d %v", v, err)
+	}
+
+	if err := w.Put("2", 2); err != ErrWriterClosed {
+		t.Errorf("expected ErrWriterClosed, got %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Errorf("expected a second Close to succeed, got %v", err)
+	}
+}
+
+func TestAsyncWriterErrors(t *testing.T) {
+	s := NewJSONStore(db, []byte("async_writer_errors"))
+	defer s.DeleteAll()
+
+	var failed []string
+	w := s.NewAsyncWriter(AsyncPolicy{
+		MaxPending: 3,
+		OnError:    func(key []byte, err error) { failed = append(failed, string(key)) },
+	})
+	w.Put("a", uniqueAccount{Login: "ann", Email: "a@example.com"})
+	w.Put("b", uniqueAccount{Login: "ann", Email: "b@example.com"})
+	w.Put("c", uniqueAccount{Login: "cat", Email: "c@example.com"})
+	if err := w.Flush(); err != ErrConflict {
+		t.Errorf("expected ErrConflict, got %v", err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Errorf("expected the error to be reported once, got %v", err)
+	}
+	if len(failed) != 1 || failed[0] != "b" {
+		t.Errorf("expected b to fail, got %v", failed)
+	}
+	if keys, _ := s.Keys(); len(keys) != 2 {
+		t.Errorf("expected the other writes to commit, got %q", keys)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
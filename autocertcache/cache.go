@@ -0,0 +1,55 @@
+// Package autocertcache provides an autocert.Cache backed by a stow.Store, so that ACME
+// certificates obtained by golang.org/x/crypto/acme/autocert are persisted in bolt.
+package autocertcache
+
+import (
+	"context"
+
+	"github.com/djherbis/stow/v4"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// Cache implements autocert.Cache using a stow.Store.
+type Cache struct {
+	store *stow.Store
+}
+
+var _ autocert.Cache = (*Cache)(nil)
+
+// New returns a Cache which keeps certificate data in store.
+func New(store *stow.Store) *Cache {
+	return &Cache{store: store}
+}
+
+// Get returns the certificate data stored at name, or autocert.ErrCacheMiss.
+func (c *Cache) Get(ctx context.Context, name string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var data []byte
+	switch err := c.store.Get(name, &data); err {
+	case nil:
+		return data, nil
+	case stow.ErrNotFound:
+		return nil, autocert.ErrCacheMiss
+	default:
+		return nil, err
+	}
+}
+
+// Put stores data at name.
+func (c *Cache) Put(ctx context.Context, name string, data []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.store.Put(name, data)
+}
+
+// Delete removes the data stored at name.
+func (c *Cache) Delete(ctx context.Context, name string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.store.Delete(name)
+}
@@ -0,0 +1,47 @@
+package autocertcache
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/djherbis/stow/v4"
+	bolt "go.etcd.io/bbolt"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+func TestCache(t *testing.T) {
+	db, err := bolt.Open(filepath.Join(t.TempDir(), "certs.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	cache := New(stow.NewStore(db, []byte("certs")))
+
+	if _, err := cache.Get(ctx, "example.com"); err != autocert.ErrCacheMiss {
+		t.Errorf("expected ErrCacheMiss got %v", err)
+	}
+
+	if err := cache.Put(ctx, "example.com", []byte("cert")); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := cache.Get(ctx, "example.com"); err != nil || !bytes.Equal(data, []byte("cert")) {
+		t.Errorf("unexpected cert data %q %v", data, err)
+	}
+
+	if err := cache.Delete(ctx, "example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.Get(ctx, "example.com"); err != autocert.ErrCacheMiss {
+		t.Errorf("expected ErrCacheMiss got %v", err)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := cache.Put(canceled, "example.com", nil); err != context.Canceled {
+		t.Errorf("expected context.Canceled got %v", err)
+	}
+}
@@ -0,0 +1,17 @@
+module github.com/djherbis/stow/v4/autocertcache
+
+go 1.26.0
+
+require (
+	github.com/djherbis/stow/v4 v4.0.0
+	go.etcd.io/bbolt v1.3.5
+	golang.org/x/crypto v0.57.0
+)
+
+require (
+	golang.org/x/net v0.58.0 // indirect
+	golang.org/x/sys v0.48.0 // indirect
+	golang.org/x/text v0.42.0 // indirect
+)
+
+replace github.com/djherbis/stow/v4 => ../
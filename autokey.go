@@ -0,0 +1,55 @@
+package stow
+
+import (
+	"encoding/binary"
+)
+
+// PutAutoKey stores val under the next key of the store's bucket sequence, and returns that
+// key. Keys are encoded big-endian (see AutoKey), so objects added this way are iterated in
+// the order they were added, which suits append-only logs and event streams.
+// Since the key is only assigned once the write starts, BeforePut hooks are passed a nil key.
+func (s *Store) PutAutoKey(val interface{}) (key uint64, err error) {
+	return s.putSequenced(val, AutoKey, nil)
+}
+
+// putSequenced stores val under keyOf the next number of the store's bucket sequence, then
+// runs then, if set, in the same transaction.
+func (s *Store) putSequenced(val interface{}, keyOf func(seq uint64) []byte, then func(tx BackendTx, objects BackendBucket, key, data []byte) error) (seq uint64, err error) {
+	if err := s.beforePut(nil, val); err != nil {
+		return 0, err
+	}
+	var keyBytes []byte
+	defer func() { s.afterPut(keyBytes, val, err) }()
+
+	data, err := s.marshalValue(val)
+	if err != nil {
+		return 0, err
+	}
+
+	err = s.update(func(tx BackendTx) error {
+		objects, err := s.bucket.createOrGet(tx)
+		if err != nil {
+			return err
+		}
+		if seq, err = objects.NextSequence(); err != nil {
+			return err
+		}
+		keyBytes = s.nsKey(keyOf(seq))
+		if err := s.writeKey(tx, objects, keyBytes, data, val, s.opts.ttl); err != nil || then == nil {
+			return err
+		}
+		return then(tx, objects, keyBytes, data)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return seq, nil
+}
+
+// AutoKey returns the bytes an object added by PutAutoKey is stored at, for use with Get,
+// Pull or Delete.
+func AutoKey(key uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, key)
+	return b
+}
@@ -0,0 +1,45 @@
+package stow
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestPutAutoKey(t *testing.T) {
+	s := NewJSONStore(db, []byte("autokey"))
+	defer s.DeleteAll()
+
+	for i := 1; i <= 300; i++ {
+		key, err := s.PutAutoKey(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key != uint64(i) {
+			t.Fatalf("expected key %d, got %d", i, key)
+		}
+	}
+
+	var v int
+	if err := s.Get(AutoKey(256), &v); err != nil || v != 256 {
+		t.Errorf("unexpected Get %d %v", v, err)
+	}
+
+	// Keys iterate in the order they were added.
+	var last uint64
+	err := s.ForEach(func(key []byte, v int) {
+		n := binary.BigEndian.Uint64(key)
+		if n != last+1 || int(n) != v {
+			t.Errorf("unexpected entry %d: %d after %d", n, v, last)
+		}
+		last = n
+	})
+	if err != nil || last != 300 {
+		t.Errorf("iterated to %d %v", last, err)
+	}
+
+	// Deleted keys aren't reused.
+	s.Delete(AutoKey(300))
+	if key, _ := s.PutAutoKey(301); key != 301 {
+		t.Errorf("expected key 301, got %d", key)
+	}
+}
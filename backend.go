@@ -0,0 +1,185 @@
+package stow
+
+import (
+	"errors"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// ErrUnsupportedBackend indicates an operation which needs a feature of bolt, like Backup,
+// on a store whose Backend isn't bolt.
+var ErrUnsupportedBackend = errors.New("operation not supported by the store's backend")
+
+// Backend is the transactional key-value engine a Store keeps its objects and metadata in.
+// It has bolt's model, a tree of buckets which each hold an ordered key space, as every
+// feature of Store relies on it; the stores of NewStore and the other constructors taking
+// a *bolt.DB are on bolt itself. Engines with a single, flat key space can be plugged in
+// with NewKVBackend, which lays the tree out in it.
+//
+// Values, keys, buckets and cursors are only valid until their transaction ends, like in bolt.
+type Backend interface {
+	// View runs fn in a read-only transaction.
+	View(fn func(tx BackendTx) error) error
+	// Update runs fn in a read-write transaction, which is committed if fn returns nil
+	// and rolled back otherwise.
+	Update(fn func(tx BackendTx) error) error
+}
+
+// BackendTx is a transaction of a Backend, it holds the top-level buckets.
+type BackendTx interface {
+	// Bucket returns the bucket called name, or nil if there's none.
+	Bucket(name []byte) BackendBucket
+	// CreateBucketIfNotExists returns the bucket called name, creating it if needed.
+	CreateBucketIfNotExists(name []byte) (BackendBucket, error)
+	// DeleteBucket removes the bucket called name and everything in it, it returns
+	// bolt.ErrBucketNotFound if there's none.
+	DeleteBucket(name []byte) error
+	// ForEach calls fn for each top-level bucket, in name order, until fn returns an error.
+	ForEach(fn func(name []byte, b BackendBucket) error) error
+}
+
+// BackendBucket is a bucket of a BackendTx. Its keys and the names of its nested buckets share
+// one ordered key space, nested buckets are listed with a nil value by ForEach and cursors.
+// It follows bolt's semantics, and returns bolt's errors (like bolt.ErrIncompatibleValue
+// for a Put on the name of a nested bucket).
+type BackendBucket interface {
+	// Get returns the value of key, or nil if there's none or key names a nested bucket.
+	Get(key []byte) []byte
+	Put(key, value []byte) error
+	// Delete removes key, it returns nil if there's no such key.
+	Delete(key []byte) error
+	// ForEach calls fn for each key and its value in key order, until fn returns an error.
+	// fn must not modify the bucket.
+	ForEach(fn func(k, v []byte) error) error
+	Cursor() BackendCursor
+
+	Bucket(name []byte) BackendBucket
+	CreateBucket(name []byte) (BackendBucket, error)
+	CreateBucketIfNotExists(name []byte) (BackendBucket, error)
+	DeleteBucket(name []byte) error
+
+	// Sequence returns the bucket's sequence number, see bolt.Bucket.NextSequence.
+	Sequence() uint64
+	SetSequence(v uint64) error
+	NextSequence() (uint64, error)
+}
+
+// BackendCursor iterates over a BackendBucket in key order. The methods return a nil key at
+// the end of the bucket.
+type BackendCursor interface {
+	First() (key, value []byte)
+	// Seek moves to the first key at or after seek.
+	Seek(seek []byte) (key, value []byte)
+	Next() (key, value []byte)
+}
+
+// batcher is implemented by Backends which can coalesce concurrent writes, see bolt.DB.Batch.
+type batcher interface {
+	Batch(fn func(tx BackendTx) error) error
+}
+
+// NewBackendStore creates a Store which persists objects in bucket of backend, encoding them
+// with codec. It works like NewCustomStore, which is NewBackendStore on bolt.
+func NewBackendStore(backend Backend, bucket []byte, codec Codec, opts ...Option) *Store {
+	s := &Store{
+		db:     backend,
+		bucket: bucketSpec{bucket},
+		codec:  codec,
+
+		immutable: &immutableCache{},
+		stats:     &storeStats{},
+		access:    &accessCounts{},
+		flights:   &flightGroup{},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// NewBoltBackend returns the Backend of db, the one NewCustomStore uses.
+func NewBoltBackend(db *bolt.DB) Backend {
+	return boltBackend{db}
+}
+
+// boltDB returns the bolt database the store is on, if it is.
+func (s *Store) boltDB() (*bolt.DB, bool) {
+	b, ok := s.db.(boltBackend)
+	return b.db, ok && b.db != nil
+}
+
+type boltBackend struct {
+	db *bolt.DB
+}
+
+func (b boltBackend) View(fn func(tx BackendTx) error) error {
+	return b.db.View(func(tx *bolt.Tx) error {
+		return fn(boltTx{tx})
+	})
+}
+
+func (b boltBackend) Update(fn func(tx BackendTx) error) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return fn(boltTx{tx})
+	})
+}
+
+func (b boltBackend) Batch(fn func(tx BackendTx) error) error {
+	return b.db.Batch(func(tx *bolt.Tx) error {
+		return fn(boltTx{tx})
+	})
+}
+
+// boltTx is the BackendTx of a bolt transaction.
+type boltTx struct {
+	*bolt.Tx
+}
+
+func (tx boltTx) Bucket(name []byte) BackendBucket {
+	return boltBucketOf(tx.Tx.Bucket(name))
+}
+
+func (tx boltTx) CreateBucketIfNotExists(name []byte) (BackendBucket, error) {
+	b, err := tx.Tx.CreateBucketIfNotExists(name)
+	return boltBucketOf(b), err
+}
+
+func (tx boltTx) ForEach(fn func(name []byte, b BackendBucket) error) error {
+	return tx.Tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+		return fn(name, boltBucket{b})
+	})
+}
+
+// boltBucket is the BackendBucket of a bolt bucket.
+type boltBucket struct {
+	b *bolt.Bucket
+}
+
+// boltBucketOf returns b as a BackendBucket, which is nil if b is.
+func boltBucketOf(b *bolt.Bucket) BackendBucket {
+	if b == nil {
+		return nil
+	}
+	return boltBucket{b}
+}
+
+func (b boltBucket) Get(key []byte) []byte                    { return b.b.Get(key) }
+func (b boltBucket) Put(key, value []byte) error              { return b.b.Put(key, value) }
+func (b boltBucket) Delete(key []byte) error                  { return b.b.Delete(key) }
+func (b boltBucket) ForEach(fn func(k, v []byte) error) error { return b.b.ForEach(fn) }
+func (b boltBucket) Cursor() BackendCursor                    { return b.b.Cursor() }
+func (b boltBucket) Bucket(name []byte) BackendBucket         { return boltBucketOf(b.b.Bucket(name)) }
+func (b boltBucket) DeleteBucket(name []byte) error           { return b.b.DeleteBucket(name) }
+func (b boltBucket) Sequence() uint64                         { return b.b.Sequence() }
+func (b boltBucket) SetSequence(v uint64) error               { return b.b.SetSequence(v) }
+func (b boltBucket) NextSequence() (uint64, error)            { return b.b.NextSequence() }
+
+func (b boltBucket) CreateBucket(name []byte) (BackendBucket, error) {
+	nested, err := b.b.CreateBucket(name)
+	return boltBucketOf(nested), err
+}
+
+func (b boltBucket) CreateBucketIfNotExists(name []byte) (BackendBucket, error) {
+	nested, err := b.b.CreateBucketIfNotExists(name)
+	return boltBucketOf(nested), err
+}
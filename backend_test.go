@@ -0,0 +1,130 @@
+package stow
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBackends(t *testing.T) {
+	for name, backend := range map[string]Backend{
+		"bolt":    NewBoltBackend(db),
+		"mem":     NewMemBackend(),
+		"bolt-kv": NewKVBackend(NewBoltKV(db, []byte("backend-kv"))),
+	} {
+		s := NewBackendStore(backend, []byte("backend"), JSONCodec{})
+		testBackend(t, name, s)
+		s.DeleteAll()
+	}
+}
+
+// testBackend checks that the features of Store which rely most on the Backend work on s.
+func testBackend(t *testing.T, name string, s *Store) {
+	s.Put("b", MyType{FirstName: "Bob"})
+	s.Put("a", MyType{FirstName: "Ann"})
+	s.Put("a\x00", MyType{FirstName: "Zero"})
+
+	var v MyType
+	if err := s.Get("a", &v); err != nil || v.FirstName != "Ann" {
+		t.Errorf("%s: unexpected value %v %v", name, v, err)
+	}
+	if err := s.Get("missing", &v); err != ErrNotFound {
+		t.Errorf("%s: expected ErrNotFound got %v", name, err)
+	}
+
+	nested := s.NewNestedStore([]byte("nested"))
+	nested.Put("a", MyType{FirstName: "Nested"})
+	var names []string
+	if err := s.ForEach(func(key string, v MyType) { names = append(names, key+v.FirstName) }); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(names, ",") != "aAnn,a\x00Zero,bBob" {
+		t.Errorf("%s: unexpected objects %q", name, names)
+	}
+	if err := nested.Get("a", &v); err != nil || v.FirstName != "Nested" {
+		t.Errorf("%s: unexpected nested value %v %v", name, v, err)
+	}
+
+	s.PutTTL("expired", MyType{}, time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+	if n, err := s.Sweep(); err != nil || n != 1 {
+		t.Errorf("%s: expected to sweep 1 object, got %d %v", name, n, err)
+	}
+
+	users := s.NewNestedStore([]byte("users"))
+	users.Put("alice", indexedUser{Name: "alice", Age: 30})
+	users.Put("bob", indexedUser{Name: "bob", Age: 40})
+	var found []indexedUser
+	if err := users.Find("Age", 40, &found); err != nil || len(found) != 1 || found[0].Name != "bob" {
+		t.Errorf("%s: unexpected Find %v %v", name, found, err)
+	}
+
+	q := s.NewNestedStore([]byte("queue")).NewQueue()
+	q.Push("first")
+	q.Push("second")
+	var item string
+	if err := q.Pop(&item); err != nil || item != "first" {
+		t.Errorf("%s: unexpected Pop %q %v", name, item, err)
+	}
+
+	if err := s.PutReader([]byte("stream"), strings.NewReader("streamed")); err != nil {
+		t.Fatal(err)
+	}
+	r, err := s.GetReader([]byte("stream"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadAll(r)
+	r.Close()
+	if string(data) != "streamed" {
+		t.Errorf("%s: unexpected stream %q", name, data)
+	}
+
+	if err := s.Pull("b", &v); err != nil || v.FirstName != "Bob" {
+		t.Errorf("%s: unexpected pull %v %v", name, v, err)
+	}
+	s.Delete("a")
+	keys, err := s.Keys()
+	if err != nil || len(keys) != 1 || !bytes.Equal(keys[0], []byte("a\x00")) {
+		t.Errorf("%s: unexpected keys %q %v", name, keys, err)
+	}
+
+	if err := s.DeleteAll(); err != nil {
+		t.Fatal(err)
+	}
+	if err := nested.Get("a", &v); err != ErrNotFound {
+		t.Errorf("%s: expected DeleteAll to remove nested stores, got %v", name, err)
+	}
+}
+
+func TestKVCursor(t *testing.T) {
+	backend := NewMemBackend()
+	s := NewBackendStore(backend, []byte("cursor"), JSONCodec{})
+
+	// More keys than a cursor reads at once, deleted while iterating.
+	for i := 0; i < 3*kvCursorBatch; i++ {
+		s.Put(AutoKey(uint64(i)), i)
+	}
+	n := 0
+	err := backend.Update(func(tx BackendTx) error {
+		objects := s.bucket.get(tx)
+		c := objects.Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			if n%2 == 0 {
+				if err := objects.Delete(k); err != nil {
+					return err
+				}
+			}
+			n++
+		}
+		return nil
+	})
+	if err != nil || n != 3*kvCursorBatch {
+		t.Fatalf("expected to iterate over every key, got %d %v", n, err)
+	}
+	if keys, _ := s.Keys(); len(keys) != 3*kvCursorBatch/2 {
+		t.Errorf("expected half the keys left, got %d", len(keys))
+	}
+}
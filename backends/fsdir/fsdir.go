@@ -0,0 +1,280 @@
+// Package fsdir provides a stow.KVBackend which keeps each value in its own file under a
+// directory, for debugging and for values too large to keep in bolt. Stores on it are
+// stow.Stores like any other, see NewStore.
+//
+// Each key of the key space stow.NewKVBackend lays the stores out into is stored in a file
+// named by its hex encoding, under a directory named by the hex encoding of its first byte.
+// Files are written to a temporary file first and renamed into place, so a value is never
+// seen half written. An Update's changes are kept in memory and written when it succeeds;
+// they're not atomic together if the process crashes midway. Transactions are serialized
+// within a Backend, but not between processes or Backends sharing a directory.
+package fsdir
+
+import (
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/djherbis/stow/v4"
+)
+
+var (
+	// ErrKeyRequired indicates an empty key, which has no file name.
+	ErrKeyRequired = errors.New("fsdir: key required")
+
+	// ErrKeyTooLong indicates a key longer than MaxKeySize.
+	ErrKeyTooLong = errors.New("fsdir: key too long")
+)
+
+// MaxKeySize is the size of the longest key, whose hex encoding fits the common 255 byte
+// limit on file names. The keys of the key space start with the path of the bucket of the
+// store's key, so a store's keys are shorter by the length of its bucket names.
+const MaxKeySize = 127
+
+// tmpPrefix starts the names of the temporary files values are written to. It can't start
+// a hex encoded key.
+const tmpPrefix = ".tmp-"
+
+// New returns a Backend which keeps its stores in files under dir, creating dir if needed.
+func New(dir string) (stow.Backend, error) {
+	kv, err := NewKV(dir)
+	if err != nil {
+		return nil, err
+	}
+	return stow.NewKVBackend(kv), nil
+}
+
+// NewKV returns the KVBackend of the files under dir, creating dir if needed.
+func NewKV(dir string) (stow.KVBackend, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &backend{dir: dir}, nil
+}
+
+// NewStore returns a store which keeps its objects in bucket, in files under dir, encoding
+// them with codec, like stow.NewCustomStore.
+func NewStore(dir string, bucket []byte, codec stow.Codec, opts ...stow.Option) (*stow.Store, error) {
+	b, err := New(dir)
+	if err != nil {
+		return nil, err
+	}
+	return stow.NewBackendStore(b, bucket, codec, opts...), nil
+}
+
+type backend struct {
+	mu  sync.RWMutex
+	dir string
+}
+
+func (b *backend) View(fn func(tx stow.KVTx) error) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	tx := &backendTx{b: b}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.err
+}
+
+func (b *backend) Update(fn func(tx stow.KVTx) error) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	tx := &backendTx{b: b, pending: make(map[string][]byte)}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if tx.err != nil {
+		return tx.err
+	}
+	return tx.commit()
+}
+
+// backendTx is a transaction of a backend. pending holds the values written by an Update,
+// nil for deleted keys, keyed by file name.
+type backendTx struct {
+	b       *backend
+	pending map[string][]byte
+	// err is the first error of a Get, which returns no error, and fails the transaction.
+	err error
+}
+
+// path returns the path of the file named name.
+func (t *backendTx) path(name string) string {
+	return filepath.Join(t.b.dir, name[:2], name)
+}
+
+func fileName(key []byte) (string, error) {
+	switch {
+	case len(key) == 0:
+		return "", ErrKeyRequired
+	case len(key) > MaxKeySize:
+		return "", ErrKeyTooLong
+	}
+	return hex.EncodeToString(key), nil
+}
+
+func (t *backendTx) Get(key []byte) []byte {
+	name, err := fileName(key)
+	if err != nil {
+		return nil
+	}
+	return t.get(name)
+}
+
+func (t *backendTx) get(name string) []byte {
+	if v, ok := t.pending[name]; ok {
+		return v
+	}
+	data, err := os.ReadFile(t.path(name))
+	if err != nil {
+		if !os.IsNotExist(err) && t.err == nil {
+			t.err = err
+		}
+		return nil
+	}
+	return data
+}
+
+func (t *backendTx) Put(key, value []byte) error {
+	name, err := fileName(key)
+	if err != nil {
+		return err
+	}
+	t.pending[name] = append([]byte{}, value...)
+	return nil
+}
+
+func (t *backendTx) Delete(key []byte) error {
+	name, err := fileName(key)
+	if err != nil {
+		return err
+	}
+	t.pending[name] = nil
+	return nil
+}
+
+func (t *backendTx) ForEach(prefix, start []byte, fn func(k, v []byte) error) error {
+	names, err := t.names(hex.EncodeToString(prefix))
+	if err != nil {
+		return err
+	}
+	from := hex.EncodeToString(start)
+	for _, name := range names[sort.SearchStrings(names, from):] {
+		v := t.get(name)
+		if t.err != nil {
+			return t.err
+		}
+		if v == nil {
+			// Removed meanwhile by another process.
+			continue
+		}
+		k, _ := hex.DecodeString(name)
+		if err := fn(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// names returns the file names of the keys whose name starts with prefix, in key order.
+func (t *backendTx) names(prefix string) ([]string, error) {
+	set := make(map[string]bool)
+	dirs, err := os.ReadDir(t.b.dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range dirs {
+		if !dir.IsDir() || !isHex(dir.Name()) || !samePrefix(dir.Name(), prefix) {
+			continue
+		}
+		files, err := os.ReadDir(filepath.Join(t.b.dir, dir.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			if name := f.Name(); f.Type().IsRegular() && isHex(name) && strings.HasPrefix(name, dir.Name()) && strings.HasPrefix(name, prefix) {
+				set[name] = true
+			}
+		}
+	}
+	for name, v := range t.pending {
+		if strings.HasPrefix(name, prefix) {
+			set[name] = v != nil
+		}
+	}
+
+	names := make([]string, 0, len(set))
+	for name, ok := range set {
+		if ok {
+			names = append(names, name)
+		}
+	}
+	// Lowercase hex sorts like the bytes it encodes.
+	sort.Strings(names)
+	return names, nil
+}
+
+// samePrefix tells whether one of a and b starts with the other.
+func samePrefix(a, b string) bool {
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+	return strings.HasPrefix(b, a)
+}
+
+func isHex(name string) bool {
+	if len(name) == 0 || len(name)%2 != 0 {
+		return false
+	}
+	_, err := hex.DecodeString(name)
+	return err == nil && strings.ToLower(name) == name
+}
+
+// commit writes the pending changes of an Update.
+func (t *backendTx) commit() error {
+	for name, v := range t.pending {
+		path := t.path(name)
+		if v == nil {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			continue
+		}
+		if err := writeFile(path, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFile writes data to a temporary file next to path, syncs it and renames it to path.
+func writeFile(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, tmpPrefix)
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
@@ -0,0 +1,127 @@
+package fsdir
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/djherbis/stow/v4"
+)
+
+type person struct {
+	Name string
+}
+
+func TestStore(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewStore(dir, []byte("people"), stow.JSONCodec{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"bob", "ann", "carl"} {
+		if err := s.Put(name, person{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var p person
+	if err := s.Get("ann", &p); err != nil || p.Name != "ann" {
+		t.Errorf("unexpected value %v %v", p, err)
+	}
+	if err := s.Get("dan", &p); err != stow.ErrNotFound {
+		t.Errorf("expected ErrNotFound got %v", err)
+	}
+	// The key of ann is the path of its bucket, people, then ann.
+	if _, err := os.Stat(filepath.Join(dir, "70", "70656f706c6500010002616e6e")); err != nil {
+		t.Errorf("expected a file for ann: %v", err)
+	}
+
+	keys, err := s.Keys()
+	if err != nil || len(keys) != 3 || string(keys[0]) != "ann" || string(keys[1]) != "bob" || string(keys[2]) != "carl" {
+		t.Errorf("unexpected keys %q %v", keys, err)
+	}
+
+	if err := s.Pull("bob", &p); err != nil || p.Name != "bob" {
+		t.Errorf("unexpected pull %v %v", p, err)
+	}
+	if has, _ := s.Has("bob"); has {
+		t.Errorf("pulled object is still there")
+	}
+
+	// A second store on the directory sees the same objects.
+	other, err := NewStore(dir, []byte("people"), stow.JSONCodec{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Get("carl", &p); err != nil || p.Name != "carl" {
+		t.Errorf("unexpected value from other store %v %v", p, err)
+	}
+
+	nested := s.NewNestedStore([]byte("nested"))
+	nested.Put("ann", person{Name: "nested"})
+	s.PutTTL("dan", person{Name: "dan"}, time.Hour)
+	var found []person
+	if err := other.NewNestedStore([]byte("nested")).Get("ann", &p); err != nil || p.Name != "nested" {
+		t.Errorf("unexpected nested value %v %v", p, err)
+	}
+	if err := s.ForEach(func(key string, p person) { found = append(found, p) }); err != nil || len(found) != 3 {
+		t.Errorf("unexpected objects %v %v", found, err)
+	}
+
+	if err := s.DeleteAll(); err != nil {
+		t.Fatal(err)
+	}
+	if keys, _ := other.Keys(); len(keys) != 0 {
+		t.Errorf("expected no keys got %q", keys)
+	}
+}
+
+func TestUpdateRollback(t *testing.T) {
+	b, err := NewKV(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Update(func(tx stow.KVTx) error { return tx.Put([]byte("a"), []byte("1")) })
+
+	errFail := errors.New("fail")
+	err = b.Update(func(tx stow.KVTx) error {
+		tx.Delete([]byte("a"))
+		tx.Put([]byte("b"), []byte("2"))
+		var keys [][]byte
+		tx.ForEach(nil, nil, func(k, v []byte) error {
+			keys = append(keys, k)
+			return nil
+		})
+		if len(keys) != 1 || !bytes.Equal(keys[0], []byte("b")) {
+			t.Errorf("update doesn't see its changes: %q", keys)
+		}
+		return errFail
+	})
+	if err != errFail {
+		t.Fatalf("expected errFail got %v", err)
+	}
+	b.View(func(tx stow.KVTx) error {
+		if string(tx.Get([]byte("a"))) != "1" || tx.Get([]byte("b")) != nil {
+			t.Errorf("failed update left changes")
+		}
+		return nil
+	})
+}
+
+func TestKeySize(t *testing.T) {
+	b, err := NewKV(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.Update(func(tx stow.KVTx) error { return tx.Put(nil, []byte("1")) })
+	if err != ErrKeyRequired {
+		t.Errorf("expected ErrKeyRequired got %v", err)
+	}
+	err = b.Update(func(tx stow.KVTx) error { return tx.Put(make([]byte, MaxKeySize+1), []byte("1")) })
+	if err != ErrKeyTooLong {
+		t.Errorf("expected ErrKeyTooLong got %v", err)
+	}
+}
@@ -0,0 +1,121 @@
+package stow
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Backup writes a consistent copy of the whole database file to w, from a read-only
+// transaction, so it can run while the database keeps serving reads and writes.
+// It returns the number of bytes written. The copy is a regular bolt file, use Restore
+// to put it back in place.
+func Backup(db *bolt.DB, w io.Writer) (n int64, err error) {
+	err = db.View(func(tx *bolt.Tx) error {
+		n, err = tx.WriteTo(w)
+		return err
+	})
+	return n, err
+}
+
+// BackupBundle works like Backup, but writes a bundle to w: the copy is compressed and/or
+// encrypted as described by opts, see NewBundleWriter. It returns the size of the copy, not
+// of the bundle. Read it back with RestoreBundle.
+func BackupBundle(db *bolt.DB, w io.Writer, opts BundleOptions) (n int64, err error) {
+	bw, err := NewBundleWriter(w, opts)
+	if err != nil {
+		return 0, err
+	}
+	n, err = Backup(db, bw)
+	if closeErr := bw.Close(); err == nil {
+		err = closeErr
+	}
+	return n, err
+}
+
+// Snapshot writes a consistent copy of the database file the store lives in to w, as
+// Backup does. Note that the copy holds the entire database, not only this store, see
+// SnapshotTo for a snapshot of the store alone. It returns ErrUnsupportedBackend for a store
+// which isn't on bolt.
+func (s *Store) Snapshot(w io.Writer) (n int64, err error) {
+	db, ok := s.boltDB()
+	if !ok {
+		return 0, ErrUnsupportedBackend
+	}
+	return Backup(db, w)
+}
+
+// Restore writes the database backup read from r to the file at path, replacing it if it
+// exists. The backup is written to a temporary file next to path, and checked for
+// consistency before it atomically replaces path, if anything fails path is left untouched.
+// The database at path must not be open while it is restored.
+func Restore(r io.Reader, path string) error {
+	mode := os.FileMode(0600)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode()
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	tmpPath := path + ".restore"
+	f, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath)
+
+	_, err = io.Copy(f, r)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := checkFile(tmpPath, mode); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// RestoreBundle works like Restore, reading the bundle written by BackupBundle from r. key is
+// the Key of the BundleOptions it was written with, nil if it isn't encrypted. A bundle which
+// was tampered with or is truncated fails with ErrBadBundle, leaving path untouched.
+func RestoreBundle(r io.Reader, key []byte, path string) error {
+	br, err := NewBundleReader(r, key)
+	if err != nil {
+		return err
+	}
+	defer br.Close()
+	return Restore(br, path)
+}
+
+// checkFile opens the bolt database at path read-only and checks its consistency.
+func checkFile(path string, mode os.FileMode) error {
+	db, err := bolt.Open(path, mode, &bolt.Options{ReadOnly: true, Timeout: time.Second})
+	if err != nil {
+		return err
+	}
+	err = db.View(func(tx *bolt.Tx) (err error) {
+		for checkErr := range tx.Check() {
+			if err == nil {
+				err = checkErr
+			}
+		}
+		return err
+	})
+	if closeErr := db.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
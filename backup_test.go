@@ -0,0 +1,62 @@
+package stow
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestBackupRestore(t *testing.T) {
+	dir := t.TempDir()
+	src, err := bolt.Open(filepath.Join(dir, "src.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	s := NewJSONStore(src, []byte("backup"))
+	s.Put("a", "1")
+	s.NewNestedStore([]byte("nested")).Put("b", "2")
+
+	var backup bytes.Buffer
+	n, err := s.Snapshot(&backup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(backup.Len()) {
+		t.Errorf("expected %d bytes written got %d", backup.Len(), n)
+	}
+	s.Put("a", "changed")
+
+	path := filepath.Join(dir, "restored.db")
+	if err := Restore(bytes.NewReader([]byte("garbage")), path); err == nil {
+		t.Errorf("expected error restoring garbage")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("failed restore created the file")
+	}
+
+	if err := Restore(bytes.NewReader(backup.Bytes()), path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path + ".restore"); !os.IsNotExist(err) {
+		t.Errorf("temporary restore file was left behind")
+	}
+
+	restored, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+
+	s = NewJSONStore(restored, []byte("backup"))
+	var v string
+	if err := s.Get("a", &v); err != nil || v != "1" {
+		t.Errorf("unexpected value %s %v", v, err)
+	}
+	if err := s.NewNestedStore([]byte("nested")).Get("b", &v); err != nil || v != "2" {
+		t.Errorf("unexpected nested value %s %v", v, err)
+	}
+}
@@ -0,0 +1,144 @@
+// Package boltdbcompat lets applications which still open their database with the archived
+// github.com/boltdb/bolt keep using stow, whose core is built on its maintained fork
+// go.etcd.io/bbolt. The stores it returns are stow.Stores on a stow.Backend over the boltdb
+// database, so code can move to a bbolt database later without changing.
+//
+// Both packages read and write the same file format, and the Backend lays the stores out as
+// stow.NewCustomStore does: the file can be opened with go.etcd.io/bbolt at any time, or
+// converted with stow.UpgradeFile.
+package boltdbcompat
+
+import (
+	"github.com/boltdb/bolt"
+	"github.com/djherbis/stow/v4"
+	bbolt "go.etcd.io/bbolt"
+)
+
+// NewStore returns a store which persists objects in bucket of db with the GobCodec, like
+// stow.NewStore.
+func NewStore(db *bolt.DB, bucket []byte, opts ...stow.Option) *stow.Store {
+	return NewCustomStore(db, bucket, stow.GobCodec{}, opts...)
+}
+
+// NewJSONStore returns a store which persists objects in bucket of db as json, like
+// stow.NewJSONStore.
+func NewJSONStore(db *bolt.DB, bucket []byte, opts ...stow.Option) *stow.Store {
+	return NewCustomStore(db, bucket, stow.JSONCodec{}, opts...)
+}
+
+// NewXMLStore returns a store which persists objects in bucket of db as xml, like
+// stow.NewXMLStore.
+func NewXMLStore(db *bolt.DB, bucket []byte, opts ...stow.Option) *stow.Store {
+	return NewCustomStore(db, bucket, stow.XMLCodec{}, opts...)
+}
+
+// NewCustomStore returns a store which persists objects in bucket of db with codec, like
+// stow.NewCustomStore.
+func NewCustomStore(db *bolt.DB, bucket []byte, codec stow.Codec, opts ...stow.Option) *stow.Store {
+	return stow.NewBackendStore(NewBackend(db), bucket, codec, opts...)
+}
+
+// NewBackend returns the stow.Backend of db. Operations which need bbolt itself, like
+// Store.Snapshot, return stow.ErrUnsupportedBackend on it.
+func NewBackend(db *bolt.DB) stow.Backend {
+	return backend{db}
+}
+
+type backend struct {
+	db *bolt.DB
+}
+
+func (b backend) View(fn func(tx stow.BackendTx) error) error {
+	return compatError(b.db.View(func(tx *bolt.Tx) error {
+		return fn(backendTx{tx})
+	}))
+}
+
+func (b backend) Update(fn func(tx stow.BackendTx) error) error {
+	return compatError(b.db.Update(func(tx *bolt.Tx) error {
+		return fn(backendTx{tx})
+	}))
+}
+
+type backendTx struct {
+	tx *bolt.Tx
+}
+
+func (t backendTx) Bucket(name []byte) stow.BackendBucket {
+	return bucketOf(t.tx.Bucket(name))
+}
+
+func (t backendTx) CreateBucketIfNotExists(name []byte) (stow.BackendBucket, error) {
+	b, err := t.tx.CreateBucketIfNotExists(name)
+	return bucketOf(b), compatError(err)
+}
+
+func (t backendTx) DeleteBucket(name []byte) error {
+	return compatError(t.tx.DeleteBucket(name))
+}
+
+func (t backendTx) ForEach(fn func(name []byte, b stow.BackendBucket) error) error {
+	return t.tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+		return fn(name, bucket{b})
+	})
+}
+
+// bucket is the stow.BackendBucket of a boltdb bucket.
+type bucket struct {
+	b *bolt.Bucket
+}
+
+// bucketOf returns b as a stow.BackendBucket, which is nil if b is.
+func bucketOf(b *bolt.Bucket) stow.BackendBucket {
+	if b == nil {
+		return nil
+	}
+	return bucket{b}
+}
+
+func (b bucket) Get(key []byte) []byte                    { return b.b.Get(key) }
+func (b bucket) Put(key, value []byte) error              { return compatError(b.b.Put(key, value)) }
+func (b bucket) Delete(key []byte) error                  { return compatError(b.b.Delete(key)) }
+func (b bucket) ForEach(fn func(k, v []byte) error) error { return b.b.ForEach(fn) }
+func (b bucket) Cursor() stow.BackendCursor               { return b.b.Cursor() }
+func (b bucket) Bucket(name []byte) stow.BackendBucket    { return bucketOf(b.b.Bucket(name)) }
+func (b bucket) DeleteBucket(name []byte) error           { return compatError(b.b.DeleteBucket(name)) }
+func (b bucket) Sequence() uint64                         { return b.b.Sequence() }
+func (b bucket) SetSequence(v uint64) error               { return compatError(b.b.SetSequence(v)) }
+
+func (b bucket) NextSequence() (uint64, error) {
+	seq, err := b.b.NextSequence()
+	return seq, compatError(err)
+}
+
+func (b bucket) CreateBucket(name []byte) (stow.BackendBucket, error) {
+	nested, err := b.b.CreateBucket(name)
+	return bucketOf(nested), compatError(err)
+}
+
+func (b bucket) CreateBucketIfNotExists(name []byte) (stow.BackendBucket, error) {
+	nested, err := b.b.CreateBucketIfNotExists(name)
+	return bucketOf(nested), compatError(err)
+}
+
+// compatErrors maps boltdb's errors to bbolt's, which stow checks for.
+var compatErrors = map[error]error{
+	bolt.ErrBucketNotFound:     bbolt.ErrBucketNotFound,
+	bolt.ErrBucketExists:       bbolt.ErrBucketExists,
+	bolt.ErrBucketNameRequired: bbolt.ErrBucketNameRequired,
+	bolt.ErrKeyRequired:        bbolt.ErrKeyRequired,
+	bolt.ErrKeyTooLarge:        bbolt.ErrKeyTooLarge,
+	bolt.ErrValueTooLarge:      bbolt.ErrValueTooLarge,
+	bolt.ErrIncompatibleValue:  bbolt.ErrIncompatibleValue,
+	bolt.ErrTxNotWritable:      bbolt.ErrTxNotWritable,
+	bolt.ErrTxClosed:           bbolt.ErrTxClosed,
+	bolt.ErrDatabaseReadOnly:   bbolt.ErrDatabaseReadOnly,
+}
+
+// compatError returns the bbolt error of a boltdb error, or err itself.
+func compatError(err error) error {
+	if e, ok := compatErrors[err]; ok {
+		return e
+	}
+	return err
+}
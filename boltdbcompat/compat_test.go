@@ -0,0 +1,59 @@
+package boltdbcompat
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/djherbis/stow/v4"
+	bbolt "go.etcd.io/bbolt"
+)
+
+type person struct {
+	Name string
+}
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "legacy.db")
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewJSONStore(db, []byte("people"))
+	if err := s.Put("ann", person{Name: "Ann"}); err != nil {
+		t.Fatal(err)
+	}
+	var p person
+	if err := s.Get("ann", &p); err != nil || p.Name != "Ann" {
+		t.Errorf("unexpected value %v %v", p, err)
+	}
+	if err := s.Get("bob", &p); err != stow.ErrNotFound {
+		t.Errorf("expected ErrNotFound got %v", err)
+	}
+	if err := s.NewNestedStore([]byte("friends")).Put("cid", person{Name: "Cid"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteAll(); err != nil {
+		t.Errorf("unexpected DeleteAll error %v", err)
+	}
+	if err := s.Put("ann", person{Name: "Ann"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Snapshot(ioutil.Discard); err != stow.ErrUnsupportedBackend {
+		t.Errorf("expected ErrUnsupportedBackend got %v", err)
+	}
+	db.Close()
+
+	// The file can then be opened with bbolt and a regular Store.
+	upgraded, err := bbolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer upgraded.Close()
+	p = person{}
+	if err := stow.NewJSONStore(upgraded, []byte("people")).Get("ann", &p); err != nil || p.Name != "Ann" {
+		t.Errorf("unexpected value from bbolt %v %v", p, err)
+	}
+}
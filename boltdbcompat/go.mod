@@ -0,0 +1,11 @@
+module github.com/djherbis/stow/v4/boltdbcompat
+
+go 1.16
+
+require (
+	github.com/boltdb/bolt v1.3.1
+	github.com/djherbis/stow/v4 v4.0.0
+	go.etcd.io/bbolt v1.3.5
+)
+
+replace github.com/djherbis/stow/v4 => ../
@@ -0,0 +1,232 @@
+package stow
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrBreakerOpen is returned by a BreakerStore which is failing fast.
+var ErrBreakerOpen = errors.New("circuit breaker is open")
+
+// BreakerState is the state of a BreakerStore.
+type BreakerState int
+
+const (
+	// BreakerClosed passes every operation to the inner store.
+	BreakerClosed BreakerState = iota
+	// BreakerOpen fails every operation with ErrBreakerOpen, until the cooldown is over.
+	BreakerOpen
+	// BreakerHalfOpen lets one operation through to probe the inner store: if it succeeds
+	// the breaker closes, otherwise it opens again. Others fail with ErrBreakerOpen meanwhile.
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// BreakerPolicy configures a BreakerStore.
+type BreakerPolicy struct {
+	// Failures is the number of failures in a row which open the breaker, 5 if zero.
+	Failures int
+
+	// Cooldown is how long the breaker stays open before probing, 30 seconds if zero.
+	Cooldown time.Duration
+
+	// IsFailure reports whether err counts as a failure of the store. If nil, any error
+	// other than ErrNotFound, ErrConflict, ErrTooLarge, a *DecodeError or a *CorruptError
+	// (which are about the objects rather than the database) is a failure.
+	IsFailure func(err error) bool
+
+	// OnStateChange, if set, is called when the breaker changes state, by the goroutine
+	// whose operation changed it.
+	OnStateChange func(from, to BreakerState)
+}
+
+// BreakerStore is a Storer which stops using its inner store for a while after it failed
+// repeatedly (a full disk, I/O errors...), so that callers fail fast with ErrBreakerOpen
+// instead of all piling up on a sick database.
+type BreakerStore struct {
+	inner  Storer
+	policy BreakerPolicy
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+	changes  [][2]BreakerState // not yet passed to OnStateChange
+}
+
+var _ Storer = (*BreakerStore)(nil)
+
+// NewBreakerStore creates a BreakerStore in front of inner.
+func NewBreakerStore(inner Storer, policy BreakerPolicy) *BreakerStore {
+	if policy.Failures <= 0 {
+		policy.Failures = 5
+	}
+	if policy.Cooldown <= 0 {
+		policy.Cooldown = 30 * time.Second
+	}
+	if policy.IsFailure == nil {
+		policy.IsFailure = isStoreFailure
+	}
+	return &BreakerStore{inner: inner, policy: policy}
+}
+
+func isStoreFailure(err error) bool {
+	var decodeErr *DecodeError
+	var corruptErr *CorruptError
+	switch {
+	case err == nil, err == ErrNotFound, err == ErrConflict, errors.Is(err, ErrTooLarge), errors.As(err, &decodeErr), errors.As(err, &corruptErr):
+		return false
+	}
+	return true
+}
+
+// State returns the current state of the breaker.
+func (b *BreakerStore) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerOpen && time.Since(b.openedAt) >= b.policy.Cooldown {
+		return BreakerHalfOpen
+	}
+	return b.state
+}
+
+// do runs op unless the breaker is open, and records its result.
+func (b *BreakerStore) do(op func() error) error {
+	probe, err := b.allow()
+	if err != nil {
+		return err
+	}
+	err = op()
+	b.record(probe, b.policy.IsFailure(err))
+	return err
+}
+
+func (b *BreakerStore) allow() (probe bool, err error) {
+	b.mu.Lock()
+	defer b.unlock()
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < b.policy.Cooldown {
+			return false, ErrBreakerOpen
+		}
+		b.setState(BreakerHalfOpen)
+		fallthrough
+	case BreakerHalfOpen:
+		if b.probing {
+			return false, ErrBreakerOpen
+		}
+		b.probing = true
+		return true, nil
+	}
+	return false, nil
+}
+
+func (b *BreakerStore) record(probe, failed bool) {
+	b.mu.Lock()
+	defer b.unlock()
+	if probe {
+		b.probing = false
+	}
+
+	if !failed {
+		b.failures = 0
+		if probe {
+			b.setState(BreakerClosed)
+		}
+		return
+	}
+
+	b.failures++
+	if probe || (b.state == BreakerClosed && b.failures >= b.policy.Failures) {
+		b.openedAt = time.Now()
+		b.setState(BreakerOpen)
+	}
+}
+
+// setState must be called with b.mu held.
+func (b *BreakerStore) setState(to BreakerState) {
+	if from := b.state; from != to {
+		b.state = to
+		b.changes = append(b.changes, [2]BreakerState{from, to})
+	}
+}
+
+// unlock releases b.mu, then passes the state changes made meanwhile to OnStateChange.
+func (b *BreakerStore) unlock() {
+	changes := b.changes
+	b.changes = nil
+	b.mu.Unlock()
+
+	if b.policy.OnStateChange != nil {
+		for _, c := range changes {
+			b.policy.OnStateChange(c[0], c[1])
+		}
+	}
+}
+
+// Put calls Put on the inner store unless the breaker is open.
+func (b *BreakerStore) Put(key interface{}, val interface{}) error {
+	return b.do(func() error { return b.inner.Put(key, val) })
+}
+
+// Get calls Get on the inner store unless the breaker is open.
+func (b *BreakerStore) Get(key interface{}, val interface{}) error {
+	return b.do(func() error { return b.inner.Get(key, val) })
+}
+
+// Pull calls Pull on the inner store unless the breaker is open.
+func (b *BreakerStore) Pull(key interface{}, val interface{}) error {
+	return b.do(func() error { return b.inner.Pull(key, val) })
+}
+
+// Has calls Has on the inner store unless the breaker is open.
+func (b *BreakerStore) Has(key interface{}) (found bool, err error) {
+	err = b.do(func() (err error) {
+		found, err = b.inner.Has(key)
+		return err
+	})
+	return found, err
+}
+
+// Delete calls Delete on the inner store unless the breaker is open.
+func (b *BreakerStore) Delete(key interface{}) error {
+	return b.do(func() error { return b.inner.Delete(key) })
+}
+
+// DeleteAll calls DeleteAll on the inner store unless the breaker is open.
+func (b *BreakerStore) DeleteAll() error {
+	return b.do(b.inner.DeleteAll)
+}
+
+// ForEach calls ForEach on the inner store unless the breaker is open.
+func (b *BreakerStore) ForEach(do interface{}) error {
+	return b.do(func() error { return b.inner.ForEach(do) })
+}
+
+// ForEachKey calls ForEachKey on the inner store unless the breaker is open.
+// Errors returned by do count like errors of the store, see BreakerPolicy.IsFailure.
+func (b *BreakerStore) ForEachKey(do func(key []byte) error) error {
+	return b.do(func() error { return b.inner.ForEachKey(do) })
+}
+
+// Keys calls Keys on the inner store unless the breaker is open.
+func (b *BreakerStore) Keys() (keys [][]byte, err error) {
+	err = b.do(func() (err error) {
+		keys, err = b.inner.Keys()
+		return err
+	})
+	return keys, err
+}
@@ -0,0 +1,77 @@
+package stow
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// failingStore is a Storer whose Put fails while err is set.
+type failingStore struct {
+	Storer
+	err error
+}
+
+func (f *failingStore) Put(key interface{}, val interface{}) error {
+	if f.err != nil {
+		return f.err
+	}
+	return f.Storer.Put(key, val)
+}
+
+func TestBreakerStore(t *testing.T) {
+	inner := &failingStore{Storer: NewJSONStore(db, []byte("breaker"))}
+	defer inner.DeleteAll()
+
+	var changes []string
+	b := NewBreakerStore(inner, BreakerPolicy{
+		Failures: 2,
+		Cooldown: 10 * time.Millisecond,
+		OnStateChange: func(from, to BreakerState) {
+			changes = append(changes, from.String()+"->"+to.String())
+		},
+	})
+
+	var v string
+	for i := 0; i < 3; i++ {
+		if err := b.Get("missing", &v); err != ErrNotFound {
+			t.Fatalf("expected ErrNotFound, got %v", err)
+		}
+	}
+	if b.State() != BreakerClosed {
+		t.Errorf("misses should not open the breaker")
+	}
+
+	inner.err = errors.New("disk full")
+	b.Put("a", "a")
+	b.Put("a", "a")
+	if b.State() != BreakerOpen {
+		t.Fatalf("expected the breaker to open")
+	}
+	if err := b.Get("a", &v); err != ErrBreakerOpen {
+		t.Errorf("expected ErrBreakerOpen, got %v", err)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if err := b.Put("a", "a"); err != inner.err {
+		t.Errorf("expected the probe to reach the store, got %v", err)
+	}
+	if b.State() != BreakerOpen {
+		t.Errorf("a failed probe should open the breaker again")
+	}
+
+	inner.err = nil
+	time.Sleep(20 * time.Millisecond)
+	if err := b.Put("a", "a"); err != nil {
+		t.Errorf("unexpected probe error %v", err)
+	}
+	if b.State() != BreakerClosed {
+		t.Errorf("a successful probe should close the breaker")
+	}
+
+	want := "[closed->open open->half-open half-open->open open->half-open half-open->closed]"
+	if got := fmt.Sprint(changes); got != want {
+		t.Errorf("unexpected state changes %s", got)
+	}
+}
@@ -0,0 +1,50 @@
+package stow
+
+// bulkLoadBatchSize is the number of objects BulkLoad writes per transaction.
+const bulkLoadBatchSize = 50000
+
+// BulkLoad stores the objects returned by next until it returns ok false, for fast initial
+// loads of large datasets. Values are stored as they are, so they must be encoded with the
+// store's Codec. The slices returned by next are copied, so it may reuse them.
+//
+// Objects are written in large transactions, with bolt filling its pages completely rather
+// than leaving room for later inserts, which suits keys returned in ascending order and
+// after the keys already in the store; keys out of order are stored too, only more slowly.
+// Objects replace those with the same keys, have no index entries and no time-to-live, and
+// loads don't run Hooks. It returns the number of objects stored, which are kept even if
+// BulkLoad fails on a later object.
+func (s *Store) BulkLoad(next func() (key, value []byte, ok bool)) (n int, err error) {
+	defer s.forgetAll()
+
+	for done := false; !done; {
+		var batch int
+		err := s.update(func(tx BackendTx) error {
+			objects, err := s.bucket.createOrGet(tx)
+			if err != nil {
+				return err
+			}
+			if b, ok := objects.(boltBucket); ok {
+				b.b.FillPercent = 1
+			}
+			for batch = 0; batch < bulkLoadBatchSize; batch++ {
+				key, value, ok := next()
+				if !ok {
+					done = true
+					return nil
+				}
+				// bolt keeps the slices until the transaction commits.
+				key = append(s.prefix[:len(s.prefix):len(s.prefix)], key...)
+				data := s.addChecksum(append([]byte(nil), value...))
+				if err := s.writeKey(tx, objects, key, data, nil, 0); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return n, err
+		}
+		n += batch
+	}
+	return n, nil
+}
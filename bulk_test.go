@@ -0,0 +1,43 @@
+package stow
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestBulkLoad(t *testing.T) {
+	s := NewJSONStore(db, []byte("bulk_load"), WithChecksums())
+	defer s.DeleteAll()
+
+	i := 0
+	key, value := make([]byte, 0, 16), make([]byte, 0, 16)
+	n, err := s.BulkLoad(func() ([]byte, []byte, bool) {
+		if i == 1000 {
+			return nil, nil, false
+		}
+		// Reuse the buffers, like a reader of a dump file would.
+		key = append(key[:0], fmt.Sprintf("%04d", i)...)
+		value, _ = json.Marshal(MyType{FirstName: fmt.Sprint(i)})
+		i++
+		return key, value, true
+	})
+	if err != nil || n != 1000 {
+		t.Fatalf("expected 1000 objects loaded, got %d %v", n, err)
+	}
+
+	var v MyType
+	if err := s.Get("0042", &v); err != nil || v.FirstName != "42" {
+		t.Errorf("unexpected object %v %v", v, err)
+	}
+	if keys, _ := s.Keys(); len(keys) != 1000 {
+		t.Errorf("expected 1000 objects, got %d", len(keys))
+	}
+
+	n, err = s.BulkLoad(func() ([]byte, []byte, bool) { return nil, []byte("{}"), true })
+	if err != bolt.ErrKeyRequired || n != 0 {
+		t.Errorf("expected ErrKeyRequired, got %d %v", n, err)
+	}
+}
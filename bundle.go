@@ -0,0 +1,358 @@
+package stow
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// ErrBadBundle indicates a bundle stream which is malformed, truncated or was tampered with.
+var ErrBadBundle = errors.New("invalid bundle")
+
+// ErrBundleKey indicates a bundle stream is encrypted, but no (or the wrong) key was provided.
+var ErrBundleKey = errors.New("bundle key missing or invalid")
+
+// ErrBundleChunkSize indicates BundleOptions whose ChunkSize is negative or not below 1<<31.
+var ErrBundleChunkSize = errors.New("bundle chunk size out of range")
+
+var bundleMagic = []byte("stowbndl")
+
+const (
+	bundleVersion = 1
+
+	bundleNoCompression   = 0
+	bundleGzipCompression = 1
+
+	bundleNoCipher     = 0
+	bundleAESGCMCipher = 1
+
+	// DefaultBundleChunkSize is the size of plaintext chunks sealed by an encrypted bundle.
+	DefaultBundleChunkSize = 64 * 1024
+
+	bundleNoncePrefixSize = 7
+	bundleFinalChunk      = 1 << 31
+)
+
+// BundleOptions configures a bundle stream created by NewBundleWriter.
+type BundleOptions struct {
+	// Compress gzips the stream before it is (optionally) encrypted.
+	Compress bool
+
+	// Key enables AES-GCM encryption when set, it must be 16, 24 or 32 bytes long.
+	Key []byte
+
+	// ChunkSize is the size of each encrypted chunk, DefaultBundleChunkSize if zero. It must
+	// be below 1<<31.
+	ChunkSize int
+}
+
+// NewBundleWriter wraps w so that everything written to it is compressed and/or encrypted
+// as described by opts. The parameters needed to read the stream back (other than the key)
+// are embedded in a header, so a bundle can be read with NewBundleReader without knowing how
+// it was written. ExportBundle and BackupBundle write exports and backups as bundles, to ship
+// them to untrusted storage. Close must be called to flush the bundle, it does not close w.
+func NewBundleWriter(w io.Writer, opts BundleOptions) (io.WriteCloser, error) {
+	if opts.ChunkSize < 0 || int64(opts.ChunkSize) >= bundleFinalChunk {
+		return nil, ErrBundleChunkSize
+	}
+	header := bundleHeader{chunkSize: uint32(opts.ChunkSize)}
+	if header.chunkSize == 0 {
+		header.chunkSize = DefaultBundleChunkSize
+	}
+	if opts.Compress {
+		header.compression = bundleGzipCompression
+	}
+
+	bw := &bundleWriter{w: w}
+	if len(opts.Key) > 0 {
+		header.cipher = bundleAESGCMCipher
+		if _, err := io.ReadFull(rand.Reader, header.noncePrefix[:]); err != nil {
+			return nil, err
+		}
+		aead, err := newBundleAEAD(opts.Key)
+		if err != nil {
+			return nil, err
+		}
+		bw.sealer = &chunkSealer{
+			w:         w,
+			aead:      aead,
+			header:    header,
+			ad:        header.bytes(),
+			chunkSize: int(header.chunkSize),
+		}
+		bw.w = bw.sealer
+	}
+
+	if _, err := w.Write(header.bytes()); err != nil {
+		return nil, err
+	}
+
+	if opts.Compress {
+		bw.gz = gzip.NewWriter(bw.w)
+		bw.w = bw.gz
+	}
+	return bw, nil
+}
+
+// NewBundleReader returns a reader of the original stream written by NewBundleWriter.
+// key must be the key the bundle was written with, or nil if it isn't encrypted.
+func NewBundleReader(r io.Reader, key []byte) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	header, err := readBundleHeader(br)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := &bundleReader{r: br}
+	switch header.cipher {
+	case bundleNoCipher:
+	case bundleAESGCMCipher:
+		if len(key) == 0 {
+			return nil, ErrBundleKey
+		}
+		aead, err := newBundleAEAD(key)
+		if err != nil {
+			return nil, err
+		}
+		reader.r = &chunkOpener{r: br, aead: aead, header: header, ad: header.bytes()}
+	default:
+		return nil, ErrBadBundle
+	}
+
+	switch header.compression {
+	case bundleNoCompression:
+	case bundleGzipCompression:
+		gz, err := gzip.NewReader(reader.r)
+		if err != nil {
+			return nil, bundleErr(err)
+		}
+		reader.gz = gz
+		reader.r = gz
+	default:
+		return nil, ErrBadBundle
+	}
+	return reader, nil
+}
+
+type bundleHeader struct {
+	compression byte
+	cipher      byte
+	chunkSize   uint32
+	noncePrefix [bundleNoncePrefixSize]byte
+}
+
+func (h bundleHeader) bytes() []byte {
+	data := make([]byte, 0, len(bundleMagic)+7+bundleNoncePrefixSize)
+	data = append(data, bundleMagic...)
+	data = append(data, bundleVersion, h.compression, h.cipher)
+	data = append(data, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(data[len(data)-4:], h.chunkSize)
+	return append(data, h.noncePrefix[:]...)
+}
+
+func readBundleHeader(r io.Reader) (h bundleHeader, err error) {
+	data := make([]byte, len(bundleMagic)+7+bundleNoncePrefixSize)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return h, bundleErr(err)
+	}
+	if !bytes.Equal(data[:len(bundleMagic)], bundleMagic) {
+		return h, ErrBadBundle
+	}
+	data = data[len(bundleMagic):]
+	if data[0] != bundleVersion {
+		return h, ErrBadBundle
+	}
+	h.compression = data[1]
+	h.cipher = data[2]
+	h.chunkSize = binary.BigEndian.Uint32(data[3:7])
+	copy(h.noncePrefix[:], data[7:])
+	if h.chunkSize == 0 || h.chunkSize >= bundleFinalChunk {
+		return h, ErrBadBundle
+	}
+	return h, nil
+}
+
+func newBundleAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// bundleNonce returns the nonce for chunk n, the final chunk uses a distinct nonce
+// so that a truncated bundle can't be passed off as complete.
+func bundleNonce(h bundleHeader, n uint32, final bool) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, h.noncePrefix[:])
+	binary.BigEndian.PutUint32(nonce[bundleNoncePrefixSize:], n)
+	if final {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+func bundleErr(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrBadBundle
+	}
+	return err
+}
+
+type bundleWriter struct {
+	w      io.Writer
+	gz     *gzip.Writer
+	sealer *chunkSealer
+	closed bool
+}
+
+func (b *bundleWriter) Write(p []byte) (int, error) {
+	return b.w.Write(p)
+}
+
+func (b *bundleWriter) Close() error {
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+
+	if b.gz != nil {
+		if err := b.gz.Close(); err != nil {
+			return err
+		}
+	}
+	if b.sealer != nil {
+		return b.sealer.close()
+	}
+	return nil
+}
+
+// chunkSealer encrypts everything written to it in chunks of chunkSize.
+type chunkSealer struct {
+	w         io.Writer
+	aead      cipher.AEAD
+	header    bundleHeader
+	ad        []byte
+	chunkSize int
+	buf       []byte
+	n         uint32
+}
+
+func (c *chunkSealer) Write(p []byte) (written int, err error) {
+	for len(p) > 0 {
+		free := c.chunkSize - len(c.buf)
+		if free > len(p) {
+			free = len(p)
+		}
+		c.buf = append(c.buf, p[:free]...)
+		p = p[free:]
+		written += free
+
+		// Always keep the last chunk buffered, so that it can be marked final on close.
+		if len(c.buf) == c.chunkSize && len(p) > 0 {
+			if err := c.seal(false); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+func (c *chunkSealer) seal(final bool) error {
+	sealed := c.aead.Seal(nil, bundleNonce(c.header, c.n, final), c.buf, c.ad)
+	c.n++
+	c.buf = c.buf[:0]
+
+	length := uint32(len(sealed))
+	if final {
+		length |= bundleFinalChunk
+	}
+	var prefix [4]byte
+	binary.BigEndian.PutUint32(prefix[:], length)
+	if _, err := c.w.Write(prefix[:]); err != nil {
+		return err
+	}
+	_, err := c.w.Write(sealed)
+	return err
+}
+
+func (c *chunkSealer) close() error {
+	return c.seal(true)
+}
+
+// chunkOpener decrypts the chunks written by a chunkSealer.
+type chunkOpener struct {
+	r      io.Reader
+	aead   cipher.AEAD
+	header bundleHeader
+	ad     []byte
+	buf    []byte
+	n      uint32
+	done   bool
+}
+
+func (c *chunkOpener) Read(p []byte) (int, error) {
+	for len(c.buf) == 0 {
+		if c.done {
+			return 0, io.EOF
+		}
+		if err := c.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
+
+func (c *chunkOpener) open() error {
+	var prefix [4]byte
+	if _, err := io.ReadFull(c.r, prefix[:]); err != nil {
+		return bundleErr(err)
+	}
+	length := binary.BigEndian.Uint32(prefix[:])
+	final := length&bundleFinalChunk != 0
+	length &^= bundleFinalChunk
+	if length > c.header.chunkSize+uint32(c.aead.Overhead()) {
+		return ErrBadBundle
+	}
+
+	sealed := make([]byte, length)
+	if _, err := io.ReadFull(c.r, sealed); err != nil {
+		return bundleErr(err)
+	}
+
+	data, err := c.aead.Open(sealed[:0], bundleNonce(c.header, c.n, final), sealed, c.ad)
+	if err != nil {
+		if c.n == 0 {
+			return ErrBundleKey
+		}
+		return ErrBadBundle
+	}
+	c.n++
+	c.buf = data
+	c.done = final
+	return nil
+}
+
+type bundleReader struct {
+	r  io.Reader
+	gz *gzip.Reader
+}
+
+func (b *bundleReader) Read(p []byte) (int, error) {
+	return b.r.Read(p)
+}
+
+func (b *bundleReader) Close() error {
+	if b.gz != nil {
+		return b.gz.Close()
+	}
+	return nil
+}
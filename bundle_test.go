@@ -0,0 +1,174 @@
+package stow
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestBundle(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 32)
+	data := bytes.Repeat([]byte("hello world "), 1000)
+
+	for _, opts := range []BundleOptions{
+		{},
+		{Compress: true},
+		{Key: key, ChunkSize: 100},
+		{Key: key, Compress: true, ChunkSize: 100},
+		{Key: key, ChunkSize: len(data)},
+	} {
+		var buf bytes.Buffer
+		w, err := NewBundleWriter(&buf, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(data[:10])
+		w.Write(data[10:])
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		if opts.Key != nil && bytes.Contains(buf.Bytes(), []byte("hello")) {
+			t.Errorf("bundle wasn't encrypted")
+		}
+
+		r, err := NewBundleReader(bytes.NewReader(buf.Bytes()), opts.Key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("bundle data mismatch for %+v", opts)
+		}
+
+		if opts.Key == nil {
+			continue
+		}
+
+		if _, err := readBundle(buf.Bytes(), nil); err != ErrBundleKey {
+			t.Errorf("expected ErrBundleKey got %v", err)
+		}
+
+		if _, err := readBundle(buf.Bytes(), bytes.Repeat([]byte{8}, 32)); err != ErrBundleKey {
+			t.Errorf("expected ErrBundleKey got %v", err)
+		}
+
+		if _, err := readBundle(buf.Bytes()[:buf.Len()-1], opts.Key); err != ErrBadBundle && err != ErrBundleKey {
+			t.Errorf("expected truncated bundle error got %v", err)
+		}
+	}
+
+	if _, err := NewBundleReader(bytes.NewReader([]byte("not a bundle at all, really")), nil); err != ErrBadBundle {
+		t.Errorf("expected ErrBadBundle got %v", err)
+	}
+}
+
+func readBundle(data, key []byte) ([]byte, error) {
+	r, err := NewBundleReader(bytes.NewReader(data), key)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+func TestBundleChunkSize(t *testing.T) {
+	for _, size := range []int{-1, bundleFinalChunk} {
+		if _, err := NewBundleWriter(ioutil.Discard, BundleOptions{ChunkSize: size}); err != ErrBundleChunkSize {
+			t.Errorf("chunk size %d: expected ErrBundleChunkSize got %v", size, err)
+		}
+	}
+	var buf bytes.Buffer
+	if _, err := BackupBundle(db, &buf, BundleOptions{ChunkSize: -1}); err != ErrBundleChunkSize {
+		t.Errorf("expected BackupBundle to fail with ErrBundleChunkSize got %v", err)
+	}
+
+	// The largest chunk size can be read back.
+	w, err := NewBundleWriter(&buf, BundleOptions{ChunkSize: bundleFinalChunk - 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("hello"))
+	w.Close()
+	r, err := NewBundleReader(&buf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ioutil.ReadAll(r); err != nil || string(got) != "hello" {
+		t.Errorf("unexpected bundle %q %v", got, err)
+	}
+}
+
+func TestExportBundle(t *testing.T) {
+	s := NewJSONStore(db, []byte("export_bundle"))
+	defer s.DeleteAll()
+	s.Put("a", "secret value")
+
+	key := bytes.Repeat([]byte{7}, 32)
+	var buf bytes.Buffer
+	if n, err := s.ExportBundle(&buf, BundleOptions{Key: key, Compress: true}); err != nil || n != 1 {
+		t.Fatalf("unexpected export %d %v", n, err)
+	}
+	if bytes.Contains(buf.Bytes(), []byte("secret")) {
+		t.Errorf("export wasn't encrypted")
+	}
+
+	dst := NewJSONStore(db, []byte("export_bundle_dst"))
+	defer dst.DeleteAll()
+	if _, err := dst.ImportBundle(bytes.NewReader(buf.Bytes()), nil); err != ErrBundleKey {
+		t.Errorf("expected ErrBundleKey got %v", err)
+	}
+	if n, err := dst.ImportBundle(bytes.NewReader(buf.Bytes()), key); err != nil || n != 1 {
+		t.Fatalf("unexpected import %d %v", n, err)
+	}
+	var v string
+	if err := dst.Get("a", &v); err != nil || v != "secret value" {
+		t.Errorf("unexpected value %q %v", v, err)
+	}
+}
+
+func TestBackupBundle(t *testing.T) {
+	dir := t.TempDir()
+	src, err := bolt.Open(filepath.Join(dir, "src.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	NewJSONStore(src, []byte("backup")).Put("a", "secret value")
+
+	key := bytes.Repeat([]byte{7}, 32)
+	var buf bytes.Buffer
+	if _, err := BackupBundle(src, &buf, BundleOptions{Key: key, Compress: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "restored.db")
+	truncated := buf.Bytes()[:buf.Len()-1]
+	if err := RestoreBundle(bytes.NewReader(truncated), key, path); !errors.Is(err, ErrBadBundle) {
+		t.Errorf("expected ErrBadBundle got %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("failed restore created the file")
+	}
+
+	if err := RestoreBundle(bytes.NewReader(buf.Bytes()), key, path); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+	var v string
+	if err := NewJSONStore(restored, []byte("backup")).Get("a", &v); err != nil || v != "secret value" {
+		t.Errorf("unexpected value %q %v", v, err)
+	}
+}
@@ -0,0 +1,166 @@
+package stow
+
+import (
+	"reflect"
+)
+
+// PutIf stores newVal with key "key" only if the currently stored value equals expected,
+// otherwise it returns ErrConflict and leaves the store untouched. The check and the write
+// happen in one transaction, which makes PutIf safe for optimistic concurrency between
+// goroutines and processes: read a value, change it, and PutIf it with the value read.
+// A nil expected means the key must not be in the store (or must have expired).
+// The stored value is decoded into the type of expected and compared field by field,
+// the same way as with WithStrictEncoding.
+func (s *Store) PutIf(key interface{}, newVal interface{}, expected interface{}) error {
+	keyBytes, err := s.toBytes(key)
+	if err != nil {
+		return err
+	}
+	return s.putIf(keyBytes, newVal, expected)
+}
+
+func (s *Store) putIf(key []byte, newVal interface{}, expected interface{}) (err error) {
+	if err := s.beforePut(key, newVal); err != nil {
+		return err
+	}
+	defer func() { s.afterPut(key, newVal, err) }()
+
+	stored, err := s.putWhen(key, newVal, func(current []byte) (bool, error) {
+		return s.matches(key, current, expected)
+	})
+	if err == nil && !stored {
+		return ErrConflict
+	}
+	return err
+}
+
+// PutIfAbsent stores val with key "key" only if the key isn't in the store (or has expired),
+// and reports whether it did. The check and the write happen in one transaction, so of many
+// concurrent callers exactly one stores its value, which makes it suitable for idempotent
+// inserts and for claiming keys. Hooks only see the call as a Put when it stores val.
+func (s *Store) PutIfAbsent(key interface{}, val interface{}) (stored bool, err error) {
+	keyBytes, err := s.toBytes(key)
+	if err != nil {
+		return false, err
+	}
+	if err := s.beforePut(keyBytes, val); err != nil {
+		return false, err
+	}
+
+	stored, err = s.putWhen(keyBytes, val, func(current []byte) (bool, error) {
+		return current == nil, nil
+	})
+	if stored || err != nil {
+		s.afterPut(keyBytes, val, err)
+	}
+	return stored, err
+}
+
+// putWhen stores newVal with key if cond, given the current value (nil if there is none),
+// returns true. The check and the write happen in one transaction.
+func (s *Store) putWhen(key []byte, newVal interface{}, cond func(current []byte) (bool, error)) (stored bool, err error) {
+	data, err := s.marshalValue(newVal)
+	if err != nil {
+		return false, err
+	}
+
+	defer s.forget(key)
+	err = s.update(func(tx BackendTx) error {
+		objects, err := s.bucket.createOrGet(tx)
+		if err != nil {
+			return err
+		}
+
+		current := objects.Get(key)
+		if current != nil && s.expiryCheck(tx)(key) {
+			current = nil
+		}
+		if ok, err := cond(current); err != nil || !ok {
+			return err
+		}
+		stored = true
+		return s.writeKey(tx, objects, key, data, newVal, s.opts.ttl)
+	})
+	if err != nil {
+		return false, err
+	}
+	return stored, nil
+}
+
+// matches reports whether the encoded value data of key (nil if there is none) equals expected.
+func (s *Store) matches(key, data []byte, expected interface{}) (bool, error) {
+	want := indirect(reflect.ValueOf(expected))
+	if data == nil || !want.IsValid() {
+		return data == nil && !want.IsValid(), nil
+	}
+
+	got := reflect.New(want.Type())
+	if err := s.unmarshalValue(key, data, got.Interface()); err != nil {
+		return false, s.decodeError(key, data, err)
+	}
+	return roundTripEqual(want, got.Elem(), true), nil
+}
+
+// Swap stores newVal with key "key" and retrieves the value it replaced into oldDest, in one
+// transaction. If there was no value (or it had expired) newVal is still stored, and Swap
+// returns ErrNotFound to tell the caller oldDest wasn't set. If the old value can't be
+// decoded, Swap returns a *DecodeError and leaves it in the store. oldDest is only set once
+// newVal was stored, a failed Swap leaves it untouched.
+func (s *Store) Swap(key interface{}, newVal interface{}, oldDest interface{}) error {
+	keyBytes, err := s.toBytes(key)
+	if err != nil {
+		return err
+	}
+	return s.swap(keyBytes, newVal, oldDest)
+}
+
+func (s *Store) swap(key []byte, newVal interface{}, oldDest interface{}) (err error) {
+	if err := s.beforePut(key, newVal); err != nil {
+		return err
+	}
+
+	data, err := s.marshalValue(newVal)
+	if err != nil {
+		s.afterPut(key, newVal, err)
+		return err
+	}
+
+	// Decode into a fresh value, which is only copied to oldDest once the write succeeded.
+	dest := reflect.ValueOf(oldDest)
+	old := oldDest
+	if dest.Kind() == reflect.Ptr && !dest.IsNil() {
+		old = reflect.New(dest.Elem().Type()).Interface()
+	}
+
+	found := false
+	defer s.forget(key)
+	err = s.update(func(tx BackendTx) error {
+		found = false
+		objects, err := s.bucket.createOrGet(tx)
+		if err != nil {
+			return err
+		}
+		// Decode the old value before replacing it, so a failure leaves it in the store.
+		if current := objects.Get(key); current != nil && !s.expiryCheck(tx)(key) {
+			if err := s.checkDecodeSize(current); err != nil {
+				return s.keyError(key, err)
+			}
+			if err := s.unmarshalValue(key, current, old); err != nil {
+				return s.decodeError(key, current, err)
+			}
+			found = true
+		}
+		return s.writeKey(tx, objects, key, data, newVal, s.opts.ttl)
+	})
+	s.afterPut(key, newVal, err)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return ErrNotFound
+	}
+	if old != oldDest {
+		dest.Elem().Set(reflect.ValueOf(old).Elem())
+	}
+	return nil
+}
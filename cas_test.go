@@ -0,0 +1,157 @@
+package stow
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestPutIf(t *testing.T) {
+	s := NewJSONStore(db, []byte("cas"))
+	defer s.DeleteAll()
+
+	if err := s.PutIf("a", MyType{"Derek", "Kered"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.PutIf("a", MyType{"Other", ""}, nil); err != ErrConflict {
+		t.Errorf("expected ErrConflict for existing key, got %v", err)
+	}
+	if err := s.PutIf("a", MyType{"New", ""}, MyType{"Derek", "Wrong"}); err != ErrConflict {
+		t.Errorf("expected ErrConflict for mismatched value, got %v", err)
+	}
+	if err := s.PutIf("a", MyType{"New", ""}, &MyType{"Derek", "Kered"}); err != nil {
+		t.Errorf("unexpected error for matching value: %v", err)
+	}
+
+	var v MyType
+	s.Get("a", &v)
+	if v.FirstName != "New" {
+		t.Errorf("expected PutIf to write, got %v", v)
+	}
+}
+
+func TestPutIfConcurrent(t *testing.T) {
+	s := NewJSONStore(db, []byte("cas-counter"))
+	defer s.DeleteAll()
+	s.Put("counter", 0)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 10; {
+				var v int
+				s.Get("counter", &v)
+				switch err := s.PutIf("counter", v+1, v); err {
+				case nil:
+					n++
+				case ErrConflict:
+				default:
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	var v int
+	if s.Get("counter", &v); v != 80 {
+		t.Errorf("expected 80 increments, got %d", v)
+	}
+}
+
+func TestSwap(t *testing.T) {
+	s := NewJSONStore(db, []byte("swap"))
+	defer s.DeleteAll()
+
+	var old MyType
+	if err := s.Swap("k", MyType{"First", "One"}, &old); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound for a new key, got %v", err)
+	}
+	if err := s.Swap("k", MyType{"Second", "Two"}, &old); err != nil || old.FirstName != "First" {
+		t.Errorf("expected the previous value: %v %v", old, err)
+	}
+
+	var v MyType
+	if err := s.Get("k", &v); err != nil || v.FirstName != "Second" {
+		t.Errorf("expected the new value to be stored: %v %v", v, err)
+	}
+}
+
+func TestSwapDecodeError(t *testing.T) {
+	s := NewJSONStore(db, []byte("swap_decode_error"))
+	defer s.DeleteAll()
+	s.Put("k", "not a number")
+
+	var n int
+	if _, ok := s.Swap("k", 1, &n).(*DecodeError); !ok {
+		t.Fatal("expected a DecodeError")
+	}
+	var v string
+	if err := s.Get("k", &v); err != nil || v != "not a number" {
+		t.Errorf("failed Swap should leave the old value: %q %v", v, err)
+	}
+}
+
+func TestSwapTooLarge(t *testing.T) {
+	s := NewJSONStore(db, []byte("swap_too_large"), WithMaxDecodeSize(32))
+	defer s.DeleteAll()
+	s.Put("k", strings.Repeat("x", 64))
+
+	var old string
+	err := s.Swap("k", "small", &old)
+	var keyErr *KeyError
+	if !errors.Is(err, ErrTooLarge) || !errors.As(err, &keyErr) || string(keyErr.Key) != "k" {
+		t.Errorf("expected ErrTooLarge for k, got %v", err)
+	}
+}
+
+func TestSwapWriteError(t *testing.T) {
+	s := NewJSONStore(db, []byte("swap_write_error"))
+	defer s.DeleteAll()
+	s.Put("1", uniqueAccount{Login: "alice", Email: "a@example.com"})
+	s.Put("2", uniqueAccount{Login: "bob", Email: "b@example.com"})
+
+	old := uniqueAccount{Login: "unchanged"}
+	if err := s.Swap("2", uniqueAccount{Login: "bob", Email: "a@example.com"}, &old); err != ErrConflict {
+		t.Fatalf("expected ErrConflict, got %v", err)
+	}
+	if old.Login != "unchanged" {
+		t.Errorf("failed Swap should leave oldDest untouched: %v", old)
+	}
+}
+
+func TestPutIfAbsent(t *testing.T) {
+	s := NewJSONStore(db, []byte("put_if_absent"))
+	defer s.DeleteAll()
+
+	var wg sync.WaitGroup
+	claims := make(chan int, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			stored, err := s.PutIfAbsent("claim", i)
+			if err != nil {
+				t.Error(err)
+			}
+			if stored {
+				claims <- i
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(claims)
+
+	winner, ok := <-claims
+	if _, more := <-claims; !ok || more {
+		t.Fatalf("expected exactly one claim to succeed")
+	}
+	var v int
+	if err := s.Get("claim", &v); err != nil || v != winner {
+		t.Errorf("expected the winning value %d: %d %v", winner, v, err)
+	}
+}
@@ -0,0 +1,83 @@
+package stow
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+// ErrCorrupt indicates a value whose checksum doesn't match, see WithChecksums.
+var ErrCorrupt = errors.New("value checksum mismatch")
+
+// checksumSize is the size of the checksum appended to values by WithChecksums.
+const checksumSize = crc32.Size
+
+var checksumTable = crc32.MakeTable(crc32.Castagnoli)
+
+// CorruptError is returned by reads which find a value whose checksum doesn't match, it
+// unwraps to ErrCorrupt. The object is left in the store.
+type CorruptError struct {
+	Key []byte
+}
+
+func (e *CorruptError) Error() string {
+	return fmt.Sprintf("%q: %v", e.Key, ErrCorrupt)
+}
+
+// Unwrap returns ErrCorrupt.
+func (e *CorruptError) Unwrap() error { return ErrCorrupt }
+
+// WithChecksums appends a CRC-32 (Castagnoli) checksum of each value to it when it's
+// written, and verifies it when the value is read, so a value torn by a crash or a bad disk
+// is reported as a *CorruptError rather than decoded into garbage (or failing to decode
+// with a confusing error). Objects written without checksums can't be read with it, and
+// vice versa. Features which move encoded values between stores as they are, like CopyTo,
+// MoveTo and ArchiveOlderThan, need both stores to agree on checksums.
+func WithChecksums() Option {
+	return func(s *Store) {
+		s.opts.checksums = true
+	}
+}
+
+// addChecksum appends the checksum of data to it, if the store has checksums.
+func (s *Store) addChecksum(data []byte) []byte {
+	if !s.opts.checksums {
+		return data
+	}
+	sum := make([]byte, checksumSize)
+	binary.BigEndian.PutUint32(sum, crc32.Checksum(data, checksumTable))
+	return append(data, sum...)
+}
+
+// addChecksumTo appends the checksum of the contents of buf to it, if the store has checksums.
+func (s *Store) addChecksumTo(buf *bytes.Buffer) {
+	if !s.opts.checksums {
+		return
+	}
+	var sum [checksumSize]byte
+	binary.BigEndian.PutUint32(sum[:], crc32.Checksum(buf.Bytes(), checksumTable))
+	buf.Write(sum[:])
+}
+
+// verifyChecksum returns data without its checksum, or a *CorruptError if it doesn't match.
+func (s *Store) verifyChecksum(key, data []byte) ([]byte, error) {
+	if !s.opts.checksums {
+		return data, nil
+	}
+	n := len(data) - checksumSize
+	if n < 0 || crc32.Checksum(data[:n], checksumTable) != binary.BigEndian.Uint32(data[n:]) {
+		return nil, &CorruptError{Key: append([]byte(nil), key...)}
+	}
+	return data[:n], nil
+}
+
+// unmarshalValue decodes data, the value stored at key, into val.
+func (s *Store) unmarshalValue(key, data []byte, val interface{}) error {
+	data, err := s.verifyChecksum(key, data)
+	if err != nil {
+		return err
+	}
+	return s.unmarshal(data, val)
+}
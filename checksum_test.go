@@ -0,0 +1,47 @@
+package stow
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestChecksums(t *testing.T) {
+	testStore(t, NewJSONStore(db, []byte("checksums"), WithChecksums()))
+
+	s := NewJSONStore(db, []byte("checksums_corrupt"), WithChecksums())
+	defer s.DeleteAll()
+	s.Put("a", MyType{FirstName: "Ann"})
+	s.Put("b", MyType{FirstName: "Bob"})
+
+	NewBoltBackend(db).Update(func(tx BackendTx) error {
+		b := s.bucket.get(tx)
+		v := append([]byte(nil), b.Get([]byte("b"))...)
+		v[0] ^= 1
+		return b.Put([]byte("b"), v)
+	})
+
+	var v MyType
+	if err := s.Get("a", &v); err != nil || v.FirstName != "Ann" {
+		t.Errorf("unexpected value %v %v", v, err)
+	}
+	err := s.Get("b", &v)
+	var corrupt *CorruptError
+	if !errors.Is(err, ErrCorrupt) || !errors.As(err, &corrupt) || string(corrupt.Key) != "b" {
+		t.Errorf("expected corrupt error for b got %v", err)
+	}
+	if err := s.Pull("b", &v); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("expected ErrCorrupt got %v", err)
+	}
+	if has, _ := s.Has("b"); !has {
+		t.Errorf("corrupt object was removed")
+	}
+	if err := s.ForEach(func(v MyType) {}); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("expected ErrCorrupt from ForEach got %v", err)
+	}
+
+	// Values written without checksums are reported as corrupt rather than misread.
+	NewJSONStore(db, []byte("checksums_corrupt")).Put("c", MyType{FirstName: "Cid"})
+	if err := s.Get("c", &v); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("expected ErrCorrupt got %v", err)
+	}
+}
@@ -0,0 +1,352 @@
+// Command stow inspects and edits the stores in a bolt database file.
+//
+//	stow -db data.db buckets
+//	stow -db data.db -codec json get people ann
+//	echo '{"Name":"Ann"}' | stow -db data.db -codec json put people ann
+//
+// Run stow -h for the commands. Buckets of nested stores are named by their path, like
+// a/b. Values are printed and read as JSON. Gob and XML values are decoded into the types of
+// a Go plugin given with -plugin, which exports the types of the buckets as
+//
+//	var Models = map[string]interface{}{"people": Person{}}
+//
+// and registers any types gob needs in its init. Without a model, XML values are printed
+// as they are stored.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"plugin"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/djherbis/stow/v4"
+	bolt "go.etcd.io/bbolt"
+)
+
+const usage = `usage: stow [flags] <command> [args]
+
+commands:
+  buckets                 list the buckets, nested ones as a/b
+  keys BUCKET [PREFIX]    list the keys of the objects in BUCKET
+  get BUCKET KEY          print an object as JSON
+  put BUCKET KEY [VALUE]  store the JSON VALUE, or stdin, as an object
+  delete BUCKET KEY       remove an object
+  count BUCKET            print the number of objects in BUCKET
+  export BUCKET           write the objects of BUCKET to stdout as JSON Lines
+  import BUCKET           read objects written by export from stdin
+  verify [BUCKET...]      check that every object, or those of the buckets, can be decoded
+
+flags:
+`
+
+func main() {
+	if err := run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr); err != nil {
+		if err != flag.ErrHelp {
+			fmt.Fprintln(os.Stderr, "stow:", err)
+		}
+		os.Exit(2)
+	}
+}
+
+// cli holds the flags and files of a run.
+type cli struct {
+	db     *bolt.DB
+	codec  stow.Codec
+	opts   []stow.Option
+	models map[string]interface{}
+	stdin  io.Reader
+	stdout io.Writer
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	flags := flag.NewFlagSet("stow", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		flags.PrintDefaults()
+	}
+	path := flags.String("db", "", "bolt database file")
+	codecName := flags.String("codec", "gob", "codec of the values: gob, json, xml or raw")
+	pluginPath := flags.String("plugin", "", "Go plugin exporting the Models of the buckets")
+	checksums := flags.Bool("checksums", false, "values carry checksums, see stow.WithChecksums")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *path == "" || flags.NArg() == 0 {
+		flags.Usage()
+		return flag.ErrHelp
+	}
+
+	c := &cli{stdin: stdin, stdout: stdout}
+	if *checksums {
+		c.opts = append(c.opts, stow.WithChecksums())
+	}
+	switch *codecName {
+	case "gob":
+		c.codec = stow.GobCodec{}
+	case "json":
+		c.codec = stow.JSONCodec{}
+	case "xml":
+		c.codec = stow.XMLCodec{}
+	case "raw":
+		c.codec = stow.RawCodec{}
+	default:
+		return fmt.Errorf("unknown codec %q", *codecName)
+	}
+	if *pluginPath != "" {
+		models, err := loadModels(*pluginPath)
+		if err != nil {
+			return err
+		}
+		c.models = models
+	}
+
+	cmd, cmdArgs := flags.Arg(0), flags.Args()[1:]
+	readOnly := cmd != "put" && cmd != "delete" && cmd != "import"
+	if _, err := os.Stat(*path); err != nil {
+		return err
+	}
+	db, err := bolt.Open(*path, 0600, &bolt.Options{ReadOnly: readOnly, Timeout: time.Second})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	c.db = db
+
+	return c.run(cmd, cmdArgs)
+}
+
+// commands holds the number of arguments of each command, and the optional ones, -1 for any.
+var commands = map[string][2]int{
+	"buckets": {0, 0},
+	"keys":    {1, 1},
+	"get":     {2, 0},
+	"put":     {2, 1},
+	"delete":  {2, 0},
+	"count":   {1, 0},
+	"export":  {1, 0},
+	"import":  {1, 0},
+	"verify":  {0, -1},
+}
+
+func (c *cli) run(cmd string, args []string) error {
+	n, ok := commands[cmd]
+	if !ok {
+		return fmt.Errorf("unknown command %q, see stow -h", cmd)
+	}
+	if len(args) < n[0] || (n[1] >= 0 && len(args) > n[0]+n[1]) {
+		return fmt.Errorf("wrong number of arguments for %s, see stow -h", cmd)
+	}
+
+	switch cmd {
+	case "buckets":
+		return c.buckets()
+	case "verify":
+		return c.verify(args)
+	}
+	s := c.store(args[0], c.codec)
+	switch cmd {
+	case "keys":
+		var prefix []byte
+		if len(args) > 1 {
+			prefix = []byte(args[1])
+		}
+		return s.ForEachKey(func(key []byte) error {
+			if bytes.HasPrefix(key, prefix) {
+				fmt.Fprintln(c.stdout, printable(key))
+			}
+			return nil
+		})
+	case "get":
+		return c.get(s, args[0], args[1])
+	case "put":
+		var value []byte
+		if len(args) > 2 {
+			value = []byte(args[2])
+		} else {
+			var err error
+			if value, err = ioutil.ReadAll(c.stdin); err != nil {
+				return err
+			}
+		}
+		return c.put(s, args[0], args[1], value)
+	case "delete":
+		return s.Delete(args[1])
+	case "count":
+		count := 0
+		err := s.ForEachKey(func([]byte) error {
+			count++
+			return nil
+		})
+		if err == nil {
+			fmt.Fprintln(c.stdout, count)
+		}
+		return err
+	case "export":
+		_, err := s.Export(c.stdout)
+		return err
+	case "import":
+		_, err := s.Import(c.stdin)
+		return err
+	}
+	return nil
+}
+
+// store returns the store of the bucket at path, nested buckets separated by /.
+func (c *cli) store(path string, codec stow.Codec) *stow.Store {
+	parts := strings.Split(path, "/")
+	s := stow.NewCustomStore(c.db, []byte(parts[0]), codec, c.opts...)
+	for _, part := range parts[1:] {
+		s = s.NewNestedStore([]byte(part))
+	}
+	return s
+}
+
+// buckets prints the path of every bucket, except stow's own.
+func (c *cli) buckets() error {
+	return c.db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			if string(name) == "__stow__" {
+				return nil
+			}
+			return c.printBuckets(printable(name), b)
+		})
+	})
+}
+
+func (c *cli) printBuckets(path string, b *bolt.Bucket) error {
+	fmt.Fprintln(c.stdout, path)
+	return b.ForEach(func(k, v []byte) error {
+		if v != nil || (len(k) > 0 && k[0] == 0) {
+			// An object, or a bucket stow keeps internally.
+			return nil
+		}
+		return c.printBuckets(path+"/"+printable(k), b.Bucket(k))
+	})
+}
+
+// verify prints the objects of buckets, or of every bucket, which can't be decoded.
+func (c *cli) verify(buckets []string) error {
+	report, err := stow.Verify(c.db, stow.VerifyOptions{
+		Codec:   c.codec,
+		Options: c.opts,
+		Models:  c.models,
+		Buckets: buckets,
+	})
+	if err != nil {
+		return err
+	}
+	for _, p := range report.Problems {
+		fmt.Fprintf(c.stdout, "%s\t%s\t%v\n", p.Bucket, printable(p.Key), p.Err)
+	}
+	fmt.Fprintf(c.stdout, "%d objects in %d buckets, %d can't be decoded\n", report.Objects, report.Buckets, len(report.Problems))
+	if !report.OK() {
+		return errors.New("verify found objects which can't be decoded")
+	}
+	return nil
+}
+
+// model returns a new value of the type of the bucket's model, or nil if there's none.
+func (c *cli) model(bucket string) interface{} {
+	m, ok := c.models[bucket]
+	if !ok {
+		return nil
+	}
+	typ := reflect.TypeOf(m)
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	return reflect.New(typ).Interface()
+}
+
+func (c *cli) get(s *stow.Store, bucket, key string) error {
+	var out []byte
+	if v := c.model(bucket); v != nil {
+		if err := s.Get(key, v); err != nil {
+			return err
+		}
+		var err error
+		if out, err = json.MarshalIndent(v, "", "  "); err != nil {
+			return err
+		}
+	} else {
+		switch c.codec.(type) {
+		case stow.JSONCodec:
+			var raw json.RawMessage
+			if err := s.Get(key, &raw); err != nil {
+				return err
+			}
+			var buf bytes.Buffer
+			if err := json.Indent(&buf, raw, "", "  "); err != nil {
+				return err
+			}
+			out = buf.Bytes()
+		case stow.XMLCodec, stow.RawCodec:
+			if err := c.store(bucket, stow.RawCodec{}).Get(key, &out); err != nil {
+				return err
+			}
+		default:
+			return errors.New("gob values need a model, see -plugin")
+		}
+	}
+	out = append(out, '\n')
+	_, err := c.stdout.Write(out)
+	return err
+}
+
+func (c *cli) put(s *stow.Store, bucket, key string, value []byte) error {
+	if v := c.model(bucket); v != nil {
+		if err := json.Unmarshal(value, v); err != nil {
+			return err
+		}
+		return s.Put(key, reflect.ValueOf(v).Elem().Interface())
+	}
+	switch c.codec.(type) {
+	case stow.JSONCodec:
+		if !json.Valid(value) {
+			return errors.New("value isn't valid JSON")
+		}
+		return s.Put(key, json.RawMessage(value))
+	case stow.RawCodec:
+		return s.Put(key, value)
+	}
+	return errors.New("values need a model to be encoded, see -plugin")
+}
+
+// loadModels returns the Models exported by the plugin at path.
+func loadModels(path string) (map[string]interface{}, error) {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	sym, err := p.Lookup("Models")
+	if err != nil {
+		return nil, err
+	}
+	models, ok := sym.(*map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("plugin Models is a %T, not a map[string]interface{}", sym)
+	}
+	return *models, nil
+}
+
+// printable returns b as a string, quoted if it isn't printable.
+func printable(b []byte) string {
+	s := string(b)
+	if !utf8.ValidString(s) || strings.IndexFunc(s, func(r rune) bool { return !unicode.IsPrint(r) }) >= 0 {
+		return strconv.Quote(s)
+	}
+	return s
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/djherbis/stow/v4"
+	bolt "go.etcd.io/bbolt"
+)
+
+type person struct {
+	Name string
+}
+
+func TestCommands(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	people := stow.NewJSONStore(db, []byte("people"))
+	people.Put("ann", person{Name: "Ann"})
+	people.Put("bob", person{Name: "Bob"})
+	people.NewNestedStore([]byte("friends")).Put("carl", person{Name: "Carl"})
+	db.Close()
+
+	cmd := func(stdin string, args ...string) string {
+		t.Helper()
+		var out, errOut bytes.Buffer
+		args = append([]string{"-db", path, "-codec", "json"}, args...)
+		if err := run(args, strings.NewReader(stdin), &out, &errOut); err != nil {
+			t.Fatalf("%v: %v %s", args, err, errOut.String())
+		}
+		return out.String()
+	}
+
+	if out := cmd("", "buckets"); out != "people\npeople/friends\n" {
+		t.Errorf("unexpected buckets %q", out)
+	}
+	if out := cmd("", "keys", "people"); out != "ann\nbob\n" {
+		t.Errorf("unexpected keys %q", out)
+	}
+	if out := cmd("", "get", "people", "ann"); out != "{\n  \"Name\": \"Ann\"\n}\n" {
+		t.Errorf("unexpected object %q", out)
+	}
+	if out := cmd("", "get", "people/friends", "carl"); !strings.Contains(out, "Carl") {
+		t.Errorf("unexpected nested object %q", out)
+	}
+
+	cmd(`{"Name":"Dan"}`, "put", "people", "dan")
+	cmd("", "delete", "people", "bob")
+	if out := cmd("", "count", "people"); out != "2\n" {
+		t.Errorf("unexpected count %q", out)
+	}
+
+	exported := cmd("", "export", "people")
+	cmd(exported, "import", "copy")
+	if out := cmd("", "keys", "copy"); out != "ann\ndan\n" {
+		t.Errorf("unexpected imported keys %q", out)
+	}
+
+	if out := cmd("", "verify"); out != "5 objects in 3 buckets, 0 can't be decoded\n" {
+		t.Errorf("unexpected verify %q", out)
+	}
+
+	var out, errOut bytes.Buffer
+	if err := run([]string{"-db", path, "get", "people", "ann"}, nil, &out, &errOut); err == nil {
+		t.Errorf("expected gob values without a model to fail")
+	}
+}
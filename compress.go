@@ -0,0 +1,139 @@
+package stow
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"io"
+)
+
+// ErrUnknownCompression indicates a value whose compression flag is not a known Compression.
+var ErrUnknownCompression = errors.New("unknown compression")
+
+// Compression is an algorithm a CompressedCodec compresses values with.
+type Compression byte
+
+const (
+	// NoCompression stores values as they are.
+	NoCompression Compression = iota
+	// GzipCompression compresses values with gzip.
+	GzipCompression
+	// FlateCompression compresses values with raw DEFLATE, which has less overhead than gzip.
+	FlateCompression
+)
+
+var _ Codec = CompressedCodec{}
+
+// CompressedCodec compresses the values encoded by Codec. Each value is written after a
+// one byte flag which records the Compression it was written with, and the value is decoded
+// by what its flag says rather than by Compression. So values written with different
+// settings can share a bucket, and Compression can change without rewriting old values.
+// It can't read values written without the flag, by Codec alone.
+//
+// Each encoder and decoder handles a single value, as Store uses them.
+type CompressedCodec struct {
+	// Codec encodes and decodes the values before they're compressed.
+	Codec Codec
+	// Compression compresses new values.
+	Compression Compression
+	// Threshold is the encoded size under which new values are stored uncompressed, as small
+	// values gain little from compression but still pay for it on every read and write.
+	Threshold int
+}
+
+// NewEncoder returns an encoder which writes a compressed value to w.
+func (c CompressedCodec) NewEncoder(w io.Writer) Encoder {
+	return compressedEncoder{c: c, w: w}
+}
+
+// NewDecoder returns a decoder which reads a compressed value from r.
+func (c CompressedCodec) NewDecoder(r io.Reader) Decoder {
+	return compressedDecoder{c: c, r: r}
+}
+
+type compressedEncoder struct {
+	c CompressedCodec
+	w io.Writer
+}
+
+func (e compressedEncoder) Encode(v interface{}) error {
+	var buf bytes.Buffer
+	if err := e.c.Codec.NewEncoder(&buf).Encode(v); err != nil {
+		return err
+	}
+
+	compression := e.c.Compression
+	if compression > FlateCompression {
+		return ErrUnknownCompression
+	}
+	if buf.Len() < e.c.Threshold {
+		compression = NoCompression
+	}
+	if _, err := e.w.Write([]byte{byte(compression)}); err != nil {
+		return err
+	}
+
+	var zw io.WriteCloser
+	switch compression {
+	case NoCompression:
+		_, err := e.w.Write(buf.Bytes())
+		return err
+	case GzipCompression:
+		zw = gzip.NewWriter(e.w)
+	case FlateCompression:
+		var err error
+		if zw, err = flate.NewWriter(e.w, flate.DefaultCompression); err != nil {
+			return err
+		}
+	}
+	if _, err := zw.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+type compressedDecoder struct {
+	c CompressedCodec
+	r io.Reader
+}
+
+func (d compressedDecoder) Decode(v interface{}) error {
+	var flag [1]byte
+	if _, err := io.ReadFull(d.r, flag[:]); err != nil {
+		return err
+	}
+
+	var r io.Reader
+	switch Compression(flag[0]) {
+	case NoCompression:
+		r = d.r
+	case GzipCompression:
+		zr, err := gzip.NewReader(d.r)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		r = zr
+	case FlateCompression:
+		zr := flate.NewReader(d.r)
+		defer zr.Close()
+		r = zr
+	default:
+		return ErrUnknownCompression
+	}
+	return d.c.Codec.NewDecoder(r).Decode(v)
+}
+
+// recompress rewrites a value encoded by a CompressedCodec with compression c.
+func recompress(data []byte, c Compression) ([]byte, error) {
+	var plain bytes.Buffer
+	if err := (CompressedCodec{Codec: RawCodec{}}).NewDecoder(bytes.NewReader(data)).Decode(&plain); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := (CompressedCodec{Codec: RawCodec{}, Compression: c}).NewEncoder(&buf).Encode(plain.Bytes()); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
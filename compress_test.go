@@ -0,0 +1,70 @@
+package stow
+
+import (
+	"strings"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestCompressedCodec(t *testing.T) {
+	testStore(t, NewCustomStore(db, []byte("compressed"), CompressedCodec{Codec: JSONCodec{}, Compression: GzipCompression}))
+
+	// Values written with each setting stay readable after it changes.
+	bucket := []byte("compressed_mixed")
+	defer NewJSONStore(db, bucket).DeleteAll()
+	large := strings.Repeat("compress me ", 100)
+	for _, c := range []Compression{NoCompression, GzipCompression, FlateCompression} {
+		s := NewCustomStore(db, bucket, CompressedCodec{Codec: JSONCodec{}, Compression: c})
+		s.Put([]byte{'k', byte(c)}, large)
+	}
+
+	s := NewCustomStore(db, bucket, CompressedCodec{Codec: JSONCodec{}})
+	for _, c := range []Compression{NoCompression, GzipCompression, FlateCompression} {
+		var v string
+		if err := s.Get([]byte{'k', byte(c)}, &v); err != nil || v != large {
+			t.Errorf("unexpected value written with compression %d: %v", c, err)
+		}
+	}
+
+	db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucket)
+		if plain, gz := b.Get([]byte{'k', 0}), b.Get([]byte{'k', 1}); len(gz) >= len(plain) {
+			t.Errorf("expected gzip to shrink the value: %d >= %d", len(gz), len(plain))
+		}
+		return nil
+	})
+
+	bad := NewCustomStore(db, bucket, CompressedCodec{Codec: JSONCodec{}, Compression: 9})
+	if err := bad.Put("bad", "value"); err != ErrUnknownCompression {
+		t.Errorf("expected ErrUnknownCompression, got %v", err)
+	}
+}
+
+func TestCompressionThreshold(t *testing.T) {
+	bucket := []byte("compressed_threshold")
+	s := NewCustomStore(db, bucket, CompressedCodec{Codec: JSONCodec{}, Compression: GzipCompression, Threshold: 512})
+	defer s.DeleteAll()
+
+	large := strings.Repeat("compress me ", 100)
+	s.Put("small", "tiny")
+	s.Put("large", large)
+
+	db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucket)
+		if flag := b.Get([]byte("small"))[0]; Compression(flag) != NoCompression {
+			t.Errorf("expected small value to skip compression got %d", flag)
+		}
+		if flag := b.Get([]byte("large"))[0]; Compression(flag) != GzipCompression {
+			t.Errorf("expected large value to be compressed got %d", flag)
+		}
+		return nil
+	})
+
+	for key, expected := range map[string]string{"small": "tiny", "large": large} {
+		var v string
+		if err := s.Get(key, &v); err != nil || v != expected {
+			t.Errorf("unexpected value for %s: %v", key, err)
+		}
+	}
+}
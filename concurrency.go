@@ -0,0 +1,24 @@
+package stow
+
+// WithConcurrencyLimit bounds the number of values the store encodes or decodes at the same
+// time to n, so a burst of reads of large objects can't allocate memory without bound.
+// Operations beyond the limit wait for a slot. Nested stores created afterward share the
+// limit of their parent. n <= 0 removes the limit.
+func WithConcurrencyLimit(n int) Option {
+	return func(s *Store) {
+		s.opts.codecSlots = nil
+		if n > 0 {
+			s.opts.codecSlots = make(chan struct{}, n)
+		}
+	}
+}
+
+// acquireCodec waits for a slot to encode or decode a value, and returns a func to release it.
+func (s *Store) acquireCodec() (release func()) {
+	slots := s.opts.codecSlots
+	if slots == nil {
+		return func() {}
+	}
+	slots <- struct{}{}
+	return func() { <-slots }
+}
@@ -0,0 +1,61 @@
+package stow
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// slowCodec is a JSONCodec which records how many values it decodes at once.
+type slowCodec struct {
+	JSONCodec
+	active, max *int32
+}
+
+type slowDecoder struct {
+	Decoder
+	c slowCodec
+}
+
+func (c slowCodec) NewDecoder(r io.Reader) Decoder {
+	return slowDecoder{Decoder: c.JSONCodec.NewDecoder(r), c: c}
+}
+
+func (d slowDecoder) Decode(v interface{}) error {
+	n := atomic.AddInt32(d.c.active, 1)
+	defer atomic.AddInt32(d.c.active, -1)
+	for {
+		max := atomic.LoadInt32(d.c.max)
+		if n <= max || atomic.CompareAndSwapInt32(d.c.max, max, n) {
+			break
+		}
+	}
+	time.Sleep(time.Millisecond)
+	return d.Decoder.Decode(v)
+}
+
+func TestWithConcurrencyLimit(t *testing.T) {
+	codec := slowCodec{active: new(int32), max: new(int32)}
+	s := NewCustomStore(db, []byte("concurrency_limit"), codec, WithConcurrencyLimit(2))
+	defer s.DeleteAll()
+	s.Put("hello", "world")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var v string
+			if err := s.Get("hello", &v); err != nil || v != "world" {
+				t.Errorf("unexpected Get %q %v", v, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if max := atomic.LoadInt32(codec.max); max > 2 {
+		t.Errorf("expected at most 2 concurrent decodes, got %d", max)
+	}
+}
@@ -0,0 +1,135 @@
+package stow
+
+import (
+	"bytes"
+	"reflect"
+)
+
+// copyBatchSize is the number of objects CopyTo writes per transaction.
+const copyBatchSize = 1000
+
+// CopyTo copies every object of the store into dst, which can be in another database,
+// replacing objects with the same keys. Encoded values are copied as they are, so dst should
+// use the same Codec, see CopyToAs to re-encode them. Objects keep their expiration time and
+// index entries, and expired objects are skipped. Nested stores and hashes aren't copied,
+// copy nested stores with their own CopyTo. Objects are copied in batches, each written in
+// its own transaction, and copies don't run Hooks.
+func (s *Store) CopyTo(dst *Store) error {
+	return s.copyTo(dst, func(tx BackendTx, objects BackendBucket, obj copiedObject) error {
+		if err := objects.Put(obj.key, obj.data); err != nil {
+			return err
+		}
+		return dst.setIndexes(tx, obj.key, obj.entries)
+	})
+}
+
+// CopyToAs works like CopyTo, but decodes each value into a new value of the type of model
+// and encodes it with dst's Codec, to migrate objects between codecs. Index entries are
+// computed by dst.
+func (s *Store) CopyToAs(dst *Store, model interface{}) error {
+	typ := reflect.TypeOf(model)
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	return s.copyTo(dst, func(tx BackendTx, objects BackendBucket, obj copiedObject) error {
+		val := reflect.New(typ).Interface()
+		if err := s.unmarshalValue(obj.key, obj.data, val); err != nil {
+			return s.decodeError(obj.key, obj.data, err)
+		}
+		data, err := dst.marshalValue(val)
+		if err != nil {
+			return err
+		}
+		return dst.writeKey(tx, objects, obj.key, data, val, 0)
+	})
+}
+
+type copiedObject struct {
+	key, data []byte
+	entries   []indexEntry
+	expiry    []byte // as kept in the ttl keys bucket
+}
+
+// copyTo reads the objects of the store in batches, and writes each batch to dst with write.
+func (s *Store) copyTo(dst *Store, write func(tx BackendTx, objects BackendBucket, obj copiedObject) error) error {
+	defer dst.forgetAll()
+
+	var after []byte
+	for {
+		batch, last, err := s.copyBatch(after)
+		if err != nil || len(batch) == 0 {
+			return err
+		}
+		after = last
+
+		err = dst.update(func(tx BackendTx) error {
+			objects, err := dst.bucket.createOrGet(tx)
+			if err != nil {
+				return err
+			}
+			for _, obj := range batch {
+				if err := write(tx, objects, obj); err != nil {
+					return err
+				}
+				if err := dst.setWritten(tx, obj.key); err != nil {
+					return err
+				}
+				if err := dst.copyExpiry(tx, obj.key, obj.expiry); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// copyBatch returns up to copyBatchSize objects after key "after", and the last key it looked at.
+func (s *Store) copyBatch(after []byte) (batch []copiedObject, last []byte, err error) {
+	err = s.db.View(func(tx BackendTx) error {
+		objects := s.bucket.get(tx)
+		if objects == nil {
+			return nil
+		}
+		meta := s.bucket.meta()
+		indexKeys := meta.child(indexKeysBucket).get(tx)
+		ttlKeys := meta.child(ttlKeysBucket).get(tx)
+		isExpired := s.expiryCheck(tx)
+
+		c := objects.Cursor()
+		k, v := c.First()
+		if after != nil {
+			if k, v = c.Seek(after); k != nil && bytes.Equal(k, after) {
+				k, v = c.Next()
+			}
+		}
+		for ; k != nil && len(batch) < copyBatchSize; k, v = c.Next() {
+			last = append(last[:0], k...)
+			if v == nil || isExpired(k) {
+				continue
+			}
+
+			obj := copiedObject{
+				key:  append([]byte(nil), k...),
+				data: append([]byte(nil), v...),
+			}
+			if indexKeys != nil {
+				if data := indexKeys.Get(k); data != nil {
+					entries, err := decodeIndexEntries(append([]byte(nil), data...))
+					if err != nil {
+						return err
+					}
+					obj.entries = entries
+				}
+			}
+			if ttlKeys != nil {
+				obj.expiry = append([]byte(nil), ttlKeys.Get(k)...)
+			}
+			batch = append(batch, obj)
+		}
+		return nil
+	})
+	return batch, last, err
+}
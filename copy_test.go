@@ -0,0 +1,53 @@
+package stow
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestCopyTo(t *testing.T) {
+	src := NewJSONStore(db, []byte("copy_src"))
+	defer src.DeleteAll()
+	src.Put("a", indexedUser{Name: "a", Email: "a@example.com"})
+	src.PutTTL("b", indexedUser{Name: "b"}, time.Hour)
+	src.PutTTL("expired", indexedUser{Name: "expired"}, time.Nanosecond)
+	time.Sleep(time.Millisecond)
+
+	other, err := bolt.Open(filepath.Join(t.TempDir(), "copy.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+
+	dst := NewJSONStore(other, []byte("copy_dst"))
+	if err := src.CopyTo(dst); err != nil {
+		t.Fatal(err)
+	}
+	keys, _ := dst.Keys()
+	if len(keys) != 2 {
+		t.Errorf("expected 2 copied objects, got %q", keys)
+	}
+	var users []indexedUser
+	if err := dst.Find("Email", "a@example.com", &users); err != nil || len(users) != 1 {
+		t.Errorf("copied objects should keep their index entries: %v %v", users, err)
+	}
+	if ttl := ttlOf(dst, "b"); ttl != time.Hour {
+		t.Errorf("copied objects should keep their ttl, got %v", ttl)
+	}
+
+	gob := NewStore(other, []byte("copy_gob"))
+	if err := src.CopyToAs(gob, indexedUser{}); err != nil {
+		t.Fatal(err)
+	}
+	var u indexedUser
+	if err := gob.Get("a", &u); err != nil || u.Email != "a@example.com" {
+		t.Errorf("unexpected re-encoded object %v %v", u, err)
+	}
+	users = nil
+	if err := gob.Find("Email", "a@example.com", &users); err != nil || len(users) != 1 {
+		t.Errorf("re-encoded objects should be indexed: %v %v", users, err)
+	}
+}
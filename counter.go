@@ -0,0 +1,81 @@
+package stow
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+var (
+	// ErrNotCounter indicates an Increment or Counter of a key which holds an object
+	// which isn't a counter.
+	ErrNotCounter = errors.New("value is not a counter")
+
+	// ErrCounterOverflow indicates an Increment which would overflow the counter.
+	// The counter is left unchanged.
+	ErrCounterOverflow = errors.New("counter overflow")
+)
+
+const counterLength = 8
+
+// Increment adds delta to the counter at key "key", creating it at zero if needed, and returns
+// its new value. The read and the write happen in one transaction, so increments are safe
+// across goroutines and processes. Counters are stored as 8 bytes rather than with the
+// store's codec, read them with Counter rather than Get, and keep them apart from other
+// objects since any 8 byte value would read as a counter. They don't run Hooks, and like
+// objects written by Put they get the store's default ttl, renewed by each Increment, and
+// its checksum (see WithChecksums).
+func (s *Store) Increment(key []byte, delta int64) (n int64, err error) {
+	key = s.nsKey(key)
+	defer s.forget(key)
+	err = s.update(func(tx BackendTx) error {
+		objects, err := s.bucket.createOrGet(tx)
+		if err != nil {
+			return err
+		}
+		if n, err = s.counter(tx, objects, key); err != nil {
+			return err
+		}
+		if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+			return ErrCounterOverflow
+		}
+		n += delta
+		return s.writeKey(tx, objects, key, s.addChecksum(encodeCounter(n)), nil, s.opts.ttl)
+	})
+	return n, err
+}
+
+// Counter returns the value of the counter at key "key", or zero if there is none.
+func (s *Store) Counter(key []byte) (n int64, err error) {
+	key = s.nsKey(key)
+	err = s.db.View(func(tx BackendTx) error {
+		objects := s.bucket.get(tx)
+		if objects == nil {
+			return nil
+		}
+		n, err = s.counter(tx, objects, key)
+		return err
+	})
+	return n, err
+}
+
+func (s *Store) counter(tx BackendTx, objects BackendBucket, key []byte) (int64, error) {
+	data := objects.Get(key)
+	if data == nil || s.expiryCheck(tx)(key) {
+		return 0, nil
+	}
+	raw, err := s.verifyChecksum(key, data)
+	if err != nil {
+		return 0, s.decodeError(key, data, err)
+	}
+	if len(raw) != counterLength {
+		return 0, ErrNotCounter
+	}
+	return int64(binary.BigEndian.Uint64(raw)), nil
+}
+
+func encodeCounter(n int64) []byte {
+	data := make([]byte, counterLength)
+	binary.BigEndian.PutUint64(data, uint64(n))
+	return data
+}
@@ -0,0 +1,63 @@
+package stow
+
+import (
+	"math"
+	"sync"
+	"testing"
+)
+
+func TestIncrement(t *testing.T) {
+	s := NewJSONStore(db, []byte("counters"))
+	defer s.DeleteAll()
+	key := []byte("hits")
+
+	if n, err := s.Counter(key); err != nil || n != 0 {
+		t.Errorf("expected a missing counter to be 0: %d %v", n, err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.Increment(key, 1); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n, err := s.Increment(key, -5); err != nil || n != 15 {
+		t.Errorf("unexpected counter %d %v", n, err)
+	}
+	if n, err := s.Counter(key); err != nil || n != 15 {
+		t.Errorf("unexpected counter %d %v", n, err)
+	}
+
+	if _, err := s.Increment(key, math.MaxInt64); err != ErrCounterOverflow {
+		t.Errorf("expected ErrCounterOverflow, got %v", err)
+	}
+
+	s.Put("object", "not a counter")
+	if _, err := s.Increment([]byte("object"), 1); err != ErrNotCounter {
+		t.Errorf("expected ErrNotCounter, got %v", err)
+	}
+}
+
+func TestIncrementChecksums(t *testing.T) {
+	s := NewJSONStore(db, []byte("counters_checksums"), WithChecksums())
+	defer s.DeleteAll()
+	key := []byte("hits")
+
+	s.Increment(key, 2)
+	if n, err := s.Increment(key, 3); err != nil || n != 5 {
+		t.Errorf("unexpected counter %d %v", n, err)
+	}
+	if n, err := s.Counter(key); err != nil || n != 5 {
+		t.Errorf("unexpected counter %d %v", n, err)
+	}
+	// The counter is checksummed like any other value.
+	if raw, err := s.GetRaw(key); err != nil || len(raw) != counterLength {
+		t.Errorf("unexpected raw counter %v %v", raw, err)
+	}
+}
@@ -0,0 +1,260 @@
+package stow
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// ErrMergeType indicates a Merge of CRDT states of different types.
+var ErrMergeType = errors.New("cannot merge states of different types")
+
+// CRDT is a conflict-free replicated data type: a state which replicas (for instance devices
+// which are only connected from time to time) change independently, and which converges once
+// they exchange their states, without a central server. GCounter, LWWRegister and ORSet are
+// provided, other types can be stored the same way if they implement CRDT with a pointer.
+type CRDT interface {
+	// Merge folds remote, a state of the same type, into the receiver. Merges must be
+	// commutative, associative and idempotent, so states can be exchanged in any order
+	// and any number of times.
+	Merge(remote CRDT) error
+}
+
+// Merge merges remote, a state received from another replica, into the state stored with
+// key "key" (the zero state if there is none) in one transaction. Afterward both the store
+// and remote hold the merged state, which can be sent back to the other replica.
+func (s *Store) Merge(key interface{}, remote CRDT) error {
+	v := reflect.ValueOf(remote)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return ErrMergeType
+	}
+
+	local := reflect.New(v.Type().Elem())
+	err := s.UpdateCRDT(key, local.Interface().(CRDT), func() error {
+		return local.Interface().(CRDT).Merge(remote)
+	})
+	if err != nil {
+		return err
+	}
+	v.Elem().Set(local.Elem())
+	return nil
+}
+
+// UpdateCRDT loads the state stored with key "key" into state (the zero state if there is
+// none), runs change and stores the changed state, in one transaction. Use it to make changes
+// local to this replica, like incrementing a GCounter, so concurrent changes aren't lost:
+//
+//	var c stow.GCounter
+//	err := store.UpdateCRDT("visits", &c, func() error {
+//		c.Inc(replica, 1)
+//		return nil
+//	})
+//
+// If change returns an error nothing is stored.
+func (s *Store) UpdateCRDT(key interface{}, state CRDT, change func() error) error {
+	keyBytes, err := s.toBytes(key)
+	if err != nil {
+		return err
+	}
+	v := reflect.ValueOf(state)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return ErrMergeType
+	}
+	return s.updateCRDT(keyBytes, v, change)
+}
+
+func (s *Store) updateCRDT(key []byte, state reflect.Value, change func() error) (err error) {
+	if err := s.beforePut(key, state.Interface()); err != nil {
+		return err
+	}
+	defer func() { s.afterPut(key, state.Interface(), err) }()
+
+	defer s.forget(key)
+	return s.update(func(tx BackendTx) error {
+		objects, err := s.bucket.createOrGet(tx)
+		if err != nil {
+			return err
+		}
+
+		state.Elem().Set(reflect.Zero(state.Type().Elem()))
+		if data := objects.Get(key); data != nil && !s.expiryCheck(tx)(key) {
+			if err := s.checkDecodeSize(data); err != nil {
+				return s.keyError(key, err)
+			}
+			if err := s.unmarshalValue(key, data, state.Interface()); err != nil {
+				return s.decodeError(key, data, err)
+			}
+		}
+
+		if err := change(); err != nil {
+			return err
+		}
+		data, err := s.marshalValue(state.Interface())
+		if err != nil {
+			return err
+		}
+		return s.writeKey(tx, objects, key, data, state.Interface(), s.opts.ttl)
+	})
+}
+
+// GCounter is a grow-only counter. Each replica increments its own count, and the value
+// is the sum of the counts of all replicas.
+type GCounter struct {
+	Counts map[string]uint64
+}
+
+// Inc adds n to the count of replica.
+func (c *GCounter) Inc(replica string, n uint64) {
+	if c.Counts == nil {
+		c.Counts = make(map[string]uint64)
+	}
+	c.Counts[replica] += n
+}
+
+// Value returns the sum of the counts of all replicas.
+func (c *GCounter) Value() (n uint64) {
+	for _, count := range c.Counts {
+		n += count
+	}
+	return n
+}
+
+// Merge keeps the highest count seen for each replica.
+func (c *GCounter) Merge(remote CRDT) error {
+	r, ok := remote.(*GCounter)
+	if !ok {
+		return ErrMergeType
+	}
+	for replica, count := range r.Counts {
+		if count > c.Counts[replica] {
+			if c.Counts == nil {
+				c.Counts = make(map[string]uint64)
+			}
+			c.Counts[replica] = count
+		}
+	}
+	return nil
+}
+
+// LWWRegister is a last-writer-wins register: it holds the value of the latest Set across
+// all replicas. Writes made at the same time are ordered by replica. Values are raw bytes,
+// encode them with the codec of your choice.
+type LWWRegister struct {
+	Value   []byte
+	Time    time.Time
+	Replica string
+}
+
+// Set writes value from replica at time at. It's ignored if the register already holds a
+// later write, since that write would win a merge anyway.
+func (r *LWWRegister) Set(replica string, value []byte, at time.Time) {
+	if r.later(at, replica) {
+		r.Value, r.Time, r.Replica = value, at, replica
+	}
+}
+
+// Merge keeps the latest of the two writes.
+func (r *LWWRegister) Merge(remote CRDT) error {
+	other, ok := remote.(*LWWRegister)
+	if !ok {
+		return ErrMergeType
+	}
+	if r.later(other.Time, other.Replica) {
+		r.Value, r.Time, r.Replica = other.Value, other.Time, other.Replica
+	}
+	return nil
+}
+
+// later reports whether a write from replica at time at wins over the register's write.
+func (r *LWWRegister) later(at time.Time, replica string) bool {
+	if !at.Equal(r.Time) {
+		return at.After(r.Time)
+	}
+	return replica > r.Replica
+}
+
+// ORSet is an observed-remove set of strings. An element is in the set if it was added
+// by an Add which no Remove has observed, so an Add concurrent with a Remove wins.
+type ORSet struct {
+	Adds    map[string]map[string]bool // element -> tags of its adds
+	Removes map[string]map[string]bool // element -> tags of the adds observed by removes
+	Clock   map[string]uint64          // replica -> number of adds made by the replica
+}
+
+// Add adds element to the set, from replica.
+func (s *ORSet) Add(replica, element string) {
+	if s.Clock == nil {
+		s.Clock = make(map[string]uint64)
+	}
+	s.Clock[replica]++
+	tag := replica + "/" + strconv.FormatUint(s.Clock[replica], 10)
+	s.Adds = addTags(s.Adds, element, map[string]bool{tag: true})
+}
+
+// Remove removes element from the set, if it's there.
+func (s *ORSet) Remove(element string) {
+	s.Removes = addTags(s.Removes, element, s.Adds[element])
+}
+
+// Contains reports whether element is in the set.
+func (s *ORSet) Contains(element string) bool {
+	for tag := range s.Adds[element] {
+		if !s.Removes[element][tag] {
+			return true
+		}
+	}
+	return false
+}
+
+// Elements returns the elements of the set, sorted.
+func (s *ORSet) Elements() []string {
+	elements := []string{}
+	for element := range s.Adds {
+		if s.Contains(element) {
+			elements = append(elements, element)
+		}
+	}
+	sort.Strings(elements)
+	return elements
+}
+
+// Merge takes the union of the adds and removes of both sets.
+func (s *ORSet) Merge(remote CRDT) error {
+	r, ok := remote.(*ORSet)
+	if !ok {
+		return ErrMergeType
+	}
+	for element, tags := range r.Adds {
+		s.Adds = addTags(s.Adds, element, tags)
+	}
+	for element, tags := range r.Removes {
+		s.Removes = addTags(s.Removes, element, tags)
+	}
+	for replica, n := range r.Clock {
+		if n > s.Clock[replica] {
+			if s.Clock == nil {
+				s.Clock = make(map[string]uint64)
+			}
+			s.Clock[replica] = n
+		}
+	}
+	return nil
+}
+
+func addTags(m map[string]map[string]bool, element string, tags map[string]bool) map[string]map[string]bool {
+	if len(tags) == 0 {
+		return m
+	}
+	if m == nil {
+		m = make(map[string]map[string]bool)
+	}
+	if m[element] == nil {
+		m[element] = make(map[string]bool, len(tags))
+	}
+	for tag := range tags {
+		m[element][tag] = true
+	}
+	return m
+}
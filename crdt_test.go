@@ -0,0 +1,103 @@
+package stow
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCRDTMerge(t *testing.T) {
+	a := NewJSONStore(db, []byte("crdt_a"))
+	defer a.DeleteAll()
+	b := NewStore(db, []byte("crdt_b"))
+	defer b.DeleteAll()
+
+	var c GCounter
+	for i := 0; i < 3; i++ {
+		if err := a.UpdateCRDT("visits", &c, func() error { c.Inc("a", 1); return nil }); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b.UpdateCRDT("visits", &c, func() error { c.Inc("b", 2); return nil })
+
+	// Sync a -> b, then b -> a, twice to check merges are idempotent.
+	for i := 0; i < 2; i++ {
+		var state GCounter
+		a.Get("visits", &state)
+		if err := b.Merge("visits", &state); err != nil {
+			t.Fatal(err)
+		}
+		if err := a.Merge("visits", &state); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, s := range []*Store{a, b} {
+		var got GCounter
+		if err := s.Get("visits", &got); err != nil || got.Value() != 5 {
+			t.Errorf("expected converged count 5, got %d %v", got.Value(), err)
+		}
+	}
+
+	if err := c.Merge(&ORSet{}); err != ErrMergeType {
+		t.Errorf("expected ErrMergeType, got %v", err)
+	}
+}
+
+func TestLWWRegister(t *testing.T) {
+	now := time.Now()
+	var a, b LWWRegister
+	a.Set("a", []byte("first"), now)
+	b.Set("b", []byte("second"), now.Add(time.Second))
+	a.Set("a", []byte("stale"), now.Add(-time.Second))
+	if string(a.Value) != "first" {
+		t.Errorf("Set of an older write should be ignored, got %q", a.Value)
+	}
+
+	ab, ba := a, b
+	ab.Merge(&b)
+	ba.Merge(&a)
+	if string(ab.Value) != "second" || !reflect.DeepEqual(ab, ba) {
+		t.Errorf("merges should converge on the latest write: %q %q", ab.Value, ba.Value)
+	}
+
+	var tie LWWRegister
+	tie.Set("z", []byte("z"), now)
+	a.Merge(&tie)
+	if string(a.Value) != "z" {
+		t.Errorf("ties should be broken by replica, got %q", a.Value)
+	}
+}
+
+func TestORSet(t *testing.T) {
+	var a, b ORSet
+	a.Add("a", "x")
+	a.Add("a", "y")
+	b.Merge(&a)
+
+	// b removes x while a concurrently adds it again, the add wins.
+	b.Remove("x")
+	a.Add("a", "x")
+	b.Remove("y")
+	b.Add("b", "z")
+
+	a.Merge(&b)
+	b.Merge(&a)
+	for _, s := range []ORSet{a, b} {
+		if got := s.Elements(); !reflect.DeepEqual(got, []string{"x", "z"}) {
+			t.Errorf("unexpected elements %v", got)
+		}
+	}
+	if a.Contains("y") {
+		t.Errorf("removed element should not be in the set")
+	}
+
+	s := NewJSONStore(db, []byte("crdt_orset"))
+	defer s.DeleteAll()
+	if err := s.Merge("set", &a); err != nil {
+		t.Fatal(err)
+	}
+	var stored ORSet
+	if err := s.Get("set", &stored); err != nil || !reflect.DeepEqual(stored.Elements(), []string{"x", "z"}) {
+		t.Errorf("unexpected stored set %v %v", stored.Elements(), err)
+	}
+}
@@ -4,7 +4,9 @@ import (
 	"encoding/gob"
 	"encoding/json"
 	"encoding/xml"
+	"fmt"
 	"io"
+	"io/ioutil"
 )
 
 // Codec provides a mechanism for storing/retriving objects as streams of data.
@@ -27,6 +29,7 @@ var (
 	_ Codec = XMLCodec{}
 	_ Codec = JSONCodec{}
 	_ Codec = GobCodec{}
+	_ Codec = RawCodec{}
 )
 
 // XMLCodec is used to encode/decode XML
@@ -58,22 +61,65 @@ func (c JSONCodec) NewDecoder(r io.Reader) Decoder {
 // GobCodec is used to encode/decode using the Gob format.
 type GobCodec struct{}
 
-// Register registers the type using gob.Register for use with NewStore() and the GobCodec.
-func Register(value interface{}) {
-	gob.Register(value)
-}
-
-// RegisterName registers the type using gob.RegisterName for use with NewStore() and the GobCodec.
-func RegisterName(name string, value interface{}) {
-	gob.RegisterName(name, value)
-}
-
 // NewEncoder returns a new gob encoder which writes to w
 func (c GobCodec) NewEncoder(w io.Writer) Encoder {
 	return gob.NewEncoder(w)
 }
 
-// NewDecoder returns a new gob decoder which reads from r
+// NewDecoder returns a new gob decoder which reads from r, values of types registered
+// with RegisterAlias are decoded as the types they alias.
 func (c GobCodec) NewDecoder(r io.Reader) Decoder {
-	return gob.NewDecoder(r)
+	dec := gob.NewDecoder(r)
+	if !hasTypeAliases() {
+		return dec
+	}
+	return aliasDecoder{dec}
+}
+
+// RawCodec stores bytes as they are, without encoding them. It encodes []byte and string
+// values, and decodes into a *[]byte, a *string or any io.Writer (like a *bytes.Buffer).
+// Use it for blobs, alone or in a CompressedCodec.
+type RawCodec struct{}
+
+// NewEncoder returns a new raw encoder which writes to w
+func (c RawCodec) NewEncoder(w io.Writer) Encoder {
+	return rawEncoder{w}
+}
+
+// NewDecoder returns a new raw decoder which reads from r
+func (c RawCodec) NewDecoder(r io.Reader) Decoder {
+	return rawDecoder{r}
+}
+
+type rawEncoder struct{ w io.Writer }
+
+func (e rawEncoder) Encode(v interface{}) (err error) {
+	switch v := v.(type) {
+	case []byte:
+		_, err = e.w.Write(v)
+	case string:
+		_, err = io.WriteString(e.w, v)
+	default:
+		err = fmt.Errorf("RawCodec can't encode %T", v)
+	}
+	return err
+}
+
+type rawDecoder struct{ r io.Reader }
+
+func (d rawDecoder) Decode(v interface{}) error {
+	switch v := v.(type) {
+	case *[]byte:
+		data, err := ioutil.ReadAll(d.r)
+		*v = data
+		return err
+	case *string:
+		data, err := ioutil.ReadAll(d.r)
+		*v = string(data)
+		return err
+	case io.Writer:
+		_, err := io.Copy(v, d.r)
+		return err
+	}
+	return fmt.Errorf("RawCodec can't decode into %T", v)
 }
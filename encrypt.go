@@ -0,0 +1,295 @@
+package stow
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+var (
+	// ErrUnknownKeyID indicates an encryption key ID which isn't in the Keyring.
+	ErrUnknownKeyID = errors.New("unknown encryption key id")
+
+	// ErrDecrypt indicates a value which can't be decrypted: it's malformed, was tampered
+	// with, or its key changed.
+	ErrDecrypt = errors.New("value can't be decrypted")
+
+	// ErrNotEncryptedCodec indicates a Rotate of a store whose Codec isn't an EncryptedCodec.
+	ErrNotEncryptedCodec = errors.New("store codec is not an EncryptedCodec")
+)
+
+// rotateBatchSize is the number of objects Rotate rewrites per transaction.
+const rotateBatchSize = 1000
+
+// Keyring holds the encryption keys of an EncryptedCodec by ID, and which of them encrypts
+// new values. It's safe for concurrent use, so keys can be added and rotated while the
+// stores using it serve traffic.
+type Keyring struct {
+	mu      sync.RWMutex
+	keys    map[string]cipher.AEAD
+	current string
+}
+
+// NewKeyring returns an empty Keyring.
+func NewKeyring() *Keyring {
+	return &Keyring{keys: make(map[string]cipher.AEAD)}
+}
+
+// Add adds the AES key with ID id, it must be 16, 24 or 32 bytes long. IDs are recorded in
+// each value so the key can be found to decrypt it, they must be at most 255 bytes long.
+// The first key added encrypts new values, until SetCurrent is called.
+func (k *Keyring) Add(id string, key []byte) error {
+	if len(id) == 0 || len(id) > 255 {
+		return fmt.Errorf("invalid encryption key id %q", id)
+	}
+	aead, err := newBundleAEAD(key)
+	if err != nil {
+		return err
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.keys[id] = aead
+	if k.current == "" {
+		k.current = id
+	}
+	return nil
+}
+
+// SetCurrent makes the key with ID id encrypt new values.
+func (k *Keyring) SetCurrent(id string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if _, ok := k.keys[id]; !ok {
+		return fmt.Errorf("%w: %q", ErrUnknownKeyID, id)
+	}
+	k.current = id
+	return nil
+}
+
+// Remove removes the key with ID id, values it encrypted can't be decrypted anymore.
+// The current key can't be removed.
+func (k *Keyring) Remove(id string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if id == k.current {
+		return fmt.Errorf("can't remove the current encryption key %q", id)
+	}
+	delete(k.keys, id)
+	return nil
+}
+
+// Current returns the ID of the key which encrypts new values.
+func (k *Keyring) Current() string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.current
+}
+
+func (k *Keyring) key(id string) (cipher.AEAD, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	aead, ok := k.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKeyID, id)
+	}
+	return aead, nil
+}
+
+var _ Codec = EncryptedCodec{}
+
+// EncryptedCodec encrypts the values encoded by Codec with AES-GCM, using the current key of
+// Keyring. Each value is written after the ID of the key which encrypted it and a random
+// nonce, and is decrypted with that key, so values encrypted with old keys stay readable
+// as long as their keys are in the Keyring; see Store.Rotate to re-encrypt them. Keys aren't
+// encrypted.
+//
+// Each encoder and decoder handles a single value, as Store uses them.
+type EncryptedCodec struct {
+	// Codec encodes and decodes the values before they're encrypted.
+	Codec Codec
+	// Keyring holds the keys.
+	Keyring *Keyring
+}
+
+// NewEncoder returns an encoder which writes an encrypted value to w.
+func (c EncryptedCodec) NewEncoder(w io.Writer) Encoder {
+	return encryptedEncoder{c: c, w: w}
+}
+
+// NewDecoder returns a decoder which reads an encrypted value from r.
+func (c EncryptedCodec) NewDecoder(r io.Reader) Decoder {
+	return encryptedDecoder{c: c, r: r}
+}
+
+type encryptedEncoder struct {
+	c EncryptedCodec
+	w io.Writer
+}
+
+func (e encryptedEncoder) Encode(v interface{}) error {
+	var buf bytes.Buffer
+	if err := e.c.Codec.NewEncoder(&buf).Encode(v); err != nil {
+		return err
+	}
+	data, err := e.c.seal(e.c.Keyring.Current(), buf.Bytes())
+	if err != nil {
+		return err
+	}
+	_, err = e.w.Write(data)
+	return err
+}
+
+type encryptedDecoder struct {
+	c EncryptedCodec
+	r io.Reader
+}
+
+func (d encryptedDecoder) Decode(v interface{}) error {
+	data, err := ioutil.ReadAll(d.r)
+	if err != nil {
+		return err
+	}
+	plain, err := d.c.open(data)
+	if err != nil {
+		return err
+	}
+	return d.c.Codec.NewDecoder(bytes.NewReader(plain)).Decode(v)
+}
+
+// seal encrypts plain with the key id, as: id length, id, nonce, sealed plain.
+// The id is authenticated along with plain.
+func (c EncryptedCodec) seal(id string, plain []byte) ([]byte, error) {
+	aead, err := c.Keyring.key(id)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, 1+len(id)+aead.NonceSize())
+	header[0] = byte(len(id))
+	copy(header[1:], id)
+	nonce := header[1+len(id):]
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(header, nonce, plain, header[:1+len(id)]), nil
+}
+
+// open decrypts a value written by seal.
+func (c EncryptedCodec) open(data []byte) ([]byte, error) {
+	id, ok := encryptionKeyID(data)
+	if !ok {
+		return nil, ErrDecrypt
+	}
+	aead, err := c.Keyring.key(id)
+	if err != nil {
+		return nil, err
+	}
+	rest := data[1+len(id):]
+	if len(rest) < aead.NonceSize() {
+		return nil, ErrDecrypt
+	}
+	plain, err := aead.Open(nil, rest[:aead.NonceSize()], rest[aead.NonceSize():], data[:1+len(id)])
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plain, nil
+}
+
+// encryptionKeyID returns the ID of the key which encrypted data.
+func encryptionKeyID(data []byte) (id string, ok bool) {
+	if len(data) == 0 || len(data) < 1+int(data[0]) {
+		return "", false
+	}
+	return string(data[1 : 1+data[0]]), true
+}
+
+// Rotate makes the key with ID newKeyID encrypt new values, and re-encrypts every object
+// encrypted with another key with it. The store's Codec must be an EncryptedCodec. Values are
+// re-encrypted without being decoded, in batches each written in its own transaction, and
+// stay readable throughout, as long as the old keys are in the Keyring: remove them only
+// once Rotate succeeded. Nested stores aren't rotated, rotate them with their own Rotate.
+// It returns the number of objects re-encrypted.
+func (s *Store) Rotate(newKeyID string) (n int, err error) {
+	c, ok := s.codec.(EncryptedCodec)
+	if !ok {
+		return 0, ErrNotEncryptedCodec
+	}
+	if err := c.Keyring.SetCurrent(newKeyID); err != nil {
+		return 0, err
+	}
+
+	var after []byte
+	for {
+		type change struct{ key, old, data []byte }
+		var changes []change
+		var last []byte
+		err := s.db.View(func(tx BackendTx) error {
+			objects := s.bucket.get(tx)
+			if objects == nil {
+				return nil
+			}
+			cur := objects.Cursor()
+			k, v := cur.First()
+			if after != nil {
+				if k, v = cur.Seek(after); k != nil && bytes.Equal(k, after) {
+					k, v = cur.Next()
+				}
+			}
+			for ; k != nil && len(changes) < rotateBatchSize; k, v = cur.Next() {
+				last = append(last[:0], k...)
+				if v == nil {
+					continue
+				}
+				if id, _ := encryptionKeyID(v); id == newKeyID {
+					continue
+				}
+				old := append([]byte(nil), v...)
+				value, err := s.verifyChecksum(k, old)
+				var plain, data []byte
+				if err == nil {
+					plain, err = c.open(value)
+				}
+				if err == nil {
+					data, err = c.seal(newKeyID, plain)
+				}
+				if err != nil {
+					return s.decodeError(k, old, err)
+				}
+				data = s.addChecksum(data)
+				changes = append(changes, change{append([]byte(nil), k...), old, data})
+			}
+			return nil
+		})
+		if err != nil || last == nil {
+			return n, err
+		}
+		after = last
+
+		err = s.update(func(tx BackendTx) error {
+			objects := s.bucket.get(tx)
+			if objects == nil {
+				return nil
+			}
+			for _, c := range changes {
+				// Leave objects which were rewritten meanwhile.
+				if !bytes.Equal(objects.Get(c.key), c.old) {
+					continue
+				}
+				s.forget(c.key)
+				if err := objects.Put(c.key, c.data); err != nil {
+					return err
+				}
+				n++
+			}
+			return nil
+		})
+		if err != nil {
+			return n, err
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package stow
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestEncryptedCodec(t *testing.T) {
+	keys := NewKeyring()
+	if err := keys.Add("k1", bytes.Repeat([]byte{1}, 32)); err != nil {
+		t.Fatal(err)
+	}
+	if err := keys.Add("k2", []byte("short")); err == nil {
+		t.Errorf("expected error adding an invalid key")
+	}
+	codec := EncryptedCodec{Codec: JSONCodec{}, Keyring: keys}
+	testStore(t, NewCustomStore(db, []byte("encrypted"), codec))
+
+	s := NewCustomStore(db, []byte("encrypted_rotate"), codec)
+	defer s.DeleteAll()
+	s.Put("a", "secret a")
+	s.Put("b", "secret b")
+
+	NewBoltBackend(db).View(func(tx BackendTx) error {
+		if v := s.bucket.get(tx).Get([]byte("a")); bytes.Contains(v, []byte("secret")) {
+			t.Errorf("value wasn't encrypted")
+		}
+		return nil
+	})
+
+	if _, err := s.Rotate("k2"); !errors.Is(err, ErrUnknownKeyID) {
+		t.Errorf("expected ErrUnknownKeyID got %v", err)
+	}
+	keys.Add("k2", bytes.Repeat([]byte{2}, 16))
+	s.Put("c", "secret c")
+	if n, err := s.Rotate("k2"); err != nil || n != 3 {
+		t.Errorf("unexpected rotate %d %v", n, err)
+	}
+	if keys.Current() != "k2" {
+		t.Errorf("expected k2 to be current got %s", keys.Current())
+	}
+	if n, err := s.Rotate("k2"); err != nil || n != 0 {
+		t.Errorf("expected nothing left to rotate got %d %v", n, err)
+	}
+
+	if err := keys.Remove("k2"); err == nil {
+		t.Errorf("expected error removing the current key")
+	}
+	if err := keys.Remove("k1"); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		var v string
+		if err := s.Get(key, &v); err != nil || v != "secret "+key {
+			t.Errorf("unexpected value for %s %q %v", key, v, err)
+		}
+	}
+
+	NewBoltBackend(db).Update(func(tx BackendTx) error {
+		b := s.bucket.get(tx)
+		v := append([]byte(nil), b.Get([]byte("a"))...)
+		v[len(v)-1] ^= 1
+		return b.Put([]byte("tampered"), v)
+	})
+	var v string
+	if err := s.Get("tampered", &v); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("expected ErrDecrypt got %v", err)
+	}
+
+	if _, err := NewJSONStore(db, []byte("encrypted_rotate")).Rotate("k2"); err != ErrNotEncryptedCodec {
+		t.Errorf("expected ErrNotEncryptedCodec got %v", err)
+	}
+}
@@ -0,0 +1,197 @@
+package stow
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+)
+
+var (
+	// ErrUnknownCodec indicates a CodecID which isn't registered, see RegisterCodec.
+	ErrUnknownCodec = errors.New("unknown codec id")
+
+	// ErrNotEnvelopeCodec indicates a PutWithCodec to a store whose Codec isn't an EnvelopeCodec.
+	ErrNotEnvelopeCodec = errors.New("store codec is not an EnvelopeCodec")
+)
+
+// envelopeMagic starts the envelope of values written by an EnvelopeCodec. No value encoded
+// by the JSON, XML or Gob codecs (or a SchemaCodec) starts with it.
+const envelopeMagic = 0xf7
+
+// CodecID identifies a codec in the envelope written by an EnvelopeCodec.
+type CodecID byte
+
+// CodecIDs of the codecs stow registers. IDs from 128 up are left for applications.
+const (
+	JSONCodecID CodecID = 1
+	GobCodecID  CodecID = 2
+	XMLCodecID  CodecID = 3
+	RawCodecID  CodecID = 4
+)
+
+var codecRegistry = struct {
+	sync.RWMutex
+	codecs map[CodecID]Codec
+}{codecs: map[CodecID]Codec{
+	JSONCodecID: JSONCodec{},
+	GobCodecID:  GobCodec{},
+	XMLCodecID:  XMLCodec{},
+	RawCodecID:  RawCodec{},
+}}
+
+// RegisterCodec registers c with ID id, so values enveloped with id are decoded by c. The
+// registration of a CodecID must not change once values were written with it.
+func RegisterCodec(id CodecID, c Codec) {
+	codecRegistry.Lock()
+	defer codecRegistry.Unlock()
+	codecRegistry.codecs[id] = c
+}
+
+// registeredCodec returns the codec registered with id.
+func registeredCodec(id CodecID) (Codec, error) {
+	codecRegistry.RLock()
+	defer codecRegistry.RUnlock()
+	c, ok := codecRegistry.codecs[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownCodec, id)
+	}
+	return c, nil
+}
+
+// registeredCodecID returns the ID c was registered with.
+func registeredCodecID(c Codec) (CodecID, error) {
+	if !reflect.TypeOf(c).Comparable() {
+		return 0, fmt.Errorf("%w: %T can't be looked up, set its ID", ErrUnknownCodec, c)
+	}
+	codecRegistry.RLock()
+	defer codecRegistry.RUnlock()
+	for id, registered := range codecRegistry.codecs {
+		if reflect.TypeOf(registered) == reflect.TypeOf(c) && registered == c {
+			return id, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %T isn't registered", ErrUnknownCodec, c)
+}
+
+// EnvelopeHeader is the envelope an EnvelopeCodec writes before each value.
+type EnvelopeHeader struct {
+	// Codec is the ID of the codec the value was encoded with.
+	Codec CodecID
+	// Version is the schema version of the value, as set by the application.
+	Version uint64
+}
+
+// ParseEnvelope returns the envelope of data, a value written by an EnvelopeCodec, and the
+// value encoded by the codec it names. ok is false if data has no envelope.
+func ParseEnvelope(data []byte) (h EnvelopeHeader, payload []byte, ok bool) {
+	if len(data) < 2 || data[0] != envelopeMagic {
+		return h, data, false
+	}
+	version, n := binary.Uvarint(data[2:])
+	if n <= 0 {
+		return h, data, false
+	}
+	return EnvelopeHeader{Codec: CodecID(data[1]), Version: version}, data[2+n:], true
+}
+
+var _ Codec = EnvelopeCodec{}
+
+// EnvelopeCodec writes the values encoded by Codec after an envelope: a magic byte, the ID
+// of the codec and a schema version. Values are decoded by the codec their envelope names,
+// so a store with an EnvelopeCodec reads the values of every registered codec, whichever
+// codec it writes with, and buckets which mix codecs are read safely instead of failing
+// with confusing decode errors. Values without an envelope are decoded by Codec.
+//
+// Each encoder and decoder handles a single value, as Store uses them.
+type EnvelopeCodec struct {
+	// Codec encodes new values, and decodes values without an envelope.
+	Codec Codec
+	// ID is the CodecID of Codec. If zero, it's looked up among the registered codecs.
+	ID CodecID
+	// Version is the schema version recorded in the envelope of new values.
+	Version uint64
+}
+
+// NewEncoder returns an encoder which writes an enveloped value to w.
+func (c EnvelopeCodec) NewEncoder(w io.Writer) Encoder {
+	return envelopeEncoder{c: c, w: w}
+}
+
+// NewDecoder returns a decoder which reads an enveloped value from r.
+func (c EnvelopeCodec) NewDecoder(r io.Reader) Decoder {
+	return envelopeDecoder{c: c, r: bufio.NewReader(r)}
+}
+
+type envelopeEncoder struct {
+	c EnvelopeCodec
+	w io.Writer
+}
+
+func (e envelopeEncoder) Encode(v interface{}) error {
+	id := e.c.ID
+	if id == 0 {
+		var err error
+		if id, err = registeredCodecID(e.c.Codec); err != nil {
+			return err
+		}
+	}
+	header := make([]byte, 2+binary.MaxVarintLen64)
+	header[0], header[1] = envelopeMagic, byte(id)
+	n := binary.PutUvarint(header[2:], e.c.Version)
+	if _, err := e.w.Write(header[:2+n]); err != nil {
+		return err
+	}
+	return e.c.Codec.NewEncoder(e.w).Encode(v)
+}
+
+type envelopeDecoder struct {
+	c EnvelopeCodec
+	r *bufio.Reader
+}
+
+func (d envelopeDecoder) Decode(v interface{}) error {
+	if b, err := d.r.Peek(1); err != nil || b[0] != envelopeMagic {
+		return d.c.Codec.NewDecoder(d.r).Decode(v)
+	}
+	d.r.ReadByte()
+	id, err := d.r.ReadByte()
+	if err != nil {
+		return err
+	}
+	if _, err := binary.ReadUvarint(d.r); err != nil {
+		return err
+	}
+
+	codec := d.c.Codec
+	if d.c.ID == 0 || CodecID(id) != d.c.ID {
+		if codec, err = registeredCodec(CodecID(id)); err != nil {
+			return err
+		}
+	}
+	return codec.NewDecoder(d.r).Decode(v)
+}
+
+// PutWithCodec works like Put, but encodes b with codec rather than with the store's Codec,
+// so values which suit another codec (like large blobs stored with RawCodec or a
+// CompressedCodec) can share a bucket with the others. The store's Codec must be an
+// EnvelopeCodec, which reads each value with the codec named by its envelope; codec must be
+// registered with RegisterCodec, or be an EnvelopeCodec with an ID.
+func (s *Store) PutWithCodec(key interface{}, b interface{}, codec Codec) error {
+	envelope, ok := s.codec.(EnvelopeCodec)
+	if !ok {
+		return ErrNotEnvelopeCodec
+	}
+	if c, ok := codec.(EnvelopeCodec); ok {
+		envelope = c
+	} else {
+		envelope.Codec, envelope.ID = codec, 0
+	}
+
+	override := *s
+	override.codec = envelope
+	return override.Put(key, b)
+}
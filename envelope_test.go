@@ -0,0 +1,93 @@
+package stow
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestEnvelopeCodec(t *testing.T) {
+	testStore(t, NewCustomStore(db, []byte("envelope"), EnvelopeCodec{Codec: JSONCodec{}}))
+
+	bucket := []byte("envelope_mixed")
+	defer NewStore(db, bucket).DeleteAll()
+	NewCustomStore(db, bucket, EnvelopeCodec{Codec: GobCodec{}, Version: 2}).Put("gob", MyType{FirstName: "Gob"})
+	NewCustomStore(db, bucket, EnvelopeCodec{Codec: XMLCodec{}}).Put("xml", MyType{FirstName: "Xml"})
+	NewJSONStore(db, bucket).Put("legacy", MyType{FirstName: "Legacy"})
+
+	// A store reads every enveloped value, whichever codec it writes with.
+	s := NewCustomStore(db, bucket, EnvelopeCodec{Codec: JSONCodec{}})
+	for key, name := range map[string]string{"gob": "Gob", "xml": "Xml", "legacy": "Legacy"} {
+		var v MyType
+		if err := s.Get(key, &v); err != nil || v.FirstName != name {
+			t.Errorf("unexpected value for %s %v %v", key, v, err)
+		}
+	}
+
+	NewBoltBackend(db).View(func(tx BackendTx) error {
+		b := tx.Bucket(bucket)
+		h, _, ok := ParseEnvelope(b.Get([]byte("gob")))
+		if !ok || h.Codec != GobCodecID || h.Version != 2 {
+			t.Errorf("unexpected envelope %v %v", h, ok)
+		}
+		if _, _, ok := ParseEnvelope(b.Get([]byte("legacy"))); ok {
+			t.Errorf("unexpected envelope for legacy value")
+		}
+		return nil
+	})
+
+	unregistered := NewCustomStore(db, bucket, EnvelopeCodec{Codec: CompressedCodec{Codec: JSONCodec{}}})
+	if err := unregistered.Put("c", "value"); !errors.Is(err, ErrUnknownCodec) {
+		t.Errorf("expected ErrUnknownCodec got %v", err)
+	}
+	custom := NewCustomStore(db, bucket, EnvelopeCodec{Codec: CompressedCodec{Codec: JSONCodec{}}, ID: 200})
+	if err := custom.Put("c", "value"); err != nil {
+		t.Fatal(err)
+	}
+	var v string
+	if err := s.Get("c", &v); !errors.Is(err, ErrUnknownCodec) {
+		t.Errorf("expected ErrUnknownCodec got %v", err)
+	}
+	RegisterCodec(200, CompressedCodec{Codec: JSONCodec{}})
+	if err := s.Get("c", &v); err != nil || v != "value" {
+		t.Errorf("unexpected value %q %v", v, err)
+	}
+}
+
+func TestPutWithCodec(t *testing.T) {
+	s := NewCustomStore(db, []byte("envelope_override"), EnvelopeCodec{Codec: JSONCodec{}})
+	defer s.DeleteAll()
+
+	blob := bytes.Repeat([]byte("blob "), 200)
+	if err := s.PutWithCodec("blob", blob, RawCodec{}); err != nil {
+		t.Fatal(err)
+	}
+	s.Put("small", MyType{FirstName: "Ann"})
+
+	var got []byte
+	if err := s.Get("blob", &got); err != nil || !bytes.Equal(got, blob) {
+		t.Errorf("unexpected blob %v", err)
+	}
+	var v MyType
+	if err := s.Get("small", &v); err != nil || v.FirstName != "Ann" {
+		t.Errorf("unexpected value %v %v", v, err)
+	}
+
+	NewBoltBackend(db).View(func(tx BackendTx) error {
+		b := s.bucket.get(tx)
+		if h, payload, _ := ParseEnvelope(b.Get([]byte("blob"))); h.Codec != RawCodecID || !bytes.Equal(payload, blob) {
+			t.Errorf("expected a raw blob got codec %d", h.Codec)
+		}
+		if h, _, _ := ParseEnvelope(b.Get([]byte("small"))); h.Codec != JSONCodecID {
+			t.Errorf("expected a json value got codec %d", h.Codec)
+		}
+		return nil
+	})
+
+	if err := s.PutWithCodec("c", blob, CompressedCodec{Codec: RawCodec{}}); !errors.Is(err, ErrUnknownCodec) {
+		t.Errorf("expected ErrUnknownCodec got %v", err)
+	}
+	if err := NewJSONStore(db, []byte("envelope_override")).PutWithCodec("c", blob, RawCodec{}); err != ErrNotEnvelopeCodec {
+		t.Errorf("expected ErrNotEnvelopeCodec got %v", err)
+	}
+}
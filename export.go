@@ -0,0 +1,246 @@
+package stow
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// ErrCodecMismatch indicates an exported object written with another codec than the store's.
+var ErrCodecMismatch = errors.New("exported object was written with another codec")
+
+// ExportRecord is the envelope of one object written by Export, each is written as a JSON
+// object on its own line (JSON Lines):
+//
+//	{"key":"YQ==","codec":"json","value":{"Name":"Ann"}}
+//	{"key":"Yg==","codec":"gob","value":"Dv+BAwEBBlBlcnNvbgH/ggAB..."}
+//
+// Key is the key as stored, base64 encoded. Codec names the codec the value was encoded
+// with: "json", "gob" or "xml" for JSONCodec, GobCodec and XMLCodec, "compressed/" followed
+// by the inner codec's name for a CompressedCodec, and the Go type of other codecs. Value
+// holds the encoded value, as the JSON document itself for the json codec so exports can be
+// read and diffed, and as a base64 encoded string for every other codec.
+type ExportRecord struct {
+	Key   []byte          `json:"key"`
+	Codec string          `json:"codec"`
+	Value json.RawMessage `json:"value"`
+}
+
+// Export writes every object of the store to w as JSON Lines, one ExportRecord per object,
+// in key order, from a single consistent transaction. Expired objects, nested stores and
+// hashes are left out, and so are the expiration times and index entries of the objects.
+// It returns the number of objects written.
+func (s *Store) Export(w io.Writer) (n int, err error) {
+	name := codecName(s.codec)
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	err = s.db.View(func(tx BackendTx) error {
+		objects := s.bucket.get(tx)
+		if objects == nil {
+			return nil
+		}
+		isExpired := s.expiryCheck(tx)
+		return objects.ForEach(func(k, v []byte) error {
+			if v == nil || isExpired(k) {
+				return nil
+			}
+			value, err := s.verifyChecksum(k, v)
+			if err == nil {
+				value, err = exportValue(name, value)
+			}
+			if err != nil {
+				return s.decodeError(k, v, err)
+			}
+			if err := enc.Encode(ExportRecord{Key: k, Codec: name, Value: value}); err != nil {
+				return err
+			}
+			n++
+			return nil
+		})
+	})
+	return n, err
+}
+
+// Import reads objects written by Export from r and puts them in the store, replacing
+// objects with the same keys. Values are stored as they were exported, so every record must
+// have been written with the store's codec, otherwise Import returns ErrCodecMismatch;
+// see ImportAs to switch codecs. Imported objects have no index entries, ImportAs computes
+// them. Objects are written in batches, each in its own transaction, and imports don't run
+// Hooks. It returns the number of objects imported, which are kept even if Import fails
+// on a later record.
+func (s *Store) Import(r io.Reader) (n int, err error) {
+	name := codecName(s.codec)
+	return s.importRecords(r, func(rec ExportRecord) (data []byte, val interface{}, err error) {
+		if rec.Codec != name {
+			return nil, nil, ErrCodecMismatch
+		}
+		data, err = importValue(rec)
+		return s.addChecksum(data), nil, err
+	})
+}
+
+// ExportBundle works like Export, but writes a bundle to w: the export is compressed and/or
+// encrypted as described by opts, see NewBundleWriter. Read it back with ImportBundle.
+func (s *Store) ExportBundle(w io.Writer, opts BundleOptions) (n int, err error) {
+	bw, err := NewBundleWriter(w, opts)
+	if err != nil {
+		return 0, err
+	}
+	n, err = s.Export(bw)
+	if closeErr := bw.Close(); err == nil {
+		err = closeErr
+	}
+	return n, err
+}
+
+// ImportBundle works like Import, reading the bundle written by ExportBundle from r. key is
+// the Key of the BundleOptions it was written with, nil if it isn't encrypted.
+func (s *Store) ImportBundle(r io.Reader, key []byte) (n int, err error) {
+	br, err := NewBundleReader(r, key)
+	if err != nil {
+		return 0, err
+	}
+	defer br.Close()
+	return s.Import(br)
+}
+
+// ImportAs works like Import, but decodes each value into a new value of the type of model
+// with the codec it was exported with, and encodes it with the store's Codec. Values of
+// codecs other than json, gob, xml and the store's own can't be decoded, and return
+// ErrCodecMismatch.
+func (s *Store) ImportAs(r io.Reader, model interface{}) (n int, err error) {
+	typ := reflect.TypeOf(model)
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	return s.importRecords(r, func(rec ExportRecord) (data []byte, val interface{}, err error) {
+		codec := s.exportedCodec(rec.Codec)
+		if codec == nil {
+			return nil, nil, ErrCodecMismatch
+		}
+		raw, err := importValue(rec)
+		if err != nil {
+			return nil, nil, err
+		}
+		val = reflect.New(typ).Interface()
+		if err := codec.NewDecoder(bytes.NewReader(raw)).Decode(val); err != nil {
+			return nil, nil, s.decodeError(rec.Key, raw, err)
+		}
+		data, err = s.marshalValue(val)
+		return data, val, err
+	})
+}
+
+// importRecords reads the records of r, converts each with convert and writes them in
+// batches of copyBatchSize.
+func (s *Store) importRecords(r io.Reader, convert func(rec ExportRecord) (data []byte, val interface{}, err error)) (n int, err error) {
+	defer s.forgetAll()
+
+	type imported struct {
+		key, data []byte
+		val       interface{}
+	}
+	dec := json.NewDecoder(r)
+	for line := 1; ; {
+		var batch []imported
+		for ; len(batch) < copyBatchSize; line++ {
+			var rec ExportRecord
+			if err = dec.Decode(&rec); err == io.EOF {
+				err = nil
+				break
+			}
+			if err == nil && len(rec.Key) == 0 {
+				err = bolt.ErrKeyRequired
+			}
+			var obj imported
+			if err == nil {
+				obj.data, obj.val, err = convert(rec)
+			}
+			if err != nil {
+				err = fmt.Errorf("export record %d: %w", line, err)
+				break
+			}
+			obj.key = rec.Key
+			batch = append(batch, obj)
+		}
+		if len(batch) == 0 {
+			return n, err
+		}
+
+		updateErr := s.update(func(tx BackendTx) error {
+			objects, err := s.bucket.createOrGet(tx)
+			if err != nil {
+				return err
+			}
+			for _, obj := range batch {
+				if err := s.writeKey(tx, objects, obj.key, obj.data, obj.val, 0); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if updateErr != nil {
+			return n, updateErr
+		}
+		n += len(batch)
+		if err != nil {
+			return n, err
+		}
+	}
+}
+
+// exportedCodec returns the codec for the codec name of an ExportRecord, or nil if unknown.
+func (s *Store) exportedCodec(name string) Codec {
+	switch name {
+	case codecName(s.codec):
+		return s.codec
+	case "json":
+		return JSONCodec{}
+	case "gob":
+		return GobCodec{}
+	case "xml":
+		return XMLCodec{}
+	}
+	return nil
+}
+
+// codecName returns the name of c in an ExportRecord.
+func codecName(c Codec) string {
+	switch c := c.(type) {
+	case JSONCodec:
+		return "json"
+	case GobCodec:
+		return "gob"
+	case XMLCodec:
+		return "xml"
+	case CompressedCodec:
+		return "compressed/" + codecName(c.Codec)
+	}
+	return fmt.Sprintf("%T", c)
+}
+
+// exportValue returns the Value of an ExportRecord for data, encoded with the codec named name.
+func exportValue(name string, data []byte) (json.RawMessage, error) {
+	if name == "json" {
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, data); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	return json.Marshal(data)
+}
+
+// importValue returns the encoded value held by rec.
+func importValue(rec ExportRecord) (data []byte, err error) {
+	if rec.Codec == "json" {
+		return append([]byte(nil), rec.Value...), nil
+	}
+	err = json.Unmarshal(rec.Value, &data)
+	return data, err
+}
@@ -0,0 +1,73 @@
+package stow
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExportImport(t *testing.T) {
+	s := NewJSONStore(db, []byte("export"))
+	defer s.DeleteAll()
+
+	s.Put("a", MyType{FirstName: "Ann"})
+	s.Put("b", MyType{FirstName: "Bob"})
+	s.PutTTL("expired", MyType{FirstName: "Old"}, time.Nanosecond)
+	time.Sleep(time.Millisecond)
+
+	var out bytes.Buffer
+	n, err := s.Export(&out)
+	if err != nil || n != 2 {
+		t.Fatalf("unexpected export %d %v", n, err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 || lines[0] != `{"key":"YQ==","codec":"json","value":{"first":"Ann","last":""}}` {
+		t.Errorf("unexpected export %q", lines)
+	}
+
+	imported := NewJSONStore(db, []byte("export-json"))
+	defer imported.DeleteAll()
+	if n, err := imported.Import(bytes.NewReader(out.Bytes())); err != nil || n != 2 {
+		t.Fatalf("unexpected import %d %v", n, err)
+	}
+	var v MyType
+	if err := imported.Get("b", &v); err != nil || v.FirstName != "Bob" {
+		t.Errorf("unexpected value %v %v", v, err)
+	}
+
+	gob := NewStore(db, []byte("export-gob"))
+	defer gob.DeleteAll()
+	if _, err := gob.Import(bytes.NewReader(out.Bytes())); !errors.Is(err, ErrCodecMismatch) {
+		t.Errorf("expected ErrCodecMismatch got %v", err)
+	}
+	if n, err := gob.ImportAs(bytes.NewReader(out.Bytes()), MyType{}); err != nil || n != 2 {
+		t.Fatalf("unexpected import %d %v", n, err)
+	}
+	v = MyType{}
+	if err := gob.Get("a", &v); err != nil || v.FirstName != "Ann" {
+		t.Errorf("unexpected value %v %v", v, err)
+	}
+
+	// Gob values round trip as base64.
+	out.Reset()
+	if _, err := gob.Export(&out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), `"codec":"gob","value":"`) {
+		t.Errorf("unexpected gob export %s", out.String())
+	}
+	gob.DeleteAll()
+	if n, err := gob.Import(&out); err != nil || n != 2 {
+		t.Fatalf("unexpected import %d %v", n, err)
+	}
+	v = MyType{}
+	if err := gob.Get("b", &v); err != nil || v.FirstName != "Bob" {
+		t.Errorf("unexpected value %v %v", v, err)
+	}
+
+	if _, err := imported.Import(strings.NewReader(`{"key":"","codec":"json","value":1}`)); err == nil {
+		t.Errorf("expected error importing an empty key")
+	}
+}
@@ -0,0 +1,60 @@
+package stow
+
+import (
+	"expvar"
+	"sync"
+	"time"
+)
+
+// expvarMu makes looking up or creating the map of WithExpvar atomic.
+var expvarMu sync.Mutex
+
+// WithExpvar publishes counters of the store's operations as the expvar.Map name, served by
+// expvar's /debug/vars handler:
+//
+//	puts, gets, deletes   operations which succeeded, Pull counts as a get and a delete
+//	misses                reads which found no object
+//	errors                operations which failed, misses excluded
+//	bytes_written         size of the encoded values written
+//	bytes_read            size of the encoded values read
+//
+// Stores given the same name share the counters. The operations counted are those recorded
+// by WithMetrics, which it can be combined with.
+func WithExpvar(name string) Option {
+	expvarMu.Lock()
+	defer expvarMu.Unlock()
+	m, ok := expvar.Get(name).(*expvar.Map)
+	if !ok {
+		m = expvar.NewMap(name)
+	}
+	return WithMetrics(expvarCollector{m})
+}
+
+type expvarCollector struct {
+	m *expvar.Map
+}
+
+func (c expvarCollector) Observe(bucket string, op Op, d time.Duration, size int, err error) {
+	switch {
+	case err == ErrNotFound:
+		c.m.Add("misses", 1)
+		return
+	case err != nil:
+		c.m.Add("errors", 1)
+		return
+	}
+	switch op {
+	case OpPut:
+		c.m.Add("puts", 1)
+		c.m.Add("bytes_written", int64(size))
+	case OpGet:
+		c.m.Add("gets", 1)
+		c.m.Add("bytes_read", int64(size))
+	case OpPull:
+		c.m.Add("gets", 1)
+		c.m.Add("deletes", 1)
+		c.m.Add("bytes_read", int64(size))
+	case OpDelete:
+		c.m.Add("deletes", 1)
+	}
+}
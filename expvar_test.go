@@ -0,0 +1,38 @@
+package stow
+
+import (
+	"expvar"
+	"testing"
+)
+
+func TestExpvar(t *testing.T) {
+	c := &recordingCollector{}
+	s := NewJSONStore(db, []byte("expvar"), WithExpvar("stow_expvar_test"), WithMetrics(c))
+	defer s.DeleteAll()
+
+	s.Put("a", MyType{FirstName: "Ann"})
+	var v MyType
+	s.Get("a", &v)
+	s.Get("missing", &v)
+	s.Pull("a", &v)
+
+	m := expvar.Get("stow_expvar_test").(*expvar.Map)
+	size := int64(len(`{"first":"Ann","last":""}` + "\n"))
+	for name, want := range map[string]int64{
+		"puts": 1, "gets": 2, "misses": 1, "deletes": 1,
+		"bytes_written": size, "bytes_read": 2 * size,
+	} {
+		if got := m.Get(name).(*expvar.Int).Value(); got != want {
+			t.Errorf("%s: expected %d got %d", name, want, got)
+		}
+	}
+	if len(c.obs) != 4 {
+		t.Errorf("expected the other collector to record 4 operations got %d", len(c.obs))
+	}
+
+	// Another store with the same name shares the counters.
+	NewJSONStore(db, []byte("expvar"), WithExpvar("stow_expvar_test")).Put("b", MyType{})
+	if got := m.Get("puts").(*expvar.Int).Value(); got != 2 {
+		t.Errorf("expected shared counters, got %d puts", got)
+	}
+}
@@ -0,0 +1,65 @@
+package stow
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"reflect"
+)
+
+type fallbackCodec struct {
+	primary   Codec
+	fallbacks []Codec
+}
+
+// FallbackCodec returns a Codec which encodes with primary, and decodes with primary too
+// unless that fails, then it tries each of fallbacks in turn. The value being decoded is
+// reset to its zero value before each attempt. Use it to migrate a store between codecs
+// gradually: old values stay readable with the old codec as a fallback, and each one is
+// written with the new codec the next time it's put (or all at once with Recode).
+// If every codec fails, the error of primary is returned.
+//
+// Beware that a fallback is only tried when primary returns an error, pick the codecs so
+// that one can't silently decode the values of another.
+func FallbackCodec(primary Codec, fallbacks ...Codec) Codec {
+	return fallbackCodec{primary: primary, fallbacks: fallbacks}
+}
+
+func (c fallbackCodec) NewEncoder(w io.Writer) Encoder {
+	return c.primary.NewEncoder(w)
+}
+
+func (c fallbackCodec) NewDecoder(r io.Reader) Decoder {
+	return fallbackDecoder{c: c, r: r}
+}
+
+type fallbackDecoder struct {
+	c fallbackCodec
+	r io.Reader
+}
+
+func (d fallbackDecoder) Decode(v interface{}) error {
+	data, err := ioutil.ReadAll(d.r)
+	if err != nil {
+		return err
+	}
+
+	err = d.c.primary.NewDecoder(bytes.NewReader(data)).Decode(v)
+	if err == nil {
+		return nil
+	}
+	for _, fallback := range d.c.fallbacks {
+		resetValue(v)
+		if fallback.NewDecoder(bytes.NewReader(data)).Decode(v) == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// resetValue sets the value v points to to its zero value.
+func resetValue(v interface{}) {
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv.Elem().Set(reflect.Zero(rv.Elem().Type()))
+	}
+}
@@ -0,0 +1,31 @@
+package stow
+
+import "testing"
+
+func TestFallbackCodec(t *testing.T) {
+	testStore(t, NewCustomStore(db, []byte("fallback"), FallbackCodec(JSONCodec{}, GobCodec{})))
+
+	bucket := []byte("fallback_migrate")
+	defer NewStore(db, bucket).DeleteAll()
+	NewStore(db, bucket).Put("old", MyType{FirstName: "Old", LastName: "Gob"})
+
+	s := NewCustomStore(db, bucket, FallbackCodec(JSONCodec{}, GobCodec{}))
+	s.Put("new", MyType{FirstName: "New"})
+
+	for key, name := range map[string]string{"old": "Old", "new": "New"} {
+		var v MyType
+		if err := s.Get(key, &v); err != nil || v.FirstName != name {
+			t.Errorf("unexpected value for %s %v %v", key, v, err)
+		}
+	}
+
+	var raw map[string]string
+	if err := NewJSONStore(db, bucket).Get("new", &raw); err != nil || raw["first"] != "New" {
+		t.Errorf("expected new values to use the primary codec got %v %v", raw, err)
+	}
+
+	var v MyType
+	if err := NewCustomStore(db, bucket, FallbackCodec(XMLCodec{})).Get("new", &v); err == nil {
+		t.Errorf("expected error when no codec decodes the value")
+	}
+}
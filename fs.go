@@ -0,0 +1,216 @@
+package stow
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FSOptions maps between keys and file paths for AsFS. Paths are slash-separated as
+// described by fs.ValidPath, and slashes in paths make directories.
+type FSOptions struct {
+	// Path returns the path of the file for key, or false to leave key out of the FS.
+	// If nil, the key itself is used when it's a valid path.
+	Path func(key []byte) (name string, ok bool)
+
+	// Key returns the key of the file at path name, it must reverse Path.
+	// If nil, the path itself is used as the key.
+	Key func(name string) []byte
+}
+
+// AsFS presents store as a read-only fs.FS, in which each object is a file holding its
+// encoded value, so tooling which consumes an fs.FS (template loaders, http.FS, ...) can
+// read straight from the store. With a JSON store the files hold JSON. Nested stores,
+// hashes and expired objects are left out, and a key whose path is also a directory
+// (like "a" with "a/b") hides that directory.
+//
+// Opening a file reads it in its own transaction, listing a directory scans the store.
+func AsFS(store *Store, opts FSOptions) fs.FS {
+	if opts.Path == nil {
+		opts.Path = func(key []byte) (string, bool) {
+			name := string(key)
+			return name, fs.ValidPath(name) && name != "."
+		}
+	}
+	if opts.Key == nil {
+		opts.Key = func(name string) []byte { return []byte(name) }
+	}
+	return storeFS{store: store, opts: opts}
+}
+
+type storeFS struct {
+	store *Store
+	opts  FSOptions
+}
+
+func (f storeFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	if name != "." {
+		data, ok, err := f.readFile(name)
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		if ok {
+			return &fsFile{info: fsInfo{name: pathBase(name), size: int64(len(data))}, Reader: bytes.NewReader(data)}, nil
+		}
+	}
+
+	entries, ok, err := f.readDir(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return &fsDir{info: fsInfo{name: pathBase(name), dir: true}, entries: entries}, nil
+}
+
+// readFile reads the object of the file at name, ok is false if there's none.
+func (f storeFS) readFile(name string) (data []byte, ok bool, err error) {
+	key := f.opts.Key(name)
+	if key == nil {
+		return nil, false, nil
+	}
+	if path, ok := f.opts.Path(key); !ok || path != name {
+		return nil, false, nil
+	}
+
+	s := f.store
+	err = s.db.View(func(tx BackendTx) error {
+		objects := s.bucket.get(tx)
+		if objects == nil {
+			return nil
+		}
+		v := objects.Get(key)
+		if v == nil || s.expiryCheck(tx)(key) {
+			return nil
+		}
+		value, err := s.verifyChecksum(key, v)
+		if err != nil {
+			return err
+		}
+		data, ok = append([]byte{}, value...), true
+		return nil
+	})
+	return data, ok, err
+}
+
+// readDir lists the directory at name, sorted by name. ok is false if it doesn't exist.
+func (f storeFS) readDir(name string) (entries []fs.DirEntry, ok bool, err error) {
+	prefix := ""
+	if name != "." {
+		prefix = name + "/"
+	}
+
+	byName := make(map[string]fs.DirEntry)
+	s := f.store
+	err = s.db.View(func(tx BackendTx) error {
+		objects := s.bucket.get(tx)
+		if objects == nil {
+			return nil
+		}
+		isExpired := s.expiryCheck(tx)
+		return objects.ForEach(func(k, v []byte) error {
+			if v == nil || isExpired(k) {
+				return nil
+			}
+			path, valid := f.opts.Path(k)
+			if !valid || !strings.HasPrefix(path, prefix) {
+				return nil
+			}
+			ok = true
+
+			rest := path[len(prefix):]
+			if i := strings.IndexByte(rest, '/'); i >= 0 {
+				if _, seen := byName[rest[:i]]; !seen {
+					byName[rest[:i]] = fsInfo{name: rest[:i], dir: true}
+				}
+				return nil
+			}
+			size := len(v)
+			if s.opts.checksums {
+				size -= checksumSize
+			}
+			byName[rest] = fsInfo{name: rest, size: int64(size)}
+			return nil
+		})
+	})
+	if err != nil || (!ok && name != ".") {
+		return nil, false, err
+	}
+
+	for _, e := range byName {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, true, nil
+}
+
+func pathBase(name string) string {
+	if i := strings.LastIndexByte(name, '/'); i >= 0 {
+		return name[i+1:]
+	}
+	return name
+}
+
+// fsInfo implements both fs.FileInfo and fs.DirEntry.
+type fsInfo struct {
+	name string
+	size int64
+	dir  bool
+}
+
+func (i fsInfo) Name() string       { return i.name }
+func (i fsInfo) Size() int64        { return i.size }
+func (i fsInfo) ModTime() time.Time { return time.Time{} }
+func (i fsInfo) IsDir() bool        { return i.dir }
+func (i fsInfo) Sys() interface{}   { return nil }
+
+func (i fsInfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+func (i fsInfo) Type() fs.FileMode          { return i.Mode().Type() }
+func (i fsInfo) Info() (fs.FileInfo, error) { return i, nil }
+
+type fsFile struct {
+	info fsInfo
+	*bytes.Reader
+}
+
+func (f *fsFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *fsFile) Close() error               { return nil }
+
+type fsDir struct {
+	info    fsInfo
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *fsDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *fsDir) Close() error               { return nil }
+
+func (d *fsDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: fs.ErrInvalid}
+}
+
+func (d *fsDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	rest := d.entries[d.offset:]
+	if n > 0 && len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if n > 0 && n < len(rest) {
+		rest = rest[:n]
+	}
+	d.offset += len(rest)
+	return rest, nil
+}
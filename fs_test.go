@@ -0,0 +1,45 @@
+package stow
+
+import (
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestAsFS(t *testing.T) {
+	s := NewJSONStore(db, []byte("as_fs"))
+	defer s.DeleteAll()
+
+	s.Put("index.html", "home")
+	s.Put("templates/a.tmpl", "a")
+	s.Put("templates/nested/b.tmpl", "b")
+	s.Put("/invalid", "hidden")
+	s.NewNestedStore([]byte("child")).Put("c", "c")
+
+	fsys := AsFS(s, FSOptions{})
+	if err := fstest.TestFS(fsys, "index.html", "templates/a.tmpl", "templates/nested/b.tmpl"); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := fs.ReadFile(fsys, "index.html")
+	if err != nil || strings.TrimSpace(string(data)) != `"home"` {
+		t.Errorf("unexpected file contents %s %v", data, err)
+	}
+	if _, err := fs.Stat(fsys, "child"); err == nil {
+		t.Errorf("nested stores should not be in the FS")
+	}
+
+	mapped := AsFS(s, FSOptions{
+		Path: func(key []byte) (string, bool) {
+			return strings.TrimPrefix(string(key), "templates/"), strings.HasPrefix(string(key), "templates/")
+		},
+		Key: func(name string) []byte { return []byte("templates/" + name) },
+	})
+	if err := fstest.TestFS(mapped, "a.tmpl", "nested/b.tmpl"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Stat(mapped, "index.html"); err == nil {
+		t.Errorf("unmapped keys should not be in the FS")
+	}
+}
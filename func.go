@@ -17,6 +17,11 @@ type funcCall struct {
 
 	isValPtr bool
 	valType  reflect.Type
+
+	// quarantine makes call skip values which can't be decoded and collect them in bad,
+	// see WithQuarantine.
+	quarantine bool
+	bad        []QuarantinedEntry
 }
 
 func newFuncCall(s *Store, fn interface{}) (fc funcCall, err error) {
@@ -57,6 +62,7 @@ func (fc *funcCall) setValue(typ reflect.Type) {
 }
 
 func (fc *funcCall) getKey(v []byte) (key reflect.Value, err error) {
+	v = fc.s.trimNS(v)
 	if fc.keyType.Kind() == reflect.String {
 		return reflect.ValueOf(string(v)), nil
 	} else if fc.keyType.Kind() == reflect.Slice && fc.keyType.Elem().Kind() == reflect.Uint8 {
@@ -65,7 +71,7 @@ func (fc *funcCall) getKey(v []byte) (key reflect.Value, err error) {
 
 	key = reflect.New(fc.keyType)
 
-	if err := fc.s.unmarshal(v, key.Interface()); err != nil {
+	if err := fc.s.unmarshalKey(v, key.Interface()); err != nil {
 		return key, err
 	}
 
@@ -76,10 +82,13 @@ func (fc *funcCall) getKey(v []byte) (key reflect.Value, err error) {
 	return key, err
 }
 
-func (fc *funcCall) getValue(v []byte) (val reflect.Value, err error) {
+func (fc *funcCall) getValue(k, v []byte) (val reflect.Value, err error) {
 	val = reflect.New(fc.valType)
 
-	if err := fc.s.unmarshal(v, val.Interface()); err != nil {
+	if err := fc.s.checkDecodeSize(v); err != nil {
+		return val, err
+	}
+	if err := fc.s.unmarshalValue(k, v, val.Interface()); err != nil {
 		return val, err
 	}
 
@@ -91,22 +100,37 @@ func (fc *funcCall) getValue(v []byte) (val reflect.Value, err error) {
 }
 
 func (fc *funcCall) call(k, v []byte) error {
-	val, err := fc.getValue(v)
+	args, err := fc.args(k, v)
+	if args != nil {
+		fc.Value.Call(args)
+	}
+	return err
+}
+
+// args decodes k and v into the arguments of the func. They're nil if the value was
+// quarantined instead, see WithQuarantine.
+func (fc *funcCall) args(k, v []byte) ([]reflect.Value, error) {
+	val, err := fc.getValue(k, v)
 	if err != nil {
-		return err
+		if fc.quarantine && quarantinable(err) {
+			fc.bad = append(fc.bad, newQuarantinedEntry(k, v, err))
+			return nil, nil
+		}
+		if err == ErrTooLarge {
+			return nil, fc.s.keyError(k, err)
+		}
+		return nil, fc.s.decodeError(k, v, err)
 	}
 
 	if !fc.hasKey {
-		fc.Value.Call([]reflect.Value{val})
-		return nil
+		return []reflect.Value{val}, nil
 	}
 
 	key, err := fc.getKey(k)
 	if err != nil {
-		return err
+		return nil, fc.s.keyError(k, err)
 	}
-	fc.Value.Call([]reflect.Value{key, val})
-	return nil
+	return []reflect.Value{key, val}, nil
 }
 
 func deref(val reflect.Value) reflect.Value {
@@ -0,0 +1,49 @@
+package stow
+
+import (
+	"bytes"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// GatherKey looks up key in every bucket of db, at any depth, in a single transaction, and
+// calls do with the path of each bucket which holds it along with its raw (encoded) value.
+// This answers questions like "which tenants have this object" when each tenant has its
+// own nested store. Expired objects and stow's own metadata are skipped. bucketPath and raw
+// are only valid until do returns, and a non-nil error from do stops the search.
+func GatherKey(db *bolt.DB, key []byte, do func(bucketPath [][]byte, raw []byte) error) error {
+	return boltBackend{db}.View(func(tx BackendTx) error {
+		return tx.ForEach(func(name []byte, b BackendBucket) error {
+			if bytes.Equal(name, metaBucketName) {
+				return nil
+			}
+			return gatherKey(tx, bucketSpec{name}, b, key, do)
+		})
+	})
+}
+
+// GatherKey works like the package level GatherKey, but only searches this store's bucket and
+// the buckets of its nested stores. Paths passed to do are still relative to the root of the db.
+func (s *Store) GatherKey(key []byte, do func(bucketPath [][]byte, raw []byte) error) error {
+	return s.db.View(func(tx BackendTx) error {
+		objects := s.bucket.get(tx)
+		if objects == nil {
+			return nil
+		}
+		return gatherKey(tx, s.bucket, objects, key, do)
+	})
+}
+
+func gatherKey(tx BackendTx, bs bucketSpec, b BackendBucket, key []byte, do func([][]byte, []byte) error) error {
+	if raw := b.Get(key); raw != nil && !bs.expiryCheck(tx)(key) {
+		if err := do(bs, raw); err != nil {
+			return err
+		}
+	}
+	return b.ForEach(func(k, v []byte) error {
+		if v != nil {
+			return nil
+		}
+		return gatherKey(tx, bs.child(k), b.Bucket(k), key, do)
+	})
+}
@@ -0,0 +1,50 @@
+package stow
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestGatherKey(t *testing.T) {
+	s := NewJSONStore(db, []byte("gather"))
+	defer s.DeleteAll()
+	other := NewJSONStore(db, []byte("gather-other"))
+	defer other.DeleteAll()
+
+	key := []byte("gather-key")
+	s.Put(key, "root")
+	s.NewNestedStore([]byte("tenant-a")).Put(key, "a")
+	s.NewNestedStore([]byte("tenant-b")).Put("unrelated", "b")
+	s.NewNestedStore([]byte("tenant-c")).NewNestedStore([]byte("deep")).Put(key, "c")
+	s.NewNestedStore([]byte("tenant-d")).PutTTL(key, "expired", time.Millisecond)
+	other.Put(key, "other")
+	time.Sleep(5 * time.Millisecond)
+
+	var found []string
+	err := s.GatherKey(key, func(path [][]byte, raw []byte) error {
+		found = append(found, string(bytes.Join(path, []byte("/")))+"="+string(bytes.TrimSpace(raw)))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{`gather="root"`, `gather/tenant-a="a"`, `gather/tenant-c/deep="c"`}
+	if len(found) != len(expected) {
+		t.Fatalf("unexpected results %q", found)
+	}
+	for i := range expected {
+		if found[i] != expected[i] {
+			t.Errorf("result %d was %q, expected %q", i, found[i], expected[i])
+		}
+	}
+
+	found = nil
+	GatherKey(db, key, func(path [][]byte, raw []byte) error {
+		found = append(found, string(path[0]))
+		return nil
+	})
+	if len(found) != 4 || found[3] != "gather-other" {
+		t.Errorf("unexpected results across the db %q", found)
+	}
+}
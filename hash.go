@@ -0,0 +1,135 @@
+package stow
+
+// Hashes are stored as a nested bucket at their key in the store's bucket, with one key per
+// field, so updating a field doesn't rewrite the others. Like nested stores they share the
+// key space of the store's objects, and are skipped by ForEach. Hash fields aren't objects:
+// they don't expire, aren't indexed and don't run Hooks.
+
+// HSet stores v as the field "field" of the hash at key "key", creating the hash if needed.
+// Keys and fields are encoded like the keys passed to Put.
+func (s *Store) HSet(key, field interface{}, v interface{}) error {
+	keyBytes, fieldBytes, err := s.hashKeys(key, field)
+	if err != nil {
+		return err
+	}
+	buf, err := s.encodeValue(v)
+	defer releaseBuffer(buf)
+	if err != nil {
+		return err
+	}
+	data := buf.Bytes()
+
+	return s.update(func(tx BackendTx) error {
+		hash, err := s.bucket.child(keyBytes).createOrGet(tx)
+		if err != nil {
+			return err
+		}
+		if err := hash.Put(fieldBytes, data); err != nil {
+			return writeError(s.bucket.child(keyBytes), fieldBytes, err)
+		}
+		return nil
+	})
+}
+
+// HGet retrieves the field "field" of the hash at key "key" into v. It returns ErrNotFound
+// if there's no such hash or field.
+func (s *Store) HGet(key, field interface{}, v interface{}) error {
+	keyBytes, fieldBytes, err := s.hashKeys(key, field)
+	if err != nil {
+		return err
+	}
+
+	var data []byte
+	err = s.db.View(func(tx BackendTx) error {
+		hash := s.bucket.child(keyBytes).get(tx)
+		if hash == nil {
+			return ErrNotFound
+		}
+		value := hash.Get(fieldBytes)
+		if value == nil {
+			return ErrNotFound
+		}
+		if err := s.checkDecodeSize(value); err != nil {
+			return err
+		}
+		data = append(data, value...)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return s.unmarshalValue(fieldBytes, data, v)
+}
+
+// HDel removes the field "field" from the hash at key "key".
+// It returns nil if the field was not found (like Delete).
+func (s *Store) HDel(key, field interface{}) error {
+	keyBytes, fieldBytes, err := s.hashKeys(key, field)
+	if err != nil {
+		return err
+	}
+
+	return s.update(func(tx BackendTx) error {
+		hash := s.bucket.child(keyBytes).get(tx)
+		if hash == nil {
+			return nil
+		}
+		return hash.Delete(fieldBytes)
+	})
+}
+
+// HClear removes the hash at key "key", with all of its fields.
+// It returns nil if the hash was not found (like Delete).
+func (s *Store) HClear(key interface{}) error {
+	keyBytes, err := s.toBytes(key)
+	if err != nil {
+		return err
+	}
+
+	return s.update(func(tx BackendTx) error {
+		hash := s.bucket.child(keyBytes)
+		if hash.get(tx) == nil {
+			return nil
+		}
+		if err := hash.delete(tx); err != nil {
+			return err
+		}
+		return hash.meta().deleteIfExists(tx)
+	})
+}
+
+// HForEach runs do on each field of the hash at key "key", in the order of their encoded
+// fields. do takes the same params as for ForEach, with the field in place of the key.
+func (s *Store) HForEach(key interface{}, do interface{}) error {
+	keyBytes, err := s.toBytes(key)
+	if err != nil {
+		return err
+	}
+	fc, err := newFuncCall(s, do)
+	if err != nil {
+		return err
+	}
+
+	return s.db.View(func(tx BackendTx) error {
+		hash := s.bucket.child(keyBytes).get(tx)
+		if hash == nil {
+			return nil
+		}
+		return hash.ForEach(func(k, v []byte) error {
+			if v == nil {
+				return nil
+			}
+			return fc.call(k, v)
+		})
+	})
+}
+
+func (s *Store) hashKeys(key, field interface{}) (keyBytes, fieldBytes []byte, err error) {
+	if keyBytes, err = s.toBytes(key); err != nil {
+		return nil, nil, err
+	}
+	if fieldBytes, err = s.encodeKey(field); err != nil {
+		return nil, nil, err
+	}
+	return keyBytes, fieldBytes, nil
+}
@@ -0,0 +1,54 @@
+package stow
+
+import "testing"
+
+func TestHash(t *testing.T) {
+	s := NewJSONStore(db, []byte("hash"))
+	defer s.DeleteAll()
+
+	s.Put("object", "value")
+	if err := s.HSet("settings/1", "theme", "dark"); err != nil {
+		t.Fatal(err)
+	}
+	s.HSet("settings/1", "lang", "en")
+	s.HSet("settings/1", "theme", "light")
+
+	var v string
+	if err := s.HGet("settings/1", "theme", &v); err != nil || v != "light" {
+		t.Errorf("unexpected HGet %q %v", v, err)
+	}
+	if err := s.HGet("settings/1", "missing", &v); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound for missing field, got %v", err)
+	}
+	if err := s.HGet("settings/2", "theme", &v); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound for missing hash, got %v", err)
+	}
+
+	fields := map[string]string{}
+	s.HForEach("settings/1", func(field string, v string) {
+		fields[field] = v
+	})
+	if len(fields) != 2 || fields["lang"] != "en" {
+		t.Errorf("unexpected fields %v", fields)
+	}
+
+	// Hashes don't show up as objects.
+	count := 0
+	if err := s.ForEach(func(v string) { count++ }); err != nil || count != 1 {
+		t.Errorf("expected ForEach to skip hashes: %d %v", count, err)
+	}
+
+	s.HDel("settings/1", "lang")
+	if err := s.HGet("settings/1", "lang", &v); err != ErrNotFound {
+		t.Errorf("expected HDel to remove the field, got %v", err)
+	}
+	if err := s.HClear("settings/1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.HGet("settings/1", "theme", &v); err != ErrNotFound {
+		t.Errorf("expected HClear to remove the hash, got %v", err)
+	}
+	if err := s.HClear("settings/1"); err != nil {
+		t.Errorf("expected HClear of a missing hash to succeed, got %v", err)
+	}
+}
@@ -0,0 +1,63 @@
+package stow
+
+import (
+	"bytes"
+)
+
+// PrefixCount is the usage of the keys which share Prefix, see PrefixHistogram.
+type PrefixCount struct {
+	Prefix     []byte
+	Count      int   // number of objects
+	KeyBytes   int64 // total size of their keys
+	ValueBytes int64 // total size of their encoded values
+}
+
+// PrefixHistogram groups the objects of the store by key prefix, and returns the usage of
+// each group in key order, to show which namespaces dominate a bucket. With a delimiter,
+// keys are grouped by their first depth parts (the prefix keeps the delimiter it ends at,
+// "user:1:name" is in "user:1:" for ":" and depth 2), without one they're grouped by their
+// first depth bytes. Keys with fewer parts make a group of their own.
+// Expired objects still take space and are counted, nested stores and hashes are not.
+func (s *Store) PrefixHistogram(delim []byte, depth int) (counts []PrefixCount, err error) {
+	err = s.db.View(func(tx BackendTx) error {
+		objects := s.bucket.get(tx)
+		if objects == nil {
+			return nil
+		}
+		return objects.ForEach(func(k, v []byte) error {
+			if v == nil {
+				return nil
+			}
+			prefix := keyPrefix(k, delim, depth)
+			// Keys are visited in order, so each group is contiguous.
+			if n := len(counts); n == 0 || !bytes.Equal(counts[n-1].Prefix, prefix) {
+				counts = append(counts, PrefixCount{Prefix: append([]byte(nil), prefix...)})
+			}
+			c := &counts[len(counts)-1]
+			c.Count++
+			c.KeyBytes += int64(len(k))
+			c.ValueBytes += int64(len(v))
+			return nil
+		})
+	})
+	return counts, err
+}
+
+// keyPrefix returns the first depth parts of key split at delim, or its first depth bytes.
+func keyPrefix(key, delim []byte, depth int) []byte {
+	if len(delim) == 0 {
+		if depth < len(key) {
+			return key[:depth]
+		}
+		return key
+	}
+	end := 0
+	for i := 0; i < depth; i++ {
+		j := bytes.Index(key[end:], delim)
+		if j < 0 {
+			return key
+		}
+		end += j + len(delim)
+	}
+	return key[:end]
+}
@@ -0,0 +1,50 @@
+package stow
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestPrefixHistogram(t *testing.T) {
+	s := NewJSONStore(db, []byte("prefix_histogram"))
+	defer s.DeleteAll()
+
+	s.Put("order:1", 1)
+	s.Put("order:2", 2)
+	s.Put("user:1:name", "a")
+	s.Put("user:1:email", "b")
+	s.Put("user:2:name", "c")
+	s.Put("version", 1)
+	s.NewNestedStore([]byte("user:nested")).Put("x", 1)
+
+	counts, err := s.PrefixHistogram([]byte(":"), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := histogramString(counts); got != "order:=2 user:=3 version=1" {
+		t.Errorf("unexpected histogram %s", got)
+	}
+	if counts[0].KeyBytes != 14 {
+		t.Errorf("unexpected key bytes %d", counts[0].KeyBytes)
+	}
+
+	counts, _ = s.PrefixHistogram([]byte(":"), 2)
+	if got := histogramString(counts); got != "order:1=1 order:2=1 user:1:=2 user:2:=1 version=1" {
+		t.Errorf("unexpected histogram %s", got)
+	}
+
+	counts, _ = s.PrefixHistogram(nil, 2)
+	if got := histogramString(counts); got != "or=2 us=3 ve=1" {
+		t.Errorf("unexpected histogram %s", got)
+	}
+}
+
+func histogramString(counts []PrefixCount) (s string) {
+	for i, c := range counts {
+		if i > 0 {
+			s += " "
+		}
+		s += fmt.Sprintf("%s=%d", c.Prefix, c.Count)
+	}
+	return s
+}
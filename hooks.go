@@ -0,0 +1,79 @@
+package stow
+
+// Hooks are funcs run around Store operations, so applications can add validation,
+// auditing, metrics or cache invalidation. Any of them may be nil. An error returned
+// by a Before hook aborts the operation and is returned to the caller.
+// Keys are passed as the bytes they are stored as. Hash fields (see HSet) don't run hooks.
+type Hooks struct {
+	// BeforePut runs before an object is written, by Put or any other write.
+	BeforePut func(key []byte, val interface{}) error
+	// AfterPut runs after an object was written, err is the result of the write.
+	AfterPut func(key []byte, val interface{}, err error)
+	// AfterGet runs after an object was read by Get or Pull, err is the result of the read.
+	AfterGet func(key []byte, val interface{}, err error)
+	// BeforeDelete runs before an object is removed by Delete or Pull.
+	BeforeDelete func(key []byte) error
+	// AfterDelete runs after an object was removed, err is the result of the delete.
+	AfterDelete func(key []byte, err error)
+}
+
+// WithHooks registers hooks to run around the Store's operations.
+// It can be given more than once, hooks run in the order they were registered.
+func WithHooks(h Hooks) Option {
+	return func(s *Store) {
+		hooks := s.opts.hooks
+		s.opts.hooks = append(hooks[:len(hooks):len(hooks)], h)
+	}
+}
+
+func (s *Store) beforePut(key []byte, val interface{}) error {
+	for _, h := range s.opts.hooks {
+		if h.BeforePut != nil {
+			if err := h.BeforePut(key, val); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Store) afterPut(key []byte, val interface{}, err error) {
+	s.stats.put(err)
+	for _, h := range s.opts.hooks {
+		if h.AfterPut != nil {
+			h.AfterPut(key, val, err)
+		}
+	}
+}
+
+func (s *Store) afterGet(key []byte, val interface{}, err error) {
+	s.stats.get(err)
+	if s.opts.adaptive != nil && err == nil {
+		s.access.record(key)
+	}
+	for _, h := range s.opts.hooks {
+		if h.AfterGet != nil {
+			h.AfterGet(key, val, err)
+		}
+	}
+}
+
+func (s *Store) beforeDelete(key []byte) error {
+	for _, h := range s.opts.hooks {
+		if h.BeforeDelete != nil {
+			if err := h.BeforeDelete(key); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Store) afterDelete(key []byte, err error) {
+	s.stats.delete(err)
+	for _, h := range s.opts.hooks {
+		if h.AfterDelete != nil {
+			h.AfterDelete(key, err)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package stow
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestHooks(t *testing.T) {
+	var events []string
+	invalid := errors.New("invalid")
+
+	s := NewJSONStore(db, []byte("hooks"), WithHooks(Hooks{
+		BeforePut: func(key []byte, val interface{}) error {
+			if val == "bad" {
+				return invalid
+			}
+			events = append(events, "before put "+string(key))
+			return nil
+		},
+		AfterPut: func(key []byte, val interface{}, err error) {
+			events = append(events, "after put "+string(key))
+		},
+		AfterGet: func(key []byte, val interface{}, err error) {
+			if err == nil {
+				events = append(events, "after get "+string(key))
+			} else {
+				events = append(events, "miss "+string(key))
+			}
+		},
+		BeforeDelete: func(key []byte) error {
+			events = append(events, "before delete "+string(key))
+			return nil
+		},
+		AfterDelete: func(key []byte, err error) {
+			events = append(events, "after delete "+string(key))
+		},
+	}), WithHooks(Hooks{
+		AfterPut: func(key []byte, val interface{}, err error) {
+			events = append(events, "after put again "+string(key))
+		},
+	}))
+	defer s.DeleteAll()
+
+	if err := s.Put("a", "bad"); err != invalid {
+		t.Errorf("expected BeforePut error to abort Put: %v", err)
+	}
+	if has, _ := s.Has("a"); has {
+		t.Errorf("aborted Put should not write")
+	}
+
+	var v string
+	s.Put("a", "good")
+	s.Get("a", &v)
+	s.Get("b", &v)
+	s.Pull("a", &v)
+	s.Delete("a")
+
+	expected := []string{
+		"before put a", "after put a", "after put again a",
+		"after get a", "miss b",
+		"before delete a", "after get a", "after delete a",
+		"before delete a", "after delete a",
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("unexpected events %q", events)
+	}
+	for i := range events {
+		if events[i] != expected[i] {
+			t.Errorf("event %d was %q, expected %q", i, events[i], expected[i])
+		}
+	}
+}
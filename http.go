@@ -0,0 +1,481 @@
+package stow
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// ErrETagMismatch indicates a conditional write whose ETag no longer matched the object.
+var ErrETagMismatch = errors.New("object changed: etag mismatch")
+
+const (
+	// httpMaxBody is the size of the largest value the HTTP handler accepts.
+	httpMaxBody = 32 << 20
+
+	// httpDefaultLimit and httpMaxLimit bound the number of objects listed at once.
+	httpDefaultLimit = 100
+	httpMaxLimit     = 1000
+)
+
+// HTTPItem is an object listed by the handler returned by NewHTTPHandler. Key is the key as
+// text, which JSON can't hold if it isn't valid UTF-8: KeyBytes is the key as stored, base64
+// encoded like the key of an ExportRecord.
+type HTTPItem struct {
+	Key      string          `json:"key"`
+	KeyBytes []byte          `json:"key_b64"`
+	ETag     string          `json:"etag"`
+	Value    json.RawMessage `json:"value"`
+}
+
+// HTTPList is a page of objects listed by the handler returned by NewHTTPHandler. Next is
+// the key to list after for the next page, empty on the last page, and NextBytes the same
+// key as stored, like HTTPItem.KeyBytes.
+type HTTPList struct {
+	Items     []HTTPItem `json:"items"`
+	Next      string     `json:"next,omitempty"`
+	NextBytes []byte     `json:"next_b64,omitempty"`
+}
+
+// NewHTTPHandler returns a handler exposing the objects of store, as an admin or debugging
+// API. The path under the handler is the key: mount it with http.StripPrefix.
+//
+//	GET    /key   returns the object, 404 if there's none
+//	PUT    /key   stores the request body as the object
+//	DELETE /key   removes the object
+//	GET    /      lists objects in key order, with the query parameters prefix (keys which
+//	              start with it), after (keys after it) and limit (at most 1000, 100 by default)
+//
+// Bodies hold values as JSON, as in an ExportRecord: the JSON document itself for a store
+// with the JSONCodec, and a base64 encoded string of the encoded value for other codecs.
+// Responses carry the ETag of the object, which GET honors in If-None-Match and PUT and
+// DELETE in If-Match (or If-None-Match: * to only create), replying 412 if it changed.
+// Keys are within the store's namespace, see Store.Namespace.
+//
+// Like Import, PUT stores values as they are given, without running Hooks. Since it doesn't
+// decode them it can't compute their index entries: it replies 409 on a store which keeps
+// indexes, see NewHTTPHandlerAs.
+func NewHTTPHandler(store *Store) http.Handler {
+	return httpHandler{s: store, codec: codecName(store.codec)}
+}
+
+// NewHTTPHandlerAs works like NewHTTPHandler, but decodes the value of each PUT into a new
+// value of the type of model with the store's Codec, like ImportAs, so its index entries are
+// kept. PUT replies 400 if the value can't be decoded.
+func NewHTTPHandlerAs(store *Store, model interface{}) http.Handler {
+	typ := reflect.TypeOf(model)
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	return httpHandler{s: store, codec: codecName(store.codec), model: typ}
+}
+
+type httpHandler struct {
+	s     *Store
+	codec string
+	// model is the type PUT decodes values into, nil if it doesn't.
+	model reflect.Type
+}
+
+var (
+	// errPrecondition is returned from a transaction to reply 412.
+	errPrecondition = errors.New("precondition failed")
+	// errIndexed is returned from a PUT which can't keep the store's indexes, to reply 409.
+	errIndexed = errors.New("store has indexes: values must be decoded, see NewHTTPHandlerAs")
+)
+
+func (h httpHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/")
+	if key == "" {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		h.list(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		h.get(w, r, h.s.nsKey([]byte(key)))
+	case http.MethodPut:
+		h.put(w, r, h.s.nsKey([]byte(key)))
+	case http.MethodDelete:
+		h.delete(w, r, h.s.nsKey([]byte(key)))
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// etag returns the ETag of an object with the value data, as stored.
+func etag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// current returns the stored value of key, or nil if there's none or it expired.
+func (h httpHandler) current(tx BackendTx, key []byte) []byte {
+	objects := h.s.bucket.get(tx)
+	if objects == nil || h.s.expiryCheck(tx)(key) {
+		return nil
+	}
+	return objects.Get(key)
+}
+
+// preconditionsHold reports whether the preconditions of r hold for the object with the stored value data.
+func preconditionsHold(r *http.Request, data []byte) bool {
+	if match := r.Header.Get("If-Match"); match != "" {
+		if data == nil || (match != "*" && !containsETag(match, etag(data))) {
+			return false
+		}
+	}
+	if r.Header.Get("If-None-Match") == "*" && data != nil {
+		return false
+	}
+	return true
+}
+
+func containsETag(header, tag string) bool {
+	for _, t := range strings.Split(header, ",") {
+		if strings.TrimSpace(t) == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func (h httpHandler) get(w http.ResponseWriter, r *http.Request, key []byte) {
+	var tag string
+	var value json.RawMessage
+	err := h.s.db.View(func(tx BackendTx) error {
+		data := h.current(tx, key)
+		if data == nil {
+			return ErrNotFound
+		}
+		tag = etag(data)
+		raw, err := h.s.verifyChecksum(key, data)
+		if err != nil {
+			return err
+		}
+		value, err = exportValue(h.codec, raw)
+		return err
+	})
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+
+	w.Header().Set("ETag", tag)
+	if containsETag(r.Header.Get("If-None-Match"), tag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == http.MethodGet {
+		w.Write(append(value, '\n'))
+	}
+}
+
+func (h httpHandler) put(w http.ResponseWriter, r *http.Request, key []byte) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, httpMaxBody))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	body = bytes.TrimSpace(body)
+	if h.codec == "json" {
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		body = buf.Bytes()
+	}
+	raw, err := importValue(ExportRecord{Codec: h.codec, Value: body})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var val interface{}
+	if h.model != nil {
+		val = reflect.New(h.model).Interface()
+		if err := h.s.unmarshal(raw, val); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	data := h.s.addChecksum(raw)
+
+	defer h.s.forget(key)
+	err = h.s.update(func(tx BackendTx) error {
+		if !preconditionsHold(r, h.current(tx, key)) {
+			return errPrecondition
+		}
+		if val == nil && h.s.hasIndexes(tx) {
+			return errIndexed
+		}
+		objects, err := h.s.bucket.createOrGet(tx)
+		if err != nil {
+			return err
+		}
+		return h.s.writeKey(tx, objects, key, data, val, h.s.opts.ttl)
+	})
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	w.Header().Set("ETag", etag(data))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h httpHandler) delete(w http.ResponseWriter, r *http.Request, key []byte) {
+	err := h.s.update(func(tx BackendTx) error {
+		data := h.current(tx, key)
+		if !preconditionsHold(r, data) {
+			return errPrecondition
+		}
+		if data == nil {
+			return nil
+		}
+		return h.s.deleteKey(tx, h.s.bucket.get(tx), key)
+	})
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h httpHandler) list(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	prefix, after := h.s.nsKey([]byte(q.Get("prefix"))), []byte(q.Get("after"))
+	if len(after) > 0 {
+		after = h.s.nsKey(after)
+	}
+	limit := httpDefaultLimit
+	if l := q.Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n <= 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		if n > httpMaxLimit {
+			n = httpMaxLimit
+		}
+		limit = n
+	}
+
+	list := HTTPList{Items: []HTTPItem{}}
+	err := h.s.db.View(func(tx BackendTx) error {
+		objects := h.s.bucket.get(tx)
+		if objects == nil {
+			return nil
+		}
+		isExpired := h.s.expiryCheck(tx)
+		c := objects.Cursor()
+		k, v := c.Seek(prefix)
+		if len(after) > 0 && bytes.Compare(after, prefix) >= 0 {
+			if k, v = c.Seek(after); k != nil && bytes.Equal(k, after) {
+				k, v = c.Next()
+			}
+		}
+		for ; k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			if v == nil || isExpired(k) {
+				continue
+			}
+			if len(list.Items) == limit {
+				last := list.Items[limit-1]
+				list.Next, list.NextBytes = last.Key, last.KeyBytes
+				return nil
+			}
+			raw, err := h.s.verifyChecksum(k, v)
+			if err != nil {
+				return err
+			}
+			value, err := exportValue(h.codec, raw)
+			if err != nil {
+				return h.s.decodeError(k, v, err)
+			}
+			key := h.s.trimNS(k)
+			list.Items = append(list.Items, HTTPItem{
+				Key:      string(key),
+				KeyBytes: append([]byte(nil), key...),
+				ETag:     etag(v),
+				Value:    value,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == http.MethodGet {
+		json.NewEncoder(w).Encode(list)
+	}
+}
+
+// httpError replies with the status of err.
+func httpError(w http.ResponseWriter, err error) {
+	switch {
+	case err == ErrNotFound:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case err == errPrecondition:
+		http.Error(w, err.Error(), http.StatusPreconditionFailed)
+	case err == errIndexed, errors.Is(err, ErrConflict):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// HTTPError is a reply of an unexpected status to an HTTPClient.
+type HTTPError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("stow http: %d %s", e.StatusCode, e.Message)
+}
+
+// HTTPClient speaks to the handler returned by NewHTTPHandler at URL. Values are encoded as
+// JSON, so for a store with another codec than the JSONCodec they're the encoded values,
+// read and written as []byte.
+type HTTPClient struct {
+	// URL is where the handler is mounted.
+	URL string
+	// Client makes the requests, http.DefaultClient if nil.
+	Client *http.Client
+}
+
+// NewHTTPClient returns an HTTPClient for the handler at url.
+func NewHTTPClient(url string) *HTTPClient {
+	return &HTTPClient{URL: strings.TrimSuffix(url, "/")}
+}
+
+func (c *HTTPClient) do(method, path string, body []byte, header http.Header) (*http.Response, error) {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, c.URL+path, r)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent, http.StatusNotModified:
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		return nil, ErrNotFound
+	case http.StatusPreconditionFailed:
+		return nil, ErrETagMismatch
+	}
+	return nil, &HTTPError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+}
+
+func keyPath(key string) string {
+	return "/" + url.PathEscape(key)
+}
+
+// Get decodes the object at key into v, and returns its ETag. It returns ErrNotFound if
+// there's no such object.
+func (c *HTTPClient) Get(key string, v interface{}) (etag string, err error) {
+	resp, err := c.do(http.MethodGet, keyPath(key), nil, nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	return resp.Header.Get("ETag"), json.NewDecoder(resp.Body).Decode(v)
+}
+
+// Put stores v at key, and returns the ETag of the new object.
+func (c *HTTPClient) Put(key string, v interface{}) (etag string, err error) {
+	return c.put(key, v, nil)
+}
+
+// PutIfMatch stores v at key if the object still has the ETag etag, or returns
+// ErrETagMismatch. An empty etag only creates the object, if there's none.
+func (c *HTTPClient) PutIfMatch(key string, v interface{}, etag string) (string, error) {
+	if etag == "" {
+		return c.put(key, v, http.Header{"If-None-Match": {"*"}})
+	}
+	return c.put(key, v, http.Header{"If-Match": {etag}})
+}
+
+func (c *HTTPClient) put(key string, v interface{}, header http.Header) (string, error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.do(http.MethodPut, keyPath(key), body, header)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	return resp.Header.Get("ETag"), nil
+}
+
+// Delete removes the object at key. It returns nil if there's none.
+func (c *HTTPClient) Delete(key string) error {
+	resp, err := c.do(http.MethodDelete, keyPath(key), nil, nil)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// List returns up to limit objects whose key starts with prefix, after the key after, in key
+// order. A limit of 0 lists the handler's default number of objects. Keys which aren't valid
+// UTF-8 are passed as they are stored, like string(item.KeyBytes).
+func (c *HTTPClient) List(prefix, after string, limit int) (list HTTPList, err error) {
+	q := url.Values{}
+	if prefix != "" {
+		q.Set("prefix", prefix)
+	}
+	if after != "" {
+		q.Set("after", after)
+	}
+	if limit > 0 {
+		q.Set("limit", strconv.Itoa(limit))
+	}
+	path := "/"
+	if len(q) > 0 {
+		path += "?" + q.Encode()
+	}
+	resp, err := c.do(http.MethodGet, path, nil, nil)
+	if err != nil {
+		return list, err
+	}
+	defer resp.Body.Close()
+	err = json.NewDecoder(resp.Body).Decode(&list)
+	return list, err
+}
@@ -0,0 +1,183 @@
+package stow
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHTTPHandler(t *testing.T) {
+	s := NewJSONStore(db, []byte("http"))
+	defer s.DeleteAll()
+	srv := httptest.NewServer(http.StripPrefix("/people", NewHTTPHandler(s)))
+	defer srv.Close()
+	c := NewHTTPClient(srv.URL + "/people")
+
+	tag, err := c.Put("ann", MyType{FirstName: "Ann"})
+	if err != nil || tag == "" {
+		t.Fatalf("unexpected put %q %v", tag, err)
+	}
+	c.Put("bob", MyType{FirstName: "Bob"})
+	c.Put("carl/x", MyType{FirstName: "Carl"})
+
+	var v MyType
+	if err := s.Get("carl/x", &v); err != nil || v.FirstName != "Carl" {
+		t.Errorf("unexpected stored value %v %v", v, err)
+	}
+	if got, err := c.Get("ann", &v); err != nil || got != tag || v.FirstName != "Ann" {
+		t.Errorf("unexpected get %v %q %v", v, got, err)
+	}
+	if _, err := c.Get("dan", &v); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound got %v", err)
+	}
+
+	// Conditional writes.
+	if _, err := c.PutIfMatch("ann", MyType{FirstName: "Ann2"}, `"stale"`); err != ErrETagMismatch {
+		t.Errorf("expected ErrETagMismatch got %v", err)
+	}
+	if _, err := c.PutIfMatch("ann", MyType{FirstName: "Ann2"}, ""); err != ErrETagMismatch {
+		t.Errorf("expected create of existing object to fail got %v", err)
+	}
+	if _, err := c.PutIfMatch("ann", MyType{FirstName: "Ann2"}, tag); err != nil {
+		t.Errorf("unexpected conditional put %v", err)
+	}
+
+	list, err := c.List("", "", 2)
+	if err != nil || len(list.Items) != 2 || list.Items[0].Key != "ann" || list.Next != "bob" {
+		t.Fatalf("unexpected list %+v %v", list, err)
+	}
+	if string(list.Items[0].Value) != `{"first":"Ann2","last":""}` {
+		t.Errorf("unexpected value %s", list.Items[0].Value)
+	}
+	list, err = c.List("", list.Next, 2)
+	if err != nil || len(list.Items) != 1 || list.Items[0].Key != "carl/x" || list.Next != "" {
+		t.Errorf("unexpected second page %+v %v", list, err)
+	}
+	if list, _ := c.List("b", "", 0); len(list.Items) != 1 || list.Items[0].Key != "bob" {
+		t.Errorf("unexpected prefix list %+v", list)
+	}
+
+	if err := c.Delete("bob"); err != nil {
+		t.Fatal(err)
+	}
+	if has, _ := s.Has("bob"); has {
+		t.Errorf("deleted object is still there")
+	}
+
+	// Bad JSON is rejected.
+	req, _ := http.NewRequest(http.MethodPut, srv.URL+"/people/bad", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil || resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400 got %v %v", resp, err)
+	} else {
+		resp.Body.Close()
+	}
+}
+
+func TestHTTPHandlerGob(t *testing.T) {
+	s := NewStore(db, []byte("http-gob"))
+	defer s.DeleteAll()
+	srv := httptest.NewServer(NewHTTPHandler(s))
+	defer srv.Close()
+	c := NewHTTPClient(srv.URL)
+
+	s.Put("ann", MyType{FirstName: "Ann"})
+	var raw []byte
+	if _, err := c.Get("ann", &raw); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Put("ann2", raw); err != nil {
+		t.Fatal(err)
+	}
+	var v MyType
+	if err := s.Get("ann2", &v); err != nil || v.FirstName != "Ann" {
+		t.Errorf("unexpected copied value %v %v", v, err)
+	}
+}
+
+func TestHTTPHandlerBinaryKeys(t *testing.T) {
+	s := NewJSONStore(db, []byte("http-binary"))
+	defer s.DeleteAll()
+	srv := httptest.NewServer(NewHTTPHandler(s))
+	defer srv.Close()
+	c := NewHTTPClient(srv.URL)
+
+	keys := [][]byte{{0xff, 0x00, 0x01}, {0xff, 0xfe}}
+	for _, k := range keys {
+		if err := s.Put(k, MyType{FirstName: "Ann"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	list, err := c.List("", "", 1)
+	if err != nil || len(list.Items) != 1 || !bytes.Equal(list.Items[0].KeyBytes, keys[0]) {
+		t.Fatalf("unexpected list %+v %v", list, err)
+	}
+	var v MyType
+	if _, err := c.Get(string(list.Items[0].KeyBytes), &v); err != nil || v.FirstName != "Ann" {
+		t.Errorf("unexpected get of listed key %v %v", v, err)
+	}
+	list, err = c.List("", string(list.NextBytes), 1)
+	if err != nil || len(list.Items) != 1 || !bytes.Equal(list.Items[0].KeyBytes, keys[1]) {
+		t.Errorf("unexpected second page %+v %v", list, err)
+	}
+}
+
+func TestHTTPHandlerNamespace(t *testing.T) {
+	s := NewJSONStore(db, []byte("http-ns"))
+	defer s.DeleteAll()
+	srv := httptest.NewServer(NewHTTPHandler(s.Namespace([]byte("people/"))))
+	defer srv.Close()
+	c := NewHTTPClient(srv.URL)
+
+	s.Put("ann", MyType{FirstName: "Outside"})
+	if _, err := c.Put("ann", MyType{FirstName: "Ann"}); err != nil {
+		t.Fatal(err)
+	}
+	var v MyType
+	if err := s.Get("people/ann", &v); err != nil || v.FirstName != "Ann" {
+		t.Errorf("unexpected value in namespace %v %v", v, err)
+	}
+	if err := s.Get("ann", &v); err != nil || v.FirstName != "Outside" {
+		t.Errorf("value outside the namespace changed %v %v", v, err)
+	}
+	if list, err := c.List("", "", 0); err != nil || len(list.Items) != 1 || list.Items[0].Key != "ann" {
+		t.Errorf("unexpected list %+v %v", list, err)
+	}
+	if err := c.Delete("ann"); err != nil {
+		t.Fatal(err)
+	}
+	if has, _ := s.Has("ann"); !has {
+		t.Errorf("object outside the namespace was deleted")
+	}
+}
+
+func TestHTTPHandlerIndexes(t *testing.T) {
+	s := NewJSONStore(db, []byte("http-index"))
+	defer s.DeleteAll()
+	s.Put("alice", uniqueAccount{Login: "alice", Email: "a"})
+
+	// Without a model PUT can't index the value.
+	srv := httptest.NewServer(NewHTTPHandler(s))
+	defer srv.Close()
+	var httpErr *HTTPError
+	if _, err := NewHTTPClient(srv.URL).Put("bob", uniqueAccount{Login: "bob", Email: "b"}); !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusConflict {
+		t.Errorf("expected 409 got %v", err)
+	}
+
+	srvAs := httptest.NewServer(NewHTTPHandlerAs(s, uniqueAccount{}))
+	defer srvAs.Close()
+	c := NewHTTPClient(srvAs.URL)
+	if _, err := c.Put("bob", uniqueAccount{Login: "bob", Email: "b"}); err != nil {
+		t.Fatal(err)
+	}
+	var found []uniqueAccount
+	if err := s.Find("Login", "bob", &found); err != nil || len(found) != 1 {
+		t.Errorf("unexpected index lookup %v %v", found, err)
+	}
+	if _, err := c.Put("carl", uniqueAccount{Login: "alice", Email: "c"}); !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusConflict {
+		t.Errorf("expected 409 for a unique conflict got %v", err)
+	}
+}
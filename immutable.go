@@ -0,0 +1,74 @@
+package stow
+
+import (
+	"bytes"
+	"sync"
+	"sync/atomic"
+)
+
+// immutableCache holds the raw values of keys under prefixes marked with MarkImmutable.
+// Lookups don't take any locks, and cached values are never revalidated against the database.
+type immutableCache struct {
+	mu       sync.Mutex   // serializes changes to prefixes
+	prefixes atomic.Value // [][]byte
+	entries  sync.Map     // map[string][]byte
+}
+
+// MarkImmutable declares that objects whose keys start with prefix never change once written.
+// After the first read of such a key its encoded value is cached by the Store, and later Gets
+// serve it without opening a transaction or checking for updates, which makes reads of
+// reference data (country tables, configuration, ...) nearly as fast as a map lookup.
+//
+// Since cached entries aren't revalidated, changes made through other Stores (or processes)
+// and expiration are not observed. Puts and Deletes through this Store drop the cached entry.
+func (s *Store) MarkImmutable(prefix []byte) {
+	c := s.immutable
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	prefixes, _ := c.prefixes.Load().([][]byte)
+	marked := make([][]byte, len(prefixes), len(prefixes)+1)
+	copy(marked, prefixes)
+	c.prefixes.Store(append(marked, append(s.prefix[:len(s.prefix):len(s.prefix)], prefix...)))
+}
+
+func (c *immutableCache) marked(key []byte) bool {
+	prefixes, _ := c.prefixes.Load().([][]byte)
+	for _, prefix := range prefixes {
+		if bytes.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *immutableCache) get(key []byte) ([]byte, bool) {
+	if !c.marked(key) {
+		return nil, false
+	}
+	data, ok := c.entries.Load(string(key))
+	if !ok {
+		return nil, false
+	}
+	return data.([]byte), true
+}
+
+// add caches data, which must not be modified afterwards, if key is marked immutable.
+func (c *immutableCache) add(key, data []byte) {
+	if c.marked(key) {
+		c.entries.Store(string(key), data)
+	}
+}
+
+func (c *immutableCache) forget(key []byte) {
+	if c.marked(key) {
+		c.entries.Delete(string(key))
+	}
+}
+
+func (c *immutableCache) reset() {
+	c.entries.Range(func(key, _ interface{}) bool {
+		c.entries.Delete(key)
+		return true
+	})
+}
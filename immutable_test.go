@@ -0,0 +1,39 @@
+package stow
+
+import "testing"
+
+func TestMarkImmutable(t *testing.T) {
+	s := NewJSONStore(db, []byte("immutable"))
+	defer s.DeleteAll()
+	other := NewJSONStore(db, []byte("immutable"))
+
+	s.MarkImmutable([]byte("country/"))
+	s.Put("country/nz", "New Zealand")
+	s.Put("setting", "a")
+
+	var v string
+	if err := s.Get("country/nz", &v); err != nil || v != "New Zealand" {
+		t.Fatalf("unexpected Get %q %v", v, err)
+	}
+	s.Get("setting", &v)
+
+	// Changes made behind the Store's back aren't observed for immutable keys.
+	other.Put("country/nz", "Aotearoa")
+	other.Put("setting", "b")
+	if s.Get("country/nz", &v); v != "New Zealand" {
+		t.Errorf("expected cached value, got %q", v)
+	}
+	if s.Get("setting", &v); v != "b" {
+		t.Errorf("expected mutable key to be re-read, got %q", v)
+	}
+
+	// Writes through the Store itself drop the cached entry.
+	s.Put("country/nz", "Aotearoa New Zealand")
+	if s.Get("country/nz", &v); v != "Aotearoa New Zealand" {
+		t.Errorf("expected Put to invalidate the cache, got %q", v)
+	}
+	s.Delete("country/nz")
+	if err := s.Get("country/nz", &v); err != ErrNotFound {
+		t.Errorf("expected Delete to invalidate the cache, got %v", err)
+	}
+}
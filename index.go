@@ -0,0 +1,420 @@
+package stow
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrNotIndexed indicates a lookup on a field which isn't tagged with `stow:"index"`.
+	ErrNotIndexed = errors.New("field is not indexed")
+
+	// ErrConflict indicates a write which conflicts with the contents of the store: a Put of
+	// an object whose field tagged with `stow:"index,unique"` has the same value as the one of
+	// an object stored at another key, or a PutIf whose expected value didn't match.
+	ErrConflict = errors.New("conflicting write")
+)
+
+// Indexes are kept in meta buckets: one nested bucket per field, keyed by the escaped
+// field value followed by the object's key, and a reverse bucket holding the index
+// entries of each key so that they can be removed without decoding the old value.
+var (
+	indexBucket     = []byte("\x00index")
+	indexKeysBucket = []byte("\x00index.keys")
+)
+
+type indexedField struct {
+	name   string
+	index  []int
+	unique bool
+}
+
+var indexedFieldsCache sync.Map // map[reflect.Type][]indexedField
+
+// indexedFields returns the fields of typ tagged with `stow:"index"` (or `stow:"index,unique"`),
+// including those of embedded structs.
+func indexedFields(typ reflect.Type) []indexedField {
+	if fields, ok := indexedFieldsCache.Load(typ); ok {
+		return fields.([]indexedField)
+	}
+
+	var fields []indexedField
+	var walk func(typ reflect.Type, index []int)
+	walk = func(typ reflect.Type, index []int) {
+		for i := 0; i < typ.NumField(); i++ {
+			f := typ.Field(i)
+			fieldIndex := append(append([]int(nil), index...), i)
+			if f.Anonymous && f.Type.Kind() == reflect.Struct {
+				walk(f.Type, fieldIndex)
+				continue
+			}
+			if f.PkgPath != "" {
+				continue
+			}
+			if tag := parseStowTag(f.Tag.Get("stow")); tag.index {
+				fields = append(fields, indexedField{name: f.Name, index: fieldIndex, unique: tag.unique})
+			}
+		}
+	}
+	if typ.Kind() == reflect.Struct {
+		walk(typ, nil)
+	}
+
+	indexedFieldsCache.Store(typ, fields)
+	return fields
+}
+
+type stowTag struct {
+	index  bool
+	unique bool
+}
+
+func parseStowTag(tag string) (t stowTag) {
+	for _, opt := range strings.Split(tag, ",") {
+		switch strings.TrimSpace(opt) {
+		case "index":
+			t.index = true
+		case "unique":
+			t.index, t.unique = true, true
+		}
+	}
+	return t
+}
+
+// indirect follows pointers and interfaces to the underlying value.
+func indirect(v reflect.Value) reflect.Value {
+	for v.IsValid() && (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) {
+		if v.IsNil() {
+			return reflect.Value{}
+		}
+		v = v.Elem()
+	}
+	return v
+}
+
+// Find decodes every object whose field "field" equals value into results, which must be a
+// pointer to a slice of the stored struct type (or pointers to it). The field must be tagged
+// with `stow:"index"`, objects are indexed as they are written so this doesn't scan the store.
+func (s *Store) Find(field string, value interface{}, results interface{}) error {
+	out, err := newResultSlice(results)
+	if err != nil {
+		return err
+	}
+	if !hasIndexedField(out.elemType, field) {
+		return ErrNotIndexed
+	}
+
+	encoded, err := s.indexValue(reflect.ValueOf(value))
+	if err != nil {
+		return err
+	}
+
+	var keys, raw [][]byte
+	err = s.db.View(func(tx BackendTx) error {
+		fieldIndex := s.bucket.meta().child(indexBucket).child([]byte(field)).get(tx)
+		objects := s.bucket.get(tx)
+		if fieldIndex == nil || objects == nil {
+			return nil
+		}
+
+		isExpired := s.expiryCheck(tx)
+		prefix := escapePart(encoded)
+		c := fieldIndex.Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			key := k[len(prefix):]
+			if data := objects.Get(key); data != nil && !isExpired(key) {
+				keys = append(keys, append([]byte(nil), key...))
+				raw = append(raw, append([]byte(nil), data...))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for i, data := range raw {
+		elem, err := out.decode(s, keys[i], data)
+		if err != nil {
+			return err
+		}
+		out.append(elem)
+	}
+	out.set()
+	return nil
+}
+
+// resultSlice collects decoded objects into a pointer to a slice of structs, or struct pointers.
+type resultSlice struct {
+	ptr      reflect.Value
+	slice    reflect.Value
+	elemType reflect.Type
+	isPtr    bool
+}
+
+func newResultSlice(results interface{}) (*resultSlice, error) {
+	ptr := reflect.ValueOf(results)
+	if ptr.Kind() != reflect.Ptr || ptr.Elem().Kind() != reflect.Slice {
+		return nil, fmt.Errorf("results must be a pointer to a slice")
+	}
+	r := &resultSlice{ptr: ptr, slice: ptr.Elem(), elemType: ptr.Elem().Type().Elem()}
+	if r.elemType.Kind() == reflect.Ptr {
+		r.isPtr = true
+		r.elemType = r.elemType.Elem()
+	}
+	return r, nil
+}
+
+// decode returns data, the value of key, decoded into a new element, as a struct value (never a pointer).
+func (r *resultSlice) decode(s *Store, key, data []byte) (reflect.Value, error) {
+	if err := s.checkDecodeSize(data); err != nil {
+		return reflect.Value{}, s.keyError(key, err)
+	}
+	elem := reflect.New(r.elemType)
+	if err := s.unmarshalValue(key, data, elem.Interface()); err != nil {
+		return reflect.Value{}, s.decodeError(key, data, err)
+	}
+	return elem.Elem(), nil
+}
+
+func (r *resultSlice) append(elem reflect.Value) {
+	if r.isPtr {
+		elem = elem.Addr()
+	}
+	r.slice = reflect.Append(r.slice, elem)
+}
+
+func (r *resultSlice) set() {
+	r.ptr.Elem().Set(r.slice)
+}
+
+func hasIndexedField(typ reflect.Type, name string) bool {
+	for _, f := range indexedFields(typ) {
+		if f.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+type indexEntry struct {
+	field  string
+	value  []byte
+	unique bool
+}
+
+// indexEntries returns the index entries for val.
+func (s *Store) indexEntries(val interface{}) (entries []indexEntry, err error) {
+	v := indirect(reflect.ValueOf(val))
+	if !v.IsValid() {
+		return nil, nil
+	}
+	for _, f := range indexedFields(v.Type()) {
+		encoded, err := s.indexValue(v.FieldByIndex(f.index))
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, indexEntry{field: f.name, value: encoded, unique: f.unique})
+	}
+	return entries, nil
+}
+
+// updateIndexes replaces the index entries kept for key with those of val, it returns
+// ErrConflict if a unique entry is already held by another key which hasn't expired.
+func (s *Store) updateIndexes(tx BackendTx, key []byte, val interface{}) error {
+	entries, err := s.indexEntries(val)
+	if err != nil {
+		return err
+	}
+	return s.setIndexes(tx, key, entries)
+}
+
+// hasIndexes reports whether the store keeps index entries for any key.
+func (s *Store) hasIndexes(tx BackendTx) bool {
+	keys := s.bucket.meta().child(indexKeysBucket).get(tx)
+	if keys == nil {
+		return false
+	}
+	k, _ := keys.Cursor().First()
+	return k != nil
+}
+
+// setIndexes replaces the index entries kept for key with entries.
+func (s *Store) setIndexes(tx BackendTx, key []byte, entries []indexEntry) error {
+	if err := s.removeIndexes(tx, key); err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+
+	meta := s.bucket.meta()
+	keys, err := meta.child(indexKeysBucket).createOrGetUntracked(tx)
+	if err != nil {
+		return err
+	}
+	isExpired := s.expiryCheck(tx)
+	for _, e := range entries {
+		fieldIndex, err := meta.child(indexBucket).child([]byte(e.field)).createOrGetUntracked(tx)
+		if err != nil {
+			return err
+		}
+		if e.unique && indexHeld(fieldIndex, e.value, isExpired) {
+			return ErrConflict
+		}
+		if err := fieldIndex.Put(indexKey(e.value, key), []byte{}); err != nil {
+			return err
+		}
+	}
+	return keys.Put(key, encodeIndexEntries(entries))
+}
+
+// indexHeld reports whether an entry for value is held by a key which hasn't expired.
+func indexHeld(fieldIndex BackendBucket, value []byte, isExpired func([]byte) bool) bool {
+	prefix := escapePart(value)
+	c := fieldIndex.Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+		if !isExpired(k[len(prefix):]) {
+			return true
+		}
+	}
+	return false
+}
+
+// removeIndexes removes the index entries kept for key.
+func (s *Store) removeIndexes(tx BackendTx, key []byte) error {
+	meta := s.bucket.meta()
+	keys := meta.child(indexKeysBucket).get(tx)
+	if keys == nil {
+		return nil
+	}
+	data := keys.Get(key)
+	if data == nil {
+		return nil
+	}
+
+	entries, err := decodeIndexEntries(data)
+	if err != nil {
+		return err
+	}
+	index := meta.child(indexBucket).get(tx)
+	for _, e := range entries {
+		if index == nil {
+			break
+		}
+		if fieldIndex := index.Bucket([]byte(e.field)); fieldIndex != nil {
+			if err := fieldIndex.Delete(indexKey(e.value, key)); err != nil {
+				return err
+			}
+		}
+	}
+	return keys.Delete(key)
+}
+
+func encodeIndexEntries(entries []indexEntry) []byte {
+	var buf bytes.Buffer
+	var n [binary.MaxVarintLen64]byte
+	put := func(data []byte) {
+		buf.Write(n[:binary.PutUvarint(n[:], uint64(len(data)))])
+		buf.Write(data)
+	}
+	for _, e := range entries {
+		put([]byte(e.field))
+		put(e.value)
+	}
+	return buf.Bytes()
+}
+
+func decodeIndexEntries(data []byte) (entries []indexEntry, err error) {
+	next := func() ([]byte, error) {
+		n, size := binary.Uvarint(data)
+		if size <= 0 || uint64(len(data)-size) < n {
+			return nil, errors.New("corrupt index entry")
+		}
+		v := data[size : size+int(n)]
+		data = data[size+int(n):]
+		return v, nil
+	}
+	for len(data) > 0 {
+		field, err := next()
+		if err != nil {
+			return nil, err
+		}
+		value, err := next()
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, indexEntry{field: string(field), value: value})
+	}
+	return entries, nil
+}
+
+func indexKey(value, key []byte) []byte {
+	return append(escapePart(value), key...)
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// indexValue encodes v so that, where possible, encoded values sort in the same order as
+// the values themselves. Types without a natural ordering are encoded with the store's codec.
+func (s *Store) indexValue(v reflect.Value) ([]byte, error) {
+	v = indirect(v)
+	if !v.IsValid() {
+		return []byte{}, nil
+	}
+
+	if v.Type() == timeType {
+		return orderedInt(v.Interface().(time.Time).UnixNano()), nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		return []byte(v.String()), nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return v.Bytes(), nil
+		}
+	case reflect.Bool:
+		if v.Bool() {
+			return []byte{1}, nil
+		}
+		return []byte{0}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return orderedInt(v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return orderedUint(v.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return orderedFloat(v.Float()), nil
+	}
+	return s.marshal(v.Interface())
+}
+
+func orderedUint(n uint64) []byte {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, n)
+	return data
+}
+
+// orderedInt flips the sign bit so negative numbers sort before positive ones.
+func orderedInt(n int64) []byte {
+	return orderedUint(uint64(n) ^ (1 << 63))
+}
+
+// orderedFloat flips the sign bit of positive numbers, and every bit of negative numbers,
+// so that the IEEE 754 representation sorts numerically.
+func orderedFloat(f float64) []byte {
+	bits := math.Float64bits(f)
+	if bits&(1<<63) != 0 {
+		bits = ^bits
+	} else {
+		bits |= 1 << 63
+	}
+	return orderedUint(bits)
+}
@@ -0,0 +1,175 @@
+package stow
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+type indexedUser struct {
+	Name  string
+	Email string `stow:"index"`
+	Age   int    `stow:"index"`
+}
+
+type uniqueAccount struct {
+	Login string `stow:"index,unique"`
+	Email string `stow:"unique"`
+}
+
+type indexedAdmin struct {
+	indexedUser
+	Level int
+}
+
+func TestFind(t *testing.T) {
+	s := NewJSONStore(db, []byte("index"))
+	defer s.DeleteAll()
+
+	s.Put("alice", indexedUser{Name: "alice", Email: "a@example.com", Age: 30})
+	s.Put("bob", &indexedUser{Name: "bob", Email: "b@example.com", Age: 30})
+	s.Put("carol", indexedUser{Name: "carol", Email: "c@example.com", Age: -1})
+
+	var users []indexedUser
+	if err := s.Find("Age", 30, &users); err != nil {
+		t.Fatal(err)
+	}
+	names := userNames(users)
+	if len(names) != 2 || names[0] != "alice" || names[1] != "bob" {
+		t.Errorf("unexpected results for Age 30: %v", names)
+	}
+
+	var ptrs []*indexedUser
+	if err := s.Find("Email", "c@example.com", &ptrs); err != nil {
+		t.Fatal(err)
+	}
+	if len(ptrs) != 1 || ptrs[0].Name != "carol" {
+		t.Errorf("unexpected results for carol's email: %v", ptrs)
+	}
+
+	// Updating an object moves it in the index.
+	s.Put("bob", indexedUser{Name: "bob", Email: "b@example.com", Age: 31})
+	users = nil
+	s.Find("Age", 30, &users)
+	if names := userNames(users); len(names) != 1 || names[0] != "alice" {
+		t.Errorf("stale index entry after update: %v", names)
+	}
+
+	// Deleting or pulling an object removes it from the index.
+	s.Delete("alice")
+	var pulled indexedUser
+	s.Pull("bob", &pulled)
+	users = nil
+	s.Find("Age", 30, &users)
+	s.Find("Age", 31, &users)
+	if len(users) != 0 {
+		t.Errorf("expected deleted objects to be removed from the index: %v", users)
+	}
+
+	if err := s.Find("Name", "carol", &users); err != ErrNotIndexed {
+		t.Errorf("expected ErrNotIndexed, got %v", err)
+	}
+}
+
+func TestUniqueIndex(t *testing.T) {
+	s := NewJSONStore(db, []byte("index-unique"))
+	defer s.DeleteAll()
+
+	if err := s.Put("1", uniqueAccount{Login: "alice", Email: "a@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put("2", uniqueAccount{Login: "bob", Email: "a@example.com"}); err != ErrConflict {
+		t.Errorf("expected ErrConflict for duplicate email, got %v", err)
+	}
+	if has, _ := s.Has("2"); has {
+		t.Errorf("conflicting Put should not write")
+	}
+
+	// Rewriting the same key with the same value isn't a conflict.
+	if err := s.Put("1", uniqueAccount{Login: "alice", Email: "a@example.com"}); err != nil {
+		t.Errorf("unexpected error rewriting key: %v", err)
+	}
+
+	// Once the value is released, another key can take it.
+	s.Put("1", uniqueAccount{Login: "alice", Email: "alice@example.com"})
+	if err := s.Put("2", uniqueAccount{Login: "bob", Email: "a@example.com"}); err != nil {
+		t.Errorf("unexpected error after value was released: %v", err)
+	}
+	s.Delete("1")
+	if err := s.Put("3", uniqueAccount{Login: "alice"}); err != nil {
+		t.Errorf("unexpected error after key was deleted: %v", err)
+	}
+
+	var accounts []uniqueAccount
+	s.Find("Login", "bob", &accounts)
+	if len(accounts) != 1 || accounts[0].Email != "a@example.com" {
+		t.Errorf("unexpected results %v", accounts)
+	}
+}
+
+func TestFindEmbedded(t *testing.T) {
+	s := NewJSONStore(db, []byte("index-embedded"))
+	defer s.DeleteAll()
+
+	s.Put("root", indexedAdmin{indexedUser{Name: "root", Email: "root@example.com"}, 9})
+
+	var admins []indexedAdmin
+	if err := s.Find("Email", "root@example.com", &admins); err != nil {
+		t.Fatal(err)
+	}
+	if len(admins) != 1 || admins[0].Level != 9 {
+		t.Errorf("unexpected results %v", admins)
+	}
+}
+
+func TestFindExpired(t *testing.T) {
+	s := NewJSONStore(db, []byte("index-ttl"))
+	defer s.DeleteAll()
+
+	s.PutTTL("dave", indexedUser{Name: "dave", Age: 40}, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+
+	var users []indexedUser
+	s.Find("Age", 40, &users)
+	if len(users) != 0 {
+		t.Errorf("expected expired objects to be skipped: %v", users)
+	}
+
+	if n, _ := s.Sweep(); n != 1 {
+		t.Errorf("expected 1 expired object, got %d", n)
+	}
+	s.db.View(func(tx BackendTx) error {
+		if keys := s.bucket.meta().child(indexKeysBucket).get(tx); keys != nil && keys.Get([]byte("dave")) != nil {
+			t.Errorf("expected Sweep to remove index entries")
+		}
+		return nil
+	})
+}
+
+func TestIndexValueOrder(t *testing.T) {
+	s := NewJSONStore(db, []byte("index-order"))
+	values := []interface{}{-5, -1, 0, 1, 300}
+	floats := []interface{}{-2.5, -0.5, 0.0, 0.25, 10.0}
+	for _, vs := range [][]interface{}{values, floats} {
+		var encoded []string
+		for _, v := range vs {
+			b, err := s.indexValue(reflect.ValueOf(v))
+			if err != nil {
+				t.Fatal(err)
+			}
+			encoded = append(encoded, string(b))
+		}
+		if !sort.StringsAreSorted(encoded) {
+			t.Errorf("encoding of %v doesn't preserve order", vs)
+		}
+	}
+}
+
+func userNames(users []indexedUser) (names []string) {
+	for _, u := range users {
+		names = append(names, u.Name)
+	}
+	sort.Strings(names)
+	return names
+}
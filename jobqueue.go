@@ -0,0 +1,329 @@
+package stow
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// The state of the jobs of a JobQueue is kept in two meta buckets: one maps keys to their
+// attempts and the time they can next be handed out, the other is ordered by that time
+// (time + key) so Dequeue can find the next job quickly.
+var (
+	jobStateBucket = []byte("\x00jobs.state")
+	jobReadyBucket = []byte("\x00jobs.ready")
+)
+
+// ErrLeaseLost is returned by Ack and Nack for a Lease which isn't held anymore: the job was
+// acked or nacked already, or its lease expired and it was handed out again. LockStore
+// returns it for LockLeases which expired or were released.
+var ErrLeaseLost = errors.New("job lease was lost")
+
+// JobPolicy configures a JobQueue.
+type JobPolicy struct {
+	// VisibilityTimeout is how long a dequeued job is leased to its consumer, 30 seconds if
+	// zero. A job which isn't acked or nacked in time is handed out again, as its consumer
+	// is presumed to have crashed.
+	VisibilityTimeout time.Duration
+
+	// MaxAttempts is how many times a job is handed out before it's moved to the dead-letter
+	// store, 5 if zero.
+	MaxAttempts int
+
+	// RetryDelay is how long a nacked job waits before it's handed out again, 1 second if
+	// zero. It doubles after each attempt, up to MaxRetryDelay (1 hour if zero).
+	RetryDelay    time.Duration
+	MaxRetryDelay time.Duration
+
+	// DeadLetter is the store jobs which failed MaxAttempts times are moved to, at the same
+	// keys. It must use the same database, and should use the same Codec since the encoded
+	// jobs are moved as is. The nested store "dead" of the queue's store if nil.
+	DeadLetter *Store
+}
+
+// JobQueue is a persistent queue of jobs with at-least-once delivery, kept in a Store under
+// the keys of its bucket sequence. Dequeue leases a job to its consumer, which Acks it once
+// done or Nacks it to retry it later. Jobs whose consumer crashed are handed out again once
+// their lease expires, and jobs which failed too many times are moved to a dead-letter store.
+// Each step is one transaction, so a crash never loses a job or leaves it half handed out.
+//
+// A JobQueue is safe for concurrent use, and should have its Store (or Namespace) to itself.
+// Deleting a job from the Store removes it from the queue. Jobs don't expire and the queue
+// doesn't run Hooks.
+type JobQueue struct {
+	s      *Store
+	policy JobPolicy
+}
+
+// Lease is a job handed out by Dequeue, which is the consumer's until Deadline.
+type Lease struct {
+	// Key is the job's key in the queue's Store.
+	Key []byte
+	// Attempt counts the times the job was handed out, 1 the first time.
+	Attempt  int
+	Deadline time.Time
+}
+
+// NewJobQueue returns the JobQueue of the jobs kept in s.
+func (s *Store) NewJobQueue(policy JobPolicy) *JobQueue {
+	if policy.VisibilityTimeout <= 0 {
+		policy.VisibilityTimeout = 30 * time.Second
+	}
+	if policy.MaxAttempts <= 0 {
+		policy.MaxAttempts = 5
+	}
+	if policy.RetryDelay <= 0 {
+		policy.RetryDelay = time.Second
+	}
+	if policy.MaxRetryDelay <= 0 {
+		policy.MaxRetryDelay = time.Hour
+	}
+	if policy.DeadLetter == nil {
+		policy.DeadLetter = s.NewNestedStore([]byte("dead")).Namespace(s.prefix)
+	}
+	return &JobQueue{s: s, policy: policy}
+}
+
+// Enqueue adds a job which can be handed out right away, and returns its key.
+func (q *JobQueue) Enqueue(val interface{}) (key []byte, err error) {
+	return q.EnqueueAt(time.Now(), val)
+}
+
+// EnqueueAfter adds a job which is only handed out once d has passed, and returns its key.
+func (q *JobQueue) EnqueueAfter(d time.Duration, val interface{}) (key []byte, err error) {
+	return q.EnqueueAt(time.Now().Add(d), val)
+}
+
+// EnqueueAt adds a job which is only handed out from t on, and returns its key. Since the
+// due time is persisted with the job, scheduled jobs survive restarts.
+func (q *JobQueue) EnqueueAt(t time.Time, val interface{}) (key []byte, err error) {
+	s := q.s
+	data, err := s.marshalValue(val)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.update(func(tx BackendTx) error {
+		objects, err := s.bucket.createOrGet(tx)
+		if err != nil {
+			return err
+		}
+		seq, err := objects.NextSequence()
+		if err != nil {
+			return err
+		}
+		key = AutoKey(seq)
+		k := s.nsKey(key)
+		if err := s.writeKey(tx, objects, k, data, val, 0); err != nil {
+			return err
+		}
+		return s.setJob(tx, k, 0, t)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Dequeue leases the next job which can be handed out and decodes it into b, or returns
+// ErrNotFound if there's none. Jobs are handed out in the order they became due, jobs
+// leased MaxAttempts times already are moved to the dead-letter store instead.
+// If the job can't be decoded, Dequeue returns its *DecodeError along with the Lease, so the
+// job can be Nacked: it still counts as an attempt.
+func (q *JobQueue) Dequeue(b interface{}) (lease *Lease, err error) {
+	s := q.s
+	buf := pool.Get().(*bytes.Buffer)
+	defer releaseBuffer(buf)
+
+	var decodeErr error
+	err = s.update(func(tx BackendTx) error {
+		lease, decodeErr = nil, nil
+		ready := s.bucket.meta().child(jobReadyBucket).get(tx)
+		if ready == nil {
+			return ErrNotFound
+		}
+		now := time.Now()
+
+		// Collect the keys first, as moving jobs changes the ready bucket.
+		var key []byte
+		var attempts int
+		var dead [][]byte
+		c := ready.Cursor()
+		for k, _ := c.First(); k != nil && key == nil; k, _ = c.Next() {
+			if len(k) < timeLength || decodeTime(k[:timeLength]).After(now) {
+				break
+			}
+			if k = k[timeLength:]; !bytes.HasPrefix(k, s.prefix) {
+				continue
+			}
+			if n, _, _ := q.s.jobState(tx, k); n >= q.policy.MaxAttempts {
+				dead = append(dead, append([]byte(nil), k...))
+			} else {
+				key, attempts = append([]byte(nil), k...), n
+			}
+		}
+		for _, k := range dead {
+			if err := q.deadLetter(tx, k); err != nil {
+				return err
+			}
+		}
+		if key == nil {
+			return nil
+		}
+
+		objects := s.bucket.get(tx)
+		var data []byte
+		if objects != nil {
+			data = objects.Get(key)
+		}
+		if data == nil {
+			// The object is gone, so is the job.
+			return s.clearJob(tx, key)
+		}
+
+		lease = &Lease{
+			Key:      s.trimNS(key),
+			Attempt:  attempts + 1,
+			Deadline: now.Add(q.policy.VisibilityTimeout),
+		}
+		if err := s.setJob(tx, key, lease.Attempt, lease.Deadline); err != nil {
+			return err
+		}
+		if err := s.checkDecodeSize(data); err != nil {
+			decodeErr = s.keyError(key, err)
+			return nil
+		}
+		buf.Write(data)
+		if err := s.unmarshalValue(key, buf.Bytes(), b); err != nil {
+			decodeErr = s.decodeError(key, data, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if lease == nil {
+		return nil, ErrNotFound
+	}
+	return lease, decodeErr
+}
+
+// Ack removes the job of lease from the queue, once it's done. It returns ErrLeaseLost if
+// the lease isn't held anymore, then the job may be handed out again or was already.
+func (q *JobQueue) Ack(lease *Lease) error {
+	s := q.s
+	key := s.nsKey(lease.Key)
+	return s.update(func(tx BackendTx) error {
+		if !q.held(tx, key, lease) {
+			return ErrLeaseLost
+		}
+		objects := s.bucket.get(tx)
+		if objects == nil {
+			return ErrLeaseLost
+		}
+		return s.deleteKey(tx, objects, key)
+	})
+}
+
+// Nack gives up the lease of a job which failed, so it's retried after a delay, or moved
+// to the dead-letter store after MaxAttempts attempts. It returns ErrLeaseLost if the lease
+// isn't held anymore.
+func (q *JobQueue) Nack(lease *Lease) error {
+	s := q.s
+	key := s.nsKey(lease.Key)
+	return s.update(func(tx BackendTx) error {
+		if !q.held(tx, key, lease) {
+			return ErrLeaseLost
+		}
+		if lease.Attempt >= q.policy.MaxAttempts {
+			return q.deadLetter(tx, key)
+		}
+		delay := q.policy.RetryDelay
+		for i := 1; i < lease.Attempt && delay < q.policy.MaxRetryDelay; i++ {
+			delay *= 2
+		}
+		if delay > q.policy.MaxRetryDelay {
+			delay = q.policy.MaxRetryDelay
+		}
+		return s.setJob(tx, key, lease.Attempt, time.Now().Add(delay))
+	})
+}
+
+// held reports whether lease is still the lease of the job at key.
+func (q *JobQueue) held(tx BackendTx, key []byte, lease *Lease) bool {
+	attempts, next, ok := q.s.jobState(tx, key)
+	return ok && attempts == lease.Attempt && next.Equal(lease.Deadline)
+}
+
+// deadLetter moves the job at key to the dead-letter store.
+func (q *JobQueue) deadLetter(tx BackendTx, key []byte) error {
+	dst := q.policy.DeadLetter
+	newKey := dst.nsKey(q.s.trimNS(key))
+	defer dst.forget(newKey)
+	err := q.s.moveKey(tx, dst, key, newKey)
+	if err == ErrNotFound {
+		return q.s.clearJob(tx, key)
+	}
+	return err
+}
+
+// jobState returns the state of the job at key: the times it was handed out, and when it can be
+// handed out next.
+func (s *Store) jobState(tx BackendTx, key []byte) (attempts int, next time.Time, ok bool) {
+	state := s.bucket.meta().child(jobStateBucket).get(tx)
+	if state == nil {
+		return 0, next, false
+	}
+	data := state.Get(key)
+	if len(data) != 2*timeLength {
+		return 0, next, false
+	}
+	return int(binary.BigEndian.Uint64(data)), decodeTime(data[timeLength:]), true
+}
+
+// setJob records the state of the job at key.
+func (s *Store) setJob(tx BackendTx, key []byte, attempts int, next time.Time) error {
+	if err := s.clearJob(tx, key); err != nil {
+		return err
+	}
+
+	meta := s.bucket.meta()
+	state, err := meta.child(jobStateBucket).createOrGetUntracked(tx)
+	if err != nil {
+		return err
+	}
+	ready, err := meta.child(jobReadyBucket).createOrGetUntracked(tx)
+	if err != nil {
+		return err
+	}
+	nextTime := encodeTime(next)
+	value := make([]byte, 2*timeLength)
+	binary.BigEndian.PutUint64(value, uint64(attempts))
+	copy(value[timeLength:], nextTime)
+	if err := state.Put(key, value); err != nil {
+		return err
+	}
+	return ready.Put(timeKey(nextTime, key), []byte{})
+}
+
+// clearJob removes any job state recorded for key.
+func (s *Store) clearJob(tx BackendTx, key []byte) error {
+	meta := s.bucket.meta()
+	state := meta.child(jobStateBucket).get(tx)
+	if state == nil {
+		return nil
+	}
+
+	old := state.Get(key)
+	if len(old) != 2*timeLength {
+		return nil
+	}
+
+	if ready := meta.child(jobReadyBucket).get(tx); ready != nil {
+		if err := ready.Delete(timeKey(old[timeLength:], key)); err != nil {
+			return err
+		}
+	}
+	return state.Delete(key)
+}
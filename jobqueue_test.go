@@ -0,0 +1,103 @@
+package stow
+
+import (
+	"testing"
+	"time"
+)
+
+func TestJobQueue(t *testing.T) {
+	s := NewJSONStore(db, []byte("jobqueue"))
+	defer s.DeleteAll()
+	q := s.NewJobQueue(JobPolicy{
+		VisibilityTimeout: 50 * time.Millisecond,
+		MaxAttempts:       3,
+		RetryDelay:        10 * time.Millisecond,
+	})
+
+	first, _ := q.Enqueue(moveJob{ID: 1})
+	q.Enqueue(moveJob{ID: 2})
+
+	var job moveJob
+	lease, err := q.Dequeue(&job)
+	if err != nil || job.ID != 1 || lease.Attempt != 1 || string(lease.Key) != string(first) {
+		t.Fatalf("unexpected first job %v %+v %v", job, lease, err)
+	}
+	retried, err := q.Dequeue(&job)
+	if err != nil || job.ID != 2 {
+		t.Fatalf("unexpected second job %v %v", job, err)
+	}
+	if _, err := q.Dequeue(&job); err != ErrNotFound {
+		t.Errorf("leased jobs shouldn't be handed out, got %v", err)
+	}
+
+	if err := q.Ack(lease); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Ack(lease); err != ErrLeaseLost {
+		t.Errorf("expected ErrLeaseLost acking twice, got %v", err)
+	}
+	if has, _ := s.Has(first); has {
+		t.Errorf("acked jobs should be removed")
+	}
+
+	// Retried after the delay.
+	if err := q.Nack(retried); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.Dequeue(&job); err != ErrNotFound {
+		t.Errorf("nacked jobs should wait, got %v", err)
+	}
+	time.Sleep(30 * time.Millisecond)
+	lease, err = q.Dequeue(&job)
+	if err != nil || job.ID != 2 || lease.Attempt != 2 {
+		t.Fatalf("expected the retry of job 2, got %v %+v %v", job, lease, err)
+	}
+
+	// Handed out again once the lease expires, then dead-lettered.
+	time.Sleep(60 * time.Millisecond)
+	expired := lease
+	if lease, err = q.Dequeue(&job); err != nil || lease.Attempt != 3 {
+		t.Fatalf("expected job 2 handed out again, got %+v %v", lease, err)
+	}
+	if err := q.Ack(expired); err != ErrLeaseLost {
+		t.Errorf("expected ErrLeaseLost for an expired lease, got %v", err)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if _, err := q.Dequeue(&job); err != ErrNotFound {
+		t.Errorf("expected the job to be dead-lettered, got %v", err)
+	}
+	var dead moveJob
+	if err := s.NewNestedStore([]byte("dead")).Get(lease.Key, &dead); err != nil || dead.ID != 2 {
+		t.Errorf("unexpected dead letter %v %v", dead, err)
+	}
+	if has, _ := s.Has(lease.Key); has {
+		t.Errorf("dead-lettered jobs should be removed from the queue")
+	}
+}
+
+func TestJobQueueScheduled(t *testing.T) {
+	s := NewJSONStore(db, []byte("jobqueue_scheduled"))
+	defer s.DeleteAll()
+	q := s.NewJobQueue(JobPolicy{})
+
+	q.EnqueueAt(time.Now().Add(time.Hour), moveJob{ID: 1})
+	q.EnqueueAfter(30*time.Millisecond, moveJob{ID: 2})
+	q.EnqueueAt(time.Now().Add(-time.Minute), moveJob{ID: 3})
+
+	var job moveJob
+	if lease, err := q.Dequeue(&job); err != nil || job.ID != 3 {
+		t.Fatalf("expected the overdue job, got %v %v", job, err)
+	} else {
+		q.Ack(lease)
+	}
+	if _, err := q.Dequeue(&job); err != ErrNotFound {
+		t.Errorf("jobs shouldn't be handed out before they're due, got %v", err)
+	}
+	time.Sleep(40 * time.Millisecond)
+	if _, err := q.Dequeue(&job); err != nil || job.ID != 2 {
+		t.Errorf("expected the job due after 30ms, got %v %v", job, err)
+	}
+	if _, err := q.Dequeue(&job); err != ErrNotFound {
+		t.Errorf("expected the job due in an hour to wait, got %v", err)
+	}
+}
@@ -0,0 +1,194 @@
+package stow
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+var (
+	// ErrUnsupportedKey indicates a key of a type the KeyCodec can't encode or decode.
+	ErrUnsupportedKey = errors.New("unsupported key type")
+
+	// ErrBadKey indicates a composite key which wasn't made by Key.
+	ErrBadKey = errors.New("invalid composite key")
+)
+
+// KeyCodec encodes keys into the bytes they are stored at, and decodes them back.
+// Bolt iterates keys in byte order, so a KeyCodec decides the order of ForEach and of
+// prefix scans.
+type KeyCodec interface {
+	EncodeKey(key interface{}) ([]byte, error)
+	DecodeKey(data []byte, key interface{}) error
+}
+
+var _ KeyCodec = OrderedKeyCodec{}
+
+// OrderedKeyCodec encodes integers, times, strings and byte slices so that their byte order
+// matches their natural order: signed and unsigned integers of any size as 8 bytes big-endian
+// (with the sign bit flipped for signed integers, so negative numbers sort first), and
+// time.Time as its signed UnixNano, so that keys sort chronologically. Strings and byte
+// slices are used as is. Decoded times are in the local time zone.
+type OrderedKeyCodec struct{}
+
+// EncodeKey returns the order-preserving encoding of key.
+func (c OrderedKeyCodec) EncodeKey(key interface{}) ([]byte, error) {
+	v := indirect(reflect.ValueOf(key))
+	if !v.IsValid() {
+		return nil, ErrUnsupportedKey
+	}
+
+	if t, ok := v.Interface().(time.Time); ok {
+		return orderedInt(t.UnixNano()), nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		return []byte(v.String()), nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return v.Bytes(), nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return orderedInt(v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return orderedUint(v.Uint()), nil
+	}
+	return nil, fmt.Errorf("%w: %T", ErrUnsupportedKey, key)
+}
+
+// DecodeKey decodes data written by EncodeKey into key, which must be a pointer.
+func (c OrderedKeyCodec) DecodeKey(data []byte, key interface{}) error {
+	ptr := reflect.ValueOf(key)
+	if ptr.Kind() != reflect.Ptr || ptr.IsNil() {
+		return fmt.Errorf("%w: %T is not a pointer", ErrUnsupportedKey, key)
+	}
+	v := ptr.Elem()
+
+	if _, ok := v.Interface().(time.Time); ok {
+		if len(data) != 8 {
+			return fmt.Errorf("invalid time key of %d bytes", len(data))
+		}
+		v.Set(reflect.ValueOf(time.Unix(0, int64(binary.BigEndian.Uint64(data)^(1<<63)))))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(string(data))
+		return nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			v.SetBytes(append([]byte(nil), data...))
+			return nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if len(data) != 8 {
+			return fmt.Errorf("invalid integer key of %d bytes", len(data))
+		}
+		n := int64(binary.BigEndian.Uint64(data) ^ (1 << 63))
+		if v.OverflowInt(n) {
+			return fmt.Errorf("key %d overflows %s", n, v.Type())
+		}
+		v.SetInt(n)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if len(data) != 8 {
+			return fmt.Errorf("invalid integer key of %d bytes", len(data))
+		}
+		n := binary.BigEndian.Uint64(data)
+		if v.OverflowUint(n) {
+			return fmt.Errorf("key %d overflows %s", n, v.Type())
+		}
+		v.SetUint(n)
+		return nil
+	}
+	return fmt.Errorf("%w: %T", ErrUnsupportedKey, key)
+}
+
+// Key builds a composite key out of parts, such as a tenant, type and id. Each part is encoded
+// with OrderedKeyCodec (so bools aside, parts may be integers, times, strings or byte slices)
+// and escaped, which means composite keys sort by their first part, then by their second part
+// and so on, and parts can contain any bytes without colliding. The key of the first n parts
+// is a prefix of the key of all of them, so it can be used to scan every key under those parts:
+//
+//	store.Put(stow.Key("acme", "invoice", 42), invoice)
+//	store.ForEachPrefix(stow.Key("acme", "invoice"), ...)
+//
+// Key panics if a part has an unsupported type.
+func Key(parts ...interface{}) []byte {
+	var key []byte
+	for _, part := range parts {
+		var encoded []byte
+		if b, ok := part.(bool); ok {
+			encoded = []byte{0}
+			if b {
+				encoded[0] = 1
+			}
+		} else {
+			var err error
+			if encoded, err = (OrderedKeyCodec{}).EncodeKey(part); err != nil {
+				panic(fmt.Sprintf("stow: Key: %v", err))
+			}
+		}
+		key = append(key, escapePart(encoded)...)
+	}
+	return key
+}
+
+// SplitKey returns the encoded parts of a composite key made by Key, they can be decoded
+// with OrderedKeyCodec.DecodeKey.
+func SplitKey(key []byte) (parts [][]byte, err error) {
+	for len(key) > 0 {
+		part, rest, ok := splitPart(key)
+		if !ok {
+			return nil, ErrBadKey
+		}
+		parts = append(parts, part)
+		key = rest
+	}
+	return parts, nil
+}
+
+// escapePart escapes a part of a composite key, so that it can be followed by other parts:
+// zero bytes are escaped as 0x00 0xFF and the part is terminated by 0x00 0x01. This preserves
+// the byte order of parts, even when one is a prefix of the other.
+func escapePart(part []byte) []byte {
+	escaped := make([]byte, 0, len(part)+2)
+	for _, b := range part {
+		if b == 0 {
+			escaped = append(escaped, 0, 0xFF)
+		} else {
+			escaped = append(escaped, b)
+		}
+	}
+	return append(escaped, 0, 1)
+}
+
+// splitPart unescapes the first part of key, and returns the rest of key after it.
+func splitPart(key []byte) (part, rest []byte, ok bool) {
+	for i := 0; i < len(key); i++ {
+		if key[i] != 0 {
+			part = append(part, key[i])
+			continue
+		}
+		if i+1 == len(key) {
+			return nil, nil, false
+		}
+		switch key[i+1] {
+		case 1:
+			if part == nil {
+				part = []byte{}
+			}
+			return part, key[i+2:], true
+		case 0xFF:
+			part = append(part, 0)
+			i++
+		default:
+			return nil, nil, false
+		}
+	}
+	return nil, nil, false
+}
@@ -0,0 +1,211 @@
+package stow
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestOrderedKeyCodec(t *testing.T) {
+	var c OrderedKeyCodec
+	now := time.Now()
+	ordered := [][]interface{}{
+		{int64(-1 << 40), -300, int8(-1), 0, 1, 256, int64(1 << 40)},
+		{uint8(0), uint(1), uint16(256), uint64(1 << 40)},
+		{now.Add(-time.Hour), now, now.Add(time.Nanosecond), now.Add(24 * time.Hour)},
+		{"", "a", "ab", "b"},
+	}
+	for _, keys := range ordered {
+		var encoded [][]byte
+		for _, key := range keys {
+			b, err := c.EncodeKey(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			encoded = append(encoded, b)
+		}
+		if !sort.SliceIsSorted(encoded, func(i, j int) bool { return bytes.Compare(encoded[i], encoded[j]) < 0 }) {
+			t.Errorf("encoding of %v doesn't preserve order", keys)
+		}
+	}
+
+	var i int64
+	b, _ := c.EncodeKey(-42)
+	if err := c.DecodeKey(b, &i); err != nil || i != -42 {
+		t.Errorf("unexpected decode %d %v", i, err)
+	}
+	var u uint64
+	b, _ = c.EncodeKey(uint32(7))
+	if err := c.DecodeKey(b, &u); err != nil || u != 7 {
+		t.Errorf("unexpected decode %d %v", u, err)
+	}
+	var tm time.Time
+	b, _ = c.EncodeKey(now)
+	if err := c.DecodeKey(b, &tm); err != nil || !tm.Equal(now) {
+		t.Errorf("unexpected decode %v %v", tm, err)
+	}
+	var small int8
+	b, _ = c.EncodeKey(1000)
+	if err := c.DecodeKey(b, &small); err == nil {
+		t.Errorf("expected overflow error")
+	}
+
+	if _, err := c.EncodeKey(1.5); !errors.Is(err, ErrUnsupportedKey) {
+		t.Errorf("expected ErrUnsupportedKey, got %v", err)
+	}
+}
+
+func TestKey(t *testing.T) {
+	ordered := [][]byte{
+		Key("acme"),
+		Key("acme", "invoice", -1),
+		Key("acme", "invoice", 2),
+		Key("acme", "invoice", 10),
+		Key("acme", "order", 1),
+		Key("acme\x00", "invoice", 1),
+		Key("acme:invoice"),
+		Key("b"),
+	}
+	if !sort.SliceIsSorted(ordered, func(i, j int) bool { return bytes.Compare(ordered[i], ordered[j]) < 0 }) {
+		t.Errorf("composite keys don't sort hierarchically")
+	}
+	if !bytes.HasPrefix(Key("acme", "invoice", 2), Key("acme", "invoice")) {
+		t.Errorf("expected key of leading parts to be a prefix")
+	}
+	if bytes.HasPrefix(Key("acme:invoice"), Key("acme")) {
+		t.Errorf("parts must not collide on delimiters")
+	}
+
+	parts, err := SplitKey(Key("a\x00b", 7, true))
+	if err != nil || len(parts) != 3 || string(parts[0]) != "a\x00b" {
+		t.Fatalf("unexpected parts %q %v", parts, err)
+	}
+	var n int
+	if err := (OrderedKeyCodec{}).DecodeKey(parts[1], &n); err != nil || n != 7 {
+		t.Errorf("unexpected part %d %v", n, err)
+	}
+	if _, err := SplitKey([]byte("not composite")); err != ErrBadKey {
+		t.Errorf("expected ErrBadKey, got %v", err)
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("expected Key to panic on unsupported parts")
+			}
+		}()
+		Key(1.5)
+	}()
+}
+
+func TestForEachPrefix(t *testing.T) {
+	s := NewJSONStore(db, []byte("keys-prefix"))
+	defer s.DeleteAll()
+
+	s.Put(Key("acme", "invoice", 2), "b")
+	s.Put(Key("acme", "invoice", 1), "a")
+	s.Put(Key("acme", "order", 1), "order")
+	s.Put(Key("other", "invoice", 1), "other")
+
+	var values []string
+	err := s.ForEachPrefix(Key("acme", "invoice"), func(v string) {
+		values = append(values, v)
+	})
+	if err != nil || len(values) != 2 || values[0] != "a" || values[1] != "b" {
+		t.Errorf("unexpected values %q %v", values, err)
+	}
+}
+
+func TestWithKeyCodec(t *testing.T) {
+	s := NewJSONStore(db, []byte("keys-codec"), WithKeyCodec(OrderedKeyCodec{}))
+	defer s.DeleteAll()
+
+	for _, n := range []int{100, -5, 3, 20} {
+		s.Put(n, n)
+	}
+	s.Put("name", 0)
+
+	var v int
+	if err := s.Get(20, &v); err != nil || v != 20 {
+		t.Errorf("unexpected Get %d %v", v, err)
+	}
+	if encoded, _ := (OrderedKeyCodec{}).EncodeKey(3); !hasKey(s, encoded) {
+		t.Errorf("expected keys to be stored with the key codec")
+	}
+
+	var keys []int
+	err := s.ForEachPrefix(nil, func(key int, v int) {
+		keys = append(keys, key)
+	})
+	if err == nil {
+		t.Errorf("expected decoding the string key as an int to fail")
+	}
+	keys = nil
+	s.Delete("name")
+	s.ForEach(func(key int, v int) {
+		keys = append(keys, key)
+	})
+	if len(keys) != 4 || keys[0] != -5 || keys[1] != 3 || keys[2] != 20 || keys[3] != 100 {
+		t.Errorf("expected keys in numeric order, got %v", keys)
+	}
+}
+
+func hasKey(s *Store, key []byte) bool {
+	has, _ := s.Has(key)
+	return has
+}
+
+type binaryKey [4]byte
+
+func (k binaryKey) MarshalBinary() ([]byte, error) { return k[:], nil }
+
+func (k *binaryKey) UnmarshalBinary(data []byte) error {
+	if len(data) != len(k) {
+		return errors.New("invalid binaryKey")
+	}
+	copy(k[:], data)
+	return nil
+}
+
+// stringerKey can't be encoded as json, since it has a chan field.
+type stringerKey struct {
+	ID int
+	Ch chan int
+}
+
+func (k stringerKey) String() string { return fmt.Sprintf("key-%d", k.ID) }
+
+func TestMarshalerKeys(t *testing.T) {
+	for _, s := range []*Store{
+		NewJSONStore(db, []byte("keys-marshaler")),
+		NewJSONStore(db, []byte("keys-marshaler-codec"), WithKeyCodec(OrderedKeyCodec{})),
+	} {
+		s.Put(binaryKey{0, 1, 2, 3}, "binary")
+		s.Put(stringerKey{ID: 7}, "stringer")
+
+		if !hasKey(s, []byte{0, 1, 2, 3}) {
+			t.Errorf("expected MarshalBinary to encode the key")
+		}
+		if !hasKey(s, []byte("key-7")) {
+			t.Errorf("expected String to encode the key")
+		}
+
+		var v string
+		if err := s.Get(binaryKey{0, 1, 2, 3}, &v); err != nil || v != "binary" {
+			t.Errorf("unexpected Get %q %v", v, err)
+		}
+
+		s.Delete(stringerKey{ID: 7})
+		var keys []binaryKey
+		err := s.ForEach(func(k binaryKey, v string) {
+			keys = append(keys, k)
+		})
+		if err != nil || len(keys) != 1 || keys[0] != (binaryKey{0, 1, 2, 3}) {
+			t.Errorf("expected UnmarshalBinary to decode keys: %v %v", keys, err)
+		}
+		s.DeleteAll()
+	}
+}
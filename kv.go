@@ -0,0 +1,443 @@
+package stow
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// KVBackend is a transactional key-value engine with a single, ordered key space, the small
+// interface to implement to plug another engine in. NewKVBackend turns it into the Backend
+// of a Store.
+type KVBackend interface {
+	// View runs fn in a read-only transaction.
+	View(fn func(tx KVTx) error) error
+	// Update runs fn in a read-write transaction, which is committed if fn returns nil
+	// and rolled back otherwise, leaving no changes.
+	Update(fn func(tx KVTx) error) error
+}
+
+// KVTx is a transaction of a KVBackend. Keys and values are only valid until it ends.
+type KVTx interface {
+	// Get returns the value of key, or nil if there's none.
+	Get(key []byte) []byte
+	// Put sets the value of key, it may keep neither key nor value after it returns.
+	Put(key, value []byte) error
+	// Delete removes key, it returns nil if there's no such key.
+	Delete(key []byte) error
+	// ForEach calls fn for each key which starts with prefix, from the first one at or after
+	// start (which is nil or starts with prefix), with its value, in key order, until fn
+	// returns an error, which ForEach returns. fn must not modify the key space.
+	ForEach(prefix, start []byte, fn func(k, v []byte) error) error
+}
+
+// NewKVBackend returns a Backend which keeps the bucket tree of its stores in kv: the keys of
+// a bucket are prefixed by the path of the bucket, so each bucket is a range of keys, and
+// nested buckets are marked by a value recording their sequence number. Since keys are
+// stored prefixed, kv should be dedicated to the Backend.
+func NewKVBackend(kv KVBackend) Backend {
+	return kvBackend{kv}
+}
+
+// KVEntries returns the prefix of the keys in which a Backend of NewKVBackend keeps the
+// entries of the bucket at path, the names of the buckets from the top one, like to watch the
+// writes to a store's bucket through the KVBackend. The entry's name follows the prefix.
+func KVEntries(path ...[]byte) []byte {
+	b := kvBucketOf{}
+	for _, name := range path {
+		b = b.nested(name)
+	}
+	return b.entries()
+}
+
+// KVValue returns the value of an entry from its value in the KVBackend, ok is false if the
+// entry is a nested bucket.
+func KVValue(v []byte) (value []byte, ok bool) {
+	if len(v) == 0 || v[0] != kvValue {
+		return nil, false
+	}
+	return v[1:], true
+}
+
+// The key of an entry of a bucket is the path of the bucket followed by kvEntries and the
+// entry's name. The path is the names of the buckets from the top, each escaped (kvEscape
+// stands for a zero byte) and followed by kvNested, so the entries of a bucket sort together,
+// before or after those of its nested buckets, but never among them.
+const (
+	kvNested  = "\x00\x01"
+	kvEntries = "\x00\x02"
+	kvEscape  = "\x00\xff"
+)
+
+// The values of entries start with their kind. A nested bucket's is followed by its sequence.
+const (
+	kvValue  byte = 0
+	kvBucket byte = 1
+)
+
+// kvCursorBatch is the number of entries a cursor reads at once.
+const kvCursorBatch = 256
+
+var errStopKV = errors.New("stop iterating")
+
+type kvBackend struct {
+	kv KVBackend
+}
+
+func (b kvBackend) View(fn func(tx BackendTx) error) error {
+	return b.kv.View(func(tx KVTx) error {
+		return fn(&kvTx{tx: tx})
+	})
+}
+
+func (b kvBackend) Update(fn func(tx BackendTx) error) error {
+	return b.kv.Update(func(tx KVTx) error {
+		return fn(&kvTx{tx: tx})
+	})
+}
+
+// kvTx is the BackendTx of a KVTx, whose top-level buckets are the nested buckets of a root
+// bucket with an empty path.
+type kvTx struct {
+	tx KVTx
+	// writes counts the writes to tx, so cursors know when what they read is stale.
+	writes int
+}
+
+func (t *kvTx) root() kvBucketOf {
+	return kvBucketOf{t: t}
+}
+
+func (t *kvTx) Bucket(name []byte) BackendBucket {
+	return t.root().Bucket(name)
+}
+
+func (t *kvTx) CreateBucketIfNotExists(name []byte) (BackendBucket, error) {
+	return t.root().CreateBucketIfNotExists(name)
+}
+
+func (t *kvTx) DeleteBucket(name []byte) error {
+	return t.root().DeleteBucket(name)
+}
+
+func (t *kvTx) ForEach(fn func(name []byte, b BackendBucket) error) error {
+	root := t.root()
+	return root.ForEach(func(k, v []byte) error {
+		if v != nil {
+			return nil
+		}
+		return fn(k, root.Bucket(k))
+	})
+}
+
+func (t *kvTx) put(key, value []byte) error {
+	t.writes++
+	return t.tx.Put(key, value)
+}
+
+func (t *kvTx) delete(key []byte) error {
+	t.writes++
+	return t.tx.Delete(key)
+}
+
+// kvBucketOf is the BackendBucket of the keys of a kvTx under path.
+type kvBucketOf struct {
+	t    *kvTx
+	path []byte
+	// marker is the key of the bucket's entry in its parent, nil for the root.
+	marker []byte
+}
+
+func (b kvBucketOf) entries() []byte {
+	return append(append([]byte{}, b.path...), kvEntries...)
+}
+
+func (b kvBucketOf) entryKey(name []byte) []byte {
+	return append(b.entries(), name...)
+}
+
+func (b kvBucketOf) nested(name []byte) kvBucketOf {
+	path := append([]byte{}, b.path...)
+	path = append(path, bytes.ReplaceAll(name, []byte{0}, []byte(kvEscape))...)
+	return kvBucketOf{t: b.t, path: append(path, kvNested...), marker: b.entryKey(name)}
+}
+
+func (b kvBucketOf) entry(name []byte) (kind byte, value []byte, ok bool) {
+	v := b.t.tx.Get(b.entryKey(name))
+	if len(v) == 0 {
+		return 0, nil, false
+	}
+	return v[0], v[1:], true
+}
+
+func (b kvBucketOf) Get(key []byte) []byte {
+	if kind, v, ok := b.entry(key); ok && kind == kvValue {
+		return v
+	}
+	return nil
+}
+
+func (b kvBucketOf) Put(key, value []byte) error {
+	if len(key) == 0 {
+		return bolt.ErrKeyRequired
+	}
+	if kind, _, ok := b.entry(key); ok && kind == kvBucket {
+		return bolt.ErrIncompatibleValue
+	}
+	v := make([]byte, 1+len(value))
+	v[0] = kvValue
+	copy(v[1:], value)
+	return b.t.put(b.entryKey(key), v)
+}
+
+func (b kvBucketOf) Delete(key []byte) error {
+	kind, _, ok := b.entry(key)
+	if !ok {
+		return nil
+	}
+	if kind == kvBucket {
+		return bolt.ErrIncompatibleValue
+	}
+	return b.t.delete(b.entryKey(key))
+}
+
+func (b kvBucketOf) ForEach(fn func(k, v []byte) error) error {
+	c := b.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		if err := fn(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b kvBucketOf) Cursor() BackendCursor {
+	return &kvCursor{b: b}
+}
+
+func (b kvBucketOf) Bucket(name []byte) BackendBucket {
+	if kind, _, ok := b.entry(name); ok && kind == kvBucket {
+		return b.nested(name)
+	}
+	return nil
+}
+
+func (b kvBucketOf) CreateBucket(name []byte) (BackendBucket, error) {
+	if len(name) == 0 {
+		return nil, bolt.ErrBucketNameRequired
+	}
+	if kind, _, ok := b.entry(name); ok {
+		if kind == kvBucket {
+			return nil, bolt.ErrBucketExists
+		}
+		return nil, bolt.ErrIncompatibleValue
+	}
+	nested := b.nested(name)
+	if err := nested.SetSequence(0); err != nil {
+		return nil, err
+	}
+	return nested, nil
+}
+
+func (b kvBucketOf) CreateBucketIfNotExists(name []byte) (BackendBucket, error) {
+	if nested := b.Bucket(name); nested != nil {
+		return nested, nil
+	}
+	return b.CreateBucket(name)
+}
+
+func (b kvBucketOf) DeleteBucket(name []byte) error {
+	kind, _, ok := b.entry(name)
+	if !ok {
+		return bolt.ErrBucketNotFound
+	}
+	if kind != kvBucket {
+		return bolt.ErrIncompatibleValue
+	}
+
+	// The keys of the bucket and of its nested buckets all start with its path.
+	prefix := b.nested(name).path
+	var keys [][]byte
+	err := b.t.tx.ForEach(prefix, nil, func(k, v []byte) error {
+		keys = append(keys, append([]byte(nil), k...))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if err := b.t.delete(k); err != nil {
+			return err
+		}
+	}
+	return b.t.delete(b.entryKey(name))
+}
+
+func (b kvBucketOf) Sequence() uint64 {
+	if b.marker == nil {
+		return 0
+	}
+	v := b.t.tx.Get(b.marker)
+	if len(v) != 9 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(v[1:])
+}
+
+func (b kvBucketOf) SetSequence(seq uint64) error {
+	if b.marker == nil {
+		return bolt.ErrIncompatibleValue
+	}
+	v := make([]byte, 9)
+	v[0] = kvBucket
+	binary.BigEndian.PutUint64(v[1:], seq)
+	return b.t.put(b.marker, v)
+}
+
+func (b kvBucketOf) NextSequence() (uint64, error) {
+	seq := b.Sequence() + 1
+	return seq, b.SetSequence(seq)
+}
+
+type kvEntry struct {
+	key, value []byte
+}
+
+// kvCursor reads the entries of a bucket in batches, and reads them again from where it is
+// when the transaction was written to since.
+type kvCursor struct {
+	b      kvBucketOf
+	batch  []kvEntry
+	pos    int
+	writes int
+}
+
+func (c *kvCursor) First() (key, value []byte) {
+	return c.Seek(nil)
+}
+
+func (c *kvCursor) Seek(seek []byte) (key, value []byte) {
+	c.read(seek)
+	return c.current()
+}
+
+func (c *kvCursor) Next() (key, value []byte) {
+	if c.pos >= len(c.batch) {
+		return nil, nil
+	}
+	last := c.batch[c.pos].key
+	c.pos++
+	if c.writes != c.b.t.writes || (c.pos == len(c.batch) && len(c.batch) == kvCursorBatch) {
+		// The smallest key after last.
+		c.read(append(append([]byte(nil), last...), 0))
+	}
+	return c.current()
+}
+
+func (c *kvCursor) current() (key, value []byte) {
+	if c.pos >= len(c.batch) {
+		return nil, nil
+	}
+	e := c.batch[c.pos]
+	return e.key, e.value
+}
+
+// read reads the batch of entries from the first one at or after start.
+func (c *kvCursor) read(start []byte) {
+	c.batch, c.pos, c.writes = c.batch[:0], 0, c.b.t.writes
+	prefix := c.b.entries()
+	err := c.b.t.tx.ForEach(prefix, append(prefix, start...), func(k, v []byte) error {
+		if len(v) == 0 {
+			return nil
+		}
+		e := kvEntry{key: append([]byte(nil), k[len(prefix):]...)}
+		if v[0] == kvValue {
+			e.value = append([]byte{}, v[1:]...)
+		}
+		c.batch = append(c.batch, e)
+		if len(c.batch) == kvCursorBatch {
+			return errStopKV
+		}
+		return nil
+	})
+	if err != nil && err != errStopKV {
+		// Cursors can't fail, like bolt's: end the iteration.
+		c.batch = c.batch[:0]
+	}
+}
+
+// NewBoltKV returns a KVBackend which keeps its keys in bucket of db, like to serve them from
+// stowd.
+func NewBoltKV(db *bolt.DB, bucket []byte) KVBackend {
+	return boltKV{db: db, bucket: bucketSpec{bucket}}
+}
+
+type boltKV struct {
+	db     *bolt.DB
+	bucket bucketSpec
+}
+
+func (b boltKV) View(fn func(tx KVTx) error) error {
+	return b.db.View(func(tx *bolt.Tx) error {
+		return fn(boltKVTx{tx: boltTx{tx}, bucket: b.bucket})
+	})
+}
+
+func (b boltKV) Update(fn func(tx KVTx) error) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return fn(boltKVTx{tx: boltTx{tx}, bucket: b.bucket})
+	})
+}
+
+type boltKVTx struct {
+	tx     BackendTx
+	bucket bucketSpec
+}
+
+func (t boltKVTx) Get(key []byte) []byte {
+	if b := t.bucket.get(t.tx); b != nil {
+		return b.Get(key)
+	}
+	return nil
+}
+
+func (t boltKVTx) Put(key, value []byte) error {
+	b, err := t.bucket.createOrGet(t.tx)
+	if err != nil {
+		return err
+	}
+	if err := b.Put(key, value); err != nil {
+		return writeError(t.bucket, key, err)
+	}
+	return nil
+}
+
+func (t boltKVTx) Delete(key []byte) error {
+	if b := t.bucket.get(t.tx); b != nil {
+		return b.Delete(key)
+	}
+	return nil
+}
+
+func (t boltKVTx) ForEach(prefix, start []byte, fn func(k, v []byte) error) error {
+	b := t.bucket.get(t.tx)
+	if b == nil {
+		return nil
+	}
+	if start == nil {
+		start = prefix
+	}
+	c := b.Cursor()
+	for k, v := c.Seek(start); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		if v == nil {
+			// A nested bucket.
+			continue
+		}
+		if err := fn(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package stow
+
+import (
+	"bytes"
+)
+
+// Lazy is an object passed to the func of ForEachLazy, whose value is only decoded when
+// Decode is called. It's only valid until that func returns.
+type Lazy struct {
+	s         *Store
+	key, data []byte
+}
+
+// Key returns the key of the object, as it's stored (without the prefix of a Namespace).
+func (l *Lazy) Key() []byte { return l.s.trimNS(l.key) }
+
+// Raw returns the value of the object as the store's Codec encoded it. Its checksum (see
+// WithChecksums) is only verified by Decode.
+func (l *Lazy) Raw() []byte {
+	if l.s.opts.checksums && len(l.data) >= checksumSize {
+		return l.data[:len(l.data)-checksumSize]
+	}
+	return l.data
+}
+
+// Decode decodes the value of the object into dest, like Get does.
+func (l *Lazy) Decode(dest interface{}) error {
+	if err := l.s.checkDecodeSize(l.data); err != nil {
+		return l.s.keyError(l.key, err)
+	}
+	if err := l.s.unmarshalValue(l.key, l.data, dest); err != nil {
+		return l.s.decodeError(l.key, l.data, err)
+	}
+	return nil
+}
+
+// ForEachLazy runs do on each object in the store, in key order, without decoding their
+// values: do can filter objects on their keys or encoded values first, and only decode those
+// it needs. The objects are read in one read-only transaction, and their values aren't
+// copied. Iteration stops at the first error returned by do.
+func (s *Store) ForEachLazy(do func(obj *Lazy) error) error {
+	var expired [][]byte
+	err := s.db.View(func(tx BackendTx) error {
+		objects := s.bucket.get(tx)
+		if objects == nil {
+			return nil
+		}
+		isExpired := s.expiryCheck(tx)
+		obj := &Lazy{s: s}
+		c := objects.Cursor()
+		for k, v := c.Seek(s.prefix); k != nil && bytes.HasPrefix(k, s.prefix); k, v = c.Next() {
+			if v == nil {
+				continue
+			}
+			if isExpired(k) {
+				expired = append(expired, append([]byte(nil), k...))
+				continue
+			}
+			obj.key, obj.data = k, v
+			if err := do(obj); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return s.afterExpiredRead(err, expired)
+}
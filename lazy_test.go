@@ -0,0 +1,54 @@
+package stow
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestForEachLazy(t *testing.T) {
+	s := NewJSONStore(db, []byte("for_each_lazy"), WithChecksums())
+	defer s.DeleteAll()
+
+	s.Put("a", MyType{FirstName: "Ann"})
+	s.Put("b", MyType{FirstName: "Bob"})
+	s.Put("c", MyType{FirstName: "Cat"})
+
+	var names []string
+	err := s.ForEachLazy(func(obj *Lazy) error {
+		if string(obj.Key()) == "b" {
+			return nil
+		}
+		if obj.Raw()[len(obj.Raw())-1] != '\n' {
+			t.Errorf("expected the raw json of %s, got %q", obj.Key(), obj.Raw())
+		}
+		var v MyType
+		if err := obj.Decode(&v); err != nil {
+			return err
+		}
+		names = append(names, v.FirstName)
+		return nil
+	})
+	if err != nil || len(names) != 2 || names[0] != "Ann" || names[1] != "Cat" {
+		t.Errorf("unexpected objects %v %v", names, err)
+	}
+
+	errStop := errors.New("stop")
+	var n int
+	err = s.ForEachLazy(func(obj *Lazy) error {
+		n++
+		return errStop
+	})
+	if err != errStop || n != 1 {
+		t.Errorf("expected iteration to stop, got %d %v", n, err)
+	}
+
+	s.Put("d", "not a MyType")
+	err = s.ForEachLazy(func(obj *Lazy) error {
+		var v MyType
+		return obj.Decode(&v)
+	})
+	var decodeErr *DecodeError
+	if !errors.As(err, &decodeErr) || string(decodeErr.Key) != "d" {
+		t.Errorf("expected a DecodeError for d, got %v", err)
+	}
+}
@@ -0,0 +1,117 @@
+package stow
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrLocked is returned by Acquire for a lock which is held by an unexpired lease.
+var ErrLocked = errors.New("lock is held")
+
+// LockStore is a set of named locks kept in a Store, so they survive restarts: a lock is held
+// until its lease is released or expires, which frees the locks of a holder which died.
+// It's safe for concurrent use, and should have its Store (or Namespace) to itself.
+type LockStore struct {
+	s *Store
+}
+
+// LockLease is a lock held by Acquire, until Expires.
+type LockLease struct {
+	Name string
+	// Token increases each time the lock is acquired, so it can fence off the writes of a
+	// holder whose lease expired.
+	Token   uint64
+	Expires time.Time
+}
+
+// lockRecord is the object a lock is kept as. It stays after the lock is released, to keep
+// its token.
+type lockRecord struct {
+	Token   uint64
+	Expires time.Time
+}
+
+// NewLockStore returns the LockStore of the locks kept in s.
+func (s *Store) NewLockStore() *LockStore {
+	return &LockStore{s: s}
+}
+
+// Acquire takes the lock name for ttl, or returns ErrLocked if it's held.
+func (l *LockStore) Acquire(name string, ttl time.Duration) (lease *LockLease, err error) {
+	key, err := l.s.toBytes(name)
+	if err != nil {
+		return nil, err
+	}
+	err = l.update(key, func(rec *lockRecord, now time.Time) error {
+		if now.Before(rec.Expires) {
+			return ErrLocked
+		}
+		rec.Token++
+		rec.Expires = now.Add(ttl)
+		lease = &LockLease{Name: name, Token: rec.Token, Expires: rec.Expires}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return lease, nil
+}
+
+// Renew extends lease for ttl from now. It returns ErrLeaseLost if the lease expired, or
+// was released.
+func (l *LockStore) Renew(lease *LockLease, ttl time.Duration) error {
+	key, err := l.s.toBytes(lease.Name)
+	if err != nil {
+		return err
+	}
+	return l.update(key, func(rec *lockRecord, now time.Time) error {
+		if rec.Token != lease.Token || !now.Before(rec.Expires) {
+			return ErrLeaseLost
+		}
+		rec.Expires = now.Add(ttl)
+		lease.Expires = rec.Expires
+		return nil
+	})
+}
+
+// Release gives up lease, so the lock can be acquired right away. It returns ErrLeaseLost if
+// the lock was acquired again since.
+func (l *LockStore) Release(lease *LockLease) error {
+	key, err := l.s.toBytes(lease.Name)
+	if err != nil {
+		return err
+	}
+	return l.update(key, func(rec *lockRecord, now time.Time) error {
+		if rec.Token != lease.Token {
+			return ErrLeaseLost
+		}
+		rec.Expires = time.Time{}
+		return nil
+	})
+}
+
+// update runs fn on the record of the lock at key, and stores it unless fn fails.
+func (l *LockStore) update(key []byte, fn func(rec *lockRecord, now time.Time) error) error {
+	s := l.s
+	defer s.forget(key)
+	return s.update(func(tx BackendTx) error {
+		objects, err := s.bucket.createOrGet(tx)
+		if err != nil {
+			return err
+		}
+		var rec lockRecord
+		if data := objects.Get(key); data != nil {
+			if err := s.unmarshalValue(key, data, &rec); err != nil {
+				return s.decodeError(key, data, err)
+			}
+		}
+		if err := fn(&rec, time.Now()); err != nil {
+			return err
+		}
+		data, err := s.marshalValue(rec)
+		if err != nil {
+			return err
+		}
+		return s.writeKey(tx, objects, key, data, nil, 0)
+	})
+}
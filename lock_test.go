@@ -0,0 +1,45 @@
+package stow
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLockStore(t *testing.T) {
+	s := NewJSONStore(db, []byte("locks"))
+	defer s.DeleteAll()
+	locks := s.NewLockStore()
+
+	lease, err := locks.Acquire("compactor", 30*time.Millisecond)
+	if err != nil || lease.Token != 1 {
+		t.Fatalf("unexpected lease %+v %v", lease, err)
+	}
+	// Locks are persisted, so they're held across LockStores.
+	if _, err := s.NewLockStore().Acquire("compactor", time.Minute); err != ErrLocked {
+		t.Errorf("expected ErrLocked, got %v", err)
+	}
+	if _, err := locks.Acquire("indexer", time.Minute); err != nil {
+		t.Errorf("locks should be independent, got %v", err)
+	}
+	if err := locks.Renew(lease, 30*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(40 * time.Millisecond)
+	if err := locks.Renew(lease, time.Minute); err != ErrLeaseLost {
+		t.Errorf("expected ErrLeaseLost renewing an expired lease, got %v", err)
+	}
+	next, err := locks.Acquire("compactor", time.Minute)
+	if err != nil || next.Token != 2 {
+		t.Fatalf("expected the expired lock to be acquired again, got %+v %v", next, err)
+	}
+	if err := locks.Release(lease); err != ErrLeaseLost {
+		t.Errorf("expected ErrLeaseLost releasing a stale lease, got %v", err)
+	}
+	if err := locks.Release(next); err != nil {
+		t.Fatal(err)
+	}
+	if lease, err := locks.Acquire("compactor", time.Minute); err != nil || lease.Token != 3 {
+		t.Errorf("expected the released lock to be acquired, got %+v %v", lease, err)
+	}
+}
@@ -0,0 +1,211 @@
+package stow
+
+import (
+	"sort"
+	"sync"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// NewMemStore returns an empty store kept in memory, encoding values with codec, for tests
+// which don't need a bolt file on disk. Each call returns a separate store, so parallel
+// tests don't share data. It's a Store like any other, on NewMemBackend.
+func NewMemStore(bucket []byte, codec Codec, opts ...Option) *Store {
+	return NewBackendStore(NewMemBackend(), bucket, codec, opts...)
+}
+
+// NewMemBackend returns an empty Backend kept in memory, which can hold several stores like
+// a bolt database. Transactions are serialized like bolt's, many readers or one writer at a
+// time, and an Update which fails leaves no changes.
+func NewMemBackend() Backend {
+	return NewKVBackend(newMemKV())
+}
+
+// memKV is a KVBackend in memory.
+type memKV struct {
+	mu   sync.RWMutex
+	data map[string][]byte
+	keys memKeys
+}
+
+func newMemKV() *memKV {
+	return &memKV{data: make(map[string][]byte)}
+}
+
+func (kv *memKV) View(fn func(tx KVTx) error) error {
+	kv.mu.RLock()
+	defer kv.mu.RUnlock()
+	return fn(&memTx{kv: kv})
+}
+
+func (kv *memKV) Update(fn func(tx KVTx) error) (err error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	tx := &memTx{kv: kv, undo: make(map[string]memUndo)}
+	committed := false
+	defer func() {
+		if !committed {
+			tx.rollback()
+		}
+	}()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	committed = true
+	return nil
+}
+
+// memTx is a transaction of a memKV. An Update writes in place, and keeps the value each key
+// it writes had before, to restore them if it fails.
+type memTx struct {
+	kv   *memKV
+	undo map[string]memUndo
+}
+
+type memUndo struct {
+	value   []byte
+	existed bool
+}
+
+func (t *memTx) Get(key []byte) []byte {
+	return t.kv.data[string(key)]
+}
+
+func (t *memTx) Put(key, value []byte) error {
+	if t.undo == nil {
+		return bolt.ErrTxNotWritable
+	}
+	t.set(string(key), append([]byte{}, value...), true)
+	return nil
+}
+
+func (t *memTx) Delete(key []byte) error {
+	if t.undo == nil {
+		return bolt.ErrTxNotWritable
+	}
+	t.set(string(key), nil, false)
+	return nil
+}
+
+// set sets the value of k, or removes it if !exists, recording its value for rollback.
+func (t *memTx) set(k string, value []byte, exists bool) {
+	old, existed := t.kv.data[k]
+	if _, ok := t.undo[k]; !ok {
+		t.undo[k] = memUndo{value: old, existed: existed}
+	}
+	t.kv.restore(k, value, exists, existed)
+}
+
+func (t *memTx) rollback() {
+	for k, u := range t.undo {
+		_, exists := t.kv.data[k]
+		t.kv.restore(k, u.value, u.existed, exists)
+	}
+}
+
+// restore sets the value of k, existed tells whether k currently has one.
+func (kv *memKV) restore(k string, value []byte, exists, existed bool) {
+	switch {
+	case exists:
+		kv.data[k] = value
+		if !existed {
+			kv.keys.insert(k)
+		}
+	case existed:
+		delete(kv.data, k)
+		kv.keys.remove(k)
+	}
+}
+
+func (t *memTx) ForEach(prefix, start []byte, fn func(k, v []byte) error) error {
+	if start == nil {
+		start = prefix
+	}
+	return t.kv.keys.ascend(string(start), func(k string) (bool, error) {
+		if len(k) < len(prefix) || k[:len(prefix)] != string(prefix) {
+			return false, nil
+		}
+		return true, fn([]byte(k), t.kv.data[k])
+	})
+}
+
+// memChunkSize is the size at which a chunk of memKeys is split.
+const memChunkSize = 512
+
+// memKeys is an ordered set of keys, kept in sorted chunks so inserting and removing a key
+// only moves the keys of its chunk.
+type memKeys struct {
+	chunks [][]string
+}
+
+// chunk returns the index of the chunk k belongs in: the first one whose last key is at or
+// after k, or the last one.
+func (m *memKeys) chunk(k string) int {
+	i := sort.Search(len(m.chunks), func(i int) bool {
+		c := m.chunks[i]
+		return c[len(c)-1] >= k
+	})
+	if i == len(m.chunks) && i > 0 {
+		i--
+	}
+	return i
+}
+
+func (m *memKeys) insert(k string) {
+	if len(m.chunks) == 0 {
+		m.chunks = [][]string{{k}}
+		return
+	}
+	i := m.chunk(k)
+	c := m.chunks[i]
+	j := sort.SearchStrings(c, k)
+	c = append(c, "")
+	copy(c[j+1:], c[j:])
+	c[j] = k
+	m.chunks[i] = c
+
+	if len(c) >= memChunkSize {
+		half := len(c) / 2
+		upper := append([]string(nil), c[half:]...)
+		m.chunks[i] = c[:half:half]
+		m.chunks = append(m.chunks, nil)
+		copy(m.chunks[i+2:], m.chunks[i+1:])
+		m.chunks[i+1] = upper
+	}
+}
+
+func (m *memKeys) remove(k string) {
+	if len(m.chunks) == 0 {
+		return
+	}
+	i := m.chunk(k)
+	c := m.chunks[i]
+	j := sort.SearchStrings(c, k)
+	if j == len(c) || c[j] != k {
+		return
+	}
+	c = append(c[:j], c[j+1:]...)
+	if len(c) > 0 {
+		m.chunks[i] = c
+		return
+	}
+	m.chunks = append(m.chunks[:i], m.chunks[i+1:]...)
+}
+
+// ascend calls fn with the keys from the first one at or after start, in order, until it
+// returns false or an error.
+func (m *memKeys) ascend(start string, fn func(k string) (bool, error)) error {
+	if len(m.chunks) == 0 {
+		return nil
+	}
+	i := m.chunk(start)
+	j := sort.SearchStrings(m.chunks[i], start)
+	for ; i < len(m.chunks); i, j = i+1, 0 {
+		for _, k := range m.chunks[i][j:] {
+			if ok, err := fn(k); !ok || err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package stow
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestMemStore(t *testing.T) {
+	s := NewMemStore([]byte("people"), JSONCodec{})
+	testBackend(t, "mem store", s)
+
+	other := NewMemStore([]byte("people"), JSONCodec{})
+	s.Put("a", MyType{FirstName: "Ann"})
+	if has, _ := other.Has("a"); has {
+		t.Errorf("separate mem stores share data")
+	}
+}
+
+func TestMemKVRollback(t *testing.T) {
+	kv := newMemKV()
+	kv.Update(func(tx KVTx) error { return tx.Put([]byte("a"), []byte("1")) })
+
+	errFail := errors.New("fail")
+	err := kv.Update(func(tx KVTx) error {
+		tx.Delete([]byte("a"))
+		tx.Put([]byte("b"), []byte("2"))
+		tx.Put([]byte("a"), []byte("3"))
+		return errFail
+	})
+	if err != errFail {
+		t.Fatalf("expected errFail got %v", err)
+	}
+	kv.View(func(tx KVTx) error {
+		if string(tx.Get([]byte("a"))) != "1" || tx.Get([]byte("b")) != nil {
+			t.Errorf("failed update left changes")
+		}
+		if err := tx.Put([]byte("c"), nil); err == nil {
+			t.Errorf("expected a write in a View to fail")
+		}
+		var keys []string
+		tx.ForEach(nil, nil, func(k, v []byte) error {
+			keys = append(keys, string(k))
+			return nil
+		})
+		if len(keys) != 1 || keys[0] != "a" {
+			t.Errorf("unexpected keys after rollback %q", keys)
+		}
+		return nil
+	})
+}
+
+func TestMemKVOrder(t *testing.T) {
+	kv := newMemKV()
+	// Enough keys to split chunks, written out of order, half of them removed.
+	kv.Update(func(tx KVTx) error {
+		for i := 0; i < 4*memChunkSize; i++ {
+			tx.Put([]byte(fmt.Sprintf("k%05d", (i*7919)%(4*memChunkSize))), nil)
+		}
+		for i := 0; i < 4*memChunkSize; i += 2 {
+			tx.Delete([]byte(fmt.Sprintf("k%05d", i)))
+		}
+		return nil
+	})
+	kv.View(func(tx KVTx) error {
+		var keys []string
+		tx.ForEach([]byte("k"), []byte("k00100"), func(k, v []byte) error {
+			keys = append(keys, string(k))
+			return nil
+		})
+		if len(keys) != 2*memChunkSize-50 || keys[0] != "k00101" {
+			t.Fatalf("unexpected keys from k00100: %d, first %q", len(keys), keys[0])
+		}
+		for i := 1; i < len(keys); i++ {
+			if keys[i-1] >= keys[i] {
+				t.Fatalf("keys out of order: %q before %q", keys[i-1], keys[i])
+			}
+		}
+		return nil
+	})
+}
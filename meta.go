@@ -0,0 +1,64 @@
+package stow
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// metaBucketName is the top-level bucket stow uses for bookkeeping about stores.
+// It mirrors the bucket layout of the stores it describes, so the metadata for a
+// store in buckets a/b lives in the bucket __stow__/a/b. Don't use it as a Store bucket.
+var metaBucketName = []byte("__stow__")
+
+// Names stow uses inside a meta bucket start with a zero byte, so they don't
+// collide with the meta buckets of nested stores.
+var createdKey = []byte("\x00created")
+
+// meta returns the bucket which holds the metadata for the store in bs.
+func (bs bucketSpec) meta() bucketSpec {
+	m := make(bucketSpec, 0, len(bs)+1)
+	m = append(m, metaBucketName)
+	return append(m, bs...)
+}
+
+// markCreated records the current time as the creation time of bs.
+func (bs bucketSpec) markCreated(tx BackendTx) error {
+	meta, err := bs.meta().createOrGetUntracked(tx)
+	if err != nil {
+		return err
+	}
+	return meta.Put(createdKey, encodeTime(time.Now()))
+}
+
+// created returns when bs was created, ok is false if the time wasn't recorded.
+func (bs bucketSpec) created(tx BackendTx) (t time.Time, ok bool) {
+	meta := bs.meta().get(tx)
+	if meta == nil {
+		return t, false
+	}
+	data := meta.Get(createdKey)
+	if len(data) != timeLength {
+		return t, false
+	}
+	return decodeTime(data), true
+}
+
+// timeLength is the length of an encoded time.
+const timeLength = 8
+
+func encodeTime(t time.Time) []byte {
+	data := make([]byte, timeLength)
+	binary.BigEndian.PutUint64(data, uint64(t.UnixNano()))
+	return data
+}
+
+func decodeTime(data []byte) time.Time {
+	return time.Unix(0, int64(binary.BigEndian.Uint64(data)))
+}
+
+// timeKey prefixes key with an encoded time, so that keys sort by time.
+func timeKey(t, key []byte) []byte {
+	k := make([]byte, 0, len(t)+len(key))
+	k = append(k, t...)
+	return append(k, key...)
+}
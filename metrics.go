@@ -0,0 +1,58 @@
+package stow
+
+import (
+	"strings"
+	"time"
+)
+
+// Op names an operation recorded by a Collector.
+type Op string
+
+// Ops recorded by a Collector. Pull is recorded as OpPull only, and Remove as OpDelete.
+const (
+	OpPut    Op = "put"
+	OpGet    Op = "get"
+	OpPull   Op = "pull"
+	OpDelete Op = "delete"
+)
+
+// Collector records the operations of the stores given WithMetrics, for metrics like
+// operation and error counts, latencies and value sizes. It's called by every goroutine
+// using the stores, so it must be safe for concurrent use, and it should be quick.
+type Collector interface {
+	// Observe records an operation on the store of the bucket, named by its path with
+	// nested buckets separated by /: how long it took, the size of the encoded value it read
+	// or wrote (0 if none) and its error. Misses are recorded with ErrNotFound.
+	Observe(bucket string, op Op, d time.Duration, size int, err error)
+}
+
+// WithMetrics makes the store record its Put, Get, Pull, Delete and Remove operations with c,
+// along with those of GetOrZero, PutTTL and the other variants built on them. Nested stores
+// inherit it. It can be given more than once, to record with several Collectors. Key counts
+// aren't recorded per operation, collectors can read them with BucketStats.
+func WithMetrics(c Collector) Option {
+	return func(s *Store) {
+		metrics := s.opts.metrics
+		s.opts.metrics = append(metrics[:len(metrics):len(metrics)], c)
+	}
+}
+
+// observe records an operation which started at start with the store's Collector, if any.
+func (s *Store) observe(op Op, start time.Time, size int, err error) {
+	if len(s.opts.metrics) == 0 {
+		return
+	}
+	d, bucket := time.Since(start), s.bucket.path()
+	for _, c := range s.opts.metrics {
+		c.Observe(bucket, op, d, size, err)
+	}
+}
+
+// path returns the path of the bucket, nested buckets separated by /.
+func (bs bucketSpec) path() string {
+	parts := make([]string, len(bs))
+	for i, b := range bs {
+		parts[i] = string(b)
+	}
+	return strings.Join(parts, "/")
+}
@@ -0,0 +1,55 @@
+package stow
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+type observation struct {
+	bucket string
+	op     Op
+	size   int
+	err    error
+}
+
+type recordingCollector struct {
+	mu  sync.Mutex
+	obs []observation
+}
+
+func (c *recordingCollector) Observe(bucket string, op Op, d time.Duration, size int, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.obs = append(c.obs, observation{bucket, op, size, err})
+}
+
+func TestMetrics(t *testing.T) {
+	c := &recordingCollector{}
+	s := NewJSONStore(db, []byte("metrics"), WithMetrics(c))
+	defer s.DeleteAll()
+	nested := s.NewNestedStore([]byte("nested"))
+
+	s.Put("a", MyType{FirstName: "Ann"})
+	var v MyType
+	s.Get("a", &v)
+	s.Get("missing", &v)
+	s.Pull("a", &v)
+	nested.Delete("b")
+
+	want := []observation{
+		{"metrics", OpPut, len(`{"first":"Ann","last":""}` + "\n"), nil},
+		{"metrics", OpGet, len(`{"first":"Ann","last":""}` + "\n"), nil},
+		{"metrics", OpGet, 0, ErrNotFound},
+		{"metrics", OpPull, len(`{"first":"Ann","last":""}` + "\n"), nil},
+		{"metrics/nested", OpDelete, 0, nil},
+	}
+	if len(c.obs) != len(want) {
+		t.Fatalf("unexpected observations %v", c.obs)
+	}
+	for i, o := range c.obs {
+		if o != want[i] {
+			t.Errorf("observation %d: expected %v got %v", i, want[i], o)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package stow
+
+import (
+	"bytes"
+	"errors"
+)
+
+// ErrDifferentDB indicates a MoveTo between stores of different databases, which can't
+// happen in one transaction.
+var ErrDifferentDB = errors.New("stores are in different databases")
+
+// Move renames the object at oldKey to newKey, replacing any object at newKey, in one
+// transaction. It returns ErrNotFound if there's no object at oldKey.
+func (s *Store) Move(oldKey, newKey []byte) error {
+	return s.move(s, s.nsKey(oldKey), s.nsKey(newKey))
+}
+
+// MoveTo moves the object at key from the store to dst, replacing any object at key in dst, in
+// one transaction, so the object is always in exactly one of the stores. This makes handoffs
+// between buckets safe, like moving work items from "pending" to "in-progress". dst must use
+// the same database, and should use the same Codec since the encoded value is moved as is.
+// It returns ErrNotFound if there's no object at key.
+//
+// Moved objects keep their expiration time and index entries, and don't run Hooks.
+func (s *Store) MoveTo(dst *Store, key []byte) error {
+	if dst.db != s.db {
+		return ErrDifferentDB
+	}
+	return s.move(dst, s.nsKey(key), dst.nsKey(key))
+}
+
+func (s *Store) move(dst *Store, oldKey, newKey []byte) error {
+	defer s.forget(oldKey)
+	defer dst.forget(newKey)
+	return s.update(func(tx BackendTx) error {
+		return s.moveKey(tx, dst, oldKey, newKey)
+	})
+}
+
+// moveKey moves the object at oldKey to newKey in dst within tx.
+func (s *Store) moveKey(tx BackendTx, dst *Store, oldKey, newKey []byte) error {
+	objects := s.bucket.get(tx)
+	if objects == nil {
+		return ErrNotFound
+	}
+	data := objects.Get(oldKey)
+	if data == nil || s.expiryCheck(tx)(oldKey) {
+		return ErrNotFound
+	}
+	if dst == s && bytes.Equal(oldKey, newKey) {
+		return nil
+	}
+	data = append([]byte(nil), data...)
+
+	meta := s.bucket.meta()
+	var entries []indexEntry
+	if keys := meta.child(indexKeysBucket).get(tx); keys != nil {
+		if encoded := keys.Get(oldKey); encoded != nil {
+			var err error
+			if entries, err = decodeIndexEntries(append([]byte(nil), encoded...)); err != nil {
+				return err
+			}
+		}
+	}
+	var expiry []byte
+	if keys := meta.child(ttlKeysBucket).get(tx); keys != nil {
+		expiry = append([]byte(nil), keys.Get(oldKey)...)
+	}
+
+	if err := s.deleteKey(tx, objects, oldKey); err != nil {
+		return err
+	}
+
+	dstObjects, err := dst.bucket.createOrGet(tx)
+	if err != nil {
+		return err
+	}
+	if err := dstObjects.Put(newKey, data); err != nil {
+		return err
+	}
+	if err := dst.setIndexes(tx, newKey, entries); err != nil {
+		return err
+	}
+	if err := dst.setWritten(tx, newKey); err != nil {
+		return err
+	}
+	return dst.copyExpiry(tx, newKey, expiry)
+}
+
+// copyExpiry gives key the expiration recorded as expiry in the ttl keys bucket, or none
+// if expiry is empty.
+func (s *Store) copyExpiry(tx BackendTx, key, expiry []byte) error {
+	if err := s.clearExpiry(tx, key); err != nil || len(expiry) < timeLength {
+		return err
+	}
+
+	meta := s.bucket.meta()
+	keys, err := meta.child(ttlKeysBucket).createOrGetUntracked(tx)
+	if err != nil {
+		return err
+	}
+	index, err := meta.child(ttlExpiryBucket).createOrGetUntracked(tx)
+	if err != nil {
+		return err
+	}
+	if err := keys.Put(key, expiry); err != nil {
+		return err
+	}
+	return index.Put(timeKey(expiry[:timeLength], key), []byte{})
+}
@@ -0,0 +1,54 @@
+package stow
+
+import (
+	"testing"
+	"time"
+)
+
+type moveJob struct {
+	ID int `stow:"index"`
+}
+
+func TestMove(t *testing.T) {
+	pending := NewJSONStore(db, []byte("move_pending"))
+	defer pending.DeleteAll()
+	running := NewJSONStore(db, []byte("move_running"))
+	defer running.DeleteAll()
+
+	pending.Put("job-1", moveJob{ID: 1})
+	pending.PutTTL("job-2", moveJob{ID: 2}, time.Hour)
+
+	if err := pending.MoveTo(running, []byte("job-1")); err != nil {
+		t.Fatal(err)
+	}
+	if has, _ := pending.Has("job-1"); has {
+		t.Errorf("moved object should be removed from the source")
+	}
+	var jobs []moveJob
+	if err := running.Find("ID", 1, &jobs); err != nil || len(jobs) != 1 {
+		t.Errorf("moved object should keep its index entries: %v %v", jobs, err)
+	}
+	if err := pending.MoveTo(running, []byte("job-1")); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+
+	if err := pending.Move([]byte("job-2"), []byte("job-2-renamed")); err != nil {
+		t.Fatal(err)
+	}
+	var job moveJob
+	if err := pending.Get("job-2-renamed", &job); err != nil || job.ID != 2 {
+		t.Errorf("unexpected renamed object %v %v", job, err)
+	}
+	if ttl := ttlOf(pending, "job-2-renamed"); ttl != time.Hour {
+		t.Errorf("renamed object should keep its ttl, got %v", ttl)
+	}
+	var renamed []moveJob
+	if err := pending.Find("ID", 2, &renamed); err != nil || len(renamed) != 1 {
+		t.Errorf("renamed object should be indexed once: %v %v", renamed, err)
+	}
+
+	other := NewJSONStore(nil, []byte("other_db"))
+	if err := pending.MoveTo(other, []byte("job-2-renamed")); err != ErrDifferentDB {
+		t.Errorf("expected ErrDifferentDB, got %v", err)
+	}
+}
@@ -0,0 +1,29 @@
+package stow
+
+import "bytes"
+
+// Namespace returns a view of the store whose keys are transparently prefixed with prefix,
+// so several kinds of objects can share one bucket without their keys colliding. Get, Put,
+// Delete and the other methods which take a key work within the namespace, and ForEach (and
+// its variants), Keys, DeleteAll, DeletePrefix and PreloadPrefix only see the keys of the
+// namespace, without the prefix. Namespaces nest: the prefix of ns.Namespace(p) is ns's
+// prefix followed by p. Methods which report on or copy the whole bucket, like Export,
+// CopyTo, Sweep, Stats or Query, and the stores of nested buckets, ignore the namespace.
+func (s *Store) Namespace(prefix []byte) *Store {
+	ns := *s
+	ns.prefix = append(s.prefix[:len(s.prefix):len(s.prefix)], prefix...)
+	return &ns
+}
+
+// nsKey returns key within the store's namespace.
+func (s *Store) nsKey(key []byte) []byte {
+	if len(s.prefix) == 0 {
+		return key
+	}
+	return append(s.prefix[:len(s.prefix):len(s.prefix)], key...)
+}
+
+// trimNS returns key without the prefix of the store's namespace.
+func (s *Store) trimNS(key []byte) []byte {
+	return bytes.TrimPrefix(key, s.prefix)
+}
@@ -0,0 +1,52 @@
+package stow
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestNamespace(t *testing.T) {
+	s := NewJSONStore(db, []byte("namespace"))
+	defer s.DeleteAll()
+	users, sessions := s.Namespace([]byte("user/")), s.Namespace([]byte("session/"))
+
+	users.Put("1", MyType{FirstName: "Derek"})
+	users.Put("2", MyType{FirstName: "Kevin"})
+	sessions.Put("1", MyType{FirstName: "Session"})
+	s.Put("plain", MyType{FirstName: "Plain"})
+
+	var got MyType
+	if err := users.Get("1", &got); err != nil || got.FirstName != "Derek" {
+		t.Errorf("unexpected user %v %v", got, err)
+	}
+	if err := sessions.Get("1", &got); err != nil || got.FirstName != "Session" {
+		t.Errorf("unexpected session %v %v", got, err)
+	}
+	if err := s.Get("user/2", &got); err != nil || got.FirstName != "Kevin" {
+		t.Errorf("the parent store should see prefixed keys: %v %v", got, err)
+	}
+	if err := users.Get("plain", &got); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+
+	var seen []string
+	users.ForEach(func(key string, val MyType) {
+		seen = append(seen, key+"="+val.FirstName)
+	})
+	if fmt.Sprint(seen) != "[1=Derek 2=Kevin]" {
+		t.Errorf("unexpected namespace scan %v", seen)
+	}
+	if keys, err := sessions.Keys(); err != nil || fmt.Sprintf("%s", keys) != "[1]" {
+		t.Errorf("unexpected namespace keys %s %v", keys, err)
+	}
+	if raw, err := users.Namespace([]byte("x/")).GetRaw([]byte("1")); err != ErrNotFound {
+		t.Errorf("nested namespaces should add their prefix, got %s %v", raw, err)
+	}
+
+	if err := users.DeleteAll(); err != nil {
+		t.Fatal(err)
+	}
+	if keys, _ := s.Keys(); fmt.Sprintf("%s", keys) != "[plain session/1]" {
+		t.Errorf("DeleteAll should only empty the namespace, left %s", keys)
+	}
+}
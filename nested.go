@@ -0,0 +1,192 @@
+package stow
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// PruneEmptyBuckets removes nested stores (at any depth) under this store which no longer
+// hold any objects. If olderThan is greater than zero, only buckets which were created more
+// than olderThan ago are removed; buckets created before stow recorded creation times are
+// considered old. The store's own bucket is never removed. It returns the number of buckets removed.
+func (s *Store) PruneEmptyBuckets(olderThan time.Duration) (n int, err error) {
+	var cutoff time.Time
+	if olderThan > 0 {
+		cutoff = time.Now().Add(-olderThan)
+	}
+
+	err = s.update(func(tx BackendTx) error {
+		objects := s.bucket.get(tx)
+		if objects == nil {
+			return nil
+		}
+		n, err = pruneEmptyBuckets(tx, s.bucket, objects, cutoff)
+		return err
+	})
+	return n, err
+}
+
+func pruneEmptyBuckets(tx BackendTx, bs bucketSpec, b BackendBucket, cutoff time.Time) (n int, err error) {
+	for _, name := range childBuckets(b) {
+		childSpec := bs.child(name)
+		child := b.Bucket(name)
+
+		pruned, err := pruneEmptyBuckets(tx, childSpec, child, cutoff)
+		n += pruned
+		if err != nil {
+			return n, err
+		}
+
+		if k, _ := child.Cursor().First(); k != nil {
+			continue
+		}
+
+		if created, ok := childSpec.created(tx); ok && !cutoff.IsZero() && created.After(cutoff) {
+			continue
+		}
+
+		if err := b.DeleteBucket(name); err != nil {
+			return n, err
+		}
+		if err := childSpec.meta().deleteIfExists(tx); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// childBuckets returns the names of b's nested buckets.
+func childBuckets(b BackendBucket) (names [][]byte) {
+	b.ForEach(func(k, v []byte) error {
+		if v == nil {
+			names = append(names, append([]byte(nil), k...))
+		}
+		return nil
+	})
+	return names
+}
+
+// NestedStores returns the names of the nested stores directly under this store, in order.
+// Hashes (see HSet) are kept in nested buckets too, so they are listed as well.
+func (s *Store) NestedStores() (names [][]byte, err error) {
+	err = s.db.View(func(tx BackendTx) error {
+		if objects := s.bucket.get(tx); objects != nil {
+			names = childBuckets(objects)
+		}
+		return nil
+	})
+	return names, err
+}
+
+// DeleteNestedStore removes the nested store "name" with everything it holds, including its
+// own nested stores. It returns nil if there is no such nested store (like Delete).
+func (s *Store) DeleteNestedStore(name []byte) error {
+	return s.update(func(tx BackendTx) error {
+		nested := s.bucket.child(name)
+		if nested.get(tx) == nil {
+			return nil
+		}
+		if err := nested.delete(tx); err != nil {
+			return err
+		}
+		return nested.meta().deleteIfExists(tx)
+	})
+}
+
+// WalkNested calls fn for each nested store under this store at any depth, parents before
+// their children, with the path of bucket names leading to it from this store. The stores
+// passed to fn are opened like NewNestedStore, and the tree is read before fn is first called
+// so fn may use them freely. The walk stops at the first error returned by fn.
+func (s *Store) WalkNested(fn func(path [][]byte, store *Store) error) error {
+	var paths [][][]byte
+	err := s.db.View(func(tx BackendTx) error {
+		if objects := s.bucket.get(tx); objects != nil {
+			paths = nestedPaths(objects, nil, paths)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		store := s
+		for _, name := range path {
+			store = store.NewNestedStore(name)
+		}
+		if err := fn(path, store); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nestedPaths appends the paths of b's nested buckets at any depth under prefix to paths.
+func nestedPaths(b BackendBucket, prefix [][]byte, paths [][][]byte) [][][]byte {
+	for _, name := range childBuckets(b) {
+		path := append(append([][]byte(nil), prefix...), name)
+		paths = append(paths, path)
+		paths = nestedPaths(b.Bucket(name), path, paths)
+	}
+	return paths
+}
+
+var bucketPathType = reflect.TypeOf([][]byte(nil))
+
+// ForEachNested works like ForEach, but also visits the objects of the nested stores under
+// this store at any depth, in one pass. do takes the path of bucket names leading from this
+// store to the object's store (empty for this store's own objects) as its first param,
+// followed by the params it would take for ForEach:
+//
+//	store.ForEachNested(func(path [][]byte, key string, order Order) { ... })
+//
+// Objects are visited in key order, each nested store's objects when its key is reached, and
+// decoded with this store's Codec. The fields of hashes (see HSet) are visited too, since
+// hashes are nested buckets. The path passed to do is only valid until do returns.
+func (s *Store) ForEachNested(do interface{}) error {
+	fn := reflect.ValueOf(do)
+	if fn.Kind() != reflect.Func || fn.Type().NumIn() < 2 || fn.Type().In(0) != bucketPathType {
+		return fmt.Errorf("ForEachNested func() must take a [][]byte path first")
+	}
+
+	// Bind the current path to do, so the remaining params can be handled like ForEach's.
+	var path [][]byte
+	in := make([]reflect.Type, fn.Type().NumIn()-1)
+	for i := range in {
+		in[i] = fn.Type().In(i + 1)
+	}
+	bound := reflect.MakeFunc(reflect.FuncOf(in, nil, false), func(args []reflect.Value) []reflect.Value {
+		fn.Call(append([]reflect.Value{reflect.ValueOf(path)}, args...))
+		return nil
+	})
+	fc, err := newFuncCall(s, bound.Interface())
+	if err != nil {
+		return err
+	}
+
+	return s.db.View(func(tx BackendTx) error {
+		objects := s.bucket.get(tx)
+		if objects == nil {
+			return nil
+		}
+		var walk func(bs bucketSpec, b BackendBucket) error
+		walk = func(bs bucketSpec, b BackendBucket) error {
+			isExpired := bs.expiryCheck(tx)
+			return b.ForEach(func(k, v []byte) error {
+				if v != nil {
+					if isExpired(k) {
+						return nil
+					}
+					return fc.call(k, v)
+				}
+				path = append(path, k)
+				err := walk(bs.child(k), b.Bucket(k))
+				path = path[:len(path)-1]
+				return err
+			})
+		}
+		return walk(s.bucket, objects)
+	})
+}
@@ -0,0 +1,128 @@
+package stow
+
+import "time"
+
+// Option configures optional behavior of a Store, it can be passed to any of the
+// Store constructors. Nested stores inherit the options of their parent.
+type Option func(*Store)
+
+type options struct {
+	ttl             time.Duration
+	deleteExpired   bool
+	slidingTTL      bool
+	ttlJitter       time.Duration
+	sweepBatch      int
+	scanBatch       int
+	strict          bool
+	maxDecodeSize   int
+	keyCodec        KeyCodec
+	writeTimes      bool
+	codecSlots      chan struct{}
+	adaptive        *AdaptivePolicy
+	schemaWriteBack bool
+	checksums       bool
+	quarantine      bool
+	batchWrites     bool
+	singleflight    bool
+	readOnly        bool
+	metrics         []Collector
+	hooks           []Hooks
+}
+
+// WithCodec makes the store encode and decode values with c.
+func WithCodec(c Codec) Option {
+	return func(s *Store) {
+		s.codec = c
+	}
+}
+
+// WithReadOnly makes every write of the store fail with ErrReadOnly, and keeps its reads from
+// writing: WithSlidingTTL, WithDeleteExpiredOnRead, WithQuarantine and the write-back of
+// WithSchemaWriteBack are ignored. Nested stores inherit it.
+func WithReadOnly() Option {
+	return func(s *Store) {
+		s.opts.readOnly = true
+	}
+}
+
+// WithTTL sets a default time-to-live for objects written by Put. See PutTTL.
+func WithTTL(ttl time.Duration) Option {
+	return func(s *Store) {
+		s.opts.ttl = ttl
+	}
+}
+
+// WithSlidingTTL makes every Get renew the time-to-live of the object it reads, so objects
+// only expire after going unread for their ttl (idle expiration). Note that this turns Get
+// into a write transaction for objects which have a ttl.
+func WithSlidingTTL() Option {
+	return func(s *Store) {
+		s.opts.slidingTTL = true
+	}
+}
+
+// WithDeleteExpiredOnRead makes reads which find expired objects delete them, rather
+// than leaving them for Sweep. Expired objects are never returned by reads either way.
+func WithDeleteExpiredOnRead() Option {
+	return func(s *Store) {
+		s.opts.deleteExpired = true
+	}
+}
+
+// WithTTLJitter adds a random duration in [0, jitter) to the expiration time of objects given
+// a time-to-live, so objects written together don't all expire (and get swept) at the same
+// instant. The ttl used to renew objects with WithSlidingTTL or Touch is unchanged.
+func WithTTLJitter(jitter time.Duration) Option {
+	return func(s *Store) {
+		s.opts.ttlJitter = jitter
+	}
+}
+
+// WithSweepBatchSize makes Sweep remove expired objects in transactions of at most n objects,
+// rather than all in one, so a large number of objects expiring together doesn't hold up other
+// writers for the whole sweep. n <= 0 means a single transaction.
+func WithSweepBatchSize(n int) Option {
+	return func(s *Store) {
+		s.opts.sweepBatch = n
+	}
+}
+
+// WithScanBatchSize makes ForEach read at most n keys per transaction, continuing after the
+// last one in a new transaction, so scans of huge stores don't hold a transaction open for
+// long, which keeps bolt from reusing the pages freed by writers meanwhile. Objects written
+// during the scan may or may not be seen. n <= 0 means a single transaction.
+func WithScanBatchSize(n int) Option {
+	return func(s *Store) {
+		s.opts.scanBatch = n
+	}
+}
+
+// WithMaxDecodeSize makes reads refuse to decode objects whose encoded size is over n bytes,
+// returning ErrTooLarge instead, so that one pathological object can't exhaust the memory of
+// a service. Pull leaves such objects in the store. n <= 0 means no limit.
+func WithMaxDecodeSize(n int) Option {
+	return func(s *Store) {
+		s.opts.maxDecodeSize = n
+	}
+}
+
+// WithKeyCodec makes the store encode keys which aren't a string or []byte with kc, rather
+// than with the Codec used for values, and decode them with it in ForEach. With OrderedKeyCodec
+// integer and time keys are compact and iterate in numeric and chronological order.
+// Objects already stored under keys encoded by another codec won't be found with it.
+func WithKeyCodec(kc KeyCodec) Option {
+	return func(s *Store) {
+		s.opts.keyCodec = kc
+	}
+}
+
+// WithBatchWrites makes Put, PutTTL, Delete and Remove write with bolt's DB.Batch rather than
+// DB.Update, so the writes of concurrent goroutines are coalesced into shared transactions,
+// and fsyncs. Each call still returns once its write is committed, which can take up to the
+// database's MaxBatchDelay, so this only pays off with many concurrent writers. When a write
+// of a batch fails, bolt retries the others without it.
+func WithBatchWrites() Option {
+	return func(s *Store) {
+		s.opts.batchWrites = true
+	}
+}
@@ -0,0 +1,157 @@
+package stow
+
+import (
+	"bytes"
+	"reflect"
+	"runtime"
+	"sync"
+)
+
+// ForEachParallel works like ForEach, but decodes the objects and runs do on them in workers
+// goroutines, for scans bound by decoding. do must be safe for concurrent use, and is passed
+// the objects in no particular order. The objects are read in one read-only transaction, and
+// ForEachParallel returns once do returned for each of them. workers <= 0 runs GOMAXPROCS
+// workers.
+func (s *Store) ForEachParallel(workers int, do interface{}) error {
+	return s.forEachParallel(workers, false, do)
+}
+
+// ForEachParallelOrdered works like ForEachParallel, but only decodes objects in parallel: do
+// runs on one object at a time, in key order, like with ForEach.
+func (s *Store) ForEachParallelOrdered(workers int, do interface{}) error {
+	return s.forEachParallel(workers, true, do)
+}
+
+type parallelJob struct {
+	k, v []byte
+	// result is sent the decoded arguments of the object, if delivered in order.
+	result chan parallelResult
+}
+
+type parallelResult struct {
+	args []reflect.Value
+	err  error
+}
+
+func (s *Store) forEachParallel(workers int, ordered bool, do interface{}) error {
+	fc, err := newFuncCall(s, do)
+	if err != nil {
+		return err
+	}
+	fc.quarantine = s.opts.quarantine
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	var expired [][]byte
+	var bad []QuarantinedEntry
+	err = s.db.View(func(tx BackendTx) error {
+		objects := s.bucket.get(tx)
+		if objects == nil {
+			return nil
+		}
+		isExpired := s.expiryCheck(tx)
+
+		var (
+			mu       sync.Mutex
+			firstErr error
+			stop     = make(chan struct{})
+			stopped  bool
+		)
+		fail := func(err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if !stopped {
+				firstErr, stopped = err, true
+				close(stop)
+			}
+		}
+
+		// Each worker gets its own funcCall, to collect quarantined values without locking.
+		calls := make([]funcCall, workers)
+		jobs := make(chan parallelJob, workers)
+		var wg sync.WaitGroup
+		for i := range calls {
+			calls[i] = fc
+			wg.Add(1)
+			go func(fc *funcCall) {
+				defer wg.Done()
+				for job := range jobs {
+					if ordered {
+						args, err := fc.args(job.k, job.v)
+						job.result <- parallelResult{args: args, err: err}
+						continue
+					}
+					select {
+					case <-stop:
+						continue
+					default:
+					}
+					if err := fc.call(job.k, job.v); err != nil {
+						fail(err)
+					}
+				}
+			}(&calls[i])
+		}
+
+		// In order, results are queued in key order and delivered by one goroutine.
+		results := make(chan chan parallelResult, workers)
+		delivered := make(chan struct{})
+		go func() {
+			defer close(delivered)
+			for result := range results {
+				var r parallelResult
+				select {
+				case r = <-result:
+				case <-stop:
+					continue
+				}
+				select {
+				case <-stop:
+				default:
+					if r.err != nil {
+						fail(r.err)
+					} else if r.args != nil {
+						fc.Value.Call(r.args)
+					}
+				}
+			}
+		}()
+
+		c := objects.Cursor()
+	scan:
+		for k, v := c.Seek(s.prefix); k != nil && bytes.HasPrefix(k, s.prefix); k, v = c.Next() {
+			if v == nil {
+				continue
+			}
+			if isExpired(k) {
+				expired = append(expired, append([]byte(nil), k...))
+				continue
+			}
+			job := parallelJob{k: k, v: v}
+			if ordered {
+				job.result = make(chan parallelResult, 1)
+				select {
+				case results <- job.result:
+				case <-stop:
+					break scan
+				}
+			}
+			select {
+			case jobs <- job:
+			case <-stop:
+				break scan
+			}
+		}
+		close(jobs)
+		wg.Wait()
+		close(results)
+		<-delivered
+
+		for _, fc := range calls {
+			bad = append(bad, fc.bad...)
+		}
+		return firstErr
+	})
+	return s.afterBadRead(s.afterExpiredRead(err, expired), bad)
+}
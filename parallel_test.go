@@ -0,0 +1,86 @@
+package stow
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestForEachParallel(t *testing.T) {
+	s := NewJSONStore(db, []byte("for_each_parallel"))
+	defer s.DeleteAll()
+
+	for i := 0; i < 500; i++ {
+		s.Put(fmt.Sprintf("%03d", i), i)
+	}
+
+	var mu sync.Mutex
+	var sum int
+	err := s.ForEachParallel(4, func(key string, v int) {
+		mu.Lock()
+		sum += v
+		mu.Unlock()
+	})
+	if err != nil || sum != 499*500/2 {
+		t.Errorf("unexpected sum %d %v", sum, err)
+	}
+
+	var seen []int
+	if err := s.ForEachParallelOrdered(4, func(v int) { seen = append(seen, v) }); err != nil {
+		t.Fatal(err)
+	}
+	for i, v := range seen {
+		if v != i {
+			t.Fatalf("expected objects in key order, got %d at %d", v, i)
+		}
+	}
+	if len(seen) != 500 {
+		t.Errorf("expected 500 objects, got %d", len(seen))
+	}
+}
+
+func TestForEachParallelDecodeError(t *testing.T) {
+	s := NewJSONStore(db, []byte("for_each_parallel_error"))
+	defer s.DeleteAll()
+
+	for i := 0; i < 100; i++ {
+		s.Put(fmt.Sprintf("%03d", i), i)
+	}
+	s.Put("050", "not an int")
+
+	var n int
+	err := s.ForEachParallelOrdered(4, func(v int) { n++ })
+	var decodeErr *DecodeError
+	if !errors.As(err, &decodeErr) || string(decodeErr.Key) != "050" {
+		t.Errorf("expected a DecodeError for 050, got %v", err)
+	}
+	if n != 50 {
+		t.Errorf("expected the objects before 050, got %d", n)
+	}
+
+	if err := s.ForEachParallel(4, func(v int) {}); !errors.As(err, &decodeErr) {
+		t.Errorf("expected a DecodeError, got %v", err)
+	}
+}
+
+func TestForEachParallelQuarantine(t *testing.T) {
+	s := NewJSONStore(db, []byte("for_each_parallel_quarantine"), WithQuarantine())
+	defer s.DeleteAll()
+	defer s.PurgeQuarantine()
+
+	for i := 0; i < 100; i++ {
+		s.Put(fmt.Sprintf("%03d", i), i)
+	}
+	s.Put("050", "not an int")
+	s.Put("075", "not an int")
+
+	var mu sync.Mutex
+	var n int
+	if err := s.ForEachParallel(4, func(v int) { mu.Lock(); n++; mu.Unlock() }); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := s.Quarantined(); n != 98 || len(entries) != 2 {
+		t.Errorf("expected 2 quarantined objects, got %d objects and %v", n, entries)
+	}
+}
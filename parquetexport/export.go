@@ -0,0 +1,51 @@
+// Package parquetexport writes the objects of a stow.Store to Parquet files, so stow data can
+// be analyzed directly with tools like DuckDB or Spark.
+package parquetexport
+
+import (
+	"errors"
+	"io"
+	"reflect"
+
+	"github.com/djherbis/stow/v4"
+	"github.com/parquet-go/parquet-go"
+)
+
+// ErrNotStruct indicates a model which isn't a struct (or a pointer to one).
+var ErrNotStruct = errors.New("parquetexport: model must be a struct")
+
+// Export writes the objects of store to w as a Parquet file, one row per object, and returns
+// the number of rows written. The objects must all be stored as the struct type of model,
+// which is only used for its type. Columns are mapped from its exported fields, named and
+// configured with `parquet:"name,optional"` style tags as described by parquet-go.
+// Nested stores, hashes and expired objects are skipped, like with ForEach.
+func Export(w io.Writer, store *stow.Store, model interface{}, opts ...parquet.WriterOption) (n int, err error) {
+	typ := reflect.TypeOf(model)
+	if typ != nil && typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ == nil || typ.Kind() != reflect.Struct {
+		return 0, ErrNotStruct
+	}
+
+	schema := parquet.SchemaOf(reflect.New(typ).Interface())
+	writer := parquet.NewWriter(w, append([]parquet.WriterOption{schema}, opts...)...)
+
+	// ForEach can't be stopped early, so once a row fails the rest are skipped.
+	var writeErr error
+	do := reflect.MakeFunc(reflect.FuncOf([]reflect.Type{reflect.PtrTo(typ)}, nil, false), func(args []reflect.Value) []reflect.Value {
+		if writeErr == nil {
+			if writeErr = writer.Write(args[0].Interface()); writeErr == nil {
+				n++
+			}
+		}
+		return nil
+	})
+	if err := store.ForEach(do.Interface()); err != nil {
+		return n, err
+	}
+	if writeErr != nil {
+		return n, writeErr
+	}
+	return n, writer.Close()
+}
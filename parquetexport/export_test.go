@@ -0,0 +1,51 @@
+package parquetexport
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/djherbis/stow/v4"
+	"github.com/parquet-go/parquet-go"
+	bolt "go.etcd.io/bbolt"
+)
+
+type event struct {
+	ID    int64   `parquet:"id"`
+	Name  string  `parquet:"name"`
+	Score float64 `parquet:"score"`
+	Note  *string `parquet:"note,optional"`
+}
+
+func TestExport(t *testing.T) {
+	db, err := bolt.Open(filepath.Join(t.TempDir(), "export.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	store := stow.NewJSONStore(db, []byte("events"))
+	note := "late"
+	store.Put("a", event{ID: 1, Name: "open", Score: 0.5})
+	store.Put("b", event{ID: 2, Name: "close", Score: 1.5, Note: &note})
+	store.NewNestedStore([]byte("c")).Put("ignored", event{ID: 3})
+
+	var buf bytes.Buffer
+	n, err := Export(&buf, store, event{})
+	if err != nil || n != 2 {
+		t.Fatalf("unexpected export result %d %v", n, err)
+	}
+
+	rows, err := parquet.Read[event](bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[0].Name != "open" || rows[1].ID != 2 || rows[1].Note == nil || *rows[1].Note != "late" {
+		t.Errorf("unexpected rows %+v", rows)
+	}
+
+	if _, err := Export(os.Stdout, store, 1); err != ErrNotStruct {
+		t.Errorf("expected ErrNotStruct, got %v", err)
+	}
+}
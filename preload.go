@@ -0,0 +1,66 @@
+package stow
+
+import (
+	"bytes"
+	"os"
+	"runtime"
+)
+
+// Preload reads the values stored at keys in a single transaction, without decoding them,
+// so the pages that hold them are loaded into memory before they are needed. This is useful
+// to reduce first-request latency after a cold start. It returns the number of keys found.
+func (s *Store) Preload(keys [][]byte) (n int, err error) {
+	err = s.db.View(func(tx BackendTx) error {
+		objects := s.bucket.get(tx)
+		if objects == nil {
+			return nil
+		}
+
+		var sum byte
+		for _, key := range keys {
+			if data := objects.Get(s.nsKey(key)); data != nil {
+				sum += touchPages(data)
+				n++
+			}
+		}
+		runtime.KeepAlive(sum)
+		return nil
+	})
+	return n, err
+}
+
+// PreloadPrefix works like Preload, but for every key which starts with prefix.
+func (s *Store) PreloadPrefix(prefix []byte) (n int, err error) {
+	prefix = s.nsKey(prefix)
+	err = s.db.View(func(tx BackendTx) error {
+		objects := s.bucket.get(tx)
+		if objects == nil {
+			return nil
+		}
+
+		var sum byte
+		c := objects.Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			if v != nil {
+				sum += touchPages(v)
+				n++
+			}
+		}
+		runtime.KeepAlive(sum)
+		return nil
+	})
+	return n, err
+}
+
+var pageSize = os.Getpagesize()
+
+// touchPages reads a byte from every page spanned by data, so that the OS faults them in.
+func touchPages(data []byte) (sum byte) {
+	for i := 0; i < len(data); i += pageSize {
+		sum += data[i]
+	}
+	if len(data) > 0 {
+		sum += data[len(data)-1]
+	}
+	return sum
+}
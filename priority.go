@@ -0,0 +1,44 @@
+package stow
+
+import "encoding/binary"
+
+// PriorityQueue is a persistent priority queue of objects, kept in a Store under keys made of
+// their priority and the store's bucket sequence, so Pop returns the object with the highest
+// priority, and objects of the same priority in the order they were pushed. Like a Queue,
+// it's safe for concurrent use and should have its Store (or Namespace) to itself.
+type PriorityQueue struct {
+	q Queue
+}
+
+// NewPriorityQueue returns the PriorityQueue of the objects kept in s.
+func (s *Store) NewPriorityQueue() *PriorityQueue {
+	return &PriorityQueue{q: Queue{s: s}}
+}
+
+// Push adds val to the queue with priority, higher priorities are popped first.
+func (q *PriorityQueue) Push(priority int64, val interface{}) error {
+	_, err := q.q.s.putSequenced(val, func(seq uint64) []byte {
+		return priorityKey(priority, seq)
+	}, nil)
+	return err
+}
+
+// Pop removes the object with the highest priority and decodes it into b, or returns
+// ErrNotFound if the queue is empty. See Queue.Pop.
+func (q *PriorityQueue) Pop(b interface{}) error { return q.q.Pop(b) }
+
+// Peek decodes the object with the highest priority into b without removing it, or returns
+// ErrNotFound if the queue is empty.
+func (q *PriorityQueue) Peek(b interface{}) error { return q.q.Peek(b) }
+
+// Len returns the number of objects in the queue.
+func (q *PriorityQueue) Len() (int, error) { return q.q.Len() }
+
+// priorityKey returns a key which sorts by descending priority, then ascending seq.
+func priorityKey(priority int64, seq uint64) []byte {
+	b := make([]byte, 16)
+	// Flipping the sign bit sorts priorities in ascending order, inverting them in descending.
+	binary.BigEndian.PutUint64(b, ^(uint64(priority) ^ 1<<63))
+	binary.BigEndian.PutUint64(b[8:], seq)
+	return b
+}
@@ -0,0 +1,38 @@
+package stow
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestPriorityQueue(t *testing.T) {
+	s := NewJSONStore(db, []byte("priority_queue"))
+	defer s.DeleteAll()
+	q := s.NewPriorityQueue()
+
+	q.Push(0, moveJob{ID: 1})
+	q.Push(10, moveJob{ID: 2})
+	q.Push(-5, moveJob{ID: 3})
+	q.Push(10, moveJob{ID: 4})
+	q.Push(0, moveJob{ID: 5})
+
+	if n, err := q.Len(); err != nil || n != 5 {
+		t.Errorf("expected 5 objects, got %d %v", n, err)
+	}
+	var job moveJob
+	if err := q.Peek(&job); err != nil || job.ID != 2 {
+		t.Errorf("unexpected head %v %v", job, err)
+	}
+	var order []int
+	for {
+		if err := q.Pop(&job); err == ErrNotFound {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		order = append(order, job.ID)
+	}
+	if fmt.Sprint(order) != "[2 4 1 5 3]" {
+		t.Errorf("unexpected pop order %v", order)
+	}
+}
@@ -0,0 +1,101 @@
+package stow
+
+import (
+	"bytes"
+	"time"
+)
+
+// ForEachOptions configures ForEachProgress.
+type ForEachOptions struct {
+	// MaxDuration limits how long each read transaction is held open. Once a transaction
+	// exceeds it, the scan continues after the last key in a new transaction, so long scans
+	// don't keep bolt from reclaiming pages. Zero scans in one transaction.
+	// Objects written during the scan may or may not be seen once it spans transactions.
+	MaxDuration time.Duration
+
+	// BatchSize limits the number of keys read per transaction, the scan continues after the
+	// last one in a new transaction like with MaxDuration. Zero doesn't limit it.
+	BatchSize int
+
+	// ProgressEvery reports progress every ProgressEvery objects, on top of the reports at
+	// the end of each transaction. Zero only reports at the end of each transaction.
+	ProgressEvery int
+
+	// After starts the scan after this key, the lastKey of a previous progress report,
+	// to resume an interrupted scan. Nil starts at the first key.
+	After []byte
+}
+
+// ForEachProgress works like ForEach, in key order, and calls progress with the number of
+// objects visited so far and the key of the last one. Progress is reported as set by opts,
+// and once more when the scan ends. lastKey is only valid until progress returns.
+// progress may be nil, to only chunk the scan.
+func (s *Store) ForEachProgress(opts ForEachOptions, do interface{}, progress func(done int, lastKey []byte)) error {
+	fc, err := newFuncCall(s, do)
+	if err != nil {
+		return err
+	}
+	fc.quarantine = s.opts.quarantine
+	if progress == nil {
+		progress = func(int, []byte) {}
+	}
+
+	done, last := 0, []byte(nil)
+	if opts.After != nil {
+		last = s.nsKey(opts.After)
+	}
+	for more := true; more; {
+		var expired [][]byte
+		err := s.db.View(func(tx BackendTx) error {
+			more = false
+			objects := s.bucket.get(tx)
+			if objects == nil {
+				return nil
+			}
+			isExpired := s.expiryCheck(tx)
+			start := time.Now()
+
+			c := objects.Cursor()
+			k, v := c.Seek(s.prefix)
+			if last != nil {
+				if k, v = c.Seek(last); k != nil && bytes.Equal(k, last) {
+					k, v = c.Next()
+				}
+			}
+			for seen := 0; k != nil && bytes.HasPrefix(k, s.prefix); k, v = c.Next() {
+				// Always move past one key, so each transaction makes progress.
+				if opts.MaxDuration > 0 && seen > 0 && time.Since(start) >= opts.MaxDuration {
+					more = true
+					break
+				}
+				if opts.BatchSize > 0 && seen >= opts.BatchSize {
+					more = true
+					break
+				}
+				seen++
+				last = append(last[:0:0], k...)
+				if v == nil {
+					continue
+				}
+				if isExpired(k) {
+					expired = append(expired, last)
+					continue
+				}
+				if err := fc.call(k, v); err != nil {
+					return err
+				}
+				done++
+				if opts.ProgressEvery > 0 && done%opts.ProgressEvery == 0 {
+					progress(done, s.trimNS(last))
+				}
+			}
+			return nil
+		})
+		err = s.afterBadRead(s.afterExpiredRead(err, expired), fc.bad)
+		if fc.bad = nil; err != nil {
+			return err
+		}
+		progress(done, s.trimNS(last))
+	}
+	return nil
+}
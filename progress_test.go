@@ -0,0 +1,75 @@
+package stow
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestForEachProgress(t *testing.T) {
+	s := NewJSONStore(db, []byte("foreach_progress"))
+	defer s.DeleteAll()
+	for i := 0; i < 10; i++ {
+		s.Put(fmt.Sprintf("key-%d", i), i)
+	}
+	s.NewNestedStore([]byte("key-5-nested")).Put("hidden", -1)
+
+	var seen []int
+	var reports []int
+	err := s.ForEachProgress(ForEachOptions{MaxDuration: time.Nanosecond}, func(v int) {
+		seen = append(seen, v)
+	}, func(done int, lastKey []byte) {
+		reports = append(reports, done)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != 10 {
+		t.Errorf("expected 10 objects, got %v", seen)
+	}
+	if len(reports) < 10 || reports[len(reports)-1] != 10 {
+		t.Errorf("expected a report per transaction ending with 10, got %v", reports)
+	}
+
+	var last []byte
+	reports = nil
+	err = s.ForEachProgress(ForEachOptions{ProgressEvery: 4}, func(v int) {}, func(done int, lastKey []byte) {
+		reports = append(reports, done)
+		last = append(last[:0], lastKey...)
+	})
+	if err != nil || fmt.Sprint(reports) != "[4 8 10]" || string(last) != "key-9" {
+		t.Errorf("unexpected reports %v, last key %q, %v", reports, last, err)
+	}
+
+	var n int
+	err = s.ForEachProgress(ForEachOptions{After: []byte("key-6")}, func(key string, v int) { n++ }, nil)
+	if err != nil || n != 3 {
+		t.Errorf("expected to resume with 3 objects left, got %d %v", n, err)
+	}
+}
+
+func TestForEachBatchSize(t *testing.T) {
+	s := NewJSONStore(db, []byte("foreach_batch_size"))
+	defer s.DeleteAll()
+	for i := 0; i < 10; i++ {
+		s.Put(fmt.Sprintf("key-%d", i), i)
+	}
+	s.NewNestedStore([]byte("key-5-nested")).Put("hidden", -1)
+
+	var reports []int
+	err := s.ForEachProgress(ForEachOptions{BatchSize: 3}, func(v int) {}, func(done int, lastKey []byte) {
+		reports = append(reports, done)
+	})
+	if err != nil || fmt.Sprint(reports) != "[3 6 8 10]" {
+		t.Errorf("expected a report per batch of 3 keys, got %v %v", reports, err)
+	}
+
+	batched := NewJSONStore(db, []byte("foreach_batch_size"), WithScanBatchSize(4))
+	var seen []int
+	if err := batched.ForEach(func(v int) { seen = append(seen, v) }); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(seen) != "[0 1 2 3 4 5 6 7 8 9]" {
+		t.Errorf("unexpected objects %v", seen)
+	}
+}
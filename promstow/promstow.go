@@ -0,0 +1,137 @@
+// Package promstow provides a Prometheus collector of stow metrics: pass it to
+// stow.WithMetrics to record the operations of stores, and register it with a
+// prometheus.Registerer to export them.
+//
+// Its metrics are, labeled by the bucket path of the store:
+//
+//	stow_operations_total{bucket,op}               operations run
+//	stow_operation_errors_total{bucket,op}         operations which failed, misses excluded
+//	stow_misses_total{bucket,op}                   reads which found no object
+//	stow_operation_duration_seconds{bucket,op}     histogram of operation latencies
+//	stow_value_size_bytes{bucket,op}               histogram of the encoded values read or written
+//	stow_objects{bucket}                           objects in the stores given to Watch
+package promstow
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/djherbis/stow/v4"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Collector implements stow.Collector and prometheus.Collector.
+type Collector struct {
+	ops      *prometheus.CounterVec
+	errs     *prometheus.CounterVec
+	misses   *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	size     *prometheus.HistogramVec
+	objects  *prometheus.Desc
+
+	mu      sync.Mutex
+	watched map[string]*stow.Store
+}
+
+var (
+	_ stow.Collector       = (*Collector)(nil)
+	_ prometheus.Collector = (*Collector)(nil)
+)
+
+// New returns a Collector whose metrics are prefixed with namespace, "stow" if empty.
+func New(namespace string) *Collector {
+	if namespace == "" {
+		namespace = "stow"
+	}
+	labels := []string{"bucket", "op"}
+	return &Collector{
+		ops: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "operations_total",
+			Help:      "Operations run on stow stores.",
+		}, labels),
+		errs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "operation_errors_total",
+			Help:      "Operations on stow stores which failed, misses excluded.",
+		}, labels),
+		misses: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "misses_total",
+			Help:      "Reads of stow stores which found no object.",
+		}, labels),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "operation_duration_seconds",
+			Help:      "Latency of operations on stow stores.",
+			Buckets:   prometheus.ExponentialBuckets(0.0001, 4, 9),
+		}, labels),
+		size: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "value_size_bytes",
+			Help:      "Size of the encoded values read or written by operations on stow stores.",
+			Buckets:   prometheus.ExponentialBuckets(64, 4, 9),
+		}, labels),
+		objects: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "objects"),
+			"Objects in watched stow stores.", []string{"bucket"}, nil),
+		watched: make(map[string]*stow.Store),
+	}
+}
+
+// Observe records an operation, see stow.Collector.
+func (c *Collector) Observe(bucket string, op stow.Op, d time.Duration, size int, err error) {
+	c.ops.WithLabelValues(bucket, string(op)).Inc()
+	switch {
+	case errors.Is(err, stow.ErrNotFound):
+		c.misses.WithLabelValues(bucket, string(op)).Inc()
+	case err != nil:
+		c.errs.WithLabelValues(bucket, string(op)).Inc()
+	}
+	c.duration.WithLabelValues(bucket, string(op)).Observe(d.Seconds())
+	if size > 0 {
+		c.size.WithLabelValues(bucket, string(op)).Observe(float64(size))
+	}
+}
+
+// Watch exports the number of objects of s, labeled with bucket. It's counted at each
+// scrape with s.BucketStats, which reads every key of the store.
+func (c *Collector) Watch(bucket string, s *stow.Store) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.watched[bucket] = s
+}
+
+// Describe sends the descriptors of the metrics.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	c.ops.Describe(ch)
+	c.errs.Describe(ch)
+	c.misses.Describe(ch)
+	c.duration.Describe(ch)
+	c.size.Describe(ch)
+	ch <- c.objects
+}
+
+// Collect sends the metrics, counting the objects of the watched stores.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.ops.Collect(ch)
+	c.errs.Collect(ch)
+	c.misses.Collect(ch)
+	c.duration.Collect(ch)
+	c.size.Collect(ch)
+
+	c.mu.Lock()
+	watched := make(map[string]*stow.Store, len(c.watched))
+	for bucket, s := range c.watched {
+		watched[bucket] = s
+	}
+	c.mu.Unlock()
+	for bucket, s := range watched {
+		stats, err := s.BucketStats()
+		if err != nil {
+			ch <- prometheus.NewInvalidMetric(c.objects, err)
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(c.objects, prometheus.GaugeValue, float64(stats.Objects), bucket)
+	}
+}
@@ -0,0 +1,53 @@
+package promstow
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/djherbis/stow/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestCollector(t *testing.T) {
+	db, err := bolt.Open(filepath.Join(t.TempDir(), "metrics.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	c := New("")
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(c)
+
+	s := stow.NewJSONStore(db, []byte("people"), stow.WithMetrics(c))
+	c.Watch("people", s)
+	s.Put("ann", "Ann")
+	s.Put("bob", "Bob")
+	var name string
+	s.Get("ann", &name)
+	s.Get("carl", &name)
+
+	expected := `
+# HELP stow_misses_total Reads of stow stores which found no object.
+# TYPE stow_misses_total counter
+stow_misses_total{bucket="people",op="get"} 1
+# HELP stow_objects Objects in watched stow stores.
+# TYPE stow_objects gauge
+stow_objects{bucket="people"} 2
+# HELP stow_operations_total Operations run on stow stores.
+# TYPE stow_operations_total counter
+stow_operations_total{bucket="people",op="get"} 2
+stow_operations_total{bucket="people",op="put"} 2
+`
+	err = testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"stow_misses_total", "stow_objects", "stow_operations_total")
+	if err != nil {
+		t.Error(err)
+	}
+	if n := testutil.CollectAndCount(c, "stow_value_size_bytes"); n != 2 {
+		t.Errorf("expected value sizes for get and put, got %d series", n)
+	}
+}
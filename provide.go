@@ -0,0 +1,107 @@
+package stow
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Storer is the interface of the object operations of a Store. Code which depends on a
+// Storer rather than a *Store can be given any store, like the one Provide returns.
+type Storer interface {
+	Put(key interface{}, b interface{}) error
+	Get(key interface{}, b interface{}) error
+	Pull(key interface{}, b interface{}) error
+	Has(key interface{}) (bool, error)
+	Delete(key interface{}) error
+	DeleteAll() error
+	ForEach(do interface{}) error
+	ForEachKey(do func(key []byte) error) error
+	Keys() ([][]byte, error)
+}
+
+var _ Storer = (*Store)(nil)
+
+// ErrNoBucket indicates a Config without a Bucket.
+var ErrNoBucket = errors.New("no bucket configured")
+
+// Config configures the Store opened by Provide.
+type Config struct {
+	// Path is the bolt database file to open, it's created if needed.
+	Path string
+
+	// Temp opens a throwaway database in a temporary file instead of Path, which is
+	// removed by the cleanup func. Use it to run tests against a fresh store.
+	Temp bool
+
+	// Memory keeps the store in memory, on NewMemBackend, instead of in a file: Path, Temp,
+	// Mode and BoltOptions are ignored. Use it to run tests without a file on disk.
+	Memory bool
+
+	// Backend puts the store on this Backend instead of a bolt file, like Memory does with
+	// NewMemBackend. The cleanup func leaves it alone.
+	Backend Backend
+
+	// Mode is the file mode of a new database file, 0600 if zero.
+	Mode os.FileMode
+
+	// BoltOptions are passed to bolt.Open.
+	BoltOptions *bolt.Options
+
+	// Bucket is the bucket of the store, it must be set.
+	Bucket []byte
+
+	// Codec encodes the objects, GobCodec if nil.
+	Codec Codec
+
+	// Options configure the store.
+	Options []Option
+}
+
+// Provide opens the database described by cfg and returns a Store on it as a Storer,
+// along with a cleanup func which closes the database (and removes it if cfg.Temp is set).
+// With cfg.Memory or cfg.Backend there's no database to open, and the cleanup func does
+// nothing.
+// Its signature suits dependency injection containers like wire or fx, so applications can
+// swap the store they get through configuration alone.
+func Provide(cfg Config) (store Storer, cleanup func(), err error) {
+	if len(cfg.Bucket) == 0 {
+		return nil, nil, ErrNoBucket
+	}
+	if cfg.Mode == 0 {
+		cfg.Mode = 0600
+	}
+	if cfg.Codec == nil {
+		cfg.Codec = GobCodec{}
+	}
+
+	if cfg.Memory && cfg.Backend == nil {
+		cfg.Backend = NewMemBackend()
+	}
+	if cfg.Backend != nil {
+		return NewBackendStore(cfg.Backend, cfg.Bucket, cfg.Codec, cfg.Options...), func() {}, nil
+	}
+
+	path, remove := cfg.Path, func() {}
+	if cfg.Temp {
+		dir, err := ioutil.TempDir("", "stow")
+		if err != nil {
+			return nil, nil, err
+		}
+		path, remove = filepath.Join(dir, "stow.db"), func() { os.RemoveAll(dir) }
+	}
+
+	db, err := bolt.Open(path, cfg.Mode, cfg.BoltOptions)
+	if err != nil {
+		remove()
+		return nil, nil, err
+	}
+	cleanup = func() {
+		db.Close()
+		remove()
+	}
+	return NewCustomStore(db, cfg.Bucket, cfg.Codec, cfg.Options...), cleanup, nil
+}
@@ -0,0 +1,55 @@
+package stow
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestProvide(t *testing.T) {
+	if _, _, err := Provide(Config{Temp: true}); err != ErrNoBucket {
+		t.Errorf("expected ErrNoBucket, got %v", err)
+	}
+
+	store, cleanup, err := Provide(Config{Temp: true, Bucket: []byte("provided"), Codec: JSONCodec{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put("hello", "world"); err != nil {
+		t.Fatal(err)
+	}
+	var v string
+	if err := store.Get("hello", &v); err != nil || v != "world" {
+		t.Errorf("unexpected value %q %v", v, err)
+	}
+
+	boltDB, _ := store.(*Store).boltDB()
+	path := boltDB.Path()
+	cleanup()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("cleanup should remove the temporary database: %v", err)
+	}
+}
+
+func TestProvideMemory(t *testing.T) {
+	dir := t.TempDir()
+	store, cleanup, err := Provide(Config{Memory: true, Path: filepath.Join(dir, "unused.db"), Bucket: []byte("provided"), Codec: JSONCodec{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	if err := store.Put("hello", "world"); err != nil {
+		t.Fatal(err)
+	}
+	var v string
+	if err := store.Get("hello", &v); err != nil || v != "world" {
+		t.Errorf("unexpected value %q %v", v, err)
+	}
+	if _, ok := store.(*Store).boltDB(); ok {
+		t.Errorf("expected a store which isn't on bolt")
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Errorf("expected no file on disk, got %d", len(files))
+	}
+}
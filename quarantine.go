@@ -0,0 +1,192 @@
+package stow
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"time"
+)
+
+// quarantineBucket holds the quarantined values of a store in its meta bucket, keyed by
+// their key, each as a JSON encoded QuarantinedEntry.
+var quarantineBucket = []byte("\x00quarantine")
+
+// QuarantinedEntry is a value which couldn't be decoded, moved out of the store's objects by
+// WithQuarantine or Verify.
+type QuarantinedEntry struct {
+	Key []byte `json:"key"`
+	// Raw is the value as it was stored.
+	Raw []byte `json:"raw"`
+	// Err is the message of the error decoding it.
+	Err string `json:"err"`
+	// Time is when it was quarantined.
+	Time time.Time `json:"time"`
+}
+
+// WithQuarantine makes reads which find a value that can't be decoded (or fails its checksum,
+// see WithChecksums) move it to the store's quarantine, rather than failing on it every
+// time. Get and Pull return a *DecodeError for the value, and ErrNotFound after that;
+// ForEach and ForEachPrefix skip it and go on with the other objects. Values of a schema newer
+// than the store's (ErrFutureSchema) are left in place, and so are values over the size set
+// with WithMaxDecodeSize. See Quarantined, RetryQuarantined and PurgeQuarantine.
+func WithQuarantine() Option {
+	return func(s *Store) {
+		s.opts.quarantine = true
+	}
+}
+
+// quarantinable reports whether err, an error decoding a value, means the value should be
+// quarantined.
+func quarantinable(err error) bool {
+	return err != ErrTooLarge && !errors.Is(err, ErrFutureSchema)
+}
+
+// quarantine moves the entries out of the store's objects, unless their value changed meanwhile.
+func (s *Store) quarantine(entries []QuarantinedEntry) error {
+	if s.opts.readOnly {
+		return nil
+	}
+	return s.update(func(tx BackendTx) error {
+		objects := s.bucket.get(tx)
+		if objects == nil {
+			return nil
+		}
+		for _, e := range entries {
+			if !bytes.Equal(objects.Get(e.Key), e.Raw) {
+				continue
+			}
+			data, err := json.Marshal(e)
+			if err != nil {
+				return err
+			}
+			quarantined, err := s.bucket.meta().child(quarantineBucket).createOrGetUntracked(tx)
+			if err != nil {
+				return err
+			}
+			if err := quarantined.Put(e.Key, data); err != nil {
+				return err
+			}
+			if err := s.deleteKey(tx, objects, e.Key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// quarantineValue quarantines the value data of key which failed to decode with err, if the
+// store has WithQuarantine and err calls for it. It returns the *DecodeError for the read.
+func (s *Store) quarantineValue(key, data []byte, err error) error {
+	if !s.opts.quarantine || !quarantinable(err) {
+		return s.decodeError(key, data, err)
+	}
+	e := newQuarantinedEntry(key, data, err)
+	if qerr := s.quarantine([]QuarantinedEntry{e}); qerr != nil {
+		return qerr
+	}
+	return s.decodeError(key, data, err)
+}
+
+// afterBadRead quarantines the values a read skipped, if it succeeded.
+func (s *Store) afterBadRead(err error, bad []QuarantinedEntry) error {
+	if err != nil || len(bad) == 0 {
+		return err
+	}
+	return s.quarantine(bad)
+}
+
+func newQuarantinedEntry(key, data []byte, err error) QuarantinedEntry {
+	return QuarantinedEntry{
+		Key:  append([]byte(nil), key...),
+		Raw:  append([]byte(nil), data...),
+		Err:  err.Error(),
+		Time: time.Now(),
+	}
+}
+
+// Quarantined returns the quarantined entries of the store, in key order.
+func (s *Store) Quarantined() (entries []QuarantinedEntry, err error) {
+	err = s.db.View(func(tx BackendTx) error {
+		quarantined := s.bucket.meta().child(quarantineBucket).get(tx)
+		if quarantined == nil {
+			return nil
+		}
+		return quarantined.ForEach(func(k, v []byte) error {
+			var e QuarantinedEntry
+			if err := json.Unmarshal(v, &e); err != nil {
+				return err
+			}
+			entries = append(entries, e)
+			return nil
+		})
+	})
+	return entries, err
+}
+
+// RetryQuarantined decodes each quarantined value into a new value of the type of model,
+// and moves those which now decode (say after registering a codec or a migration) back into
+// the store, with their index entries. Values whose key was written meanwhile are left in
+// quarantine. It returns the number of objects restored.
+func (s *Store) RetryQuarantined(model interface{}) (restored int, err error) {
+	typ := reflect.TypeOf(model)
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	entries, err := s.Quarantined()
+	if err != nil || len(entries) == 0 {
+		return 0, err
+	}
+
+	type decoded struct {
+		e   QuarantinedEntry
+		val interface{}
+	}
+	var ok []decoded
+	for _, e := range entries {
+		val := reflect.New(typ).Interface()
+		if s.unmarshalValue(e.Key, e.Raw, val) == nil {
+			ok = append(ok, decoded{e: e, val: val})
+		}
+	}
+	if len(ok) == 0 {
+		return 0, nil
+	}
+
+	defer s.forgetAll()
+	err = s.update(func(tx BackendTx) error {
+		quarantined := s.bucket.meta().child(quarantineBucket).get(tx)
+		if quarantined == nil {
+			return nil
+		}
+		objects, err := s.bucket.createOrGet(tx)
+		if err != nil {
+			return err
+		}
+		restored = 0
+		for _, d := range ok {
+			if quarantined.Get(d.e.Key) == nil || objects.Get(d.e.Key) != nil {
+				continue
+			}
+			if err := s.writeKey(tx, objects, d.e.Key, d.e.Raw, d.val, 0); err != nil {
+				return err
+			}
+			if err := quarantined.Delete(d.e.Key); err != nil {
+				return err
+			}
+			restored++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return restored, nil
+}
+
+// PurgeQuarantine removes every quarantined entry of the store.
+func (s *Store) PurgeQuarantine() error {
+	return s.update(func(tx BackendTx) error {
+		return s.bucket.meta().child(quarantineBucket).deleteIfExists(tx)
+	})
+}
@@ -0,0 +1,97 @@
+package stow
+
+import (
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestQuarantine(t *testing.T) {
+	s := NewJSONStore(db, []byte("quarantine"), WithQuarantine())
+	defer s.DeleteAll()
+
+	s.Put("a", MyType{FirstName: "Ann"})
+	s.Put("c", MyType{FirstName: "Carl"})
+	putRaw := func(key, value string) {
+		db.Update(func(tx *bolt.Tx) error {
+			return tx.Bucket([]byte("quarantine")).Put([]byte(key), []byte(value))
+		})
+	}
+	putRaw("b", `{"first":5}`)
+	putRaw("d", `not json`)
+
+	var names []string
+	if err := s.ForEach(func(v MyType) { names = append(names, v.FirstName) }); err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 || names[0] != "Ann" || names[1] != "Carl" {
+		t.Errorf("unexpected objects %v", names)
+	}
+
+	entries, err := s.Quarantined()
+	if err != nil || len(entries) != 2 || string(entries[0].Key) != "b" || string(entries[0].Raw) != `{"first":5}` {
+		t.Fatalf("unexpected quarantine %+v %v", entries, err)
+	}
+	if entries[0].Err == "" || entries[0].Time.IsZero() {
+		t.Errorf("quarantined entry lacks its error or time %+v", entries[0])
+	}
+	var v MyType
+	if err := s.Get("b", &v); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound got %v", err)
+	}
+
+	// Values which decode into the model are restored.
+	n, err := s.RetryQuarantined(struct {
+		First int `json:"first"`
+	}{})
+	if err != nil || n != 1 {
+		t.Fatalf("unexpected retry %d %v", n, err)
+	}
+	if has, _ := s.Has("b"); !has {
+		t.Errorf("restored object is missing")
+	}
+	if entries, _ := s.Quarantined(); len(entries) != 1 || string(entries[0].Key) != "d" {
+		t.Errorf("unexpected quarantine after retry %+v", entries)
+	}
+
+	// Get and Pull quarantine what they can't decode.
+	if err := s.Get("b", &v); err == nil {
+		t.Errorf("expected a decode error")
+	} else if _, ok := err.(*DecodeError); !ok {
+		t.Errorf("expected a *DecodeError got %T", err)
+	}
+	putRaw("e", `nope`)
+	if err := s.Pull("e", &v); err == nil {
+		t.Errorf("expected a decode error")
+	}
+	if entries, _ := s.Quarantined(); len(entries) != 3 {
+		t.Errorf("expected 3 quarantined entries got %+v", entries)
+	}
+
+	if err := s.PurgeQuarantine(); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := s.Quarantined(); len(entries) != 0 {
+		t.Errorf("expected an empty quarantine got %+v", entries)
+	}
+}
+
+func TestVerifyQuarantine(t *testing.T) {
+	s := NewJSONStore(db, []byte("verify-quarantine"))
+	defer s.DeleteAll()
+	s.Put("a", MyType{FirstName: "Ann"})
+	db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("verify-quarantine")).Put([]byte("b"), []byte("not json"))
+	})
+
+	report, err := Verify(db, VerifyOptions{Codec: JSONCodec{}, Buckets: []string{"verify-quarantine"}, Quarantine: true})
+	if err != nil || len(report.Problems) != 1 {
+		t.Fatalf("unexpected report %+v %v", report, err)
+	}
+	if has, _ := s.Has("b"); has {
+		t.Errorf("bad object wasn't quarantined")
+	}
+	if entries, _ := s.Quarantined(); len(entries) != 1 {
+		t.Errorf("unexpected quarantine %+v", entries)
+	}
+}
@@ -0,0 +1,360 @@
+package stow
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// Query selects objects by the value of their fields, see Store.Query.
+type Query struct {
+	s     *Store
+	conds []condition
+	order string
+	desc  bool
+	limit int
+	err   error
+}
+
+type condition struct {
+	field string
+	op    string
+	value interface{}
+}
+
+// Query returns a new Query over the objects in the store, for example:
+//
+//	store.Query().Where("Age", ">", 30).OrderBy("Name").Limit(10).Run(&people)
+//
+// Conditions are matched against the fields of the type results are decoded into. When a
+// condition is on a field tagged with `stow:"index"` the index is used to narrow down the objects
+// that are decoded, otherwise every object in the store is decoded and filtered.
+func (s *Store) Query() *Query {
+	return &Query{s: s}
+}
+
+// Where adds a condition that the value of field compares to value with op, which is one of
+// "=" (or "=="), "!=", "<", "<=", ">" or ">=". Strings, byte slices, booleans, numbers and
+// time.Time compare by their natural order, other types only support "=" and "!=".
+func (q *Query) Where(field, op string, value interface{}) *Query {
+	switch op {
+	case "==":
+		op = "="
+	case "=", "!=", "<", "<=", ">", ">=":
+	default:
+		q.fail(fmt.Errorf("unsupported operator %q", op))
+	}
+	q.conds = append(q.conds, condition{field: field, op: op, value: value})
+	return q
+}
+
+// OrderBy sorts the results by field, in ascending order.
+func (q *Query) OrderBy(field string) *Query {
+	q.order, q.desc = field, false
+	return q
+}
+
+// OrderByDesc sorts the results by field, in descending order.
+func (q *Query) OrderByDesc(field string) *Query {
+	q.order, q.desc = field, true
+	return q
+}
+
+// Limit returns at most n results, n <= 0 means no limit.
+func (q *Query) Limit(n int) *Query {
+	q.limit = n
+	return q
+}
+
+func (q *Query) fail(err error) {
+	if q.err == nil {
+		q.err = err
+	}
+}
+
+type compiledCondition struct {
+	field string
+	index []int
+	op    string
+	value []byte
+}
+
+func (c compiledCondition) match(v []byte) bool {
+	cmp := bytes.Compare(v, c.value)
+	switch c.op {
+	case "=":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	default:
+		return cmp >= 0
+	}
+}
+
+// Run decodes the objects matching the query into results, which must be a pointer to a
+// slice of the stored struct type (or pointers to it).
+func (q *Query) Run(results interface{}) error {
+	if q.err != nil {
+		return q.err
+	}
+	out, err := newResultSlice(results)
+	if err != nil {
+		return err
+	}
+
+	conds := make([]compiledCondition, len(q.conds))
+	for i, c := range q.conds {
+		f, ok := out.elemType.FieldByName(c.field)
+		if !ok {
+			return fmt.Errorf("unknown field %q", c.field)
+		}
+		if !isOrdered(f.Type) && c.op != "=" && c.op != "!=" {
+			return fmt.Errorf("operator %q is not supported for field %q", c.op, c.field)
+		}
+		v, err := coerce(c.value, f.Type)
+		if err != nil {
+			return fmt.Errorf("field %q: %v", c.field, err)
+		}
+		encoded, err := q.s.indexValue(v)
+		if err != nil {
+			return err
+		}
+		conds[i] = compiledCondition{field: c.field, index: f.Index, op: c.op, value: encoded}
+	}
+
+	var orderIndex []int
+	if q.order != "" {
+		f, ok := out.elemType.FieldByName(q.order)
+		if !ok {
+			return fmt.Errorf("unknown field %q", q.order)
+		}
+		if !isOrdered(f.Type) {
+			return fmt.Errorf("cannot order by field %q", q.order)
+		}
+		orderIndex = f.Index
+	}
+
+	scan := q.plan(out.elemType, conds)
+	// Results can be cut off at the limit while scanning, unless they still need sorting.
+	sorted := q.order == "" || (scan.field == q.order && !q.desc)
+
+	var elems []reflect.Value
+	err = q.s.db.View(func(tx BackendTx) error {
+		objects := q.s.bucket.get(tx)
+		if objects == nil {
+			return nil
+		}
+		isExpired := q.s.expiryCheck(tx)
+
+		return scan.each(tx, q.s, objects, func(key, data []byte) (bool, error) {
+			if isExpired(key) {
+				return true, nil
+			}
+			elem, err := out.decode(q.s, key, data)
+			if err != nil {
+				return false, err
+			}
+			for _, c := range conds {
+				v, err := q.s.indexValue(fieldByIndex(elem, c.index))
+				if err != nil {
+					return false, err
+				}
+				if !c.match(v) {
+					return true, nil
+				}
+			}
+			elems = append(elems, elem)
+			return !sorted || q.limit <= 0 || len(elems) < q.limit, nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	if !sorted {
+		if err := q.sort(elems, orderIndex); err != nil {
+			return err
+		}
+	}
+	if q.limit > 0 && len(elems) > q.limit {
+		elems = elems[:q.limit]
+	}
+	for _, elem := range elems {
+		out.append(elem)
+	}
+	out.set()
+	return nil
+}
+
+func (q *Query) sort(elems []reflect.Value, orderIndex []int) error {
+	keys := make([][]byte, len(elems))
+	for i, elem := range elems {
+		key, err := q.s.indexValue(fieldByIndex(elem, orderIndex))
+		if err != nil {
+			return err
+		}
+		keys[i] = key
+	}
+	sort.Stable(byKeys{elems, keys, q.desc})
+	return nil
+}
+
+type byKeys struct {
+	elems []reflect.Value
+	keys  [][]byte
+	desc  bool
+}
+
+func (b byKeys) Len() int { return len(b.elems) }
+func (b byKeys) Swap(i, j int) {
+	b.elems[i], b.elems[j] = b.elems[j], b.elems[i]
+	b.keys[i], b.keys[j] = b.keys[j], b.keys[i]
+}
+func (b byKeys) Less(i, j int) bool {
+	if b.desc {
+		return bytes.Compare(b.keys[i], b.keys[j]) > 0
+	}
+	return bytes.Compare(b.keys[i], b.keys[j]) < 0
+}
+
+// queryScan describes how a query reads candidate objects: by walking the index of field
+// between lo and hi (inclusive of values prefixed by hi), or the whole store if field is empty.
+type queryScan struct {
+	field  string
+	lo, hi []byte
+}
+
+// plan picks the index of the first indexed condition which can bound a scan, or else the
+// index of the order field so results come out sorted, or else a scan of the whole store.
+func (q *Query) plan(typ reflect.Type, conds []compiledCondition) queryScan {
+	for _, c := range conds {
+		if c.op == "!=" || !hasIndexedField(typ, c.field) {
+			continue
+		}
+		scan := queryScan{field: c.field}
+		prefix := escapePart(c.value)
+		switch c.op {
+		case "=":
+			scan.lo, scan.hi = prefix, prefix
+		case ">", ">=":
+			scan.lo = prefix
+		case "<", "<=":
+			scan.hi = prefix
+		}
+		return scan
+	}
+	if q.order != "" && hasIndexedField(typ, q.order) {
+		return queryScan{field: q.order}
+	}
+	return queryScan{}
+}
+
+// each calls do with the key and value of each candidate object, until do returns false.
+func (scan queryScan) each(tx BackendTx, s *Store, objects BackendBucket, do func(key, data []byte) (bool, error)) error {
+	if scan.field == "" {
+		c := objects.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			if v == nil {
+				continue
+			}
+			if more, err := do(k, v); !more || err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	fieldIndex := s.bucket.meta().child(indexBucket).child([]byte(scan.field)).get(tx)
+	if fieldIndex == nil {
+		return nil
+	}
+	c := fieldIndex.Cursor()
+	k, _ := c.First()
+	if scan.lo != nil {
+		k, _ = c.Seek(scan.lo)
+	}
+	for ; k != nil; k, _ = c.Next() {
+		if scan.hi != nil && bytes.Compare(k, scan.hi) > 0 && !bytes.HasPrefix(k, scan.hi) {
+			return nil
+		}
+		key := indexedKey(k)
+		data := objects.Get(key)
+		if data == nil {
+			continue
+		}
+		if more, err := do(key, data); !more || err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// indexedKey returns the object key from an index key made by indexKey.
+func indexedKey(k []byte) []byte {
+	_, key, _ := splitPart(k)
+	return key
+}
+
+// fieldByIndex works like reflect.Value.FieldByIndex, but returns the zero Value when it
+// steps through a nil embedded pointer instead of panicking.
+func fieldByIndex(v reflect.Value, index []int) reflect.Value {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
+}
+
+// isOrdered reports whether indexValue preserves the natural order of values of typ.
+func isOrdered(typ reflect.Type) bool {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ == timeType {
+		return true
+	}
+	switch typ.Kind() {
+	case reflect.Slice:
+		return typ.Elem().Kind() == reflect.Uint8
+	case reflect.String, reflect.Bool:
+		return true
+	}
+	return isNumber(typ.Kind())
+}
+
+func isNumber(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// coerce converts value to the type of a field, so that it's encoded the same way.
+func coerce(value interface{}, typ reflect.Type) (reflect.Value, error) {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	v := indirect(reflect.ValueOf(value))
+	if !v.IsValid() || v.Type() == typ {
+		return v, nil
+	}
+	if (v.Kind() == typ.Kind() || isNumber(v.Kind()) && isNumber(typ.Kind())) && v.Type().ConvertibleTo(typ) {
+		return v.Convert(typ), nil
+	}
+	return reflect.Value{}, fmt.Errorf("cannot compare %s with %v", typ, value)
+}
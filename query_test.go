@@ -0,0 +1,77 @@
+package stow
+
+import "testing"
+
+type queryPerson struct {
+	Name string
+	Age  int `stow:"index"`
+	City string
+}
+
+func TestQuery(t *testing.T) {
+	s := NewJSONStore(db, []byte("query"))
+	defer s.DeleteAll()
+
+	people := []queryPerson{
+		{"ann", 25, "paris"},
+		{"bob", 35, "oslo"},
+		{"cat", 45, "paris"},
+		{"dan", 31, "rome"},
+		{"eve", 30, "paris"},
+	}
+	for _, p := range people {
+		s.Put(p.Name, p)
+	}
+
+	tests := []struct {
+		name     string
+		query    *Query
+		expected []string
+	}{
+		{"indexed range", s.Query().Where("Age", ">", 30), []string{"dan", "bob", "cat"}},
+		{"indexed bounds", s.Query().Where("Age", ">=", 30).Where("Age", "<", 40), []string{"eve", "dan", "bob"}},
+		{"indexed equality", s.Query().Where("Age", "==", 45), []string{"cat"}},
+		{"scan", s.Query().Where("City", "=", "paris"), []string{"ann", "cat", "eve"}},
+		{"scan and index", s.Query().Where("City", "=", "paris").Where("Age", "<=", 30), []string{"ann", "eve"}},
+		{"not equal", s.Query().Where("City", "!=", "paris").OrderBy("Name"), []string{"bob", "dan"}},
+		{"order by", s.Query().OrderBy("Name").Limit(2), []string{"ann", "bob"}},
+		{"order by desc", s.Query().Where("Age", ">", 26).OrderByDesc("Name").Limit(3), []string{"eve", "dan", "cat"}},
+		{"order by index", s.Query().OrderBy("Age").Limit(3), []string{"ann", "eve", "dan"}},
+		{"order by index desc", s.Query().OrderByDesc("Age").Limit(1), []string{"cat"}},
+		{"float literal", s.Query().Where("Age", "<", 30.0), []string{"ann"}},
+	}
+	for _, test := range tests {
+		var results []queryPerson
+		if err := test.query.Run(&results); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if len(results) != len(test.expected) {
+			t.Errorf("%s: unexpected results %v", test.name, results)
+			continue
+		}
+		for i := range results {
+			if results[i].Name != test.expected[i] {
+				t.Errorf("%s: result %d was %q, expected %q", test.name, i, results[i].Name, test.expected[i])
+			}
+		}
+	}
+
+	var ptrs []*queryPerson
+	if err := s.Query().Where("Name", "=", "bob").Run(&ptrs); err != nil || len(ptrs) != 1 || ptrs[0].Age != 35 {
+		t.Errorf("unexpected pointer results %v %v", ptrs, err)
+	}
+
+	var results []queryPerson
+	errs := []*Query{
+		s.Query().Where("Age", "~", 1),
+		s.Query().Where("Missing", "=", 1),
+		s.Query().Where("Name", ">", 1),
+		s.Query().OrderBy("Missing"),
+	}
+	for i, q := range errs {
+		if err := q.Run(&results); err == nil {
+			t.Errorf("expected query %d to fail", i)
+		}
+	}
+}
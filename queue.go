@@ -0,0 +1,157 @@
+package stow
+
+import (
+	"bytes"
+	"time"
+)
+
+// Queue is a persistent first-in, first-out queue of objects, kept in a Store under the
+// keys of its bucket sequence (see PutAutoKey). It's safe for concurrent use, Pop removes
+// each object for one consumer only, since it reads and deletes it in one transaction.
+// A Queue should have its Store (or Namespace) to itself, other objects would be popped too.
+type Queue struct {
+	s *Store
+}
+
+// NewQueue returns the Queue of the objects kept in s.
+func (s *Store) NewQueue() *Queue {
+	return &Queue{s: s}
+}
+
+// Push adds val at the tail of the queue.
+func (q *Queue) Push(val interface{}) error {
+	_, err := q.s.PutAutoKey(val)
+	return err
+}
+
+// Pop removes the object at the head of the queue and decodes it into b, or returns
+// ErrNotFound if the queue is empty. Like with Pull, an object which can't be decoded is
+// left at the head of the queue, unless it's quarantined (see WithQuarantine).
+// Since the key is only known once the transaction starts, BeforeDelete hooks are passed a
+// nil key.
+func (q *Queue) Pop(b interface{}) (err error) {
+	s := q.s
+	if err := s.beforeDelete(nil); err != nil {
+		return err
+	}
+	start := time.Now()
+	var key []byte
+	var size int
+	defer func() {
+		s.afterGet(key, b, err)
+		s.afterDelete(key, err)
+		s.observe(OpPull, start, size, err)
+	}()
+
+	buf := pool.Get().(*bytes.Buffer)
+	defer releaseBuffer(buf)
+
+	var empty bool
+	err = s.update(func(tx BackendTx) error {
+		objects := s.bucket.get(tx)
+		if objects == nil {
+			empty = true
+			return nil
+		}
+		k, data, expired := q.head(tx, objects)
+		if k != nil {
+			key = append([]byte(nil), k...)
+			if err := s.checkDecodeSize(data); err != nil {
+				return s.keyError(key, err)
+			}
+			// Decode before deleting, so a value which can't be decoded isn't lost.
+			size = len(data)
+			buf.Write(data)
+			if err := s.unmarshalValue(key, buf.Bytes(), b); err != nil {
+				return s.decodeError(key, data, err)
+			}
+			if err := s.deleteKey(tx, objects, key); err != nil {
+				return err
+			}
+		}
+		empty = k == nil
+		if s.opts.deleteExpired {
+			for _, k := range expired {
+				if err := s.deleteKey(tx, objects, k); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+
+	if decodeErr, ok := err.(*DecodeError); ok && s.opts.quarantine && quarantinable(decodeErr.Err) {
+		return s.quarantineValue(decodeErr.Key, decodeErr.Raw, decodeErr.Err)
+	}
+	if err == nil && empty {
+		return ErrNotFound
+	}
+	return err
+}
+
+// Peek decodes the object at the head of the queue into b without removing it, or returns
+// ErrNotFound if the queue is empty.
+func (q *Queue) Peek(b interface{}) (err error) {
+	s := q.s
+	start := time.Now()
+	var key []byte
+	var size int
+	defer func() {
+		s.afterGet(key, b, err)
+		s.observe(OpGet, start, size, err)
+	}()
+
+	buf := bytes.NewBuffer(nil)
+	err = s.db.View(func(tx BackendTx) error {
+		objects := s.bucket.get(tx)
+		if objects == nil {
+			return ErrNotFound
+		}
+		k, data, _ := q.head(tx, objects)
+		if k == nil {
+			return ErrNotFound
+		}
+		key = append([]byte(nil), k...)
+		if err := s.checkDecodeSize(data); err != nil {
+			return s.keyError(key, err)
+		}
+		size = len(data)
+		buf.Write(data)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := s.unmarshalValue(key, buf.Bytes(), b); err != nil {
+		return s.decodeError(key, buf.Bytes(), err)
+	}
+	return nil
+}
+
+// Len returns the number of objects in the queue.
+func (q *Queue) Len() (n int, err error) {
+	err = q.s.ForEachKey(func([]byte) error {
+		n++
+		return nil
+	})
+	return n, err
+}
+
+// head returns the first object of the queue which hasn't expired, and the keys of the
+// expired objects before it.
+func (q *Queue) head(tx BackendTx, objects BackendBucket) (key, data []byte, expired [][]byte) {
+	s := q.s
+	isExpired := s.expiryCheck(tx)
+	c := objects.Cursor()
+	for k, v := c.Seek(s.prefix); k != nil && bytes.HasPrefix(k, s.prefix); k, v = c.Next() {
+		if v == nil {
+			continue
+		}
+		if isExpired(k) {
+			expired = append(expired, append([]byte(nil), k...))
+			continue
+		}
+		return k, v, expired
+	}
+	return nil, nil, expired
+}
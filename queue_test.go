@@ -0,0 +1,83 @@
+package stow
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestQueue(t *testing.T) {
+	s := NewJSONStore(db, []byte("queue"))
+	defer s.DeleteAll()
+	q := s.NewQueue()
+
+	var got MyType
+	if err := q.Pop(&got); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound from an empty queue, got %v", err)
+	}
+	for _, name := range []string{"first", "second", "third"} {
+		if err := q.Push(MyType{FirstName: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, err := q.Len(); err != nil || n != 3 {
+		t.Errorf("expected 3 objects, got %d %v", n, err)
+	}
+	if err := q.Peek(&got); err != nil || got.FirstName != "first" {
+		t.Errorf("unexpected head %v %v", got, err)
+	}
+	for _, name := range []string{"first", "second", "third"} {
+		if err := q.Pop(&got); err != nil || got.FirstName != name {
+			t.Errorf("expected %s, got %v %v", name, got, err)
+		}
+	}
+	if err := q.Peek(&got); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound once drained, got %v", err)
+	}
+}
+
+func TestQueueConsumers(t *testing.T) {
+	s := NewJSONStore(db, []byte("queue_consumers"))
+	defer s.DeleteAll()
+	q := s.Namespace([]byte("jobs/")).NewQueue()
+	s.Put("other", MyType{})
+
+	const jobs = 100
+	for i := 0; i < jobs; i++ {
+		q.Push(moveJob{ID: i})
+	}
+
+	var mu sync.Mutex
+	popped := make(map[int]int)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				var job moveJob
+				if err := q.Pop(&job); err != nil {
+					if err != ErrNotFound {
+						t.Error(err)
+					}
+					return
+				}
+				mu.Lock()
+				popped[job.ID]++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(popped) != jobs {
+		t.Errorf("expected %d jobs popped, got %d", jobs, len(popped))
+	}
+	for id, n := range popped {
+		if n != 1 {
+			t.Errorf("job %d popped %d times", id, n)
+		}
+	}
+	if has, _ := s.Has("other"); !has {
+		t.Errorf("the queue's namespace shouldn't pop other objects")
+	}
+}
@@ -0,0 +1,95 @@
+package stow
+
+import (
+	"errors"
+	"time"
+)
+
+// RateLimitStore is a set of token bucket rate limiters kept in a Store, one per key, so
+// limits hold across restarts and deploys: each key may make burst requests at once, and
+// another one every interval. Allow decides in one transaction, so it's safe for concurrent
+// use. The state of a key expires once its bucket would be full again, so Sweep (see
+// StartSweeper) removes the keys which went idle. A RateLimitStore should have its Store (or
+// Namespace) to itself.
+type RateLimitStore struct {
+	s        *Store
+	interval time.Duration
+	burst    int
+}
+
+// rateLimitRecord is the object the state of a key is kept as.
+type rateLimitRecord struct {
+	Tokens  float64
+	Updated time.Time
+}
+
+// errRateLimited rolls back the transaction of a request which isn't allowed, there's
+// nothing to store.
+var errRateLimited = errors.New("rate limited")
+
+// NewRateLimitStore returns the RateLimitStore of the limiters kept in s, which allow burst
+// requests at once and one more every interval.
+func (s *Store) NewRateLimitStore(interval time.Duration, burst int) *RateLimitStore {
+	return &RateLimitStore{s: s, interval: interval, burst: burst}
+}
+
+// Allow reports whether key may make a request now, and counts it if so.
+func (r *RateLimitStore) Allow(key interface{}) (bool, error) {
+	return r.AllowN(key, 1)
+}
+
+// AllowN reports whether key may make n requests now, and counts them if so.
+func (r *RateLimitStore) AllowN(key interface{}, n int) (allowed bool, err error) {
+	s := r.s
+	keyBytes, err := s.toBytes(key)
+	if err != nil {
+		return false, err
+	}
+
+	defer s.forget(keyBytes)
+	err = s.update(func(tx BackendTx) error {
+		objects, err := s.bucket.createOrGet(tx)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		rec := rateLimitRecord{Tokens: float64(r.burst), Updated: now}
+		if data := objects.Get(keyBytes); data != nil && !s.expiryCheck(tx)(keyBytes) {
+			if err := s.unmarshalValue(keyBytes, data, &rec); err != nil {
+				return s.decodeError(keyBytes, data, err)
+			}
+			if r.interval > 0 {
+				rec.Tokens += float64(now.Sub(rec.Updated)) / float64(r.interval)
+			}
+			if rec.Tokens > float64(r.burst) {
+				rec.Tokens = float64(r.burst)
+			}
+			rec.Updated = now
+		}
+		if rec.Tokens < float64(n) {
+			return errRateLimited
+		}
+		rec.Tokens -= float64(n)
+
+		data, err := s.marshalValue(rec)
+		if err != nil {
+			return err
+		}
+		return s.writeKey(tx, objects, keyBytes, data, nil, r.refill(rec.Tokens))
+	})
+	if err == errRateLimited {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// refill returns how long a bucket with tokens takes to be full again.
+func (r *RateLimitStore) refill(tokens float64) time.Duration {
+	missing := float64(r.burst) - tokens
+	if missing <= 0 || r.interval <= 0 {
+		// A full bucket could expire right away, but a ttl <= 0 doesn't expire.
+		return time.Nanosecond
+	}
+	return time.Duration(missing * float64(r.interval))
+}
@@ -0,0 +1,38 @@
+package stow
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimitStore(t *testing.T) {
+	s := NewJSONStore(db, []byte("ratelimit"))
+	defer s.DeleteAll()
+	limits := s.NewRateLimitStore(20*time.Millisecond, 2)
+
+	for i, want := range []bool{true, true, false} {
+		if allowed, err := limits.Allow("1.2.3.4"); err != nil || allowed != want {
+			t.Errorf("request %d: expected %v, got %v %v", i, want, allowed, err)
+		}
+	}
+	if allowed, _ := limits.Allow("5.6.7.8"); !allowed {
+		t.Errorf("keys should be limited independently")
+	}
+	// The state is persisted, so it's shared across RateLimitStores.
+	if allowed, _ := s.NewRateLimitStore(20*time.Millisecond, 2).Allow("1.2.3.4"); allowed {
+		t.Errorf("expected the limit to hold across RateLimitStores")
+	}
+
+	time.Sleep(25 * time.Millisecond)
+	if allowed, _ := limits.Allow("1.2.3.4"); !allowed {
+		t.Errorf("expected a token to be refilled")
+	}
+	if allowed, _ := limits.AllowN("5.6.7.8", 3); allowed {
+		t.Errorf("expected AllowN over the burst to be denied")
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if n, err := s.Sweep(); err != nil || n != 2 {
+		t.Errorf("expected the idle keys to expire, got %d %v", n, err)
+	}
+}
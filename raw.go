@@ -0,0 +1,95 @@
+package stow
+
+import (
+	"time"
+)
+
+// GetFunc calls fn with the value stored at key, as the store's Codec encoded it, from
+// inside a read-only transaction so the value isn't copied: fn may inspect it or decode it
+// in place, but must not modify it or keep it once it returns. GetFunc returns ErrNotFound
+// if there's no such object, and otherwise the error of fn. The value's checksum is
+// verified (see WithChecksums), WithMaxDecodeSize doesn't apply, and Hooks aren't run.
+func (s *Store) GetFunc(key []byte, fn func(raw []byte) error) (err error) {
+	key = s.nsKey(key)
+	start := time.Now()
+	var size int
+	defer func() {
+		s.observe(OpGet, start, size, err)
+	}()
+
+	if data, ok := s.immutable.get(key); ok {
+		size = len(data)
+		raw, err := s.verifyChecksum(key, data)
+		if err != nil {
+			return s.decodeError(key, data, err)
+		}
+		return fn(raw)
+	}
+
+	var expired bool
+	var ttl time.Duration
+	err = s.db.View(func(tx BackendTx) error {
+		objects := s.bucket.get(tx)
+		if objects == nil {
+			return ErrNotFound
+		}
+		data := objects.Get(key)
+		if data == nil {
+			return ErrNotFound
+		}
+		if s.expiryCheck(tx)(key) {
+			expired = true
+			return ErrNotFound
+		}
+		if s.opts.slidingTTL {
+			ttl = s.ttlOf(tx, key)
+		}
+		size = len(data)
+		raw, err := s.verifyChecksum(key, data)
+		if err != nil {
+			return s.decodeError(key, data, err)
+		}
+		return fn(raw)
+	})
+
+	if expired && s.opts.deleteExpired {
+		if err := s.deleteExpired([][]byte{key}); err != nil {
+			return err
+		}
+	}
+	if err == nil && s.opts.slidingTTL {
+		err = s.slide(key, ttl)
+	}
+	return err
+}
+
+// GetRaw returns a copy of the value stored at key, as the store's Codec encoded it, or
+// ErrNotFound if there's none. See GetFunc to read it without the copy.
+func (s *Store) GetRaw(key []byte) (raw []byte, err error) {
+	err = s.GetFunc(key, func(data []byte) error {
+		raw = append([]byte(nil), data...)
+		return nil
+	})
+	return raw, err
+}
+
+// PutRaw stores raw at key as it is, bypassing the store's Codec, so it must be a value
+// encoded with it (like one returned by GetRaw). The object gets the default time-to-live
+// (see WithTTL) but no index entries, and Hooks aren't run.
+func (s *Store) PutRaw(key, raw []byte) (err error) {
+	key = s.nsKey(key)
+	start := time.Now()
+	data := s.addChecksum(append([]byte(nil), raw...))
+	defer func() {
+		s.observe(OpPut, start, len(data), err)
+	}()
+
+	defer s.forget(key)
+	return s.write(func(tx BackendTx) error {
+		objects, err := s.bucket.createOrGet(tx)
+		if err != nil {
+			return err
+		}
+		return s.writeKey(tx, objects, key, data, nil, s.opts.ttl)
+	})
+}
@@ -0,0 +1,51 @@
+package stow
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestGetFunc(t *testing.T) {
+	s := NewJSONStore(db, []byte("get_func"), WithChecksums())
+	defer s.DeleteAll()
+
+	s.Put("a", MyType{FirstName: "Ann"})
+
+	var v MyType
+	err := s.GetFunc([]byte("a"), func(raw []byte) error {
+		return s.unmarshal(raw, &v)
+	})
+	if err != nil || v.FirstName != "Ann" {
+		t.Errorf("unexpected value %v %v", v, err)
+	}
+	if err := s.GetFunc([]byte("missing"), func([]byte) error { return nil }); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	errStop := errors.New("stop")
+	if err := s.GetFunc([]byte("a"), func([]byte) error { return errStop }); err != errStop {
+		t.Errorf("expected the error of fn, got %v", err)
+	}
+}
+
+func TestGetRawPutRaw(t *testing.T) {
+	s := NewJSONStore(db, []byte("get_raw"), WithChecksums())
+	defer s.DeleteAll()
+
+	s.Put("a", MyType{FirstName: "Ann"})
+	raw, err := s.GetRaw([]byte("a"))
+	if err != nil || !bytes.Equal(raw, []byte(`{"first":"Ann","last":""}`+"\n")) {
+		t.Fatalf("unexpected raw value %q %v", raw, err)
+	}
+
+	if err := s.PutRaw([]byte("b"), raw); err != nil {
+		t.Fatal(err)
+	}
+	var v MyType
+	if err := s.Get("b", &v); err != nil || v.FirstName != "Ann" {
+		t.Errorf("unexpected value %v %v", v, err)
+	}
+	if _, err := s.GetRaw([]byte("missing")); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,104 @@
+package stow
+
+import (
+	"reflect"
+)
+
+// recodedKey is kept in the meta bucket of a store while it's recoded, and holds the last
+// key which was recoded.
+var recodedKey = []byte("\x00recoded")
+
+// Recode re-encodes every object of the store with codec in place, by decoding each value
+// into a new value of the type of model, and returns a Store using codec on the same bucket
+// and with the same Options, to use from then on. Objects keep their expiration time and
+// index entries, and expired objects are left as they are. Nested stores and hashes aren't
+// recoded, recode nested stores with their own Recode.
+//
+// Objects are recoded in batches, each in its own transaction, and the store must not be
+// written meanwhile. The progress is recorded along with each batch: if Recode fails or is
+// interrupted, calling it again with the same codec resumes after the last batch recoded,
+// rather than failing to decode the values which already use codec.
+func (s *Store) Recode(codec Codec, model interface{}) (*Store, error) {
+	typ := reflect.TypeOf(model)
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	recoded := &Store{
+		db:     s.db,
+		bucket: s.bucket,
+		codec:  codec,
+		opts:   s.opts,
+
+		immutable: &immutableCache{},
+		stats:     &storeStats{},
+		access:    &accessCounts{},
+		flights:   &flightGroup{},
+	}
+	defer s.forgetAll()
+
+	var after []byte
+	err := s.db.View(func(tx BackendTx) error {
+		if meta := s.bucket.meta().get(tx); meta != nil {
+			after = append([]byte(nil), meta.Get(recodedKey)...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(after) == 0 {
+		after = nil
+	}
+
+	for {
+		batch, last, err := s.copyBatch(after)
+		if err != nil {
+			return nil, err
+		}
+		if len(batch) == 0 {
+			break
+		}
+		after = last
+
+		for i, obj := range batch {
+			val := reflect.New(typ).Interface()
+			if err := s.unmarshalValue(obj.key, obj.data, val); err != nil {
+				return nil, s.decodeError(obj.key, obj.data, err)
+			}
+			if batch[i].data, err = recoded.marshalValue(val); err != nil {
+				return nil, err
+			}
+		}
+
+		err = s.update(func(tx BackendTx) error {
+			objects, err := s.bucket.createOrGet(tx)
+			if err != nil {
+				return err
+			}
+			for _, obj := range batch {
+				if err := objects.Put(obj.key, obj.data); err != nil {
+					return err
+				}
+			}
+			meta, err := s.bucket.meta().createOrGetUntracked(tx)
+			if err != nil {
+				return err
+			}
+			return meta.Put(recodedKey, last)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = s.update(func(tx BackendTx) error {
+		if meta := s.bucket.meta().get(tx); meta != nil && meta.Get(recodedKey) != nil {
+			return meta.Delete(recodedKey)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return recoded, nil
+}
@@ -0,0 +1,60 @@
+package stow
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRecode(t *testing.T) {
+	s := NewStore(db, []byte("recode"))
+	defer s.DeleteAll()
+
+	s.Put("a", MyType{FirstName: "Ann"})
+	s.PutTTL("b", MyType{FirstName: "Bob"}, time.Hour)
+
+	recoded, err := s.Recode(JSONCodec{}, MyType{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v MyType
+	if err := recoded.Get("b", &v); err != nil || v.FirstName != "Bob" {
+		t.Errorf("unexpected value %v %v", v, err)
+	}
+	NewBoltBackend(db).View(func(tx BackendTx) error {
+		if ttl := recoded.ttlOf(tx, []byte("b")); ttl != time.Hour {
+			t.Errorf("expected ttl to be kept got %v", ttl)
+		}
+		return nil
+	})
+	var raw map[string]string
+	if err := NewJSONStore(db, []byte("recode")).Get("a", &raw); err != nil || raw["first"] != "Ann" {
+		t.Errorf("expected json value got %v %v", raw, err)
+	}
+
+	// An interrupted Recode resumes after the last key it recoded.
+	recoded.Put("c", MyType{FirstName: "Cid"})
+	back := NewStore(db, []byte("recode"))
+	back.Put("d", MyType{FirstName: "Dan"})
+	NewBoltBackend(db).Update(func(tx BackendTx) error {
+		meta, _ := s.bucket.meta().createOrGetUntracked(tx)
+		return meta.Put(recodedKey, []byte("c"))
+	})
+	if _, err := s.Recode(JSONCodec{}, MyType{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a", "c", "d"} {
+		if err := recoded.Get(key, &v); err != nil {
+			t.Errorf("unexpected error for %s %v", key, err)
+		}
+	}
+	NewBoltBackend(db).View(func(tx BackendTx) error {
+		if s.bucket.meta().get(tx).Get(recodedKey) != nil {
+			t.Errorf("progress was left behind")
+		}
+		return nil
+	})
+
+	if _, err := s.Recode(XMLCodec{}, MyType{}); err == nil {
+		t.Errorf("expected decode error recoding json values as gob")
+	}
+}
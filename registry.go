@@ -0,0 +1,411 @@
+package stow
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	// ErrTypeChanged indicates a registered type which changed incompatibly since CheckTypes
+	// last recorded it, so the values gob encoded with it can't be decoded anymore.
+	ErrTypeChanged = errors.New("registered type changed incompatibly")
+
+	// ErrTypeNotRegistered indicates a type CheckTypes recorded earlier which isn't registered
+	// anymore, like after it was renamed without an alias.
+	ErrTypeNotRegistered = errors.New("type isn't registered")
+)
+
+// typesBucket is the meta bucket where CheckTypes records the fields of each registered
+// type, by the name it's registered with.
+var typesBucket = []byte("\x00types")
+
+// Gob writes the name a type is registered with along with each value of an interface
+// type, so the name is the type's stable ID: it must not change while such values are kept.
+var typeRegistry = struct {
+	sync.RWMutex
+	// types holds the type of each registered name, the type an alias decodes as for aliases.
+	types   map[string]reflect.Type
+	aliases map[reflect.Type]typeAlias
+	// aliasCount is the number of aliases of each type, to keep their alias types distinct.
+	aliasCount map[reflect.Type]int
+}{
+	types:      make(map[string]reflect.Type),
+	aliases:    make(map[reflect.Type]typeAlias),
+	aliasCount: make(map[reflect.Type]int),
+}
+
+// hasAliases is set once an alias is registered, so GobCodec only looks for aliases then.
+var hasAliases int32
+
+func hasTypeAliases() bool {
+	return atomic.LoadInt32(&hasAliases) != 0
+}
+
+// typeAlias is the struct type an alias is registered with in gob, its values are converted
+// to target after they're decoded.
+type typeAlias struct {
+	target reflect.Type
+	// fields holds the index in target of each field of the alias type.
+	fields []int
+}
+
+// Register registers the type using gob.Register for use with NewStore() and the GobCodec.
+func Register(value interface{}) {
+	RegisterName(gobName(value), value)
+}
+
+// RegisterName registers the type using gob.RegisterName for use with NewStore() and the GobCodec.
+// Registering types with a name of your own keeps their gob values readable when they're
+// renamed or moved to another package, see RegisterAlias otherwise.
+func RegisterName(name string, value interface{}) {
+	gob.RegisterName(name, value)
+	typeRegistry.Lock()
+	defer typeRegistry.Unlock()
+	typeRegistry.types[name] = reflect.TypeOf(value)
+}
+
+// RegisterAlias makes the GobCodec decode the values gob wrote for the type registered as
+// oldName as values of value's type, like after that type was renamed or moved. value must be
+// a struct, or a pointer to one, and should be registered itself so new values are written
+// with its name. Old values are decoded as if into value's type: by field name, like gob
+// does for values of concrete types. Like gob.RegisterName, it panics if oldName is
+// registered already, unless as an alias of the same type.
+func RegisterAlias(oldName string, value interface{}) {
+	target := reflect.TypeOf(value)
+
+	typeRegistry.Lock()
+	defer typeRegistry.Unlock()
+	if typeRegistry.types[oldName] == target {
+		return
+	}
+	n := typeRegistry.aliasCount[target]
+	alias, info := aliasType(target, n)
+	gob.RegisterName(oldName, reflect.Zero(alias).Interface())
+	typeRegistry.types[oldName] = target
+	typeRegistry.aliases[alias] = info
+	typeRegistry.aliasCount[target] = n + 1
+	atomic.StoreInt32(&hasAliases, 1)
+}
+
+// aliasType returns the nth alias type of target: an unnamed struct type with the exported
+// fields of target's struct, since gob allows one name per type.
+func aliasType(target reflect.Type, n int) (reflect.Type, typeAlias) {
+	t := target
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("stow: RegisterAlias needs a struct or a pointer to one, got %v", target))
+	}
+
+	info := typeAlias{target: target}
+	var fields []reflect.StructField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		// Gob names embedded fields by their type, like the field's name.
+		fields = append(fields, reflect.StructField{Name: f.Name, Type: f.Type, Tag: f.Tag})
+		info.fields = append(info.fields, i)
+	}
+	// Gob ignores the fields values don't have, so a field only the alias has keeps it distinct.
+	if n > 0 {
+		fields = append(fields, reflect.StructField{Name: fmt.Sprintf("StowAlias%d", n), Type: reflect.TypeOf([0]byte{})})
+	}
+
+	alias := reflect.StructOf(fields)
+	if target.Kind() == reflect.Ptr {
+		alias = reflect.PtrTo(alias)
+	}
+	return alias, info
+}
+
+// gobName returns the name gob.Register registers value's type with.
+func gobName(value interface{}) string {
+	rt := reflect.TypeOf(value)
+	name := rt.String()
+
+	// Like gob.Register, this only dereferences a pointer to check if it's named, and then
+	// uses the unqualified name of the pointer type.
+	star := ""
+	if rt.Name() == "" {
+		if pt := rt; pt.Kind() == reflect.Ptr {
+			star = "*"
+			rt = pt
+		}
+	}
+	if rt.Name() != "" {
+		if rt.PkgPath() == "" {
+			name = star + rt.Name()
+		} else {
+			name = star + rt.PkgPath() + "." + rt.Name()
+		}
+	}
+	return name
+}
+
+// aliasDecoder is a gob decoder which converts the values of alias types it decodes.
+type aliasDecoder struct {
+	*gob.Decoder
+}
+
+func (d aliasDecoder) Decode(v interface{}) error {
+	if err := d.Decoder.Decode(v); err != nil {
+		return err
+	}
+	typeRegistry.RLock()
+	defer typeRegistry.RUnlock()
+	resolveAliases(reflect.ValueOf(v))
+	return nil
+}
+
+// resolveAliases replaces the values of alias types held by interfaces in v, which must be
+// addressable below its pointers.
+func resolveAliases(v reflect.Value) {
+	if !mayHoldAlias(v.Type()) {
+		return
+	}
+	switch v.Kind() {
+	case reflect.Ptr:
+		if !v.IsNil() {
+			resolveAliases(v.Elem())
+		}
+	case reflect.Interface:
+		if v.IsNil() || !v.CanSet() {
+			return
+		}
+		e := v.Elem()
+		if alias, ok := typeRegistry.aliases[e.Type()]; ok {
+			e = alias.convert(e)
+		} else if mayHoldAlias(e.Type()) {
+			c := reflect.New(e.Type()).Elem()
+			c.Set(e)
+			e = c
+		} else {
+			return
+		}
+		resolveAliases(e)
+		v.Set(e)
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if f := v.Field(i); f.CanSet() {
+				resolveAliases(f)
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			resolveAliases(v.Index(i))
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			c := reflect.New(v.Type().Elem()).Elem()
+			c.Set(iter.Value())
+			resolveAliases(c)
+			v.SetMapIndex(iter.Key(), c)
+		}
+	}
+}
+
+// holdsInterfaces caches mayHoldAlias, by type.
+var holdsInterfaces sync.Map
+
+// mayHoldAlias reports whether values of t can hold interfaces.
+func mayHoldAlias(t reflect.Type) bool {
+	if holds, ok := holdsInterfaces.Load(t); ok {
+		return holds.(bool)
+	}
+	holds := holdsInterface(t, make(map[reflect.Type]bool))
+	holdsInterfaces.Store(t, holds)
+	return holds
+}
+
+func holdsInterface(t reflect.Type, seen map[reflect.Type]bool) bool {
+	if seen[t] {
+		return false
+	}
+	seen[t] = true
+	switch t.Kind() {
+	case reflect.Interface:
+		return true
+	case reflect.Ptr, reflect.Slice, reflect.Array:
+		return holdsInterface(t.Elem(), seen)
+	case reflect.Map:
+		return holdsInterface(t.Elem(), seen)
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if f := t.Field(i); f.PkgPath == "" && holdsInterface(f.Type, seen) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// convert returns v, a value of the alias type, as a value of its target type.
+func (a typeAlias) convert(v reflect.Value) reflect.Value {
+	target := a.target
+	if target.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return reflect.Zero(target)
+		}
+		p := reflect.New(target.Elem())
+		a.copyFields(p.Elem(), v.Elem())
+		return p
+	}
+	c := reflect.New(target).Elem()
+	a.copyFields(c, v)
+	return c
+}
+
+func (a typeAlias) copyFields(dst, src reflect.Value) {
+	for i, field := range a.fields {
+		dst.Field(field).Set(src.Field(i))
+	}
+}
+
+// CheckTypes checks that the types registered with Register, RegisterName and RegisterAlias
+// can still decode the gob values written with them, and records them in the store's meta
+// bucket for the next check: call it when the store is opened, to fail fast rather than
+// decode values wrong. A type changed incompatibly (ErrTypeChanged) if a field it had when
+// CheckTypes last ran has a type gob can't decode into the field's new type. A type recorded
+// earlier which isn't registered anymore, under its name or as an alias, fails the check with
+// ErrTypeNotRegistered, see ForgetType for types which aren't used anymore.
+func (s *Store) CheckTypes() error {
+	typeRegistry.RLock()
+	current := make(map[string]string, len(typeRegistry.types))
+	for name, t := range typeRegistry.types {
+		current[name] = typeFingerprint(t)
+	}
+	typeRegistry.RUnlock()
+
+	check := func(tx BackendTx) error {
+		recorded := s.bucket.meta().child(typesBucket).get(tx)
+		if recorded == nil {
+			return nil
+		}
+		return recorded.ForEach(func(name, fingerprint []byte) error {
+			now, ok := current[string(name)]
+			if !ok {
+				return fmt.Errorf("%w: %s", ErrTypeNotRegistered, name)
+			}
+			return compareFingerprints(string(name), string(fingerprint), now)
+		})
+	}
+	if s.opts.readOnly {
+		return s.db.View(check)
+	}
+	return s.update(func(tx BackendTx) error {
+		if err := check(tx); err != nil {
+			return err
+		}
+		recorded, err := s.bucket.meta().child(typesBucket).createOrGetUntracked(tx)
+		if err != nil {
+			return err
+		}
+		for name, fingerprint := range current {
+			if err := recorded.Put([]byte(name), []byte(fingerprint)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ForgetType removes the type registered as name from the types CheckTypes recorded, once
+// the store doesn't hold values of it anymore.
+func (s *Store) ForgetType(name string) error {
+	return s.update(func(tx BackendTx) error {
+		recorded := s.bucket.meta().child(typesBucket).get(tx)
+		if recorded == nil {
+			return nil
+		}
+		return recorded.Delete([]byte(name))
+	})
+}
+
+// typeFingerprint describes t as gob sees it: "=kind" for types other than structs, and
+// "field:kind,..." for the exported fields of structs.
+func typeFingerprint(t reflect.Type) string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return "=" + gobKind(t)
+	}
+	var fields []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" || f.Type.Kind() == reflect.Chan || f.Type.Kind() == reflect.Func {
+			continue
+		}
+		fields = append(fields, f.Name+":"+gobKind(f.Type))
+	}
+	sort.Strings(fields)
+	return strings.Join(fields, ",")
+}
+
+// gobKind returns the kind of t as gob encodes it: gob flattens pointers, and sizes of
+// numbers don't matter.
+func gobKind(t reflect.Type) string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return "int"
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return "uint"
+	case reflect.Float32, reflect.Float64:
+		return "float"
+	case reflect.Complex64, reflect.Complex128:
+		return "complex"
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return "bytes"
+		}
+	}
+	return t.Kind().String()
+}
+
+// compareFingerprints returns an ErrTypeChanged if the type registered as name was recorded
+// as was, and gob can't decode its values into the type it is now.
+func compareFingerprints(name, was, now string) error {
+	if was == now {
+		return nil
+	}
+	if strings.HasPrefix(was, "=") || strings.HasPrefix(now, "=") {
+		return fmt.Errorf("%w: %s was %s, is %s", ErrTypeChanged, name, describeFingerprint(was), describeFingerprint(now))
+	}
+	fields := make(map[string]string)
+	for _, field := range strings.Split(now, ",") {
+		if i := strings.IndexByte(field, ':'); i >= 0 {
+			fields[field[:i]] = field[i+1:]
+		}
+	}
+	for _, field := range strings.Split(was, ",") {
+		i := strings.IndexByte(field, ':')
+		if i < 0 {
+			continue
+		}
+		// Gob ignores the fields which were removed.
+		if kind, ok := fields[field[:i]]; ok && kind != field[i+1:] {
+			return fmt.Errorf("%w: %s field %s was %s, is %s", ErrTypeChanged, name, field[:i], field[i+1:], kind)
+		}
+	}
+	return nil
+}
+
+func describeFingerprint(fingerprint string) string {
+	if strings.HasPrefix(fingerprint, "=") {
+		return fingerprint[1:]
+	}
+	return "struct"
+}
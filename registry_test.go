@@ -0,0 +1,108 @@
+package stow
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type registryHolder struct {
+	Value  interface{}
+	Values []interface{}
+}
+
+type registryAccount struct {
+	Login string
+	Age   int
+}
+
+type registryCounter struct {
+	Count int
+}
+
+// registryOldAccount returns a value of an alias type of *registryAccount with fields fields,
+// which gob encodes like the values written for the alias's old name.
+func registryOldAccount(fields int, login string) interface{} {
+	typeRegistry.RLock()
+	defer typeRegistry.RUnlock()
+	for alias, info := range typeRegistry.aliases {
+		if info.target == reflect.TypeOf(&registryAccount{}) && alias.Elem().NumField() == fields {
+			v := reflect.New(alias.Elem())
+			v.Elem().Field(0).SetString(login)
+			return v.Interface()
+		}
+	}
+	return nil
+}
+
+func TestRegisterAlias(t *testing.T) {
+	RegisterName("stow.test.Account", &registryAccount{})
+	RegisterAlias("stow.test.LegacyAccount", &registryAccount{})
+	RegisterAlias("stow.test.LegacyUser", &registryAccount{})
+
+	s := NewStore(db, []byte("registry_alias"))
+	defer s.DeleteAll()
+
+	// The second alias of a type has an extra field to keep it distinct.
+	legacyAccount, legacyUser := registryOldAccount(2, "ann"), registryOldAccount(3, "cat")
+	if legacyAccount == nil || legacyUser == nil {
+		t.Fatal("expected the alias types")
+	}
+	s.Put("holder", registryHolder{
+		Value:  legacyAccount,
+		Values: []interface{}{legacyUser, &registryAccount{Login: "bob"}},
+	})
+
+	var got registryHolder
+	if err := s.Get("holder", &got); err != nil {
+		t.Fatal(err)
+	}
+	if a, ok := got.Value.(*registryAccount); !ok || a.Login != "ann" {
+		t.Errorf("expected the alias to decode as *registryAccount, got %#v", got.Value)
+	}
+	if a, ok := got.Values[0].(*registryAccount); !ok || a.Login != "cat" {
+		t.Errorf("expected aliases in slices to be converted, got %#v", got.Values[0])
+	}
+	if a, ok := got.Values[1].(*registryAccount); !ok || a.Login != "bob" {
+		t.Errorf("unexpected current value %#v", got.Values[1])
+	}
+}
+
+func TestCheckTypes(t *testing.T) {
+	RegisterName("stow.test.Counter", registryCounter{})
+	s := NewStore(db, []byte("registry_check"))
+	defer s.DeleteAll()
+
+	if err := s.CheckTypes(); err != nil {
+		t.Fatal(err)
+	}
+	record := func(name, fingerprint string) {
+		NewBoltBackend(db).Update(func(tx BackendTx) error {
+			b, _ := s.bucket.meta().child(typesBucket).createOrGetUntracked(tx)
+			return b.Put([]byte(name), []byte(fingerprint))
+		})
+	}
+
+	// Sizes of numbers don't matter to gob, and removed fields are ignored.
+	record("stow.test.Counter", "Count:int,Removed:string")
+	if err := s.CheckTypes(); err != nil {
+		t.Errorf("expected a compatible change, got %v", err)
+	}
+
+	record("stow.test.Counter", "Count:string")
+	if err := s.CheckTypes(); !errors.Is(err, ErrTypeChanged) {
+		t.Errorf("expected ErrTypeChanged, got %v", err)
+	}
+	record("stow.test.Counter", "Count:int")
+
+	record("stow.test.Renamed", "Count:int")
+	if err := s.CheckTypes(); !errors.Is(err, ErrTypeNotRegistered) {
+		t.Errorf("expected ErrTypeNotRegistered, got %v", err)
+	}
+	if err := s.ForgetType("stow.test.Renamed"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CheckTypes(); err != nil {
+		t.Errorf("expected forgotten types to pass, got %v", err)
+	}
+}
@@ -0,0 +1,303 @@
+package stow
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"time"
+)
+
+// ErrRetryDone indicates a transition was attempted on a RetryStore key which already
+// reached a terminal state.
+var ErrRetryDone = errors.New("retry already completed or failed")
+
+var retryDueBucket = []byte("\x00retry.due")
+
+// RetryState is the state of a key tracked by a RetryStore.
+type RetryState int
+
+const (
+	// RetryPending keys are waiting for their next attempt.
+	RetryPending RetryState = iota
+	// RetryCompleted keys succeeded, this is a terminal state.
+	RetryCompleted
+	// RetryFailed keys ran out of attempts, this is a terminal state.
+	RetryFailed
+)
+
+// RetryPolicy configures the exponential backoff of a RetryStore.
+type RetryPolicy struct {
+	// InitialDelay is the delay after the first failure, 1 second if zero.
+	InitialDelay time.Duration
+	// MaxDelay caps the delay between attempts, unlimited if zero.
+	MaxDelay time.Duration
+	// Multiplier grows the delay after each failure, 2 if zero.
+	Multiplier float64
+	// MaxAttempts moves a key to RetryFailed once it failed this many times, unlimited if zero.
+	MaxAttempts int
+}
+
+// Delay returns how long to wait before the next attempt, after attempts failures.
+func (p RetryPolicy) Delay(attempts int) time.Duration {
+	initial, multiplier := p.InitialDelay, p.Multiplier
+	if initial <= 0 {
+		initial = time.Second
+	}
+	if multiplier <= 0 {
+		multiplier = 2
+	}
+
+	delay := float64(initial) * math.Pow(multiplier, float64(attempts-1))
+	if p.MaxDelay > 0 && delay > float64(p.MaxDelay) {
+		return p.MaxDelay
+	}
+	if delay > math.MaxInt64 {
+		return math.MaxInt64
+	}
+	return time.Duration(delay)
+}
+
+// RetryEntry is the state a RetryStore keeps for each key.
+type RetryEntry struct {
+	Key         []byte `json:"-" xml:"-"`
+	State       RetryState
+	Attempts    int
+	NextAttempt time.Time
+	LastError   string
+}
+
+// RetryStore tracks attempt counts, next-attempt times and terminal states of keys,
+// backing off exponentially between failed attempts. Keys are within the namespace of its
+// store, see Store.Namespace.
+type RetryStore struct {
+	store  *Store
+	policy RetryPolicy
+}
+
+// NewRetryStore creates a RetryStore which keeps its entries in store.
+func NewRetryStore(store *Store, policy RetryPolicy) *RetryStore {
+	return &RetryStore{store: store, policy: policy}
+}
+
+// Add starts tracking key, it is due immediately. Adding a key which is already
+// tracked does nothing.
+func (r *RetryStore) Add(key []byte) error {
+	return r.AddAt(key, time.Now())
+}
+
+// AddAt starts tracking key, its first attempt is due at t. Adding a key which is
+// already tracked does nothing.
+func (r *RetryStore) AddAt(key []byte, t time.Time) error {
+	key = r.store.nsKey(key)
+	return r.store.update(func(tx BackendTx) error {
+		objects, err := r.store.bucket.createOrGet(tx)
+		if err != nil {
+			return err
+		}
+		if objects.Get(key) != nil {
+			return nil
+		}
+		return r.put(tx, objects, RetryEntry{Key: key, NextAttempt: t}, nil)
+	})
+}
+
+// Get returns the entry for key, or ErrNotFound.
+func (r *RetryStore) Get(key []byte) (entry RetryEntry, err error) {
+	err = r.store.Get(key, &entry)
+	entry.Key = key
+	return entry, err
+}
+
+// Due calls do for every pending key whose next attempt is at or before now, in order
+// of their next attempt. do may call Complete or Fail. Keys removed other than through the
+// RetryStore, like by Store.Delete, are no longer tracked.
+func (r *RetryStore) Due(now time.Time, do func(entry RetryEntry) error) error {
+	var due []RetryEntry
+	var stale [][]byte
+	err := r.store.db.View(func(tx BackendTx) error {
+		index := r.store.bucket.meta().child(retryDueBucket).get(tx)
+		objects := r.store.bucket.get(tx)
+		if index == nil || objects == nil {
+			return nil
+		}
+
+		end := encodeTime(now)
+		c := index.Cursor()
+		for k, _ := c.First(); k != nil && bytes.Compare(k[:timeLength], end) <= 0; k, _ = c.Next() {
+			key := k[timeLength:]
+			if !bytes.HasPrefix(key, r.store.prefix) {
+				// The key of another namespace of the bucket.
+				continue
+			}
+			entry, ok, err := r.dueEntry(objects, k)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				stale = append(stale, append([]byte(nil), k...))
+				continue
+			}
+			entry.Key = append([]byte(nil), r.store.trimNS(key)...)
+			due = append(due, entry)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(stale) > 0 && !r.store.opts.readOnly {
+		if err := r.removeStale(stale); err != nil {
+			return err
+		}
+	}
+
+	for _, entry := range due {
+		if err := do(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dueEntry returns the entry of the key of k, a key of the due index. ok is false if k is
+// stale: the key was deleted, or its entry isn't due at the time of k anymore.
+func (r *RetryStore) dueEntry(objects BackendBucket, k []byte) (entry RetryEntry, ok bool, err error) {
+	key := k[timeLength:]
+	data := objects.Get(key)
+	if data == nil {
+		return entry, false, nil
+	}
+	if err := r.store.unmarshalValue(key, data, &entry); err != nil {
+		return entry, false, err
+	}
+	if entry.State != RetryPending || !bytes.Equal(encodeTime(entry.NextAttempt), k[:timeLength]) {
+		return entry, false, nil
+	}
+	return entry, true, nil
+}
+
+// removeStale removes the keys of the due index which are still stale.
+func (r *RetryStore) removeStale(stale [][]byte) error {
+	return r.store.update(func(tx BackendTx) error {
+		index := r.store.bucket.meta().child(retryDueBucket).get(tx)
+		if index == nil {
+			return nil
+		}
+		objects := r.store.bucket.get(tx)
+		for _, k := range stale {
+			if objects != nil {
+				if _, ok, err := r.dueEntry(objects, k); err != nil || ok {
+					continue
+				}
+			}
+			if err := index.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Complete moves key to the RetryCompleted state.
+func (r *RetryStore) Complete(key []byte) error {
+	return r.transition(key, func(entry *RetryEntry) {
+		entry.State = RetryCompleted
+	})
+}
+
+// Fail records a failed attempt for key, scheduling the next attempt according to the
+// RetryPolicy, or moving it to RetryFailed if it ran out of attempts. cause may be nil.
+func (r *RetryStore) Fail(key []byte, cause error) error {
+	return r.transition(key, func(entry *RetryEntry) {
+		entry.Attempts++
+		if cause != nil {
+			entry.LastError = cause.Error()
+		}
+		if r.policy.MaxAttempts > 0 && entry.Attempts >= r.policy.MaxAttempts {
+			entry.State = RetryFailed
+			return
+		}
+		entry.NextAttempt = time.Now().Add(r.policy.Delay(entry.Attempts))
+	})
+}
+
+// Remove stops tracking key.
+func (r *RetryStore) Remove(key []byte) error {
+	key = r.store.nsKey(key)
+	return r.store.update(func(tx BackendTx) error {
+		objects := r.store.bucket.get(tx)
+		if objects == nil {
+			return nil
+		}
+		var old RetryEntry
+		if data := objects.Get(key); data != nil {
+			if err := r.store.unmarshalValue(key, data, &old); err != nil {
+				return err
+			}
+			if err := r.unindex(tx, key, old); err != nil {
+				return err
+			}
+		}
+		return r.store.deleteKey(tx, objects, key)
+	})
+}
+
+func (r *RetryStore) transition(key []byte, change func(entry *RetryEntry)) error {
+	key = r.store.nsKey(key)
+	return r.store.update(func(tx BackendTx) error {
+		objects := r.store.bucket.get(tx)
+		if objects == nil {
+			return ErrNotFound
+		}
+		data := objects.Get(key)
+		if data == nil {
+			return ErrNotFound
+		}
+
+		var entry RetryEntry
+		if err := r.store.unmarshalValue(key, data, &entry); err != nil {
+			return err
+		}
+		if entry.State != RetryPending {
+			return ErrRetryDone
+		}
+
+		old := entry
+		entry.Key = key
+		change(&entry)
+		return r.put(tx, objects, entry, &old)
+	})
+}
+
+func (r *RetryStore) put(tx BackendTx, objects BackendBucket, entry RetryEntry, old *RetryEntry) error {
+	if old != nil {
+		if err := r.unindex(tx, entry.Key, *old); err != nil {
+			return err
+		}
+	}
+
+	data, err := r.store.marshalValue(entry)
+	if err != nil {
+		return err
+	}
+	if err := objects.Put(entry.Key, data); err != nil {
+		return err
+	}
+
+	if entry.State != RetryPending {
+		return nil
+	}
+	index, err := r.store.bucket.meta().child(retryDueBucket).createOrGetUntracked(tx)
+	if err != nil {
+		return err
+	}
+	return index.Put(timeKey(encodeTime(entry.NextAttempt), entry.Key), []byte{})
+}
+
+func (r *RetryStore) unindex(tx BackendTx, key []byte, entry RetryEntry) error {
+	index := r.store.bucket.meta().child(retryDueBucket).get(tx)
+	if index == nil || entry.State != RetryPending {
+		return nil
+	}
+	return index.Delete(timeKey(encodeTime(entry.NextAttempt), key))
+}
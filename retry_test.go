@@ -0,0 +1,164 @@
+package stow
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	p := RetryPolicy{InitialDelay: time.Second, MaxDelay: 5 * time.Second}
+	for attempts, delay := range []time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 5 * time.Second} {
+		if attempts == 0 {
+			continue
+		}
+		if got := p.Delay(attempts); got != delay {
+			t.Errorf("Delay(%d) = %v, expected %v", attempts, got, delay)
+		}
+	}
+}
+
+func TestRetryStore(t *testing.T) {
+	s := NewJSONStore(db, []byte("retry"))
+	defer s.DeleteAll()
+	r := NewRetryStore(s, RetryPolicy{InitialDelay: time.Hour, MaxAttempts: 2})
+
+	r.Add([]byte("a"))
+	r.Add([]byte("b"))
+	r.AddAt([]byte("later"), time.Now().Add(time.Hour))
+
+	var due []string
+	err := r.Due(time.Now(), func(entry RetryEntry) error {
+		due = append(due, string(entry.Key))
+		if string(entry.Key) == "a" {
+			return r.Complete(entry.Key)
+		}
+		return r.Fail(entry.Key, errors.New("boom"))
+	})
+	if err != nil || len(due) != 2 {
+		t.Fatalf("unexpected due keys %q %v", due, err)
+	}
+
+	if entry, err := r.Get([]byte("a")); err != nil || entry.State != RetryCompleted {
+		t.Errorf("expected a to be completed: %+v %v", entry, err)
+	}
+	if err := r.Fail([]byte("a"), nil); err != ErrRetryDone {
+		t.Errorf("expected ErrRetryDone got %v", err)
+	}
+
+	entry, err := r.Get([]byte("b"))
+	if err != nil || entry.State != RetryPending || entry.Attempts != 1 || entry.LastError != "boom" {
+		t.Errorf("unexpected entry for b: %+v %v", entry, err)
+	}
+	if !entry.NextAttempt.After(time.Now().Add(time.Hour - time.Minute)) {
+		t.Errorf("expected b to back off: %v", entry.NextAttempt)
+	}
+
+	due = nil
+	r.Due(time.Now().Add(2*time.Hour), func(entry RetryEntry) error {
+		due = append(due, string(entry.Key))
+		return nil
+	})
+	if len(due) != 2 || due[0] != "later" || due[1] != "b" {
+		t.Errorf("unexpected due order %q", due)
+	}
+
+	r.Fail([]byte("b"), nil)
+	if entry, _ := r.Get([]byte("b")); entry.State != RetryFailed {
+		t.Errorf("expected b to fail after max attempts: %+v", entry)
+	}
+
+	r.Remove([]byte("later"))
+	if _, err := r.Get([]byte("later")); err != ErrNotFound {
+		t.Errorf("expected removed key to be missing: %v", err)
+	}
+	r.Due(time.Now().Add(24*time.Hour), func(entry RetryEntry) error {
+		t.Errorf("unexpected due key %s", entry.Key)
+		return nil
+	})
+}
+
+func TestRetryStoreNamespace(t *testing.T) {
+	s := NewJSONStore(db, []byte("retry_namespace"))
+	defer s.DeleteAll()
+	r := NewRetryStore(s.Namespace([]byte("jobs/")), RetryPolicy{InitialDelay: time.Hour})
+	other := NewRetryStore(s.Namespace([]byte("other/")), RetryPolicy{})
+
+	r.Add([]byte("a"))
+	other.Add([]byte("b"))
+	if has, _ := s.Has("jobs/a"); !has {
+		t.Errorf("expected the entry in the namespace")
+	}
+	if err := r.Fail([]byte("a"), errors.New("boom")); err != nil {
+		t.Fatal(err)
+	}
+	if entry, err := r.Get([]byte("a")); err != nil || entry.Attempts != 1 {
+		t.Errorf("unexpected entry %+v %v", entry, err)
+	}
+
+	var due []string
+	other.Due(time.Now().Add(2*time.Hour), func(entry RetryEntry) error {
+		due = append(due, string(entry.Key))
+		return other.Complete(entry.Key)
+	})
+	if len(due) != 1 || due[0] != "b" {
+		t.Errorf("unexpected due keys %q", due)
+	}
+	if err := r.Remove([]byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if has, _ := s.Has("jobs/a"); has {
+		t.Errorf("expected the entry to be removed")
+	}
+}
+
+func TestRetryStoreDeletedKey(t *testing.T) {
+	s := NewJSONStore(db, []byte("retry_deleted"))
+	defer s.DeleteAll()
+	r := NewRetryStore(s, RetryPolicy{})
+
+	r.Add([]byte("a"))
+	r.Add([]byte("b"))
+	if err := s.Delete("a"); err != nil {
+		t.Fatal(err)
+	}
+
+	var due []string
+	err := r.Due(time.Now(), func(entry RetryEntry) error {
+		due = append(due, string(entry.Key))
+		return nil
+	})
+	if err != nil || len(due) != 1 || due[0] != "b" {
+		t.Errorf("unexpected due keys %q %v", due, err)
+	}
+
+	// A deleted key added again for later isn't due at its old time.
+	r.Add([]byte("c"))
+	s.Delete("c")
+	r.AddAt([]byte("c"), time.Now().Add(time.Hour))
+	due = nil
+	r.Due(time.Now(), func(entry RetryEntry) error {
+		due = append(due, string(entry.Key))
+		return nil
+	})
+	if len(due) != 1 || due[0] != "b" {
+		t.Errorf("unexpected due keys %q", due)
+	}
+}
+
+func TestRetryStoreChecksums(t *testing.T) {
+	s := NewJSONStore(db, []byte("retry-checksums"), WithChecksums())
+	defer s.DeleteAll()
+	r := NewRetryStore(s, RetryPolicy{InitialDelay: time.Hour})
+
+	r.Add([]byte("a"))
+	err := r.Due(time.Now(), func(entry RetryEntry) error {
+		return r.Fail(entry.Key, errors.New("boom"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry, err := r.Get([]byte("a")); err != nil || entry.Attempts != 1 {
+		t.Errorf("unexpected entry for a: %+v %v", entry, err)
+	}
+}
@@ -0,0 +1,136 @@
+package stow
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// ringSizeBucket is the meta bucket which maps the prefix of each RingStore's keys (see
+// Namespace) to its number of entries and their size, so Add doesn't count them. deleteKey
+// keeps it up to date, however entries are removed.
+var ringSizeBucket = []byte("\x00ring.size")
+
+// RingStore is a capped log of objects kept in a Store, like the last lines of a log: Add
+// appends an object under the next key of the store's bucket sequence (see PutAutoKey), and
+// evicts the oldest objects over its limits in the same transaction. A RingStore should have
+// its Store (or Namespace) to itself.
+type RingStore struct {
+	s          *Store
+	maxEntries int
+	maxBytes   int64
+}
+
+// NewRingStore returns a RingStore which keeps at most maxEntries objects in s, whose encoded
+// values are at most maxBytes in total. A limit <= 0 means no limit.
+func (s *Store) NewRingStore(maxEntries int, maxBytes int64) *RingStore {
+	return &RingStore{s: s, maxEntries: maxEntries, maxBytes: maxBytes}
+}
+
+// Add appends val to the ring and returns its key (see AutoKey), evicting the oldest objects
+// once the ring is over its limits. The newest object is always kept, even if it's over
+// maxBytes on its own.
+func (r *RingStore) Add(val interface{}) (key uint64, err error) {
+	s := r.s
+	return s.putSequenced(val, AutoKey, func(tx BackendTx, objects BackendBucket, key, data []byte) error {
+		if err := s.addRingEntries(tx, 1, int64(len(data))); err != nil {
+			return err
+		}
+		for {
+			entries, size := s.ringSize(tx)
+			if !(r.maxEntries > 0 && entries > int64(r.maxEntries)) && !(r.maxBytes > 0 && size > r.maxBytes && entries > 1) {
+				return nil
+			}
+			oldest := s.oldestRingEntry(objects)
+			if oldest == nil || bytes.Equal(oldest, key) {
+				return nil
+			}
+			if err := s.deleteKey(tx, objects, oldest); err != nil {
+				return err
+			}
+		}
+	})
+}
+
+// ForEach calls do on the objects of the ring, from the oldest to the newest, see
+// Store.ForEach.
+func (r *RingStore) ForEach(do interface{}) error {
+	return r.s.ForEach(do)
+}
+
+// Len returns the number of objects in the ring, and the size of their encoded values.
+func (r *RingStore) Len() (entries int, size int64, err error) {
+	err = r.s.db.View(func(tx BackendTx) error {
+		n, b := r.s.ringSize(tx)
+		entries, size = int(n), b
+		return nil
+	})
+	return entries, size, err
+}
+
+// oldestRingEntry returns a copy of the first key of the ring.
+func (s *Store) oldestRingEntry(objects BackendBucket) []byte {
+	c := objects.Cursor()
+	for k, v := c.Seek(s.prefix); k != nil && bytes.HasPrefix(k, s.prefix); k, v = c.Next() {
+		if v != nil && len(k) == len(s.prefix)+8 {
+			return append([]byte(nil), k...)
+		}
+	}
+	return nil
+}
+
+// ringSize returns the number of entries of the store's ring, and their size.
+func (s *Store) ringSize(tx BackendTx) (entries, size int64) {
+	rings := s.bucket.meta().child(ringSizeBucket).get(tx)
+	if rings == nil {
+		return 0, 0
+	}
+	return decodeRingSize(rings.Get(ringKey(s.prefix)))
+}
+
+// addRingEntries adds entries and size to the size of the store's ring.
+func (s *Store) addRingEntries(tx BackendTx, entries, size int64) error {
+	rings, err := s.bucket.meta().child(ringSizeBucket).createOrGetUntracked(tx)
+	if err != nil {
+		return err
+	}
+	return putRingSize(rings, s.prefix, entries, size)
+}
+
+// forgetRingEntry removes the object at key from the size of its ring, if it's in one.
+func (s *Store) forgetRingEntry(tx BackendTx, objects BackendBucket, key []byte) error {
+	rings := s.bucket.meta().child(ringSizeBucket).get(tx)
+	if rings == nil || len(key) < 8 {
+		return nil
+	}
+	prefix := key[:len(key)-8]
+	if rings.Get(ringKey(prefix)) == nil {
+		return nil
+	}
+	data := objects.Get(key)
+	if data == nil {
+		return nil
+	}
+	return putRingSize(rings, prefix, -1, -int64(len(data)))
+}
+
+func putRingSize(rings BackendBucket, prefix []byte, entries, size int64) error {
+	key := ringKey(prefix)
+	oldEntries, oldSize := decodeRingSize(rings.Get(key))
+	value := make([]byte, 16)
+	binary.BigEndian.PutUint64(value, uint64(oldEntries+entries))
+	binary.BigEndian.PutUint64(value[8:], uint64(oldSize+size))
+	return rings.Put(key, value)
+}
+
+// ringKey returns the key of the size of the ring of keys with prefix. It starts with a zero
+// byte since the prefix may be empty, which bolt doesn't allow as a key.
+func ringKey(prefix []byte) []byte {
+	return append([]byte{0}, prefix...)
+}
+
+func decodeRingSize(data []byte) (entries, size int64) {
+	if len(data) != 16 {
+		return 0, 0
+	}
+	return int64(binary.BigEndian.Uint64(data)), int64(binary.BigEndian.Uint64(data[8:]))
+}
@@ -0,0 +1,54 @@
+package stow
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRingStore(t *testing.T) {
+	s := NewJSONStore(db, []byte("ring"))
+	defer s.DeleteAll()
+	r := s.NewRingStore(3, 0)
+
+	for i := 1; i <= 5; i++ {
+		if _, err := r.Add(moveJob{ID: i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var ids []int
+	r.ForEach(func(job moveJob) { ids = append(ids, job.ID) })
+	if fmt.Sprint(ids) != "[3 4 5]" {
+		t.Errorf("expected the last 3 entries, got %v", ids)
+	}
+
+	// Entries removed through the store are accounted for.
+	s.Delete(AutoKey(4))
+	if n, _, err := r.Len(); err != nil || n != 2 {
+		t.Errorf("expected 2 entries, got %d %v", n, err)
+	}
+	r.Add(moveJob{ID: 6})
+	r.Add(moveJob{ID: 7})
+	ids = nil
+	r.ForEach(func(job moveJob) { ids = append(ids, job.ID) })
+	if fmt.Sprint(ids) != "[5 6 7]" {
+		t.Errorf("unexpected entries %v", ids)
+	}
+}
+
+func TestRingStoreBytes(t *testing.T) {
+	s := NewJSONStore(db, []byte("ring_bytes"))
+	defer s.DeleteAll()
+	data, _ := s.marshalValue(moveJob{ID: 1})
+	r := s.Namespace([]byte("log/")).NewRingStore(0, int64(2*len(data)))
+
+	for i := 1; i <= 4; i++ {
+		r.Add(moveJob{ID: i})
+	}
+	n, size, err := r.Len()
+	if err != nil || n != 2 || size != int64(2*len(data)) {
+		t.Errorf("expected 2 entries of %d bytes, got %d %d %v", 2*len(data), n, size, err)
+	}
+	if keys, _ := s.Keys(); len(keys) != 2 {
+		t.Errorf("expected 2 objects left, got %d", len(keys))
+	}
+}
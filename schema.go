@@ -0,0 +1,185 @@
+package stow
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+var (
+	// ErrFutureSchema indicates a value written with a newer schema version than the SchemaCodec has migrations for.
+	ErrFutureSchema = errors.New("value has a newer schema version")
+
+	// ErrMigrationType indicates a migrated value which can't be assigned to the value being decoded.
+	ErrMigrationType = errors.New("migrated value has the wrong type")
+)
+
+// schemaMagic starts the schema header of values written by a SchemaCodec. No value encoded
+// by the JSON, XML or Gob codecs starts with a zero byte.
+const schemaMagic = 0
+
+var _ Codec = SchemaCodec{}
+
+// Migration converts values from one schema version to the next.
+type Migration struct {
+	// Model is a value of the type values had before the migration, old values are decoded
+	// into a new value of its type.
+	Model interface{}
+
+	// Migrate converts old, a value of Model's type (or the value returned by the previous
+	// migration), into a value of the next version.
+	Migrate func(old interface{}) (interface{}, error)
+}
+
+// SchemaCodec writes the values encoded by Codec after a header which records their schema
+// version, and migrates values written with older versions when they're decoded, so struct
+// definitions can evolve without misdecoding old data. Migrations is the ordered list of
+// migrations from each version to the next: Migrations[0] migrates version 1 to 2, and so on.
+// The current version, which new values are written with, is len(Migrations)+1.
+//
+// Values written without a header, by Codec alone, are read as version 1, so SchemaCodec
+// can be adopted by a store which already has objects. Old values are migrated each time
+// they're read, unless the store has WithSchemaWriteBack, see Recode to migrate them all.
+type SchemaCodec struct {
+	Codec      Codec
+	Migrations []Migration
+}
+
+// Version returns the current schema version.
+func (c SchemaCodec) Version() int {
+	return len(c.Migrations) + 1
+}
+
+// NewEncoder returns an encoder which writes a value with the current schema version to w.
+func (c SchemaCodec) NewEncoder(w io.Writer) Encoder {
+	return schemaEncoder{c: c, w: w}
+}
+
+// NewDecoder returns a decoder which reads a value from r, migrating it to the current
+// schema version if needed.
+func (c SchemaCodec) NewDecoder(r io.Reader) Decoder {
+	return schemaDecoder{c: c, r: bufio.NewReader(r)}
+}
+
+type schemaEncoder struct {
+	c SchemaCodec
+	w io.Writer
+}
+
+func (e schemaEncoder) Encode(v interface{}) error {
+	header := make([]byte, 1+binary.MaxVarintLen64)
+	header[0] = schemaMagic
+	n := binary.PutUvarint(header[1:], uint64(e.c.Version()))
+	if _, err := e.w.Write(header[:1+n]); err != nil {
+		return err
+	}
+	return e.c.Codec.NewEncoder(e.w).Encode(v)
+}
+
+type schemaDecoder struct {
+	c SchemaCodec
+	r *bufio.Reader
+}
+
+func (d schemaDecoder) Decode(v interface{}) error {
+	version, err := readSchemaVersion(d.r)
+	if err != nil {
+		return err
+	}
+
+	current := d.c.Version()
+	switch {
+	case version == current:
+		return d.c.Codec.NewDecoder(d.r).Decode(v)
+	case version > current:
+		return ErrFutureSchema
+	case version < 1:
+		return fmt.Errorf("invalid schema version %d", version)
+	}
+
+	m := d.c.Migrations[version-1]
+	old := reflect.New(reflect.TypeOf(m.Model))
+	if err := d.c.Codec.NewDecoder(d.r).Decode(old.Interface()); err != nil {
+		return err
+	}
+	val := old.Elem().Interface()
+	for _, m := range d.c.Migrations[version-1:] {
+		if val, err = m.Migrate(val); err != nil {
+			return err
+		}
+	}
+
+	dst := reflect.ValueOf(v)
+	if dst.Kind() != reflect.Ptr || dst.IsNil() {
+		return fmt.Errorf("%w: can't decode into %T", ErrMigrationType, v)
+	}
+	migrated := reflect.ValueOf(val)
+	if migrated.IsValid() && !migrated.Type().AssignableTo(dst.Elem().Type()) && migrated.Kind() == reflect.Ptr {
+		migrated = migrated.Elem()
+	}
+	if !migrated.IsValid() || !migrated.Type().AssignableTo(dst.Elem().Type()) {
+		return fmt.Errorf("%w: %T is not assignable to %T", ErrMigrationType, val, v)
+	}
+	dst.Elem().Set(migrated)
+	return nil
+}
+
+// readSchemaVersion reads the schema header from r, a value without one is version 1.
+func readSchemaVersion(r *bufio.Reader) (int, error) {
+	if b, err := r.Peek(1); err != nil || b[0] != schemaMagic {
+		return 1, nil
+	}
+	r.ReadByte()
+	version, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, err
+	}
+	return int(version), nil
+}
+
+// WithSchemaWriteBack makes Get write back the objects it migrated with the store's
+// SchemaCodec, encoded with the current schema version, so each old object is only migrated
+// once. The object is only written back if it didn't change since it was read, and keeps its
+// expiration time. Note that this turns Get into a write transaction for old objects.
+func WithSchemaWriteBack() Option {
+	return func(s *Store) {
+		s.opts.schemaWriteBack = true
+	}
+}
+
+// writeBackSchema writes val back at key if data, its encoded value, has an older schema
+// version than the store's SchemaCodec. It returns the data now held by the store.
+func (s *Store) writeBackSchema(key, data []byte, val interface{}) ([]byte, error) {
+	c, ok := s.codec.(SchemaCodec)
+	if !ok || !s.opts.schemaWriteBack || s.opts.readOnly {
+		return data, nil
+	}
+	if version, err := readSchemaVersion(bufio.NewReader(bytes.NewReader(data))); err != nil || version >= c.Version() {
+		return data, err
+	}
+
+	updated, err := s.marshalValue(val)
+	if err != nil {
+		return nil, err
+	}
+	written := false
+	err = s.update(func(tx BackendTx) error {
+		objects := s.bucket.get(tx)
+		if objects == nil || !bytes.Equal(objects.Get(key), data) {
+			return nil
+		}
+		written = true
+		if err := objects.Put(key, updated); err != nil {
+			return err
+		}
+		return s.updateIndexes(tx, key, val)
+	})
+	if err != nil || !written {
+		return data, err
+	}
+	return updated, nil
+}
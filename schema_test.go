@@ -0,0 +1,86 @@
+package stow
+
+import (
+	"errors"
+	"testing"
+)
+
+type personV1 struct{ Name string }
+
+type personV2 struct{ First, Last string }
+
+type personV3 struct {
+	First, Last string
+	Age         int
+}
+
+func TestSchemaCodec(t *testing.T) {
+	legacy := NewJSONStore(db, []byte("schema"))
+	defer legacy.DeleteAll()
+	legacy.Put("old", personV1{Name: "Ann Lee"})
+
+	codec := SchemaCodec{Codec: JSONCodec{}, Migrations: []Migration{
+		{Model: personV1{}, Migrate: func(old interface{}) (interface{}, error) {
+			p := old.(personV1)
+			return personV2{First: p.Name[:3], Last: p.Name[4:]}, nil
+		}},
+		{Model: personV2{}, Migrate: func(old interface{}) (interface{}, error) {
+			p := old.(personV2)
+			return &personV3{First: p.First, Last: p.Last, Age: -1}, nil
+		}},
+	}}
+	if codec.Version() != 3 {
+		t.Errorf("expected version 3 got %d", codec.Version())
+	}
+
+	v2 := NewCustomStore(db, []byte("schema"), SchemaCodec{Codec: JSONCodec{}, Migrations: codec.Migrations[:1]})
+	v2.Put("two", personV2{First: "Bob", Last: "Ray"})
+
+	s := NewCustomStore(db, []byte("schema"), codec)
+	s.Put("three", personV3{First: "Cid", Age: 3})
+
+	for key, expected := range map[string]personV3{
+		"old":   {First: "Ann", Last: "Lee", Age: -1},
+		"two":   {First: "Bob", Last: "Ray", Age: -1},
+		"three": {First: "Cid", Age: 3},
+	} {
+		var p personV3
+		if err := s.Get(key, &p); err != nil || p != expected {
+			t.Errorf("unexpected value for %s %v %v", key, p, err)
+		}
+	}
+
+	var p personV2
+	if err := v2.Get("three", &p); !errors.Is(err, ErrFutureSchema) {
+		t.Errorf("expected ErrFutureSchema got %v", err)
+	}
+	var wrong personV1
+	if err := s.Get("old", &wrong); !errors.Is(err, ErrMigrationType) {
+		t.Errorf("expected ErrMigrationType got %v", err)
+	}
+
+	// Without write back old values are left as they are, with it they're rewritten.
+	version := func(key string) (v int) {
+		NewBoltBackend(db).View(func(tx BackendTx) error {
+			data := s.bucket.get(tx).Get([]byte(key))
+			if data[0] != schemaMagic {
+				v = 1
+			} else {
+				v = int(data[1])
+			}
+			return nil
+		})
+		return v
+	}
+	if version("old") != 1 {
+		t.Errorf("expected old value to be kept")
+	}
+	written := NewCustomStore(db, []byte("schema"), codec, WithSchemaWriteBack())
+	var migrated personV3
+	if err := written.Get("old", &migrated); err != nil || migrated.Age != -1 {
+		t.Errorf("unexpected value %v %v", migrated, err)
+	}
+	if version("old") != 3 {
+		t.Errorf("expected old value to be written back got version %d", version("old"))
+	}
+}
@@ -0,0 +1,14 @@
+module github.com/djherbis/stow/v4/sessionstore
+
+go 1.26.0
+
+replace github.com/djherbis/stow/v4 => ../
+
+require (
+	github.com/djherbis/stow/v4 v4.0.0
+	github.com/gorilla/securecookie v1.1.2
+	github.com/gorilla/sessions v1.4.0
+	go.etcd.io/bbolt v1.3.5
+)
+
+require golang.org/x/sys v0.45.0 // indirect
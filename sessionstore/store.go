@@ -0,0 +1,139 @@
+// Package sessionstore provides a gorilla/sessions Store backed by a stow.Store, so that web
+// sessions are persisted in bolt and expire with their MaxAge.
+package sessionstore
+
+import (
+	"encoding/base32"
+	"net/http"
+	"time"
+
+	"github.com/djherbis/stow/v4"
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+)
+
+// Store implements sessions.Store using a stow.Store. The cookie only holds the session's ID,
+// the session's values are kept in the stow.Store at that ID, encoded by Codecs, for the
+// session's MaxAge. Run the stow.Store's sweeper (see stow.Store.StartSweeper) to remove the
+// sessions which expired.
+type Store struct {
+	Codecs  []securecookie.Codec
+	Options *sessions.Options // default configuration
+	store   *stow.Store
+}
+
+var _ sessions.Store = (*Store)(nil)
+
+// New returns a Store which keeps sessions in store. See sessions.NewCookieStore for
+// keyPairs.
+func New(store *stow.Store, keyPairs ...[]byte) *Store {
+	s := &Store{
+		Codecs: securecookie.CodecsFromPairs(keyPairs...),
+		Options: &sessions.Options{
+			Path:   "/",
+			MaxAge: 86400 * 30,
+		},
+		store: store,
+	}
+	s.MaxAge(s.Options.MaxAge)
+	return s
+}
+
+// Get returns a session for the given name after adding it to the registry.
+//
+// See sessions.CookieStore.Get.
+func (s *Store) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+// New returns a session for the given name without adding it to the registry. A session
+// whose ID isn't in the store anymore, because it expired, is replaced by a new one.
+//
+// See sessions.CookieStore.New.
+func (s *Store) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	opts := *s.Options
+	session.Options = &opts
+	session.IsNew = true
+
+	c, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+	if err := securecookie.DecodeMulti(name, c.Value, &session.ID, s.Codecs...); err != nil {
+		return session, err
+	}
+	switch err := s.load(session); err {
+	case nil:
+		session.IsNew = false
+		return session, nil
+	case stow.ErrNotFound:
+		session.ID = ""
+		return session, nil
+	default:
+		return session, err
+	}
+}
+
+var base32RawStdEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Save stores session and adds its cookie to the response.
+//
+// If the Options.MaxAge of the session is <= 0 the session is deleted from the store, and
+// its cookie from the browser.
+func (s *Store) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.Options.MaxAge <= 0 {
+		if session.ID != "" {
+			if err := s.store.Delete(session.ID); err != nil {
+				return err
+			}
+		}
+		http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	if session.ID == "" {
+		session.ID = base32RawStdEncoding.EncodeToString(securecookie.GenerateRandomKey(32))
+	}
+	if err := s.save(session); err != nil {
+		return err
+	}
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.Codecs...)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, sessions.NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
+
+// MaxAge sets the maximum age for the store and the underlying cookie implementation.
+// Individual sessions can be deleted by setting Options.MaxAge = -1 for that session.
+func (s *Store) MaxAge(age int) {
+	s.Options.MaxAge = age
+
+	// Set the maxAge for each securecookie instance.
+	for _, codec := range s.Codecs {
+		if sc, ok := codec.(*securecookie.SecureCookie); ok {
+			sc.MaxAge(age)
+		}
+	}
+}
+
+// save stores the encoded session.Values at session.ID, for the session's MaxAge.
+func (s *Store) save(session *sessions.Session) error {
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.Values, s.Codecs...)
+	if err != nil {
+		return err
+	}
+	ttl := time.Duration(session.Options.MaxAge) * time.Second
+	return s.store.PutTTL(session.ID, []byte(encoded), ttl)
+}
+
+// load decodes the values stored at session.ID into session.Values.
+func (s *Store) load(session *sessions.Session) error {
+	var encoded []byte
+	if err := s.store.Get(session.ID, &encoded); err != nil {
+		return err
+	}
+	return securecookie.DecodeMulti(session.Name(), string(encoded), &session.Values, s.Codecs...)
+}
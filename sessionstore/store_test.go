@@ -0,0 +1,70 @@
+package sessionstore
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/djherbis/stow/v4"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestStore(t *testing.T) {
+	db, err := bolt.Open(filepath.Join(t.TempDir(), "sessions.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	sessions := stow.NewStore(db, []byte("sessions"))
+	store := New(sessions, []byte("0123456789abcdef0123456789abcdef"))
+
+	r := httptest.NewRequest("GET", "/", nil)
+	session, err := store.New(r, "app")
+	if err != nil || !session.IsNew {
+		t.Fatalf("expected a new session, got %v %v", session, err)
+	}
+	session.Values["user"] = "derek"
+	w := httptest.NewRecorder()
+	if err := store.Save(r, w, session); err != nil {
+		t.Fatal(err)
+	}
+	cookie := w.Result().Cookies()[0]
+	if has, _ := sessions.Has(session.ID); !has {
+		t.Errorf("expected the session to be stored at its ID")
+	}
+
+	r = httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(cookie)
+	loaded, err := store.New(r, "app")
+	if err != nil || loaded.IsNew || loaded.Values["user"] != "derek" {
+		t.Fatalf("unexpected loaded session %v %v", loaded, err)
+	}
+
+	loaded.Options.MaxAge = -1
+	if err := store.Save(r, httptest.NewRecorder(), loaded); err != nil {
+		t.Fatal(err)
+	}
+	if has, _ := sessions.Has(session.ID); has {
+		t.Errorf("expected the session to be deleted")
+	}
+	if again, err := store.New(r, "app"); err != nil || !again.IsNew || again.ID != "" {
+		t.Errorf("expected a deleted session to be replaced, got %v %v", again, err)
+	}
+}
+
+func TestStoreBadCookie(t *testing.T) {
+	db, err := bolt.Open(filepath.Join(t.TempDir(), "sessions.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	store := New(stow.NewStore(db, []byte("sessions")), []byte("0123456789abcdef0123456789abcdef"))
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(&http.Cookie{Name: "app", Value: "forged"})
+	if session, err := store.New(r, "app"); err == nil || !session.IsNew {
+		t.Errorf("expected an error and a new session for a forged cookie, got %v %v", session, err)
+	}
+}
@@ -0,0 +1,115 @@
+package stow
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+)
+
+// WithSingleflight makes concurrent Gets of the same key share one read and decode of the
+// object, so a hot key isn't decoded by every goroutine which asks for it at once. Each Get
+// is passed a copy of the shared value, which is shallow: its maps, slices and pointers are
+// shared by the Gets which were coalesced, so they must not be modified. A coalesced Get sets
+// b to the decoded value, rather than decoding the object into b's current value.
+func WithSingleflight() Option {
+	return func(s *Store) {
+		s.opts.singleflight = true
+	}
+}
+
+// errFlightPanicked is returned to the Gets which joined a read which panicked.
+var errFlightPanicked = errors.New("stow: coalesced read panicked")
+
+// forget drops what the store keeps in memory about the object at key, once it was written:
+// its cached immutable value, and any read of it in progress, so later Gets don't join a read
+// which may have seen the value before the write.
+func (s *Store) forget(key []byte) {
+	s.immutable.forget(key)
+	s.flights.forget(key)
+}
+
+// forgetAll works like forget, for every key of the store.
+func (s *Store) forgetAll() {
+	s.immutable.reset()
+	s.flights.reset()
+}
+
+// flightGroup coalesces the concurrent reads of a key into the same type.
+type flightGroup struct {
+	mu      sync.Mutex
+	flights map[flightKey]*flight
+}
+
+type flightKey struct {
+	key string
+	typ reflect.Type
+}
+
+type flight struct {
+	done chan struct{}
+	val  reflect.Value
+	size int
+	err  error
+}
+
+// get decodes the object at key into b with read, or waits for the read of the same key into
+// the same type in progress, and copies its result into b.
+func (g *flightGroup) get(key []byte, b interface{}, read func(key []byte, b interface{}) (int, error)) (int, error) {
+	dest := reflect.ValueOf(b)
+	if dest.Kind() != reflect.Ptr || dest.IsNil() {
+		return read(key, b)
+	}
+	k := flightKey{key: string(key), typ: dest.Type()}
+
+	g.mu.Lock()
+	if f, ok := g.flights[k]; ok {
+		g.mu.Unlock()
+		<-f.done
+		if f.err == nil {
+			dest.Elem().Set(f.val.Elem())
+		}
+		return f.size, f.err
+	}
+	f := &flight{done: make(chan struct{}), val: reflect.New(dest.Type().Elem()), err: errFlightPanicked}
+	if g.flights == nil {
+		g.flights = make(map[flightKey]*flight)
+	}
+	g.flights[k] = f
+	g.mu.Unlock()
+
+	func() {
+		defer func() {
+			g.mu.Lock()
+			// A write may have replaced f with a newer read already.
+			if g.flights[k] == f {
+				delete(g.flights, k)
+			}
+			g.mu.Unlock()
+			close(f.done)
+		}()
+		f.size, f.err = read(key, f.val.Interface())
+	}()
+
+	if f.err == nil {
+		dest.Elem().Set(f.val.Elem())
+	}
+	return f.size, f.err
+}
+
+// forget makes later reads of key start a new read, rather than join the reads in progress.
+func (g *flightGroup) forget(key []byte) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for k := range g.flights {
+		if k.key == string(key) {
+			delete(g.flights, k)
+		}
+	}
+}
+
+// reset works like forget, for every key.
+func (g *flightGroup) reset() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.flights = nil
+}
@@ -0,0 +1,123 @@
+package stow
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWithSingleflight(t *testing.T) {
+	codec := slowCodec{active: new(int32), max: new(int32)}
+	s := NewCustomStore(db, []byte("singleflight"), codec, WithSingleflight())
+	defer s.DeleteAll()
+	s.Put("hello", MyType{FirstName: "Derek"})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var v MyType
+			if err := s.Get("hello", &v); err != nil || v.FirstName != "Derek" {
+				t.Errorf("unexpected Get %v %v", v, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if max := atomic.LoadInt32(codec.max); max != 1 {
+		t.Errorf("expected Gets of a key to share decodes, got %d at once", max)
+	}
+	var missing MyType
+	if err := s.Get("missing", &missing); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+// hookCodec is a JSONCodec which calls before ahead of each decode.
+type hookCodec struct {
+	JSONCodec
+	before func()
+}
+
+type hookDecoder struct {
+	Decoder
+	before func()
+}
+
+func (c hookCodec) NewDecoder(r io.Reader) Decoder {
+	return hookDecoder{Decoder: c.JSONCodec.NewDecoder(r), before: c.before}
+}
+
+func (d hookDecoder) Decode(v interface{}) error {
+	d.before()
+	return d.Decoder.Decode(v)
+}
+
+func TestSingleflightWrite(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	var decodes int32
+	codec := hookCodec{before: func() {
+		if atomic.AddInt32(&decodes, 1) == 1 {
+			close(started)
+			<-release
+		}
+	}}
+	s := NewCustomStore(db, []byte("singleflight-write"), codec, WithSingleflight())
+	defer s.DeleteAll()
+	s.Put("k", "old")
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var v string
+		s.Get("k", &v)
+	}()
+	<-started
+	s.Put("k", "new")
+
+	var v string
+	if err := s.Get("k", &v); err != nil || v != "new" {
+		t.Errorf("expected a Get after Put to see the new value, got %q %v", v, err)
+	}
+	close(release)
+	<-done
+}
+
+func TestSingleflightPanic(t *testing.T) {
+	var decodes int32
+	codec := hookCodec{before: func() {
+		if atomic.AddInt32(&decodes, 1) == 1 {
+			panic("boom")
+		}
+	}}
+	s := NewCustomStore(db, []byte("singleflight-panic"), codec, WithSingleflight())
+	defer s.DeleteAll()
+	s.Put("k", "v")
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("expected the decode to panic")
+			}
+		}()
+		var v string
+		s.Get("k", &v)
+	}()
+
+	got := make(chan error, 1)
+	go func() {
+		var v string
+		got <- s.Get("k", &v)
+	}()
+	select {
+	case err := <-got:
+		if err != nil {
+			t.Errorf("unexpected Get error after a panic: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Get blocked after a read panicked")
+	}
+}
@@ -0,0 +1,291 @@
+package stow
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"io"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// ErrBadSnapshot indicates a snapshot stream which is malformed, truncated or corrupt.
+var ErrBadSnapshot = errors.New("invalid snapshot")
+
+var snapshotMagic = []byte("stowsnap\x01")
+
+var restoreBucket = bucketSpec{metaBucketName, []byte("\x00restore")}
+
+const (
+	snapshotEnd = iota
+	snapshotValue
+	snapshotBucket
+)
+
+// SnapshotTo writes a consistent snapshot of the store to w, including nested stores and
+// the metadata stow keeps for them (like TTLs). The format is checksummed and suitable for
+// backing the Snapshot/Persist half of a replicated state machine (such as a hashicorp/raft FSM).
+func (s *Store) SnapshotTo(w io.Writer) error {
+	sw := &snapshotWriter{w: bufio.NewWriter(w), crc: crc32.NewIEEE()}
+	sw.write(snapshotMagic)
+
+	err := s.db.View(func(tx BackendTx) error {
+		sw.tree(s.bucket.get(tx))
+		sw.tree(s.bucket.meta().get(tx))
+		return sw.err
+	})
+	if err != nil {
+		return err
+	}
+
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], sw.crc.Sum32())
+	if _, err := sw.w.Write(sum[:]); err != nil {
+		return err
+	}
+	return sw.w.Flush()
+}
+
+// RestoreSnapshot replaces the contents of the store with a snapshot written by SnapshotTo.
+// The snapshot is first staged in a temporary bucket and only swapped in once it has been
+// read completely and its checksum verified, all in one transaction. A failed restore leaves
+// the store untouched.
+func (s *Store) RestoreSnapshot(r io.Reader) error {
+	sr := &snapshotReader{r: bufio.NewReader(r), crc: crc32.NewIEEE()}
+	defer s.forgetAll()
+
+	return s.update(func(tx BackendTx) error {
+		if err := restoreBucket.deleteIfExists(tx); err != nil {
+			return err
+		}
+		staging, err := restoreBucket.createOrGetUntracked(tx)
+		if err != nil {
+			return err
+		}
+
+		if magic := sr.read(len(snapshotMagic)); sr.err == nil && !bytes.Equal(magic, snapshotMagic) {
+			return ErrBadSnapshot
+		}
+		for _, name := range []string{"data", "meta"} {
+			b, err := staging.CreateBucket([]byte(name))
+			if err != nil {
+				return err
+			}
+			sr.tree(b)
+		}
+		if err := sr.verify(); err != nil {
+			return err
+		}
+
+		if err := s.bucket.deleteIfExists(tx); err != nil {
+			return err
+		}
+		if err := s.bucket.meta().deleteIfExists(tx); err != nil {
+			return err
+		}
+		targets := []struct {
+			name string
+			spec bucketSpec
+		}{{"data", s.bucket}, {"meta", s.bucket.meta()}}
+		for _, target := range targets {
+			dst, err := target.spec.createOrGetUntracked(tx)
+			if err != nil {
+				return err
+			}
+			if err := copyBucket(dst, staging.Bucket([]byte(target.name))); err != nil {
+				return err
+			}
+		}
+		return restoreBucket.delete(tx)
+	})
+}
+
+// copyBucket copies every key, nested bucket and sequence in src into dst.
+func copyBucket(dst, src BackendBucket) error {
+	if err := dst.SetSequence(src.Sequence()); err != nil {
+		return err
+	}
+	return src.ForEach(func(k, v []byte) error {
+		if v != nil {
+			return dst.Put(k, v)
+		}
+		child, err := dst.CreateBucketIfNotExists(k)
+		if err != nil {
+			return err
+		}
+		return copyBucket(child, src.Bucket(k))
+	})
+}
+
+type snapshotWriter struct {
+	w   *bufio.Writer
+	crc hash.Hash32
+	err error
+}
+
+func (w *snapshotWriter) write(data []byte) {
+	if w.err != nil {
+		return
+	}
+	w.crc.Write(data)
+	_, w.err = w.w.Write(data)
+}
+
+func (w *snapshotWriter) uvarint(n uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	w.write(buf[:binary.PutUvarint(buf[:], n)])
+}
+
+func (w *snapshotWriter) bytes(data []byte) {
+	w.uvarint(uint64(len(data)))
+	w.write(data)
+}
+
+// tree writes b's sequence, followed by its values and nested buckets, a nil b is written as empty.
+func (w *snapshotWriter) tree(b BackendBucket) {
+	if b == nil {
+		w.uvarint(0)
+		w.uvarint(snapshotEnd)
+		return
+	}
+
+	w.uvarint(b.Sequence())
+	b.ForEach(func(k, v []byte) error {
+		if v != nil {
+			w.uvarint(snapshotValue)
+			w.bytes(k)
+			w.bytes(v)
+		} else {
+			w.uvarint(snapshotBucket)
+			w.bytes(k)
+			w.tree(b.Bucket(k))
+		}
+		return w.err
+	})
+	w.uvarint(snapshotEnd)
+}
+
+type snapshotReader struct {
+	r   *bufio.Reader
+	crc hash.Hash32
+	err error
+}
+
+func (r *snapshotReader) fail(err error) {
+	if r.err != nil {
+		return
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = ErrBadSnapshot
+	}
+	r.err = err
+}
+
+func (r *snapshotReader) read(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	// n comes from the stream: the buffer grows as the data is read rather than being
+	// allocated upfront, so a corrupt length can't use more memory than the stream holds.
+	buf := bytes.NewBuffer([]byte{})
+	if _, err := buf.ReadFrom(io.LimitReader(r.r, int64(n))); err != nil {
+		r.fail(err)
+		return nil
+	}
+	if buf.Len() != n {
+		r.fail(ErrBadSnapshot)
+		return nil
+	}
+	data := buf.Bytes()
+	r.crc.Write(data)
+	return data
+}
+
+func (r *snapshotReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	n, err := binary.ReadUvarint(byteReaderFunc(func() (byte, error) {
+		b, err := r.r.ReadByte()
+		if err == nil {
+			r.crc.Write([]byte{b})
+		}
+		return b, err
+	}))
+	if err != nil {
+		r.fail(err)
+	}
+	return n
+}
+
+func (r *snapshotReader) bytes() []byte {
+	n := r.uvarint()
+	if n > bolt.MaxValueSize {
+		r.fail(ErrBadSnapshot)
+	}
+	return r.read(int(n))
+}
+
+// tree reads a tree written by snapshotWriter.tree into b.
+func (r *snapshotReader) tree(b BackendBucket) {
+	if err := b.SetSequence(r.uvarint()); err != nil {
+		r.fail(err)
+	}
+	for r.err == nil {
+		switch r.uvarint() {
+		case snapshotEnd:
+			return
+		case snapshotValue:
+			k, v := r.bytes(), r.bytes()
+			if r.err == nil {
+				r.fail(snapshotErr(b.Put(k, v)))
+			}
+		case snapshotBucket:
+			k := r.bytes()
+			if r.err != nil {
+				return
+			}
+			child, err := b.CreateBucket(k)
+			if err != nil {
+				r.fail(snapshotErr(err))
+				return
+			}
+			r.tree(child)
+		default:
+			r.fail(ErrBadSnapshot)
+		}
+	}
+}
+
+// verify checks the trailing checksum, and returns any error encountered while reading.
+func (r *snapshotReader) verify() error {
+	if r.err != nil {
+		return r.err
+	}
+	sum := r.crc.Sum32()
+	var trailer [4]byte
+	if _, err := io.ReadFull(r.r, trailer[:]); err != nil {
+		return ErrBadSnapshot
+	}
+	if binary.BigEndian.Uint32(trailer[:]) != sum {
+		return ErrBadSnapshot
+	}
+	return nil
+}
+
+// snapshotErr maps the errors bolt returns for invalid keys and values to ErrBadSnapshot.
+func snapshotErr(err error) error {
+	switch err {
+	case bolt.ErrKeyRequired, bolt.ErrKeyTooLarge, bolt.ErrValueTooLarge,
+		bolt.ErrIncompatibleValue, bolt.ErrBucketExists, bolt.ErrBucketNameRequired:
+		return ErrBadSnapshot
+	}
+	return err
+}
+
+type byteReaderFunc func() (byte, error)
+
+func (f byteReaderFunc) ReadByte() (byte, error) { return f() }
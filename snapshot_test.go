@@ -0,0 +1,90 @@
+package stow
+
+import (
+	"bytes"
+	"encoding/binary"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestSnapshot(t *testing.T) {
+	s := NewJSONStore(db, []byte("snapshot"))
+	defer s.DeleteAll()
+
+	s.Put("a", "1")
+	s.PutTTL("b", "2", time.Hour)
+	s.NewNestedStore([]byte("nested")).Put("c", "3")
+
+	var snapshot bytes.Buffer
+	if err := s.SnapshotTo(&snapshot); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Delete("a")
+	s.Put("d", "4")
+
+	data := snapshot.Bytes()
+	for _, bad := range [][]byte{data[:len(data)-1], append(append([]byte(nil), data[:20]...), data[21:]...), []byte("garbage")} {
+		if err := s.RestoreSnapshot(bytes.NewReader(bad)); err != ErrBadSnapshot {
+			t.Errorf("expected ErrBadSnapshot got %v", err)
+		}
+	}
+	if has, _ := s.Has("d"); !has {
+		t.Errorf("failed restore modified the store")
+	}
+
+	if err := s.RestoreSnapshot(bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	var v string
+	for key, expected := range map[string]string{"a": "1", "b": "2"} {
+		if err := s.Get(key, &v); err != nil || v != expected {
+			t.Errorf("unexpected value for %s: %s %v", key, v, err)
+		}
+	}
+	if has, _ := s.Has("d"); has {
+		t.Errorf("restore should replace the store's contents")
+	}
+	if err := s.NewNestedStore([]byte("nested")).Get("c", &v); err != nil || v != "3" {
+		t.Errorf("nested store was not restored: %s %v", v, err)
+	}
+	if err := s.Touch("b", time.Hour); err != nil {
+		t.Errorf("ttl was not restored: %v", err)
+	}
+	if ttl := ttlOf(s, "b"); ttl != time.Hour {
+		t.Errorf("unexpected restored ttl %v", ttl)
+	}
+}
+
+func TestSnapshotCorruptLength(t *testing.T) {
+	s := NewJSONStore(db, []byte("snapshot"))
+	defer s.DeleteAll()
+
+	// A value claiming to be 1GiB long, followed by only a few bytes.
+	data := append([]byte(nil), snapshotMagic...)
+	for _, n := range []uint64{0, snapshotValue, 1 << 30} {
+		var buf [binary.MaxVarintLen64]byte
+		data = append(data, buf[:binary.PutUvarint(buf[:], n)]...)
+	}
+	data = append(data, "abc"...)
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	if err := s.RestoreSnapshot(bytes.NewReader(data)); err != ErrBadSnapshot {
+		t.Errorf("expected ErrBadSnapshot got %v", err)
+	}
+	runtime.ReadMemStats(&after)
+	if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 64<<20 {
+		t.Errorf("restoring a corrupt snapshot allocated %d bytes", alloc)
+	}
+}
+
+func ttlOf(s *Store, key string) (ttl time.Duration) {
+	s.db.View(func(tx BackendTx) error {
+		ttl = s.ttlOf(tx, []byte(key))
+		return nil
+	})
+	return ttl
+}
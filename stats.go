@@ -0,0 +1,157 @@
+package stow
+
+import (
+	"sync/atomic"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Stats are counters of the operations run on a Store. Pull counts as both a Get and a Delete.
+type Stats struct {
+	Puts         int64 // objects written
+	PutErrors    int64 // writes which failed
+	Gets         int64 // objects read
+	Misses       int64 // reads which found no object
+	GetErrors    int64 // reads which failed, other than misses
+	Deletes      int64 // deletes, including those of missing objects
+	DeleteErrors int64 // deletes which failed
+}
+
+// Sub returns the difference between s and prev, the counts of operations since prev.
+func (s Stats) Sub(prev Stats) Stats {
+	return Stats{
+		Puts:         s.Puts - prev.Puts,
+		PutErrors:    s.PutErrors - prev.PutErrors,
+		Gets:         s.Gets - prev.Gets,
+		Misses:       s.Misses - prev.Misses,
+		GetErrors:    s.GetErrors - prev.GetErrors,
+		Deletes:      s.Deletes - prev.Deletes,
+		DeleteErrors: s.DeleteErrors - prev.DeleteErrors,
+	}
+}
+
+// storeStats holds the counters of a Store. They're kept in their own allocation so the
+// int64s are 64-bit aligned for the atomic operations, even on 32-bit platforms.
+type storeStats struct {
+	puts, putErrors, gets, misses, getErrors, deletes, deleteErrors int64
+}
+
+// Stats returns the store's counters. The counters are per Store, so nested stores have
+// their own, and they are kept in memory only.
+func (s *Store) Stats() Stats {
+	c := s.stats
+	return Stats{
+		Puts:         atomic.LoadInt64(&c.puts),
+		PutErrors:    atomic.LoadInt64(&c.putErrors),
+		Gets:         atomic.LoadInt64(&c.gets),
+		Misses:       atomic.LoadInt64(&c.misses),
+		GetErrors:    atomic.LoadInt64(&c.getErrors),
+		Deletes:      atomic.LoadInt64(&c.deletes),
+		DeleteErrors: atomic.LoadInt64(&c.deleteErrors),
+	}
+}
+
+// StatsDelta returns the counts of operations since the counters were since, along with the
+// current counters to pass to the next call. This lets exporters that poll compute rates
+// without keeping counters of their own:
+//
+//	delta, last = store.StatsDelta(last)
+func (s *Store) StatsDelta(since Stats) (delta, now Stats) {
+	now = s.Stats()
+	return now.Sub(since), now
+}
+
+// ResetStats sets the store's counters to zero, and returns their values before the reset.
+// Each counter is reset atomically, operations running concurrently are counted either
+// before or after the reset.
+func (s *Store) ResetStats() Stats {
+	c := s.stats
+	return Stats{
+		Puts:         atomic.SwapInt64(&c.puts, 0),
+		PutErrors:    atomic.SwapInt64(&c.putErrors, 0),
+		Gets:         atomic.SwapInt64(&c.gets, 0),
+		Misses:       atomic.SwapInt64(&c.misses, 0),
+		GetErrors:    atomic.SwapInt64(&c.getErrors, 0),
+		Deletes:      atomic.SwapInt64(&c.deletes, 0),
+		DeleteErrors: atomic.SwapInt64(&c.deleteErrors, 0),
+	}
+}
+
+func (c *storeStats) put(err error) {
+	if err != nil {
+		atomic.AddInt64(&c.putErrors, 1)
+		return
+	}
+	atomic.AddInt64(&c.puts, 1)
+}
+
+func (c *storeStats) get(err error) {
+	switch err {
+	case nil:
+		atomic.AddInt64(&c.gets, 1)
+	case ErrNotFound:
+		atomic.AddInt64(&c.misses, 1)
+	default:
+		atomic.AddInt64(&c.getErrors, 1)
+	}
+}
+
+func (c *storeStats) delete(err error) {
+	if err != nil && err != ErrNotFound {
+		atomic.AddInt64(&c.deleteErrors, 1)
+		return
+	}
+	atomic.AddInt64(&c.deletes, 1)
+}
+
+// BucketStats describes the storage used by a Store, see Store.BucketStats.
+type BucketStats struct {
+	// Bolt holds bolt's statistics of the store's bucket, including nested stores and
+	// hashes: key count, depth, page counts and leaf/branch bytes. It's zero for a store
+	// which isn't on bolt.
+	Bolt bolt.BucketStats
+
+	Objects        int     // objects directly in the store, not counting nested stores
+	Nested         int     // nested stores and hashes directly under the store
+	KeyBytes       int64   // total size of the objects' keys
+	ValueBytes     int64   // total size of the objects' encoded values
+	AvgValueSize   float64 // mean size of an encoded value
+	LargestValue   int     // size of the largest encoded value
+	ExpiredObjects int     // objects counted above which have expired but weren't swept yet
+}
+
+// BucketStats returns statistics of the storage used by the store, for capacity monitoring.
+// It reads every key of the store, in one read transaction. The store's counters of
+// operations are returned by Stats.
+func (s *Store) BucketStats() (stats BucketStats, err error) {
+	err = s.db.View(func(tx BackendTx) error {
+		objects := s.bucket.get(tx)
+		if objects == nil {
+			return nil
+		}
+		if b, ok := objects.(boltBucket); ok {
+			stats.Bolt = b.b.Stats()
+		}
+		isExpired := s.expiryCheck(tx)
+		return objects.ForEach(func(k, v []byte) error {
+			if v == nil {
+				stats.Nested++
+				return nil
+			}
+			stats.Objects++
+			stats.KeyBytes += int64(len(k))
+			stats.ValueBytes += int64(len(v))
+			if len(v) > stats.LargestValue {
+				stats.LargestValue = len(v)
+			}
+			if isExpired(k) {
+				stats.ExpiredObjects++
+			}
+			return nil
+		})
+	})
+	if stats.Objects > 0 {
+		stats.AvgValueSize = float64(stats.ValueBytes) / float64(stats.Objects)
+	}
+	return stats, err
+}
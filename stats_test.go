@@ -0,0 +1,79 @@
+package stow
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	s := NewJSONStore(db, []byte("stats"))
+	defer s.DeleteAll()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			s.Put(i, i)
+		}(i)
+	}
+	wg.Wait()
+
+	var v int
+	s.Get(1, &v)
+	s.Get("missing", &v)
+	s.Pull(2, &v)
+	s.Delete(3)
+
+	expected := Stats{Puts: 10, Gets: 2, Misses: 1, Deletes: 2}
+	if stats := s.Stats(); stats != expected {
+		t.Errorf("expected %+v, got %+v", expected, stats)
+	}
+
+	delta, last := s.StatsDelta(Stats{})
+	if delta != expected || last != expected {
+		t.Errorf("unexpected delta %+v since zero", delta)
+	}
+	s.Get(4, &v)
+	if delta, _ := s.StatsDelta(last); delta != (Stats{Gets: 1}) {
+		t.Errorf("unexpected delta %+v", delta)
+	}
+
+	if before := s.ResetStats(); before.Gets != 3 {
+		t.Errorf("expected ResetStats to return the counters, got %+v", before)
+	}
+	if stats := s.Stats(); stats != (Stats{}) {
+		t.Errorf("expected zero counters after reset, got %+v", stats)
+	}
+	if stats := s.NewNestedStore([]byte("nested")).Stats(); stats != (Stats{}) {
+		t.Errorf("expected nested stores to have their own counters, got %+v", stats)
+	}
+}
+
+func TestBucketStats(t *testing.T) {
+	s := NewJSONStore(db, []byte("bucket_stats"))
+	defer s.DeleteAll()
+
+	if stats, err := s.BucketStats(); err != nil || stats.Objects != 0 {
+		t.Errorf("expected empty stats for a new store: %+v %v", stats, err)
+	}
+
+	s.Put("a", "1234")
+	s.Put("bb", "12345678")
+	s.NewNestedStore([]byte("nested")).Put("c", 1)
+
+	stats, err := s.BucketStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// JSON adds quotes and a newline to each value.
+	if stats.Objects != 2 || stats.Nested != 1 || stats.KeyBytes != 3 || stats.ValueBytes != 18 || stats.LargestValue != 11 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	if stats.AvgValueSize != 9 {
+		t.Errorf("unexpected average value size %v", stats.AvgValueSize)
+	}
+	if stats.Bolt.KeyN != 4 {
+		t.Errorf("expected bolt to count 4 keys, with the nested bucket, got %d", stats.Bolt.KeyN)
+	}
+}
@@ -3,8 +3,12 @@ package stow
 
 import (
 	"bytes"
+	"encoding"
 	"errors"
+	"fmt"
+	"reflect"
 	"sync"
+	"time"
 
 	bolt "go.etcd.io/bbolt"
 )
@@ -16,73 +20,128 @@ var pool = &sync.Pool{
 // ErrNotFound indicates object is not in database.
 var ErrNotFound = errors.New("not found")
 
+// ErrTooLarge indicates an object larger than the limit set with WithMaxDecodeSize.
+var ErrTooLarge = errors.New("object too large to decode")
+
+// ErrReadOnly is returned by the writes of a store made with WithReadOnly.
+var ErrReadOnly = errors.New("store is read-only")
+
+// ErrKeyTooLarge indicates a key longer than bolt.MaxKeySize. It wraps bolt.ErrKeyTooLarge.
+var ErrKeyTooLarge = fmt.Errorf("key over %d bytes: %w", bolt.MaxKeySize, bolt.ErrKeyTooLarge)
+
+// ErrValueTooLarge indicates an encoded value longer than bolt.MaxValueSize. It wraps
+// bolt.ErrValueTooLarge, and is returned in a *KeyError.
+var ErrValueTooLarge = fmt.Errorf("value over %d bytes: %w", bolt.MaxValueSize, bolt.ErrValueTooLarge)
+
 // Store manages objects persistence.
 type Store struct {
-	db     *bolt.DB
+	db     Backend
 	bucket bucketSpec
 	codec  Codec
+	opts   options
+	// prefix is the prefix of the keys of the store's namespace, see Namespace.
+	prefix []byte
+
+	immutable *immutableCache
+	stats     *storeStats
+	access    *accessCounts
+	flights   *flightGroup
+}
+
+// New creates a new Store, using the underlying bolt.DB "bucket" to persist objects,
+// configured by opts. Objects are encoded with GobCodec unless WithCodec says otherwise.
+// The other constructors are shorthands for New with a codec.
+func New(db *bolt.DB, bucket []byte, opts ...Option) *Store {
+	return NewCustomStore(db, bucket, GobCodec{}, opts...)
 }
 
 // NewStore creates a new Store, using the underlying
 // bolt.DB "bucket" to persist objects.
 // NewStore uses GobEncoding, your objects must be registered
 // via gob.Register() for this encoding to work.
-func NewStore(db *bolt.DB, bucket []byte) *Store {
-	return NewCustomStore(db, bucket, GobCodec{})
+func NewStore(db *bolt.DB, bucket []byte, opts ...Option) *Store {
+	return NewCustomStore(db, bucket, GobCodec{}, opts...)
 }
 
 // NewJSONStore creates a new Store, using the underlying
 // bolt.DB "bucket" to persist objects as json.
-func NewJSONStore(db *bolt.DB, bucket []byte) *Store {
-	return NewCustomStore(db, bucket, JSONCodec{})
+func NewJSONStore(db *bolt.DB, bucket []byte, opts ...Option) *Store {
+	return NewCustomStore(db, bucket, JSONCodec{}, opts...)
 }
 
 // NewXMLStore creates a new Store, using the underlying
 // bolt.DB "bucket" to persist objects as xml.
-func NewXMLStore(db *bolt.DB, bucket []byte) *Store {
-	return NewCustomStore(db, bucket, XMLCodec{})
+func NewXMLStore(db *bolt.DB, bucket []byte, opts ...Option) *Store {
+	return NewCustomStore(db, bucket, XMLCodec{}, opts...)
 }
 
 // NewCustomStore allows you to create a store with
 // a custom underlying Encoding
-func NewCustomStore(db *bolt.DB, bucket []byte, codec Codec) *Store {
-	return &Store{db: db, bucket: bucketSpec{bucket}, codec: codec}
+func NewCustomStore(db *bolt.DB, bucket []byte, codec Codec, opts ...Option) *Store {
+	return NewBackendStore(boltBackend{db}, bucket, codec, opts...)
 }
 
 // NewNestedStore returns a new Store which is nested inside the current store's
-// bucket. It inherits the original store's Codec, and will be deleted by the parent
+// bucket. It inherits the original store's Codec and Options, and will be deleted by the parent
 // store's DeleteAll method. Also note that buckets are in the parents key-space so
 // you cannot have a NestedStore whose "bucket" is the same as a parent's key.
-func (s *Store) NewNestedStore(bucket []byte) *Store {
-	return s.NewCustomNestedStore(bucket, s.codec)
+func (s *Store) NewNestedStore(bucket []byte, opts ...Option) *Store {
+	return s.NewCustomNestedStore(bucket, s.codec, opts...)
 }
 
 // NewCustomNestedStore works the same as NewNestedStore except you can override the
 // Codec used by the returned Store.
-func (s *Store) NewCustomNestedStore(bucket []byte, codec Codec) *Store {
-	return &Store{
+func (s *Store) NewCustomNestedStore(bucket []byte, codec Codec, opts ...Option) *Store {
+	nested := &Store{
 		db:     s.db,
-		bucket: append(s.bucket, bucket),
+		bucket: s.bucket.child(bucket),
 		codec:  codec,
+		opts:   s.opts,
+
+		immutable: &immutableCache{},
+		stats:     &storeStats{},
+		access:    &accessCounts{},
+		flights:   &flightGroup{},
 	}
+	for _, opt := range opts {
+		opt(nested)
+	}
+	return nested
 }
 
 func (s *Store) marshal(val interface{}) (data []byte, err error) {
-	buf := pool.Get().(*bytes.Buffer)
+	buf, err := s.encode(val)
+	data = append(data, buf.Bytes()...)
+	releaseBuffer(buf)
+	return data, err
+}
+
+// encode encodes val into a buffer from pool, which must be given back with releaseBuffer
+// once the encoding isn't used anymore. Writes which are done with the encoding once their
+// transaction commits use it as it is, rather than a copy.
+func (s *Store) encode(val interface{}) (buf *bytes.Buffer, err error) {
+	defer s.acquireCodec()()
+
+	buf = pool.Get().(*bytes.Buffer)
 	enc := s.codec.NewEncoder(buf)
 	err = enc.Encode(val)
-	data = append(data, buf.Bytes()...)
-	buf.Reset()
-	pool.Put(buf)
 
 	if pCodec, ok := s.codec.(*pooledCodec); ok && err == nil {
 		pCodec.PutEncoder(enc)
 	}
 
-	return data, err
+	return buf, err
+}
+
+// releaseBuffer gives buf back to pool.
+func releaseBuffer(buf *bytes.Buffer) {
+	buf.Reset()
+	pool.Put(buf)
 }
 
 func (s *Store) unmarshal(data []byte, val interface{}) (err error) {
+	defer s.acquireCodec()()
+
 	dec := s.codec.NewDecoder(bytes.NewReader(data))
 	err = dec.Decode(val)
 
@@ -92,19 +151,79 @@ func (s *Store) unmarshal(data []byte, val interface{}) (err error) {
 	return err
 }
 
+// checkDecodeSize returns ErrTooLarge if data is over the limit set with WithMaxDecodeSize.
+func (s *Store) checkDecodeSize(data []byte) error {
+	if s.opts.maxDecodeSize > 0 && len(data) > s.opts.maxDecodeSize {
+		return ErrTooLarge
+	}
+	return nil
+}
+
+// toBytes encodes a key: strings and []byte are used directly, other keys are encoded by the
+// KeyCodec set with WithKeyCodec, unless it doesn't support them, then by their MarshalBinary
+// or MarshalText method, and otherwise with the store's Codec. Many types implement String
+// for display only, so it's only used for keys the Codec can't encode, as those keys couldn't
+// be decoded back.
 func (s *Store) toBytes(key interface{}) (keyBytes []byte, err error) {
+	keyBytes, err = s.encodeKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return s.nsKey(keyBytes), nil
+}
+
+// encodeKey encodes key like toBytes, outside of the store's namespace.
+func (s *Store) encodeKey(key interface{}) (keyBytes []byte, err error) {
 	switch k := key.(type) {
 	case string:
 		return []byte(k), nil
 	case []byte:
 		return k, nil
-	default:
-		return s.marshal(key)
 	}
+
+	if s.opts.keyCodec != nil {
+		keyBytes, err = s.opts.keyCodec.EncodeKey(key)
+		if !errors.Is(err, ErrUnsupportedKey) {
+			return keyBytes, err
+		}
+	}
+
+	switch k := key.(type) {
+	case encoding.BinaryMarshaler:
+		return k.MarshalBinary()
+	case encoding.TextMarshaler:
+		return k.MarshalText()
+	}
+
+	keyBytes, err = s.marshal(key)
+	if k, ok := key.(fmt.Stringer); ok && err != nil {
+		return []byte(k.String()), nil
+	}
+	return keyBytes, err
+}
+
+// unmarshalKey decodes a key encoded by toBytes into key, which is a pointer.
+func (s *Store) unmarshalKey(data []byte, key interface{}) error {
+	if s.opts.keyCodec != nil {
+		err := s.opts.keyCodec.DecodeKey(data, key)
+		if !errors.Is(err, ErrUnsupportedKey) {
+			return err
+		}
+	}
+
+	switch k := key.(type) {
+	case encoding.BinaryUnmarshaler:
+		return k.UnmarshalBinary(data)
+	case encoding.TextUnmarshaler:
+		return k.UnmarshalText(data)
+	}
+	return s.unmarshal(data, key)
 }
 
 // Put will store b with key "key". If key is []byte or string it uses the key
-// directly. Otherwise, it marshals the given type into bytes using the stores Encoder.
+// directly. Otherwise, it uses the key's MarshalBinary or MarshalText method if it has one,
+// or else marshals the given type into bytes using the stores Encoder.
+// Keys and encoded values over bolt's limits fail with ErrKeyTooLarge and ErrValueTooLarge.
 func (s *Store) Put(key interface{}, b interface{}) error {
 	keyBytes, err := s.toBytes(key)
 	if err != nil {
@@ -116,22 +235,81 @@ func (s *Store) Put(key interface{}, b interface{}) error {
 // Put will store b with key "key". If key is []byte or string it uses the key
 // directly. Otherwise, it marshals the given type into bytes using the stores Encoder.
 func (s *Store) put(key []byte, b interface{}) (err error) {
+	return s.putTTL(key, b, s.opts.ttl)
+}
+
+func (s *Store) putTTL(key []byte, b interface{}, ttl time.Duration) (err error) {
+	if err := s.beforePut(key, b); err != nil {
+		return err
+	}
+	start := time.Now()
 	var data []byte
-	data, err = s.marshal(b)
+	defer func() {
+		s.afterPut(key, b, err)
+		s.observe(OpPut, start, len(data), err)
+	}()
+
+	buf, err := s.encodeValue(b)
+	defer releaseBuffer(buf)
 	if err != nil {
 		return err
 	}
+	data = buf.Bytes()
 
-	return s.db.Update(func(tx *bolt.Tx) error {
+	defer s.forget(key)
+	return s.write(func(tx BackendTx) error {
 		objects, err := s.bucket.createOrGet(tx)
 		if err != nil {
 			return err
 		}
-		return objects.Put(key, data)
+		return s.writeKey(tx, objects, key, data, b, ttl)
 	})
 }
 
+// write runs fn in a read-write transaction, which is shared with other writers if
+// WithBatchWrites is set. fn may then run more than once, see bolt's DB.Batch.
+func (s *Store) write(fn func(tx BackendTx) error) error {
+	if b, ok := s.db.(batcher); ok && s.opts.batchWrites && !s.opts.readOnly {
+		return b.Batch(fn)
+	}
+	return s.update(fn)
+}
+
+// update runs fn in a read-write transaction, or returns ErrReadOnly for a store made with
+// WithReadOnly.
+func (s *Store) update(fn func(tx BackendTx) error) error {
+	if s.opts.readOnly {
+		return ErrReadOnly
+	}
+	return s.db.Update(fn)
+}
+
+// writeKey stores data, the encoding of val, at key in objects, along with any metadata kept for it.
+func (s *Store) writeKey(tx BackendTx, objects BackendBucket, key, data []byte, val interface{}, ttl time.Duration) error {
+	entries, err := s.indexEntries(val)
+	if err != nil {
+		return err
+	}
+	return s.writeEntries(tx, objects, key, data, entries, ttl)
+}
+
+// writeEntries works like writeKey, given the index entries of the value rather than the value.
+func (s *Store) writeEntries(tx BackendTx, objects BackendBucket, key, data []byte, entries []indexEntry, ttl time.Duration) error {
+	if err := objects.Put(key, data); err != nil {
+		return writeError(s.bucket, key, err)
+	}
+	if err := s.setIndexes(tx, key, entries); err != nil {
+		return err
+	}
+	if err := s.setWritten(tx, key); err != nil {
+		return err
+	}
+	return s.setExpiry(tx, key, ttl)
+}
+
 // Pull will retrieve b with key "key", and removes it from the store.
+// The object is only removed once it was decoded, if that fails Pull returns
+// a *DecodeError and leaves the object in the store.
 func (s *Store) Pull(key interface{}, b interface{}) error {
 	keyBytes, err := s.toBytes(key)
 	if err != nil {
@@ -141,14 +319,23 @@ func (s *Store) Pull(key interface{}, b interface{}) error {
 }
 
 // Pull will retrieve b with key "key", and removes it from the store.
-func (s *Store) pull(key []byte, b interface{}) error {
-	buf := pool.Get().(*bytes.Buffer)
+func (s *Store) pull(key []byte, b interface{}) (err error) {
+	if err := s.beforeDelete(key); err != nil {
+		return err
+	}
+	start := time.Now()
+	var size int
 	defer func() {
-		buf.Reset()
-		pool.Put(buf)
+		s.afterGet(key, b, err)
+		s.afterDelete(key, err)
+		s.observe(OpPull, start, size, err)
 	}()
 
-	err := s.db.Update(func(tx *bolt.Tx) error {
+	buf := pool.Get().(*bytes.Buffer)
+	defer releaseBuffer(buf)
+
+	var expired bool
+	err = s.update(func(tx BackendTx) error {
 		objects := s.bucket.get(tx)
 		if objects == nil {
 			return ErrNotFound
@@ -159,15 +346,132 @@ func (s *Store) pull(key []byte, b interface{}) error {
 			return ErrNotFound
 		}
 
+		if s.expiryCheck(tx)(key) {
+			expired = true
+			if s.opts.deleteExpired {
+				return s.deleteKey(tx, objects, key)
+			}
+			return nil
+		}
+
+		if err := s.checkDecodeSize(data); err != nil {
+			return s.keyError(key, err)
+		}
+		// Decode before deleting, so a value which can't be decoded isn't lost.
+		size = len(data)
 		buf.Write(data)
-		return objects.Delete(key)
+		if err := s.unmarshalValue(key, buf.Bytes(), b); err != nil {
+			return s.decodeError(key, data, err)
+		}
+		return s.deleteKey(tx, objects, key)
 	})
 
+	if decodeErr, ok := err.(*DecodeError); ok && s.opts.quarantine && quarantinable(decodeErr.Err) {
+		return s.quarantineValue(decodeErr.Key, decodeErr.Raw, decodeErr.Err)
+	}
 	if err != nil {
 		return err
 	}
+	if expired {
+		return ErrNotFound
+	}
+	return nil
+}
 
-	return s.unmarshal(buf.Bytes(), b)
+// DecodeError is returned by reads (Get, Pull, ForEach and the others) when a value can't be
+// decoded, or fails its checksum. Pull leaves the object in the store, and Raw holds its
+// encoded value so callers can inspect or salvage it. errors.Is and errors.As see through
+// it to the error of the Codec, or the *CorruptError.
+type DecodeError struct {
+	// Bucket is the path of the store's bucket, nested buckets separated by /.
+	Bucket string
+	Key    []byte
+	Raw    []byte
+	Err    error
+}
+
+func (e *DecodeError) Error() string {
+	if e.Bucket == "" {
+		return fmt.Sprintf("decoding %q: %v", e.Key, e.Err)
+	}
+	return fmt.Sprintf("decoding %q in %q: %v", e.Key, e.Bucket, e.Err)
+}
+
+// Unwrap returns the error of the Codec.
+func (e *DecodeError) Unwrap() error { return e.Err }
+
+// KeyError is returned for failures about an object other than decoding its value, like a
+// value over the limit of WithMaxDecodeSize (ErrTooLarge) or a key which can't be decoded
+// into the key type of a ForEach func. errors.Is and errors.As see through it to Err.
+type KeyError struct {
+	// Bucket is the path of the store's bucket, nested buckets separated by /.
+	Bucket string
+	Key    []byte
+	Err    error
+}
+
+func (e *KeyError) Error() string {
+	if e.Bucket == "" {
+		return fmt.Sprintf("%q: %v", e.Key, e.Err)
+	}
+	return fmt.Sprintf("%q in %q: %v", e.Key, e.Bucket, e.Err)
+}
+
+// Unwrap returns the error about the object.
+func (e *KeyError) Unwrap() error { return e.Err }
+
+// decodeError returns a *DecodeError for err, the failure to decode data, the value of key.
+func (s *Store) decodeError(key, data []byte, err error) error {
+	if decodeErr, ok := err.(*DecodeError); ok {
+		if decodeErr.Bucket == "" {
+			decodeErr.Bucket = s.bucket.path()
+		}
+		return decodeErr
+	}
+	return &DecodeError{
+		Bucket: s.bucket.path(),
+		Key:    append([]byte(nil), key...),
+		Raw:    append([]byte(nil), data...),
+		Err:    err,
+	}
+}
+
+// keyError returns a *KeyError for err, a failure about the object at key.
+func (s *Store) keyError(key []byte, err error) error {
+	return &KeyError{Bucket: s.bucket.path(), Key: append([]byte(nil), key...), Err: err}
+}
+
+// writeError maps the errors bolt returns for keys and values over its limits when writing
+// key to bucket to ErrKeyTooLarge and ErrValueTooLarge.
+func writeError(bucket bucketSpec, key []byte, err error) error {
+	switch err {
+	case bolt.ErrKeyTooLarge:
+		return ErrKeyTooLarge
+	case bolt.ErrValueTooLarge:
+		return &KeyError{Bucket: bucket.path(), Key: append([]byte(nil), key...), Err: ErrValueTooLarge}
+	}
+	return err
+}
+
+// deleteKey removes key from objects, along with any metadata kept for it.
+func (s *Store) deleteKey(tx BackendTx, objects BackendBucket, key []byte) error {
+	s.forget(key)
+	if err := s.forgetRingEntry(tx, objects, key); err != nil {
+		return err
+	}
+	if err := objects.Delete(key); err != nil {
+		return err
+	}
+	if err := s.removeIndexes(tx, key); err != nil {
+		return err
+	}
+	if err := s.clearWritten(tx, key); err != nil {
+		return err
+	}
+	if err := s.clearJob(tx, key); err != nil {
+		return err
+	}
+	return s.clearExpiry(tx, key)
 }
 
 // Get will retrieve b with key "key". If key is []byte or string it uses the key
@@ -181,9 +485,36 @@ func (s *Store) Get(key interface{}, b interface{}) error {
 }
 
 // Get will retrieve b with key "key"
-func (s *Store) get(key []byte, b interface{}) error {
+func (s *Store) get(key []byte, b interface{}) (err error) {
+	start := time.Now()
+	var size int
+	defer func() {
+		s.afterGet(key, b, err)
+		s.observe(OpGet, start, size, err)
+	}()
+
+	if s.opts.singleflight {
+		size, err = s.flights.get(key, b, s.getValue)
+	} else {
+		size, err = s.getValue(key, b)
+	}
+	return err
+}
+
+// getValue reads and decodes the object at key into b, and returns its encoded size.
+func (s *Store) getValue(key []byte, b interface{}) (size int, err error) {
+	if data, ok := s.immutable.get(key); ok {
+		size = len(data)
+		if err := s.unmarshalValue(key, data, b); err != nil {
+			return size, s.decodeError(key, data, err)
+		}
+		return size, nil
+	}
+
 	buf := bytes.NewBuffer(nil)
-	err := s.db.View(func(tx *bolt.Tx) error {
+	var expired bool
+	var ttl time.Duration
+	err = s.db.View(func(tx BackendTx) error {
 		objects := s.bucket.get(tx)
 		if objects == nil {
 			return ErrNotFound
@@ -192,38 +523,279 @@ func (s *Store) get(key []byte, b interface{}) error {
 		if data == nil {
 			return ErrNotFound
 		}
+		if s.expiryCheck(tx)(key) {
+			expired = true
+			return ErrNotFound
+		}
+		if err := s.checkDecodeSize(data); err != nil {
+			return s.keyError(key, err)
+		}
+		if s.opts.slidingTTL {
+			ttl = s.ttlOf(tx, key)
+		}
+		size = len(data)
 		buf.Write(data)
 		return nil
 	})
 
 	if err != nil {
-		return err
+		if expired && s.opts.deleteExpired {
+			if err := s.deleteExpired([][]byte{key}); err != nil {
+				return size, err
+			}
+		}
+		return size, err
 	}
 
-	return s.unmarshal(buf.Bytes(), b)
+	if s.opts.slidingTTL {
+		if err := s.slide(key, ttl); err != nil {
+			return size, err
+		}
+	}
+
+	if err := s.unmarshalValue(key, buf.Bytes(), b); err != nil {
+		return size, s.quarantineValue(key, buf.Bytes(), err)
+	}
+	data, err := s.writeBackSchema(key, buf.Bytes(), b)
+	if err != nil {
+		return size, err
+	}
+	s.immutable.add(key, data)
+	return size, nil
+}
+
+// GetOrZero works like Get, but never returns ErrNotFound. Instead, found reports whether
+// key "key" was in the store, and when it isn't b is set to its zero value.
+func (s *Store) GetOrZero(key interface{}, b interface{}) (found bool, err error) {
+	err = s.Get(key, b)
+	switch err {
+	case nil:
+		return true, nil
+	case ErrNotFound:
+		if v := reflect.ValueOf(b); v.Kind() == reflect.Ptr && !v.IsNil() {
+			v.Elem().Set(reflect.Zero(v.Elem().Type()))
+		}
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+// GetOrPut retrieves the object with key "key" into dest. If there is none, it stores
+// defaultVal with key "key" and retrieves it into dest instead. The check and the write
+// happen in one transaction, so concurrent callers all end up with the same object,
+// and found reports whether it was already in the store.
+// BeforePut hooks check defaultVal even when it doesn't end up being stored.
+func (s *Store) GetOrPut(key interface{}, defaultVal interface{}, dest interface{}) (found bool, err error) {
+	keyBytes, err := s.toBytes(key)
+	if err != nil {
+		return false, err
+	}
+	return s.getOrPut(keyBytes, defaultVal, dest)
+}
+
+func (s *Store) getOrPut(key []byte, defaultVal interface{}, dest interface{}) (found bool, err error) {
+	if err := s.beforePut(key, defaultVal); err != nil {
+		return false, err
+	}
+
+	encoded, err := s.encodeValue(defaultVal)
+	defer releaseBuffer(encoded)
+	if err != nil {
+		return false, err
+	}
+	data := encoded.Bytes()
+
+	buf := bytes.NewBuffer(nil)
+	err = s.update(func(tx BackendTx) error {
+		objects, err := s.bucket.createOrGet(tx)
+		if err != nil {
+			return err
+		}
+		if current := objects.Get(key); current != nil && !s.expiryCheck(tx)(key) {
+			found = true
+			if err := s.checkDecodeSize(current); err != nil {
+				return s.keyError(key, err)
+			}
+			buf.Write(current)
+			return nil
+		}
+		s.forget(key)
+		buf.Write(data)
+		return s.writeKey(tx, objects, key, data, defaultVal, s.opts.ttl)
+	})
+	if !found {
+		s.afterPut(key, defaultVal, err)
+	}
+	if err == nil {
+		if err = s.unmarshalValue(key, buf.Bytes(), dest); err != nil {
+			err = s.decodeError(key, buf.Bytes(), err)
+		}
+	}
+	if found {
+		s.afterGet(key, dest, err)
+	}
+	return found, err
+}
+
+// Has reports whether the store contains key "key", without reading or decoding its value.
+// If key is []byte or string it uses the key directly. Otherwise, it marshals the given
+// type into bytes using the stores Encoder.
+func (s *Store) Has(key interface{}) (bool, error) {
+	keyBytes, err := s.toBytes(key)
+	if err != nil {
+		return false, err
+	}
+
+	var found bool
+	err = s.db.View(func(tx BackendTx) error {
+		objects := s.bucket.get(tx)
+		if objects == nil {
+			return nil
+		}
+		found = objects.Get(keyBytes) != nil && !s.expiryCheck(tx)(keyBytes)
+		return nil
+	})
+	return found, err
 }
 
 // ForEach will run do on each object in the store.
 // do can be a function which takes either: 1 param which will take on each "value"
 // or 2 params where the first param is the "key" and the second is the "value".
+//
+// With WithScanBatchSize the objects are read in several transactions, see ForEachProgress
+// and SnapshotForEach.
 func (s *Store) ForEach(do interface{}) error {
+	if s.opts.scanBatch > 0 {
+		return s.ForEachProgress(ForEachOptions{BatchSize: s.opts.scanBatch}, do, nil)
+	}
+	return s.SnapshotForEach(do)
+}
+
+// SnapshotForEach works like ForEach, but always reads the objects in one read-only
+// transaction, even with WithScanBatchSize, so do sees the store as it was when the scan
+// started. The tradeoff is that the transaction stays open until the scan ends: meanwhile
+// bolt can't reuse the pages freed by writers, so the file grows under concurrent writes, and
+// a writer which needs to grow the file waits for the scan to end.
+func (s *Store) SnapshotForEach(do interface{}) error {
+	if len(s.prefix) > 0 {
+		return s.ForEachPrefix(nil, do)
+	}
+	fc, err := newFuncCall(s, do)
+	if err != nil {
+		return err
+	}
+	fc.quarantine = s.opts.quarantine
+
+	var expired [][]byte
+	err = s.db.View(func(tx BackendTx) error {
+		objects := s.bucket.get(tx)
+		if objects == nil {
+			return nil
+		}
+		isExpired := s.expiryCheck(tx)
+		return objects.ForEach(func(k, v []byte) error {
+			if v == nil {
+				// A nested store or hash.
+				return nil
+			}
+			if isExpired(k) {
+				expired = append(expired, append([]byte(nil), k...))
+				return nil
+			}
+			return fc.call(k, v)
+		})
+	})
+	return s.afterBadRead(s.afterExpiredRead(err, expired), fc.bad)
+}
+
+// ForEachPrefix works like ForEach, but only runs do on the objects whose key starts with
+// prefix, in key order. Combined with Key it scans every object under the first parts of
+// a composite key.
+func (s *Store) ForEachPrefix(prefix []byte, do interface{}) error {
 	fc, err := newFuncCall(s, do)
 	if err != nil {
 		return err
 	}
+	prefix = s.nsKey(prefix)
+	fc.quarantine = s.opts.quarantine
 
-	return s.db.View(func(tx *bolt.Tx) error {
+	var expired [][]byte
+	err = s.db.View(func(tx BackendTx) error {
 		objects := s.bucket.get(tx)
 		if objects == nil {
 			return nil
 		}
-		return objects.ForEach(fc.call)
+		isExpired := s.expiryCheck(tx)
+		c := objects.Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			if v == nil {
+				continue
+			}
+			if isExpired(k) {
+				expired = append(expired, append([]byte(nil), k...))
+				continue
+			}
+			if err := fc.call(k, v); err != nil {
+				return err
+			}
+		}
+		return nil
 	})
+	return s.afterBadRead(s.afterExpiredRead(err, expired), fc.bad)
+}
+
+// ForEachKey will run do on the key of each object in the store, without reading or
+// decoding the values. The key passed to do is only valid until do returns.
+// Iteration stops at the first error returned by do.
+func (s *Store) ForEachKey(do func(key []byte) error) error {
+	var expired [][]byte
+	err := s.db.View(func(tx BackendTx) error {
+		objects := s.bucket.get(tx)
+		if objects == nil {
+			return nil
+		}
+		isExpired := s.expiryCheck(tx)
+		c := objects.Cursor()
+		for k, v := c.Seek(s.prefix); k != nil && bytes.HasPrefix(k, s.prefix); k, v = c.Next() {
+			if v == nil {
+				continue
+			}
+			if isExpired(k) {
+				expired = append(expired, append([]byte(nil), k...))
+				continue
+			}
+			if err := do(s.trimNS(k)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return s.afterExpiredRead(err, expired)
+}
+
+// Keys returns the keys of all objects in the store, in order.
+func (s *Store) Keys() (keys [][]byte, err error) {
+	err = s.ForEachKey(func(key []byte) error {
+		keys = append(keys, append([]byte(nil), key...))
+		return nil
+	})
+	return keys, err
 }
 
 // DeleteAll empties the store
 func (s *Store) DeleteAll() error {
-	return s.db.Update(s.bucket.delete)
+	if len(s.prefix) > 0 {
+		_, err := s.DeletePrefix(nil)
+		return err
+	}
+	defer s.forgetAll()
+	return s.update(func(tx BackendTx) error {
+		if err := s.bucket.delete(tx); err != nil {
+			return err
+		}
+		return s.bucket.meta().deleteIfExists(tx)
+	})
 }
 
 // Delete will remove the item with the specified key from the store.
@@ -233,18 +805,88 @@ func (s *Store) Delete(key interface{}) error {
 	if err != nil {
 		return err
 	}
-	return s.db.Update(func(tx *bolt.Tx) error {
+	if err := s.beforeDelete(keyBytes); err != nil {
+		return err
+	}
+	start := time.Now()
+	err = s.write(func(tx BackendTx) error {
+		objects := s.bucket.get(tx)
+		if objects == nil {
+			return nil
+		}
+		return s.deleteKey(tx, objects, keyBytes)
+	})
+	s.afterDelete(keyBytes, err)
+	s.observe(OpDelete, start, 0, err)
+	return err
+}
+
+// Remove works like Delete, but also reports whether key "key" was in the store.
+// An expired object is removed but doesn't count as existing.
+func (s *Store) Remove(key interface{}) (existed bool, err error) {
+	keyBytes, err := s.toBytes(key)
+	if err != nil {
+		return false, err
+	}
+	if err := s.beforeDelete(keyBytes); err != nil {
+		return false, err
+	}
+	start := time.Now()
+	err = s.write(func(tx BackendTx) error {
+		// A batched write can run more than once.
+		existed = false
+		objects := s.bucket.get(tx)
+		if objects == nil || objects.Get(keyBytes) == nil {
+			return nil
+		}
+		existed = !s.expiryCheck(tx)(keyBytes)
+		return s.deleteKey(tx, objects, keyBytes)
+	})
+	s.afterDelete(keyBytes, err)
+	s.observe(OpDelete, start, 0, err)
+	return existed && err == nil, err
+}
+
+// DeletePrefix removes every object whose key starts with prefix in one transaction, and
+// returns how many it removed, not counting expired objects. Like DeleteAll it doesn't run
+// Hooks, and nested stores and hashes are left alone.
+func (s *Store) DeletePrefix(prefix []byte) (n int, err error) {
+	prefix = s.nsKey(prefix)
+	err = s.update(func(tx BackendTx) error {
 		objects := s.bucket.get(tx)
 		if objects == nil {
 			return nil
 		}
-		return objects.Delete(keyBytes)
+
+		// Deleting while a cursor iterates can skip keys, so collect them first.
+		var keys [][]byte
+		c := objects.Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			if v != nil {
+				keys = append(keys, append([]byte(nil), k...))
+			}
+		}
+
+		isExpired := s.expiryCheck(tx)
+		for _, key := range keys {
+			if !isExpired(key) {
+				n++
+			}
+			if err := s.deleteKey(tx, objects, key); err != nil {
+				return err
+			}
+		}
+		return nil
 	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
 }
 
 type bucketSpec [][]byte
 
-func (bs bucketSpec) get(tx *bolt.Tx) (bt *bolt.Bucket) {
+func (bs bucketSpec) get(tx BackendTx) (bt BackendBucket) {
 	for _, b := range bs {
 		if bt != nil {
 			bt = bt.Bucket(b)
@@ -259,7 +901,32 @@ func (bs bucketSpec) get(tx *bolt.Tx) (bt *bolt.Bucket) {
 	return bt
 }
 
-func (bs bucketSpec) createOrGet(tx *bolt.Tx) (bt *bolt.Bucket, err error) {
+func (bs bucketSpec) createOrGet(tx BackendTx) (bt BackendBucket, err error) {
+	for i, b := range bs {
+		var created bool
+		if bt != nil {
+			created = bt.Bucket(b) == nil
+			bt, err = bt.CreateBucketIfNotExists(b)
+		} else {
+			created = tx.Bucket(b) == nil
+			bt, err = tx.CreateBucketIfNotExists(b)
+		}
+
+		if bt == nil || err != nil {
+			break
+		}
+
+		if created {
+			if err = bs[:i+1].markCreated(tx); err != nil {
+				break
+			}
+		}
+	}
+	return bt, err
+}
+
+// createOrGetUntracked is like createOrGet, but doesn't record when buckets were created.
+func (bs bucketSpec) createOrGetUntracked(tx BackendTx) (bt BackendBucket, err error) {
 	for _, b := range bs {
 		if bt != nil {
 			bt, err = bt.CreateBucketIfNotExists(b)
@@ -274,7 +941,13 @@ func (bs bucketSpec) createOrGet(tx *bolt.Tx) (bt *bolt.Bucket, err error) {
 	return bt, err
 }
 
-func (bs bucketSpec) delete(tx *bolt.Tx) (err error) {
+func (bs bucketSpec) child(name []byte) bucketSpec {
+	child := make(bucketSpec, 0, len(bs)+1)
+	child = append(child, bs...)
+	return append(child, name)
+}
+
+func (bs bucketSpec) delete(tx BackendTx) (err error) {
 	switch len(bs) {
 	case 0:
 		return nil
@@ -283,6 +956,18 @@ func (bs bucketSpec) delete(tx *bolt.Tx) (err error) {
 	default:
 		lastParentBucket := bs[:len(bs)-1]
 		childBucketName := bs[len(bs)-1]
-		return lastParentBucket.get(tx).DeleteBucket(childBucketName)
+		parent := lastParentBucket.get(tx)
+		if parent == nil {
+			return bolt.ErrBucketNotFound
+		}
+		return parent.DeleteBucket(childBucketName)
+	}
+}
+
+// deleteIfExists works like delete, but doesn't fail when the bucket doesn't exist.
+func (bs bucketSpec) deleteIfExists(tx BackendTx) error {
+	if err := bs.delete(tx); err != bolt.ErrBucketNotFound {
+		return err
 	}
+	return nil
 }
@@ -2,11 +2,16 @@ package stow
 
 import (
 	"bytes"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	bolt "go.etcd.io/bbolt"
 )
@@ -223,8 +228,16 @@ func testStore(t testing.TB, store *Store) {
 		t.Errorf("key should not be found.")
 	}
 
+	if found, err := store.Has("hello"); err != nil || found {
+		t.Errorf("Has should not find key: %v %v", found, err)
+	}
+
 	testForEach(t, store)
 
+	if found, err := store.Has("hello"); err != nil || !found {
+		t.Errorf("Has should find key: %v %v", found, err)
+	}
+
 	store.Get("hello", &name)
 
 	if name.FirstName != "Derek" || name.LastName != "Kered" {
@@ -308,3 +321,434 @@ func TestFunc(t *testing.T) {
 		t.Errorf("expected bad # of args func error")
 	}
 }
+
+func TestPruneEmptyBuckets(t *testing.T) {
+	parent := NewJSONStore(db, []byte("prune_parent"))
+	parent.Put("hello", "world")
+
+	empty := parent.NewNestedStore([]byte("empty"))
+	empty.Put("hello", "world")
+	empty.Delete("hello")
+	empty.NewNestedStore([]byte("empty_child")).Put("hello", "world")
+	empty.NewNestedStore([]byte("empty_child")).Delete("hello")
+
+	full := parent.NewNestedStore([]byte("full"))
+	full.Put("hello", "world")
+
+	if n, err := parent.PruneEmptyBuckets(time.Hour); err != nil || n != 0 {
+		t.Errorf("new buckets should not be pruned: %d %v", n, err)
+	}
+
+	if n, err := parent.PruneEmptyBuckets(0); err != nil || n != 2 {
+		t.Errorf("expected 2 pruned buckets: %d %v", n, err)
+	}
+
+	var v string
+	if err := full.Get("hello", &v); err != nil || v != "world" {
+		t.Errorf("non-empty bucket was pruned: %v", err)
+	}
+	if err := parent.Get("hello", &v); err != nil || v != "world" {
+		t.Errorf("parent lost data: %v", err)
+	}
+	parent.DeleteAll()
+}
+
+func TestKeys(t *testing.T) {
+	s := NewJSONStore(db, []byte("keys"))
+	if keys, err := s.Keys(); err != nil || len(keys) != 0 {
+		t.Errorf("expected no keys: %v %v", keys, err)
+	}
+
+	s.Put("b", 2)
+	s.Put("a", 1)
+	s.NewNestedStore([]byte("c")).Put("d", 3)
+
+	keys, err := s.Keys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || string(keys[0]) != "a" || string(keys[1]) != "b" {
+		t.Errorf("unexpected keys %q", keys)
+	}
+
+	stop := fmt.Errorf("stop")
+	var n int
+	if err := s.ForEachKey(func(key []byte) error { n++; return stop }); err != stop || n != 1 {
+		t.Errorf("ForEachKey should stop on error: %v %d", err, n)
+	}
+	s.DeleteAll()
+}
+
+func TestPreload(t *testing.T) {
+	s := NewJSONStore(db, []byte("preload"))
+	s.Put("user/1", 1)
+	s.Put("user/2", 2)
+	s.Put("group/1", 1)
+
+	if n, err := s.Preload([][]byte{[]byte("user/1"), []byte("missing")}); err != nil || n != 1 {
+		t.Errorf("unexpected preload result %d %v", n, err)
+	}
+
+	if n, err := s.PreloadPrefix([]byte("user/")); err != nil || n != 2 {
+		t.Errorf("unexpected preload prefix result %d %v", n, err)
+	}
+	s.DeleteAll()
+}
+
+func TestGetOrZero(t *testing.T) {
+	s := NewJSONStore(db, []byte("get_or_zero"))
+	defer s.DeleteAll()
+
+	s.Put("hello", MyType{"Derek", "Kered"})
+
+	v := MyType{"Not", "Zero"}
+	if found, err := s.GetOrZero("missing", &v); err != nil || found || v != (MyType{}) {
+		t.Errorf("expected zero value on miss: %v %v %v", found, err, v)
+	}
+
+	if found, err := s.GetOrZero("hello", &v); err != nil || !found || v.FirstName != "Derek" {
+		t.Errorf("expected value: %v %v %v", found, err, v)
+	}
+}
+
+func TestMaxDecodeSize(t *testing.T) {
+	s := NewJSONStore(db, []byte("max_decode_size"), WithMaxDecodeSize(32))
+	defer s.DeleteAll()
+
+	s.Put("small", "ok")
+	s.Put("large", strings.Repeat("x", 100))
+
+	var v string
+	if err := s.Get("small", &v); err != nil || v != "ok" {
+		t.Errorf("unexpected Get of small object %q %v", v, err)
+	}
+	err := s.Get("large", &v)
+	var keyErr *KeyError
+	if !errors.Is(err, ErrTooLarge) || !errors.As(err, &keyErr) || string(keyErr.Key) != "large" {
+		t.Errorf("expected ErrTooLarge for large, got %v", err)
+	}
+	if err := s.Pull("large", &v); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("expected ErrTooLarge, got %v", err)
+	}
+	if has, _ := s.Has("large"); !has {
+		t.Errorf("expected refused Pull to leave the object")
+	}
+	if err := s.ForEach(func(v string) {}); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("expected ForEach to return ErrTooLarge, got %v", err)
+	}
+}
+
+func TestWriteLimits(t *testing.T) {
+	s := NewJSONStore(db, []byte("write_limits"))
+	defer s.DeleteAll()
+
+	key := strings.Repeat("k", bolt.MaxKeySize+1)
+	if err := s.Put(key, "v"); !errors.Is(err, ErrKeyTooLarge) || !errors.Is(err, bolt.ErrKeyTooLarge) {
+		t.Errorf("expected ErrKeyTooLarge, got %v", err)
+	}
+	if err := s.HSet("hash", key, "v"); !errors.Is(err, ErrKeyTooLarge) {
+		t.Errorf("expected ErrKeyTooLarge from HSet, got %v", err)
+	}
+	if has, _ := s.Has(key); has {
+		t.Errorf("expected the refused Put to store nothing")
+	}
+
+	err := writeError(s.bucket, []byte("big"), bolt.ErrValueTooLarge)
+	var keyErr *KeyError
+	if !errors.Is(err, ErrValueTooLarge) || !errors.As(err, &keyErr) || string(keyErr.Key) != "big" || keyErr.Bucket != "write_limits" {
+		t.Errorf("expected ErrValueTooLarge for big, got %v", err)
+	}
+}
+
+func TestBatchWrites(t *testing.T) {
+	s := NewJSONStore(db, []byte("batch_writes"), WithBatchWrites())
+	defer s.DeleteAll()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := s.Put(fmt.Sprint(i), i); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	var n int
+	if err := s.ForEach(func(key string, v int) {
+		if key != fmt.Sprint(v) {
+			t.Errorf("unexpected object %s: %d", key, v)
+		}
+		n++
+	}); err != nil || n != 50 {
+		t.Errorf("expected 50 objects, got %d %v", n, err)
+	}
+
+	if err := s.Delete("0"); err != nil {
+		t.Fatal(err)
+	}
+	if existed, err := s.Remove("1"); err != nil || !existed {
+		t.Errorf("expected 1 to be removed: %v %v", existed, err)
+	}
+	if existed, err := s.Remove("1"); err != nil || existed {
+		t.Errorf("expected 1 to be gone: %v %v", existed, err)
+	}
+	if keys, _ := s.Keys(); len(keys) != 48 {
+		t.Errorf("expected 48 objects, got %d", len(keys))
+	}
+}
+
+func TestGetOrPut(t *testing.T) {
+	s := NewJSONStore(db, []byte("get_or_put"))
+	defer s.DeleteAll()
+
+	var v MyType
+	if found, err := s.GetOrPut("hello", MyType{"Derek", "Kered"}, &v); err != nil || found || v.FirstName != "Derek" {
+		t.Errorf("expected default to be stored: %v %v %v", found, err, v)
+	}
+	if found, err := s.GetOrPut("hello", MyType{"Other", "Person"}, &v); err != nil || !found || v.FirstName != "Derek" {
+		t.Errorf("expected stored value: %v %v %v", found, err, v)
+	}
+
+	var wg sync.WaitGroup
+	var stored int32
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var n int
+			found, err := s.GetOrPut("race", i, &n)
+			if err != nil {
+				t.Error(err)
+			}
+			if !found {
+				atomic.AddInt32(&stored, 1)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if stored != 1 {
+		t.Errorf("expected exactly one default to be stored, got %d", stored)
+	}
+}
+
+func TestRemoveAndDeletePrefix(t *testing.T) {
+	s := NewJSONStore(db, []byte("delete_prefix"))
+	defer s.DeleteAll()
+
+	s.Put("hello", "world")
+	if existed, err := s.Remove("hello"); err != nil || !existed {
+		t.Errorf("expected Remove to find the key: %v %v", existed, err)
+	}
+	if existed, err := s.Remove("hello"); err != nil || existed {
+		t.Errorf("expected Remove to report a missing key: %v %v", existed, err)
+	}
+
+	for i := 0; i < 5; i++ {
+		s.Put(fmt.Sprintf("user/%d", i), i)
+	}
+	s.Put("users", "kept")
+	s.PutTTL("user/expired", 0, time.Nanosecond)
+	s.NewNestedStore([]byte("user/nested")).Put("kept", 1)
+	time.Sleep(time.Millisecond)
+
+	if n, err := s.DeletePrefix([]byte("user/")); err != nil || n != 5 {
+		t.Errorf("expected 5 deleted objects: %d %v", n, err)
+	}
+	keys, _ := s.Keys()
+	if len(keys) != 1 || string(keys[0]) != "users" {
+		t.Errorf("unexpected keys left %q", keys)
+	}
+	var v int
+	if err := s.NewNestedStore([]byte("user/nested")).Get("kept", &v); err != nil {
+		t.Errorf("nested store should be left alone: %v", err)
+	}
+}
+
+func TestSnapshotForEach(t *testing.T) {
+	s := NewJSONStore(db, []byte("snapshot_for_each"), WithScanBatchSize(1))
+	defer s.DeleteAll()
+
+	// scan counts the objects seen while another goroutine writes a new one meanwhile.
+	scan := func(forEach func(do interface{}) error) int {
+		s.DeleteAll()
+		s.Put("a", 1)
+		s.Put("b", 2)
+		var n int
+		err := forEach(func(key string, v int) {
+			if n++; key == "a" {
+				done := make(chan error)
+				go func() { done <- s.Put("c", 3) }()
+				if err := <-done; err != nil {
+					t.Fatal(err)
+				}
+			}
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	if n := scan(s.SnapshotForEach); n != 2 {
+		t.Errorf("expected SnapshotForEach to miss the new object, got %d objects", n)
+	}
+	if n := scan(s.ForEach); n != 3 {
+		t.Errorf("expected a batched ForEach to see the new object, got %d objects", n)
+	}
+}
+
+func TestForEachDecodeError(t *testing.T) {
+	s := NewJSONStore(db, []byte("foreach_decode_error"))
+	defer s.DeleteAll()
+	nested := s.NewNestedStore([]byte("nested"))
+	nested.Put("a", 1)
+	nested.Put("b", "not a number")
+
+	err := nested.ForEach(func(n int) {})
+	var derr *DecodeError
+	if !errors.As(err, &derr) {
+		t.Fatalf("expected a DecodeError, got %v", err)
+	}
+	if derr.Bucket != "foreach_decode_error/nested" || string(derr.Key) != "b" {
+		t.Errorf("unexpected DecodeError %q %q", derr.Bucket, derr.Key)
+	}
+	if err := nested.Get("b", new(int)); !errors.As(err, &derr) || string(derr.Key) != "b" {
+		t.Errorf("expected a DecodeError from Get, got %v", err)
+	}
+}
+
+func TestPullDecodeError(t *testing.T) {
+	s := NewJSONStore(db, []byte("pull_decode_error"))
+	defer s.DeleteAll()
+	s.Put("hello", "not a number")
+
+	var n int
+	err := s.Pull("hello", &n)
+	derr, ok := err.(*DecodeError)
+	if !ok {
+		t.Fatalf("expected a DecodeError, got %v", err)
+	}
+	if string(derr.Key) != "hello" || strings.TrimSpace(string(derr.Raw)) != `"not a number"` || derr.Bucket != "pull_decode_error" {
+		t.Errorf("unexpected DecodeError %q %q %q", derr.Bucket, derr.Key, derr.Raw)
+	}
+
+	var v string
+	if err := s.Pull("hello", &v); err != nil || v != "not a number" {
+		t.Errorf("failed Pull should leave the object: %q %v", v, err)
+	}
+}
+
+func TestNestedStores(t *testing.T) {
+	parent := NewJSONStore(db, []byte("nested_stores"))
+	defer parent.DeleteAll()
+	parent.Put("object", 1)
+	parent.NewNestedStore([]byte("b")).Put("x", 1)
+	parent.NewNestedStore([]byte("a")).NewNestedStore([]byte("deep")).Put("y", 2)
+
+	names, err := parent.NestedStores()
+	if err != nil || fmt.Sprintf("%s", names) != "[a b]" {
+		t.Errorf("unexpected nested stores %s %v", names, err)
+	}
+
+	var walked []string
+	err = parent.WalkNested(func(path [][]byte, store *Store) error {
+		var keys []string
+		store.ForEachKey(func(key []byte) error { keys = append(keys, string(key)); return nil })
+		walked = append(walked, fmt.Sprintf("%s=%v", bytes.Join(path, []byte("/")), keys))
+		return nil
+	})
+	if err != nil || fmt.Sprint(walked) != "[a=[] a/deep=[y] b=[x]]" {
+		t.Errorf("unexpected walk %v %v", walked, err)
+	}
+
+	if err := parent.DeleteNestedStore([]byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := parent.DeleteNestedStore([]byte("missing")); err != nil {
+		t.Errorf("deleting a missing nested store should return nil: %v", err)
+	}
+	if names, _ := parent.NestedStores(); fmt.Sprintf("%s", names) != "[b]" {
+		t.Errorf("unexpected nested stores after delete %s", names)
+	}
+	var v int
+	if err := parent.Get("object", &v); err != nil || v != 1 {
+		t.Errorf("parent objects should be left alone: %v", err)
+	}
+}
+
+func TestForEachNested(t *testing.T) {
+	s := NewJSONStore(db, []byte("foreach_nested"))
+	defer s.DeleteAll()
+	s.Put("a", 1)
+	s.NewNestedStore([]byte("tenant-1")).Put("b", 2)
+	s.NewNestedStore([]byte("tenant-1")).NewNestedStore([]byte("deep")).Put("c", 3)
+	s.NewNestedStore([]byte("tenant-2")).PutTTL("expired", 4, time.Nanosecond)
+	s.Put("z", 5)
+	time.Sleep(time.Millisecond)
+
+	var visited []string
+	err := s.ForEachNested(func(path [][]byte, key string, v int) {
+		visited = append(visited, fmt.Sprintf("%s:%s=%d", bytes.Join(path, []byte("/")), key, v))
+	})
+	want := "[:a=1 tenant-1:b=2 tenant-1/deep:c=3 :z=5]"
+	if err != nil || fmt.Sprint(visited) != want {
+		t.Errorf("unexpected visits %v %v", visited, err)
+	}
+
+	if err := s.ForEachNested(func(key string, v int) {}); err == nil {
+		t.Errorf("expected an error for a func without a path")
+	}
+}
+
+func TestNew(t *testing.T) {
+	s := New(db, []byte("new"), WithCodec(JSONCodec{}), WithTTL(time.Hour))
+	defer s.DeleteAll()
+
+	if err := s.Put("hello", MyType{FirstName: "Derek"}); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := s.GetRaw([]byte("hello"))
+	if err != nil || !bytes.HasPrefix(raw, []byte(`{"first":"Derek"`)) {
+		t.Errorf("expected a json value, got %q %v", raw, err)
+	}
+
+	var v MyType
+	if err := New(db, []byte("new"), WithCodec(JSONCodec{})).Get("hello", &v); err != nil || v.FirstName != "Derek" {
+		t.Errorf("unexpected value %v %v", v, err)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	s := NewJSONStore(db, []byte("read_only"))
+	defer s.DeleteAll()
+	s.PutTTL("expired", "x", time.Nanosecond)
+	s.Put("hello", "world")
+	time.Sleep(time.Millisecond)
+
+	ro := NewJSONStore(db, []byte("read_only"), WithReadOnly(), WithDeleteExpiredOnRead())
+	if err := ro.Put("hello", "again"); err != ErrReadOnly {
+		t.Errorf("expected ErrReadOnly from Put, got %v", err)
+	}
+	if err := ro.Delete("hello"); err != ErrReadOnly {
+		t.Errorf("expected ErrReadOnly from Delete, got %v", err)
+	}
+	if err := ro.NewNestedStore([]byte("nested")).Put("a", "b"); err != ErrReadOnly {
+		t.Errorf("expected nested stores to be read-only, got %v", err)
+	}
+
+	var v string
+	if err := ro.Get("hello", &v); err != nil || v != "world" {
+		t.Errorf("unexpected value %q %v", v, err)
+	}
+	if err := ro.Get("expired", &v); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	if err := ro.ForEach(func(v string) {}); err != nil {
+		t.Errorf("expected reads to skip deleting expired objects, got %v", err)
+	}
+	if keys, _ := NewJSONStore(db, []byte("read_only")).Keys(); len(keys) != 1 {
+		t.Errorf("expected the expired object to be left, got %q", keys)
+	}
+}
@@ -0,0 +1,125 @@
+// Package stowcache provides an HTTP middleware which caches responses in a stow.Store, so a
+// small service gets a response cache which survives restarts without running a cache server.
+//
+// Only successful responses to GET requests are cached, and responses which set cookies or
+// whose Cache-Control says not to store them are passed through. Requests with an
+// Authorization header bypass the cache.
+package stowcache
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/djherbis/stow/v4"
+)
+
+// Options configures a Handler.
+type Options struct {
+	// TTL is how long a response is cached, 5 minutes if zero.
+	TTL time.Duration
+
+	// MaxBodySize is the size of the largest body which is cached, 1 MiB if zero. Larger
+	// responses are passed through.
+	MaxBodySize int
+
+	// Key returns the key a request's response is cached at, its URL if nil.
+	Key func(r *http.Request) string
+}
+
+// response is a cached response, as it's kept in the store.
+type response struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+// Handler returns a handler which serves the responses of next cached in store, and caches
+// those it didn't have yet. Errors of the store aren't reported: the request is served by
+// next, and its response isn't cached. Caching happens once next returns, so a response
+// which is still being written to a client isn't served to others. Run the store's sweeper
+// (see stow.Store.StartSweeper) to remove the responses which expired.
+func Handler(next http.Handler, store *stow.Store, opts Options) http.Handler {
+	if opts.TTL <= 0 {
+		opts.TTL = 5 * time.Minute
+	}
+	if opts.MaxBodySize <= 0 {
+		opts.MaxBodySize = 1 << 20
+	}
+	if opts.Key == nil {
+		opts.Key = func(r *http.Request) string { return r.URL.String() }
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.Header.Get("Authorization") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := opts.Key(r)
+		var cached response
+		if err := store.Get(key, &cached); err == nil {
+			header := w.Header()
+			for k, v := range cached.Header {
+				header[k] = v
+			}
+			w.WriteHeader(cached.Status)
+			w.Write(cached.Body)
+			return
+		}
+
+		rec := &recorder{ResponseWriter: w, max: opts.MaxBodySize}
+		next.ServeHTTP(rec, r)
+		if rec.cacheable() {
+			store.PutTTL(key, response{Status: rec.status, Header: rec.header, Body: rec.body.Bytes()}, opts.TTL)
+		}
+	})
+}
+
+// recorder passes a response through, and keeps a copy of it while it's small enough to
+// be cached.
+type recorder struct {
+	http.ResponseWriter
+	max int
+
+	status   int
+	header   http.Header
+	body     bytes.Buffer
+	tooLarge bool
+}
+
+func (rec *recorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+		rec.header = rec.Header().Clone()
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *recorder) Write(p []byte) (int, error) {
+	if rec.status == 0 {
+		rec.WriteHeader(http.StatusOK)
+	}
+	if !rec.tooLarge {
+		if rec.body.Len()+len(p) > rec.max {
+			rec.tooLarge = true
+			rec.body = bytes.Buffer{}
+		} else {
+			rec.body.Write(p)
+		}
+	}
+	return rec.ResponseWriter.Write(p)
+}
+
+// cacheable reports whether the recorded response may be cached.
+func (rec *recorder) cacheable() bool {
+	if rec.status == 0 {
+		rec.status, rec.header = http.StatusOK, rec.Header().Clone()
+	}
+	if rec.status != http.StatusOK || rec.tooLarge || rec.header.Get("Set-Cookie") != "" {
+		return false
+	}
+	cc := strings.ToLower(rec.header.Get("Cache-Control"))
+	return !strings.Contains(cc, "no-store") && !strings.Contains(cc, "private")
+}
@@ -0,0 +1,76 @@
+package stowcache
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/djherbis/stow/v4"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestHandler(t *testing.T) {
+	db, err := bolt.Open(filepath.Join(t.TempDir(), "cache.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	calls := 0
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		switch r.URL.Path {
+		case "/private":
+			w.Header().Set("Cache-Control", "private")
+		case "/missing":
+			http.NotFound(w, r)
+			return
+		case "/large":
+			fmt.Fprint(w, strings.Repeat("x", 100))
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprintf(w, "%s %d", r.URL.Path, calls)
+	})
+	h := Handler(next, stow.NewStore(db, []byte("responses")), Options{
+		TTL:         50 * time.Millisecond,
+		MaxBodySize: 64,
+	})
+
+	get := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w
+	}
+
+	if w := get("GET", "/a"); w.Body.String() != "/a 1" {
+		t.Errorf("unexpected response %q", w.Body.String())
+	}
+	if w := get("GET", "/a"); w.Body.String() != "/a 1" || w.Code != http.StatusOK || w.Header().Get("Content-Type") != "text/plain" {
+		t.Errorf("expected the cached response, got %d %q %v", w.Code, w.Body.String(), w.Header())
+	}
+	if w := get("GET", "/a?page=2"); w.Body.String() != "/a 2" {
+		t.Errorf("responses should be cached by URL, got %q", w.Body.String())
+	}
+	if w := get("POST", "/a"); w.Body.String() != "/a 3" {
+		t.Errorf("POST shouldn't be cached, got %q", w.Body.String())
+	}
+
+	for _, path := range []string{"/private", "/missing", "/large"} {
+		before := calls
+		get("GET", path)
+		get("GET", path)
+		if calls != before+2 {
+			t.Errorf("%s shouldn't be cached", path)
+		}
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if w := get("GET", "/a"); w.Body.String() == "/a 1" {
+		t.Errorf("expected the cached response to expire")
+	}
+}
@@ -0,0 +1,261 @@
+package stowd
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/djherbis/stow/v4"
+	"github.com/djherbis/stow/v4/stowd/stowpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrConflict indicates an Update which kept conflicting with the writes of other clients.
+var ErrConflict = errors.New("stowd: too many conflicting transactions")
+
+// maxCommitAttempts is the number of times an Update runs before failing with ErrConflict.
+const maxCommitAttempts = 10
+
+// scanPage is the number of keys a transaction's ForEach reads from the server at once.
+const scanPage = 256
+
+// Client is a stow.KVBackend on a stowd server.
+//
+// A View's reads are made one by one, and iterations read a page of keys at a time: they
+// don't see the store as of a single transaction.
+// An Update's writes are sent together when it returns, and are applied only if the values
+// it read are unchanged; otherwise it's run again, so it must not have side effects beyond
+// its transaction. Only the keys an iteration hands to its caller count as read, and keys
+// added by other clients while an Update iterates aren't conflicts.
+type Client struct {
+	rpc stowpb.StowClient
+}
+
+var _ stow.KVBackend = (*Client)(nil)
+
+// NewClient returns a Client which speaks to the server on conn.
+func NewClient(conn grpc.ClientConnInterface) *Client {
+	return &Client{rpc: stowpb.NewStowClient(conn)}
+}
+
+// NewStore returns a store which keeps its objects in bucket, on the server on conn, encoding
+// them with codec, like stow.NewCustomStore.
+func NewStore(conn grpc.ClientConnInterface, bucket []byte, codec stow.Codec, opts ...stow.Option) *stow.Store {
+	return stow.NewBackendStore(stow.NewKVBackend(NewClient(conn)), bucket, codec, opts...)
+}
+
+// View runs fn with reads from the server.
+func (c *Client) View(fn func(tx stow.KVTx) error) error {
+	tx := &clientTx{c: c}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.err
+}
+
+// Update runs fn, and commits its writes if the values it read are unchanged.
+func (c *Client) Update(fn func(tx stow.KVTx) error) error {
+	for attempt := 0; attempt < maxCommitAttempts; attempt++ {
+		tx := &clientTx{c: c, writes: make(map[string]*stowpb.Write), reads: make(map[string]*stowpb.Read)}
+		if err := fn(tx); err != nil {
+			return err
+		}
+		if tx.err != nil {
+			return tx.err
+		}
+		if len(tx.writes) == 0 {
+			return nil
+		}
+
+		req := &stowpb.CommitRequest{}
+		for _, r := range tx.reads {
+			req.Reads = append(req.Reads, r)
+		}
+		for _, w := range tx.writes {
+			req.Writes = append(req.Writes, w)
+		}
+		_, err := c.rpc.Commit(context.Background(), req)
+		if status.Code(err) == codes.Aborted {
+			continue
+		}
+		return err
+	}
+	return ErrConflict
+}
+
+// Event is a write to a key of a store, seen by Watch. Value is the value as stored, nil if
+// Deleted.
+type Event struct {
+	Key     []byte
+	Value   []byte
+	Deleted bool
+}
+
+// Watch calls fn with each write made through the server to a key with prefix of the store
+// on bucket, until ctx is done or fn returns an error, which Watch returns. The deletion of
+// a nested store of the store is seen as the deletion of its name.
+func (c *Client) Watch(ctx context.Context, bucket, prefix []byte, fn func(e Event) error) error {
+	entries := stow.KVEntries(bucket)
+	stream, err := c.rpc.Watch(ctx, &stowpb.WatchRequest{Prefix: append(entries, prefix...)})
+	if err != nil {
+		return err
+	}
+	for {
+		e, err := stream.Recv()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		event := Event{Key: e.Key[len(entries):], Deleted: e.Deleted}
+		if !e.Deleted {
+			var ok bool
+			if event.Value, ok = stow.KVValue(e.Value); !ok {
+				// A nested store's bucket.
+				continue
+			}
+		}
+		if err := fn(event); err != nil {
+			return err
+		}
+	}
+}
+
+// clientTx is a transaction of a Client. An Update records its reads, and keeps its writes
+// until it commits.
+type clientTx struct {
+	c      *Client
+	reads  map[string]*stowpb.Read
+	writes map[string]*stowpb.Write
+	// err is the first error of a Get, which returns no error, and fails the transaction.
+	err error
+}
+
+func (t *clientTx) Get(key []byte) []byte {
+	if w, ok := t.writes[string(key)]; ok {
+		if w.Delete {
+			return nil
+		}
+		return w.Value
+	}
+	if t.err != nil {
+		return nil
+	}
+	resp, err := t.c.rpc.Get(context.Background(), &stowpb.GetRequest{Key: key})
+	switch {
+	case status.Code(err) == codes.NotFound:
+		t.read(key, nil)
+		return nil
+	case err != nil:
+		t.err = err
+		return nil
+	}
+	value := append([]byte{}, resp.Value...)
+	t.read(key, value)
+	return value
+}
+
+// read records the value of key read by an Update.
+func (t *clientTx) read(key, value []byte) {
+	if t.reads == nil {
+		return
+	}
+	k := string(key)
+	if _, ok := t.reads[k]; !ok {
+		t.reads[k] = &stowpb.Read{Key: append([]byte(nil), key...), Value: value, Found: value != nil}
+	}
+}
+
+func (t *clientTx) Put(key, value []byte) error {
+	if t.writes == nil {
+		return errors.New("stowd: write in a View")
+	}
+	t.writes[string(key)] = &stowpb.Write{Key: append([]byte(nil), key...), Value: append([]byte{}, value...)}
+	return nil
+}
+
+func (t *clientTx) Delete(key []byte) error {
+	if t.writes == nil {
+		return errors.New("stowd: write in a View")
+	}
+	t.writes[string(key)] = &stowpb.Write{Key: append([]byte(nil), key...), Delete: true}
+	return nil
+}
+
+func (t *clientTx) ForEach(prefix, start []byte, fn func(k, v []byte) error) error {
+	if t.err != nil {
+		return t.err
+	}
+	// The keys the transaction wrote, which are merged in order with the ones on the server.
+	var written []string
+	for k := range t.writes {
+		if strings.HasPrefix(k, string(prefix)) && k >= string(start) {
+			written = append(written, k)
+		}
+	}
+	sort.Strings(written)
+	// writes calls fn with the written keys up to key, or all of them if last.
+	writes := func(key string, last bool) error {
+		for len(written) > 0 && (last || written[0] <= key) {
+			w := t.writes[written[0]]
+			written = written[1:]
+			if w.Delete {
+				continue
+			}
+			if err := fn(w.Key, w.Value); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for {
+		page, err := t.scan(prefix, start)
+		if err != nil {
+			return err
+		}
+		for _, kv := range page {
+			if err := writes(string(kv.Key), false); err != nil {
+				return err
+			}
+			if _, ok := t.writes[string(kv.Key)]; ok {
+				continue
+			}
+			value := append([]byte{}, kv.Value...)
+			t.read(kv.Key, value)
+			if err := fn(kv.Key, value); err != nil {
+				return err
+			}
+		}
+		if len(page) < scanPage {
+			return writes("", true)
+		}
+		// The smallest key after the page.
+		start = append(append([]byte(nil), page[len(page)-1].Key...), 0)
+	}
+}
+
+// scan returns the keys with prefix from the first one at or after start, and their values,
+// up to scanPage of them.
+func (t *clientTx) scan(prefix, start []byte) ([]*stowpb.KeyValue, error) {
+	stream, err := t.c.rpc.Scan(context.Background(), &stowpb.ScanRequest{Prefix: prefix, Start: start, Limit: scanPage})
+	if err != nil {
+		return nil, err
+	}
+	var page []*stowpb.KeyValue
+	for {
+		kv, err := stream.Recv()
+		if err == io.EOF {
+			return page, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		page = append(page, kv)
+	}
+}
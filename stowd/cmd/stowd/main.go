@@ -0,0 +1,41 @@
+// Command stowd serves a bucket of a bolt database over gRPC, for stowd.NewStore clients,
+// which keep their stores in it.
+//
+//	stowd -db data.db -bucket people -addr :7070
+package main
+
+import (
+	"flag"
+	"log"
+	"net"
+
+	"github.com/djherbis/stow/v4"
+	"github.com/djherbis/stow/v4/stowd"
+	"github.com/djherbis/stow/v4/stowd/stowpb"
+	bolt "go.etcd.io/bbolt"
+	"google.golang.org/grpc"
+)
+
+func main() {
+	path := flag.String("db", "stow.db", "bolt database file, created if needed")
+	bucket := flag.String("bucket", "stow", "bucket to serve")
+	addr := flag.String("addr", ":7070", "address to listen on")
+	flag.Parse()
+
+	db, err := bolt.Open(*path, 0600, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	lis, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	srv := grpc.NewServer()
+	stowpb.RegisterStowServer(srv, stowd.NewServer(stow.NewBoltKV(db, []byte(*bucket))))
+	log.Printf("serving bucket %q of %s on %s", *bucket, *path, lis.Addr())
+	if err := srv.Serve(lis); err != nil {
+		log.Fatal(err)
+	}
+}
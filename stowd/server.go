@@ -0,0 +1,218 @@
+// Package stowd serves a stow.KVBackend over gRPC, so processes on other machines can share
+// bolt-backed stores, and provides the client side: a stow.KVBackend speaking the protocol,
+// which NewStore puts stores on.
+//
+// Values are encoded by the clients, the server stores the bytes it's sent. Clients which
+// share a store should use the same Codec, and the same one as a stow.Store reading the
+// bucket directly, if any.
+package stowd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/djherbis/stow/v4"
+	"github.com/djherbis/stow/v4/stowd/stowpb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// watchBuffer is the number of events buffered for a watcher. A watcher which falls further
+// behind is ended with the ResourceExhausted status, rather than holding up writes.
+const watchBuffer = 256
+
+var (
+	errConflict = errors.New("stowd: reads changed before commit")
+	errStopScan = errors.New("stowd: scan limit reached")
+)
+
+// Server implements stowpb.StowServer on a stow.KVBackend, like the one returned by
+// stow.NewBoltKV. Register it with stowpb.RegisterStowServer.
+//
+// Watchers only see the writes made through the Server.
+type Server struct {
+	stowpb.UnimplementedStowServer
+
+	backend stow.KVBackend
+
+	mu       sync.Mutex
+	watchers map[*watcher]struct{}
+}
+
+type watcher struct {
+	prefix []byte
+	events chan *stowpb.WatchEvent
+	// slow is closed when events overflows.
+	slow chan struct{}
+}
+
+var _ stowpb.StowServer = (*Server)(nil)
+
+// NewServer returns a Server for backend.
+func NewServer(backend stow.KVBackend) *Server {
+	return &Server{backend: backend, watchers: make(map[*watcher]struct{})}
+}
+
+// Get returns the value of a key, or the NotFound status.
+func (s *Server) Get(ctx context.Context, req *stowpb.GetRequest) (*stowpb.GetResponse, error) {
+	var value []byte
+	err := s.backend.View(func(tx stow.KVTx) error {
+		v := tx.Get(req.Key)
+		if v == nil {
+			return status.Error(codes.NotFound, stow.ErrNotFound.Error())
+		}
+		value = append([]byte{}, v...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &stowpb.GetResponse{Value: value}, nil
+}
+
+// Put sets the value of a key.
+func (s *Server) Put(ctx context.Context, req *stowpb.PutRequest) (*stowpb.PutResponse, error) {
+	if err := s.commit(nil, []*stowpb.Write{{Key: req.Key, Value: req.Value}}); err != nil {
+		return nil, err
+	}
+	return &stowpb.PutResponse{}, nil
+}
+
+// Delete removes a key.
+func (s *Server) Delete(ctx context.Context, req *stowpb.DeleteRequest) (*stowpb.DeleteResponse, error) {
+	if err := s.commit(nil, []*stowpb.Write{{Key: req.Key, Delete: true}}); err != nil {
+		return nil, err
+	}
+	return &stowpb.DeleteResponse{}, nil
+}
+
+// Commit applies the writes of a transaction if its reads still hold, or fails with the
+// Aborted status.
+func (s *Server) Commit(ctx context.Context, req *stowpb.CommitRequest) (*stowpb.CommitResponse, error) {
+	if err := s.commit(req.Reads, req.Writes); err != nil {
+		return nil, err
+	}
+	return &stowpb.CommitResponse{}, nil
+}
+
+func (s *Server) commit(reads []*stowpb.Read, writes []*stowpb.Write) error {
+	err := s.backend.Update(func(tx stow.KVTx) error {
+		for _, r := range reads {
+			v := tx.Get(r.Key)
+			if (v != nil) != r.Found || !bytes.Equal(v, r.Value) {
+				return errConflict
+			}
+		}
+		for _, w := range writes {
+			var err error
+			if w.Delete {
+				err = tx.Delete(w.Key)
+			} else {
+				// Keep empty values apart from missing ones.
+				err = tx.Put(w.Key, append([]byte{}, w.Value...))
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == errConflict {
+		return status.Error(codes.Aborted, err.Error())
+	}
+	if err == nil {
+		s.publish(writes)
+	}
+	return err
+}
+
+// Scan streams the keys with the prefix and their values, from the start key and up to the
+// limit, from one transaction.
+func (s *Server) Scan(req *stowpb.ScanRequest, stream stowpb.Stow_ScanServer) error {
+	start := req.Start
+	if !bytes.HasPrefix(start, req.Prefix) {
+		if bytes.Compare(start, req.Prefix) > 0 {
+			// Every key with the prefix is before start.
+			return nil
+		}
+		start = nil
+	}
+	var sent uint32
+	err := s.backend.View(func(tx stow.KVTx) error {
+		return tx.ForEach(req.Prefix, start, func(k, v []byte) error {
+			if req.Limit > 0 && sent == req.Limit {
+				return errStopScan
+			}
+			sent++
+			return stream.Send(&stowpb.KeyValue{Key: k, Value: v})
+		})
+	})
+	if err == errStopScan {
+		return nil
+	}
+	return err
+}
+
+// Watch streams the writes to the keys with the prefix, until the client goes away.
+func (s *Server) Watch(req *stowpb.WatchRequest, stream stowpb.Stow_WatchServer) error {
+	w := &watcher{
+		prefix: req.Prefix,
+		events: make(chan *stowpb.WatchEvent, watchBuffer),
+		slow:   make(chan struct{}),
+	}
+	s.mu.Lock()
+	s.watchers[w] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.watchers, w)
+		s.mu.Unlock()
+	}()
+
+	for {
+		select {
+		case e := <-w.events:
+			if err := stream.Send(e); err != nil {
+				return err
+			}
+		case <-w.slow:
+			return status.Error(codes.ResourceExhausted, "stowd: watcher fell behind")
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+	}
+}
+
+// publish sends the committed writes to the watchers.
+func (s *Server) publish(writes []*stowpb.Write) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for w := range s.watchers {
+		if !w.send(writes) {
+			close(w.slow)
+			delete(s.watchers, w)
+		}
+	}
+}
+
+// send queues the events of the writes to keys with w's prefix, it returns false if w's
+// buffer is full.
+func (w *watcher) send(writes []*stowpb.Write) bool {
+	for _, write := range writes {
+		if !bytes.HasPrefix(write.Key, w.prefix) {
+			continue
+		}
+		e := &stowpb.WatchEvent{Key: write.Key, Deleted: write.Delete}
+		if !write.Delete {
+			e.Value = write.Value
+		}
+		select {
+		case w.events <- e:
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,223 @@
+package stowd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/djherbis/stow/v4"
+	"github.com/djherbis/stow/v4/stowd/stowpb"
+	bolt "go.etcd.io/bbolt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+type person struct {
+	Name string
+}
+
+// serve starts a Server with opts on a temporary bolt database, and returns a connection to it.
+func serve(t *testing.T, opts ...grpc.ServerOption) *grpc.ClientConn {
+	db, err := bolt.Open(filepath.Join(t.TempDir(), "stowd.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer(opts...)
+	stowpb.RegisterStowServer(srv, NewServer(stow.NewBoltKV(db, []byte("stowd"))))
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestStore(t *testing.T) {
+	conn := serve(t)
+	s := NewStore(conn, []byte("people"), stow.JSONCodec{})
+
+	for _, name := range []string{"bob", "ann"} {
+		if err := s.Put(name, person{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var p person
+	if err := s.Get("ann", &p); err != nil || p.Name != "ann" {
+		t.Errorf("unexpected value %v %v", p, err)
+	}
+	if err := s.Get("carl", &p); err != stow.ErrNotFound {
+		t.Errorf("expected ErrNotFound got %v", err)
+	}
+
+	// Another client shares the objects.
+	other := NewStore(conn, []byte("people"), stow.JSONCodec{})
+	var names []string
+	err := other.ForEach(func(key string, p person) { names = append(names, p.Name) })
+	if err != nil || len(names) != 2 || names[0] != "ann" || names[1] != "bob" {
+		t.Errorf("unexpected objects %v %v", names, err)
+	}
+
+	if err := other.Pull("bob", &p); err != nil || p.Name != "bob" {
+		t.Errorf("unexpected pull %v %v", p, err)
+	}
+	if has, _ := s.Has("bob"); has {
+		t.Errorf("pulled object is still there")
+	}
+	if err := s.DeleteAll(); err != nil {
+		t.Fatal(err)
+	}
+	if keys, _ := other.Keys(); len(keys) != 0 {
+		t.Errorf("expected no keys got %q", keys)
+	}
+}
+
+func TestConcurrentPull(t *testing.T) {
+	conn := serve(t)
+	s := NewStore(conn, []byte("people"), stow.JSONCodec{})
+	s.Put("job", person{Name: "ann"})
+
+	// Only one of the concurrent pulls gets the object.
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	pulled := 0
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var p person
+			if err := NewStore(conn, []byte("people"), stow.JSONCodec{}).Pull("job", &p); err == nil {
+				mu.Lock()
+				pulled++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if pulled != 1 {
+		t.Errorf("expected 1 pull got %d", pulled)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	conn := serve(t)
+	c := NewClient(conn)
+	s := stow.NewBackendStore(stow.NewKVBackend(c), []byte("people"), stow.JSONCodec{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	events := make(chan Event)
+	go c.Watch(ctx, []byte("people"), []byte("a"), func(e Event) error {
+		select {
+		case events <- e:
+		case <-ctx.Done():
+		}
+		return nil
+	})
+
+	// Keep writing until the watch is registered.
+	tick := time.NewTicker(10 * time.Millisecond)
+	defer tick.Stop()
+	for done := false; !done; {
+		select {
+		case <-tick.C:
+			s.Put("b", person{Name: "bob"})
+			s.Put("ann", person{Name: "ann"})
+		case e := <-events:
+			if string(e.Key) != "ann" || !bytes.Contains(e.Value, []byte("ann")) || e.Deleted {
+				t.Errorf("unexpected event %v", e)
+			}
+			done = true
+		case <-ctx.Done():
+			t.Fatal("no event")
+		}
+	}
+}
+
+// countingStream counts the messages a server stream sends.
+type countingStream struct {
+	grpc.ServerStream
+	sent *int64
+}
+
+func (s countingStream) SendMsg(m interface{}) error {
+	atomic.AddInt64(s.sent, 1)
+	return s.ServerStream.SendMsg(m)
+}
+
+func TestScanPages(t *testing.T) {
+	var sent int64
+	conn := serve(t, grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, countingStream{ServerStream: ss, sent: &sent})
+	}))
+	s := NewStore(conn, []byte("people"), stow.JSONCodec{})
+	const n = 600
+	for i := 0; i < n; i++ {
+		if err := s.Put(fmt.Sprintf("%04d", i), person{Name: "ann"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	atomic.StoreInt64(&sent, 0)
+	count := 0
+	if err := s.ForEach(func(key string, p person) { count++ }); err != nil || count != n {
+		t.Fatalf("unexpected objects %d %v", count, err)
+	}
+	// Each key is streamed once, not once for each batch of the iteration.
+	if sent := atomic.LoadInt64(&sent); sent > n+scanPage {
+		t.Errorf("expected about %d keys streamed got %d", n, sent)
+	}
+}
+
+func TestScanReads(t *testing.T) {
+	conn := serve(t)
+	c := NewClient(conn)
+	err := c.Update(func(tx stow.KVTx) error {
+		for _, k := range []string{"a", "b", "c"} {
+			if err := tx.Put([]byte(k), []byte(k)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The Update only reads "a", so another client writing "c" isn't a conflict.
+	runs := 0
+	err = c.Update(func(tx stow.KVTx) error {
+		runs++
+		err := tx.ForEach(nil, nil, func(k, v []byte) error {
+			return errStopScan
+		})
+		if err != errStopScan {
+			return err
+		}
+		if runs == 1 {
+			if err := NewClient(conn).Update(func(tx stow.KVTx) error {
+				return tx.Put([]byte("c"), []byte("changed"))
+			}); err != nil {
+				return err
+			}
+		}
+		return tx.Put([]byte("d"), []byte("d"))
+	})
+	if err != nil || runs != 1 {
+		t.Errorf("expected 1 run got %d %v", runs, err)
+	}
+}
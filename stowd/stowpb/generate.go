@@ -0,0 +1,4 @@
+// Package stowpb holds the gRPC protocol stowd serves, generated from stow.proto.
+package stowpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative stow.proto
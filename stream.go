@@ -0,0 +1,221 @@
+package stow
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"io/ioutil"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// streamsBucket holds the streams of a store in its meta bucket, one bucket per key. Each has
+// a bucket per generation of the stream, holding its chunks, and currentStreamKey names
+// the generation readers see.
+var streamsBucket = []byte("\x00streams")
+
+var currentStreamKey = []byte("current")
+
+const (
+	// streamChunkSize is the size of the chunks streams are stored in.
+	streamChunkSize = 64 * 1024
+
+	// streamBatchChunks is the number of chunks PutReader writes per transaction.
+	streamBatchChunks = 16
+)
+
+// PutReader stores the data read from r until io.EOF as the stream at key, replacing any
+// previous stream at key. The data is stored as it is, without going through the Codec, in
+// chunks written in transactions of a bounded size, so payloads of any size can be stored
+// without holding them in memory. The new stream only replaces the old one once r has been
+// read completely, readers never see a partial stream, and if PutReader fails the old
+// stream is left in place.
+//
+// Streams are kept apart from the objects of the store: Get, ForEach and the others don't
+// see them, read them with GetReader and delete them with DeleteStream or DeleteAll.
+func (s *Store) PutReader(key []byte, r io.Reader) error {
+	key = s.nsKey(key)
+	spec := s.bucket.meta().child(streamsBucket).child(key)
+
+	var gen []byte
+	err := s.update(func(tx BackendTx) error {
+		stream, err := spec.createOrGetUntracked(tx)
+		if err != nil {
+			return err
+		}
+		seq, err := stream.NextSequence()
+		if err != nil {
+			return err
+		}
+		gen = orderedUint(seq)
+		_, err = stream.CreateBucket(gen)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if err = s.writeStream(spec, gen, r); err == nil {
+		err = s.update(func(tx BackendTx) error {
+			stream := spec.get(tx)
+			if stream == nil {
+				return ErrNotFound
+			}
+			if old := stream.Get(currentStreamKey); old != nil {
+				if err := stream.DeleteBucket(old); err != nil && err != bolt.ErrBucketNotFound {
+					return err
+				}
+			}
+			return stream.Put(currentStreamKey, gen)
+		})
+	}
+	if err != nil {
+		// Remove the partial generation, the stream may be gone altogether.
+		s.update(func(tx BackendTx) error {
+			if stream := spec.get(tx); stream != nil {
+				stream.DeleteBucket(gen)
+			}
+			return nil
+		})
+	}
+	return err
+}
+
+// writeStream writes the chunks read from r to the generation gen of the stream in spec.
+func (s *Store) writeStream(spec bucketSpec, gen []byte, r io.Reader) error {
+	var n uint64
+	for done := false; !done; {
+		var chunks [][]byte
+		for len(chunks) < streamBatchChunks {
+			chunk := make([]byte, streamChunkSize)
+			read, err := io.ReadFull(r, chunk)
+			if read > 0 {
+				chunks = append(chunks, chunk[:read])
+			}
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				done = true
+				break
+			}
+			if err != nil {
+				return err
+			}
+		}
+		if len(chunks) == 0 {
+			return nil
+		}
+
+		err := s.update(func(tx BackendTx) error {
+			stream := spec.get(tx)
+			if stream == nil || stream.Bucket(gen) == nil {
+				return ErrNotFound
+			}
+			chunksBucket := stream.Bucket(gen)
+			for i, chunk := range chunks {
+				if err := chunksBucket.Put(orderedUint(n+uint64(i)), chunk); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		n += uint64(len(chunks))
+	}
+	return nil
+}
+
+// GetReader returns a reader of the stream stored at key by PutReader, or ErrNotFound if
+// there's none. The reader reads from a read-only transaction, so it sees the stream as it
+// was when GetReader was called even if it's replaced meanwhile, and it must be closed to
+// end that transaction. Like any transaction it must only be used by one goroutine at a time.
+// On a Backend other than bolt, which can't keep a transaction open, the stream is read into
+// memory at once.
+func (s *Store) GetReader(key []byte) (io.ReadCloser, error) {
+	key = s.nsKey(key)
+	db, ok := s.boltDB()
+	if !ok {
+		var buf bytes.Buffer
+		err := s.db.View(func(tx BackendTx) error {
+			chunks := s.streamChunks(tx, key)
+			if chunks == nil {
+				return ErrNotFound
+			}
+			return chunks.ForEach(func(k, v []byte) error {
+				buf.Write(v)
+				return nil
+			})
+		})
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.NopCloser(&buf), nil
+	}
+
+	tx, err := db.Begin(false)
+	if err != nil {
+		return nil, err
+	}
+	chunks := s.streamChunks(boltTx{tx}, key)
+	if chunks == nil {
+		tx.Rollback()
+		return nil, ErrNotFound
+	}
+	return &streamReader{tx: tx, c: chunks.Cursor()}, nil
+}
+
+// streamChunks returns the bucket of the chunks of the current stream at key, if any.
+func (s *Store) streamChunks(tx BackendTx, key []byte) BackendBucket {
+	if stream := s.bucket.meta().child(streamsBucket).child(key).get(tx); stream != nil {
+		if gen := stream.Get(currentStreamKey); gen != nil {
+			return stream.Bucket(gen)
+		}
+	}
+	return nil
+}
+
+// DeleteStream removes the stream stored at key by PutReader.
+// It returns nil if there was none.
+func (s *Store) DeleteStream(key []byte) error {
+	key = s.nsKey(key)
+	return s.update(func(tx BackendTx) error {
+		return s.bucket.meta().child(streamsBucket).child(key).deleteIfExists(tx)
+	})
+}
+
+type streamReader struct {
+	tx      *bolt.Tx
+	c       BackendCursor
+	chunk   []byte
+	started bool
+}
+
+func (r *streamReader) Read(p []byte) (n int, err error) {
+	if r.tx == nil {
+		return 0, fs.ErrClosed
+	}
+	for len(r.chunk) == 0 {
+		var k []byte
+		if !r.started {
+			k, r.chunk = r.c.First()
+			r.started = true
+		} else {
+			k, r.chunk = r.c.Next()
+		}
+		if k == nil {
+			return 0, io.EOF
+		}
+	}
+	n = copy(p, r.chunk)
+	r.chunk = r.chunk[n:]
+	return n, nil
+}
+
+func (r *streamReader) Close() error {
+	if r.tx == nil {
+		return nil
+	}
+	err := r.tx.Rollback()
+	r.tx, r.chunk = nil, nil
+	return err
+}
@@ -0,0 +1,72 @@
+package stow
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func TestStreams(t *testing.T) {
+	s := NewJSONStore(db, []byte("streams"))
+	defer s.DeleteAll()
+
+	if _, err := s.GetReader([]byte("payload")); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound got %v", err)
+	}
+
+	payload := bytes.Repeat([]byte("0123456789"), streamChunkSize*streamBatchChunks/5)
+	if err := s.PutReader([]byte("payload"), bytes.NewReader(payload)); err != nil {
+		t.Fatal(err)
+	}
+	r, err := s.GetReader([]byte("payload"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Replacing the stream doesn't affect open readers.
+	if err := s.PutReader([]byte("payload"), bytes.NewReader([]byte("replaced"))); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil || !bytes.Equal(got, payload) {
+		t.Errorf("unexpected stream of %d bytes %v", len(got), err)
+	}
+	r.Close()
+	if _, err := r.Read(make([]byte, 1)); err == nil {
+		t.Errorf("expected error reading a closed stream")
+	}
+
+	r, _ = s.GetReader([]byte("payload"))
+	if got, _ := ioutil.ReadAll(r); string(got) != "replaced" {
+		t.Errorf("unexpected stream %q", got)
+	}
+	r.Close()
+
+	// A failed PutReader leaves the previous stream.
+	broken := io.MultiReader(bytes.NewReader(payload), &errReader{errors.New("broken")})
+	if err := s.PutReader([]byte("payload"), broken); err == nil || err.Error() != "broken" {
+		t.Errorf("expected read error got %v", err)
+	}
+	r, _ = s.GetReader([]byte("payload"))
+	if got, _ := ioutil.ReadAll(r); string(got) != "replaced" {
+		t.Errorf("unexpected stream after failed put %q", got)
+	}
+	r.Close()
+
+	if has, _ := s.Has("payload"); has {
+		t.Errorf("streams should be apart from objects")
+	}
+
+	if err := s.DeleteStream([]byte("payload")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetReader([]byte("payload")); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound after delete got %v", err)
+	}
+}
+
+type errReader struct{ err error }
+
+func (r *errReader) Read([]byte) (int, error) { return 0, r.err }
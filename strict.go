@@ -0,0 +1,129 @@
+package stow
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"time"
+)
+
+// ErrLossyEncoding indicates a value which changed when it was encoded and decoded with the
+// store's Codec, see WithStrictEncoding.
+var ErrLossyEncoding = errors.New("value does not survive encoding")
+
+// WithStrictEncoding makes every write decode the value it just encoded and compare the result
+// with the original, failing with ErrLossyEncoding when they differ. This catches types that
+// silently lose data under the store's Codec, like unexported fields with gob or numbers
+// in interface{} fields (which come back as float64) with json. It at least doubles the cost of writes, so it's meant for tests and
+// debug builds. Nil and empty slices and maps are considered equal, and times are compared
+// with time.Time.Equal.
+func WithStrictEncoding() Option {
+	return func(s *Store) {
+		s.opts.strict = true
+	}
+}
+
+// marshalValue encodes an object for storage, checking that it round-trips in strict mode.
+func (s *Store) marshalValue(val interface{}) ([]byte, error) {
+	buf, err := s.encodeValue(val)
+	defer releaseBuffer(buf)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), buf.Bytes()...), nil
+}
+
+// encodeValue works like marshalValue, but returns the encoding in a buffer from pool, see
+// encode. The buffer must be released even if encodeValue fails.
+func (s *Store) encodeValue(val interface{}) (*bytes.Buffer, error) {
+	buf, err := s.encode(val)
+	if err != nil || !s.opts.strict {
+		s.addChecksumTo(buf)
+		return buf, err
+	}
+
+	original := indirect(reflect.ValueOf(val))
+	if !original.IsValid() {
+		s.addChecksumTo(buf)
+		return buf, nil
+	}
+	decoded := reflect.New(original.Type())
+	if err := s.unmarshal(buf.Bytes(), decoded.Interface()); err != nil {
+		return buf, fmt.Errorf("%w: %T: %v", ErrLossyEncoding, val, err)
+	}
+	if !roundTripEqual(original, decoded.Elem(), true) {
+		return buf, fmt.Errorf("%w: %T", ErrLossyEncoding, val)
+	}
+	s.addChecksumTo(buf)
+	return buf, nil
+}
+
+// roundTripEqual works like reflect.DeepEqual, but with the relaxations WithStrictEncoding
+// documents. exported is false for values reached through unexported fields, whose methods
+// can't be called.
+func roundTripEqual(a, b reflect.Value, exported bool) bool {
+	if !a.IsValid() || !b.IsValid() {
+		return a.IsValid() == b.IsValid()
+	}
+	if a.Type() != b.Type() {
+		return false
+	}
+	if a.Type() == timeType && exported {
+		return a.Interface().(time.Time).Equal(b.Interface().(time.Time))
+	}
+
+	switch a.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if a.IsNil() || b.IsNil() {
+			return a.IsNil() == b.IsNil()
+		}
+		return roundTripEqual(a.Elem(), b.Elem(), exported)
+	case reflect.Struct:
+		for i := 0; i < a.NumField(); i++ {
+			fieldExported := exported && a.Type().Field(i).PkgPath == ""
+			if !roundTripEqual(a.Field(i), b.Field(i), fieldExported) {
+				return false
+			}
+		}
+		return true
+	case reflect.Slice, reflect.Array:
+		if a.Len() != b.Len() {
+			return false
+		}
+		for i := 0; i < a.Len(); i++ {
+			if !roundTripEqual(a.Index(i), b.Index(i), exported) {
+				return false
+			}
+		}
+		return true
+	case reflect.Map:
+		if a.Len() != b.Len() {
+			return false
+		}
+		iter := a.MapRange()
+		for iter.Next() {
+			if !roundTripEqual(iter.Value(), b.MapIndex(iter.Key()), exported) {
+				return false
+			}
+		}
+		return true
+	case reflect.Float32, reflect.Float64:
+		x, y := a.Float(), b.Float()
+		return x == y || math.IsNaN(x) && math.IsNaN(y)
+	case reflect.Complex64, reflect.Complex128:
+		return a.Complex() == b.Complex()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return a.Int() == b.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return a.Uint() == b.Uint()
+	case reflect.String:
+		return a.String() == b.String()
+	case reflect.Bool:
+		return a.Bool() == b.Bool()
+	case reflect.Func, reflect.Chan, reflect.UnsafePointer:
+		return a.IsNil() && b.IsNil()
+	}
+	return false
+}
@@ -0,0 +1,42 @@
+package stow
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+type strictValue struct {
+	Name    string
+	Tags    []string
+	Created time.Time
+	Extra   interface{}
+	hidden  int
+}
+
+func TestStrictEncoding(t *testing.T) {
+	for _, s := range []*Store{
+		NewJSONStore(db, []byte("strict-json"), WithStrictEncoding()),
+		NewStore(db, []byte("strict-gob"), WithStrictEncoding()),
+	} {
+		if err := s.Put("ok", strictValue{Name: "ok", Tags: []string{}, Created: time.Now()}); err != nil {
+			t.Errorf("unexpected error for value which round-trips: %v", err)
+		}
+		if err := s.Put("hidden", &strictValue{hidden: 1}); !errors.Is(err, ErrLossyEncoding) {
+			t.Errorf("expected ErrLossyEncoding for unexported field, got %v", err)
+		}
+		if has, _ := s.Has("hidden"); has {
+			t.Errorf("lossy Put should not write")
+		}
+		s.DeleteAll()
+	}
+
+	s := NewJSONStore(db, []byte("strict-json"), WithStrictEncoding())
+	defer s.DeleteAll()
+	if err := s.Put("extra", strictValue{Extra: 1}); !errors.Is(err, ErrLossyEncoding) {
+		t.Errorf("expected ErrLossyEncoding for int in interface{}, got %v", err)
+	}
+	if err := NewJSONStore(db, []byte("strict-json")).Put("extra", strictValue{Extra: 1}); err != nil {
+		t.Errorf("expected lossy values to be written without strict encoding: %v", err)
+	}
+}
@@ -0,0 +1,269 @@
+package stow
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Expiration times are kept in two meta buckets: one maps keys to their expiration time,
+// the other is ordered by expiration time (time + key) so Sweep can find expired keys quickly.
+var (
+	ttlKeysBucket   = []byte("\x00ttl.keys")
+	ttlExpiryBucket = []byte("\x00ttl.expiry")
+)
+
+// PutTTL works like Put, but the object expires after ttl. Expired objects are removed by
+// Sweep, which can be run periodically with StartSweeper. A ttl <= 0 means the object never
+// expires, and a regular Put of the same key will replace its expiration with the store default.
+func (s *Store) PutTTL(key interface{}, b interface{}, ttl time.Duration) error {
+	keyBytes, err := s.toBytes(key)
+	if err != nil {
+		return err
+	}
+	return s.putTTL(keyBytes, b, ttl)
+}
+
+// Touch gives key "key" a new time-to-live of ttl starting now, a ttl <= 0 means it never
+// expires. It returns ErrNotFound if the key isn't in the store or has already expired.
+func (s *Store) Touch(key interface{}, ttl time.Duration) error {
+	keyBytes, err := s.toBytes(key)
+	if err != nil {
+		return err
+	}
+	return s.update(func(tx BackendTx) error {
+		return s.touch(tx, keyBytes, ttl)
+	})
+}
+
+func (s *Store) touch(tx BackendTx, key []byte, ttl time.Duration) error {
+	objects := s.bucket.get(tx)
+	if objects == nil || objects.Get(key) == nil || s.expiryCheck(tx)(key) {
+		return ErrNotFound
+	}
+	return s.setExpiry(tx, key, ttl)
+}
+
+// slide renews the ttl of key after a read, for stores using WithSlidingTTL. ttl is the one
+// the read saw, there's no write if it's zero.
+func (s *Store) slide(key []byte, ttl time.Duration) error {
+	if s.opts.readOnly || ttl <= 0 {
+		return nil
+	}
+	return s.update(func(tx BackendTx) error {
+		// The ttl may have changed since the read.
+		ttl := s.ttlOf(tx, key)
+		if ttl <= 0 {
+			return nil
+		}
+		if err := s.touch(tx, key, ttl); err != ErrNotFound {
+			return err
+		}
+		return nil
+	})
+}
+
+// Sweep removes all objects whose time-to-live has passed, and returns how many were removed.
+// With WithSweepBatchSize the objects are removed over several transactions, if one of them
+// fails the objects removed by the earlier ones stay removed.
+func (s *Store) Sweep() (n int, err error) {
+	now := encodeTime(time.Now())
+	for {
+		removed, more, err := s.sweep(now, s.opts.sweepBatch)
+		n += removed
+		if err != nil || !more {
+			return n, err
+		}
+	}
+}
+
+// sweep removes up to limit objects which expired before now (or all of them if limit <= 0),
+// and reports whether there may be more left.
+func (s *Store) sweep(now []byte, limit int) (n int, more bool, err error) {
+	err = s.update(func(tx BackendTx) error {
+		expiry := s.bucket.meta().child(ttlExpiryBucket).get(tx)
+		if expiry == nil {
+			return nil
+		}
+
+		var expired [][]byte
+		c := expiry.Cursor()
+		for k, _ := c.First(); k != nil && bytes.Compare(k[:timeLength], now) <= 0; k, _ = c.Next() {
+			if limit > 0 && len(expired) == limit {
+				more = true
+				break
+			}
+			expired = append(expired, append([]byte(nil), k...))
+		}
+
+		objects := s.bucket.get(tx)
+		for _, k := range expired {
+			key := k[timeLength:]
+			if objects != nil && objects.Get(key) != nil {
+				if err := s.deleteKey(tx, objects, key); err != nil {
+					return err
+				}
+				n++
+				continue
+			}
+			if err := s.clearExpiry(tx, key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, false, err
+	}
+	return n, more, nil
+}
+
+// StartSweeper starts a goroutine which calls Sweep every interval, until the returned
+// stop func is called. Errors are not reported, the sweep is simply retried at the next interval.
+func (s *Store) StartSweeper(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.Sweep()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			wg.Wait()
+		})
+	}
+}
+
+// expiryCheck returns a func which reports whether a key has expired. Reads treat expired
+// objects as missing even if Sweep hasn't removed them yet.
+func (s *Store) expiryCheck(tx BackendTx) func(key []byte) bool {
+	return s.bucket.expiryCheck(tx)
+}
+
+func (bs bucketSpec) expiryCheck(tx BackendTx) func(key []byte) bool {
+	keys := bs.meta().child(ttlKeysBucket).get(tx)
+	if keys == nil {
+		return func([]byte) bool { return false }
+	}
+
+	now := encodeTime(time.Now())
+	return func(key []byte) bool {
+		expires := keys.Get(key)
+		return len(expires) >= timeLength && bytes.Compare(expires[:timeLength], now) <= 0
+	}
+}
+
+// afterExpiredRead deletes the expired keys found by a read when WithDeleteExpiredOnRead is set.
+func (s *Store) afterExpiredRead(err error, expired [][]byte) error {
+	if err != nil || len(expired) == 0 || !s.opts.deleteExpired {
+		return err
+	}
+	return s.deleteExpired(expired)
+}
+
+// deleteExpired removes keys which are (still) expired.
+func (s *Store) deleteExpired(keys [][]byte) error {
+	if s.opts.readOnly {
+		return nil
+	}
+	return s.update(func(tx BackendTx) error {
+		objects := s.bucket.get(tx)
+		if objects == nil {
+			return nil
+		}
+		isExpired := s.expiryCheck(tx)
+		for _, key := range keys {
+			if isExpired(key) {
+				if err := s.deleteKey(tx, objects, key); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// setExpiry makes key expire after ttl, or never if ttl <= 0.
+func (s *Store) setExpiry(tx BackendTx, key []byte, ttl time.Duration) error {
+	if ttl <= 0 {
+		return s.clearExpiry(tx, key)
+	}
+
+	meta := s.bucket.meta()
+	keys, err := meta.child(ttlKeysBucket).createOrGetUntracked(tx)
+	if err != nil {
+		return err
+	}
+	expiry, err := meta.child(ttlExpiryBucket).createOrGetUntracked(tx)
+	if err != nil {
+		return err
+	}
+
+	if old := keys.Get(key); len(old) >= timeLength {
+		if err := expiry.Delete(timeKey(old[:timeLength], key)); err != nil {
+			return err
+		}
+	}
+
+	// The ttl itself is kept after the expiration time, so that sliding expiration can renew it.
+	expiresAt := time.Now().Add(ttl)
+	if s.opts.ttlJitter > 0 {
+		expiresAt = expiresAt.Add(time.Duration(rand.Int63n(int64(s.opts.ttlJitter))))
+	}
+	expires := encodeTime(expiresAt)
+	value := make([]byte, 2*timeLength)
+	copy(value, expires)
+	binary.BigEndian.PutUint64(value[timeLength:], uint64(ttl))
+	if err := keys.Put(key, value); err != nil {
+		return err
+	}
+	return expiry.Put(timeKey(expires, key), []byte{})
+}
+
+// ttlOf returns the ttl key was last given, or zero if it doesn't expire.
+func (s *Store) ttlOf(tx BackendTx, key []byte) time.Duration {
+	keys := s.bucket.meta().child(ttlKeysBucket).get(tx)
+	if keys == nil {
+		return 0
+	}
+	if data := keys.Get(key); len(data) == 2*timeLength {
+		return time.Duration(binary.BigEndian.Uint64(data[timeLength:]))
+	}
+	return 0
+}
+
+// clearExpiry removes any expiration time set for key.
+func (s *Store) clearExpiry(tx BackendTx, key []byte) error {
+	meta := s.bucket.meta()
+	keys := meta.child(ttlKeysBucket).get(tx)
+	if keys == nil {
+		return nil
+	}
+
+	old := keys.Get(key)
+	if len(old) < timeLength {
+		return nil
+	}
+
+	if expiry := meta.child(ttlExpiryBucket).get(tx); expiry != nil {
+		if err := expiry.Delete(timeKey(old[:timeLength], key)); err != nil {
+			return err
+		}
+	}
+	return keys.Delete(key)
+}
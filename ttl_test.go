@@ -0,0 +1,225 @@
+package stow
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTTL(t *testing.T) {
+	s := NewJSONStore(db, []byte("ttl"))
+	defer s.DeleteAll()
+
+	s.PutTTL("short", "value", time.Nanosecond)
+	s.PutTTL("long", "value", time.Hour)
+	s.Put("forever", "value")
+	s.PutTTL("replaced", "value", time.Nanosecond)
+	s.Put("replaced", "value")
+
+	time.Sleep(time.Millisecond)
+
+	if n, err := s.Sweep(); err != nil || n != 1 {
+		t.Errorf("expected 1 expired object: %d %v", n, err)
+	}
+
+	for key, found := range map[string]bool{"short": false, "long": true, "forever": true, "replaced": true} {
+		if has, _ := s.Has(key); has != found {
+			t.Errorf("expected Has(%s) to be %v", key, found)
+		}
+	}
+
+	if n, err := s.Sweep(); err != nil || n != 0 {
+		t.Errorf("expected nothing to sweep: %d %v", n, err)
+	}
+}
+
+func TestDefaultTTL(t *testing.T) {
+	s := NewJSONStore(db, []byte("default_ttl"), WithTTL(time.Nanosecond))
+	defer s.DeleteAll()
+
+	s.Put("a", "value")
+	s.Put("b", "value")
+	s.PutTTL("c", "value", time.Hour)
+	s.Put("d", "value")
+	s.Delete("d")
+
+	stop := s.StartSweeper(time.Millisecond)
+	defer stop()
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if keys, _ := s.Keys(); len(keys) == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	stop()
+
+	keys, err := s.Keys()
+	if err != nil || len(keys) != 1 || string(keys[0]) != "c" {
+		t.Errorf("sweeper did not expire objects: %q %v", keys, err)
+	}
+}
+
+func TestTouch(t *testing.T) {
+	s := NewJSONStore(db, []byte("touch"))
+	defer s.DeleteAll()
+
+	if err := s.Touch("missing", time.Hour); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound got %v", err)
+	}
+
+	s.PutTTL("a", "value", 50*time.Millisecond)
+	s.PutTTL("b", "value", 50*time.Millisecond)
+	if err := s.Touch("a", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Touch("b", 0); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	if n, err := s.Sweep(); err != nil || n != 0 {
+		t.Errorf("touched objects should not expire: %d %v", n, err)
+	}
+}
+
+func TestSlidingTTL(t *testing.T) {
+	s := NewJSONStore(db, []byte("sliding_ttl"), WithSlidingTTL(), WithTTL(100*time.Millisecond))
+	defer s.DeleteAll()
+
+	s.Put("read", "value")
+	s.Put("idle", "value")
+
+	var v string
+	for i := 0; i < 4; i++ {
+		time.Sleep(40 * time.Millisecond)
+		if err := s.Get("read", &v); err != nil {
+			t.Fatalf("read object expired: %v", err)
+		}
+	}
+
+	if err := s.Get("idle", &v); err != ErrNotFound {
+		t.Errorf("idle object should expire: %v", err)
+	}
+}
+
+// updateCounter is a Backend which counts its write transactions.
+type updateCounter struct {
+	Backend
+	updates int
+}
+
+func (b *updateCounter) Update(fn func(tx BackendTx) error) error {
+	b.updates++
+	return b.Backend.Update(fn)
+}
+
+func TestSlidingTTLWithoutTTL(t *testing.T) {
+	backend := &updateCounter{Backend: NewMemBackend()}
+	s := NewBackendStore(backend, []byte("sliding_ttl"), JSONCodec{}, WithSlidingTTL())
+	s.Put("forever", "value")
+	s.PutTTL("ttl", "value", time.Hour)
+
+	backend.updates = 0
+	var v string
+	for i := 0; i < 10; i++ {
+		if err := s.Get("forever", &v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if backend.updates != 0 {
+		t.Errorf("expected no writes for an object without ttl got %d", backend.updates)
+	}
+	if err := s.Get("ttl", &v); err != nil {
+		t.Fatal(err)
+	}
+	if backend.updates != 1 {
+		t.Errorf("expected 1 write for an object with a ttl got %d", backend.updates)
+	}
+}
+
+func TestLazyExpiration(t *testing.T) {
+	for _, deleteExpired := range []bool{false, true} {
+		var opts []Option
+		if deleteExpired {
+			opts = append(opts, WithDeleteExpiredOnRead())
+		}
+		s := NewJSONStore(db, []byte("lazy_ttl"), opts...)
+
+		s.PutTTL("expired", "value", time.Nanosecond)
+		s.PutTTL("fresh", "value", time.Hour)
+		time.Sleep(time.Millisecond)
+
+		var v string
+		if err := s.Get("expired", &v); err != ErrNotFound {
+			t.Errorf("expected expired object to be not found: %v", err)
+		}
+		if has, _ := s.Has("expired"); has {
+			t.Errorf("expected Has to ignore expired object")
+		}
+		if keys, _ := s.Keys(); len(keys) != 1 || string(keys[0]) != "fresh" {
+			t.Errorf("expected only fresh key: %q", keys)
+		}
+		s.ForEach(func(key string, v string) {
+			if key != "fresh" {
+				t.Errorf("unexpected key in ForEach: %s", key)
+			}
+		})
+		if err := s.Pull("expired", &v); err != ErrNotFound {
+			t.Errorf("expected expired object to be not found: %v", err)
+		}
+
+		n, _ := s.Sweep()
+		if deleteExpired && n != 0 {
+			t.Errorf("expected reads to delete expired objects")
+		} else if !deleteExpired && n != 1 {
+			t.Errorf("expected reads to leave expired objects for Sweep")
+		}
+		s.DeleteAll()
+	}
+}
+
+func TestTTLJitter(t *testing.T) {
+	s := NewJSONStore(db, []byte("ttl-jitter"), WithTTLJitter(time.Hour))
+	defer s.DeleteAll()
+
+	for _, key := range []string{"a", "b", "c", "d"} {
+		s.PutTTL(key, "v", time.Minute)
+	}
+
+	expires := map[string]bool{}
+	s.db.View(func(tx BackendTx) error {
+		return s.bucket.meta().child(ttlKeysBucket).get(tx).ForEach(func(k, v []byte) error {
+			at := decodeTime(v[:timeLength])
+			if d := time.Until(at); d < 59*time.Second || d > time.Hour+time.Minute {
+				t.Errorf("expiration of %s out of range: %v", k, d)
+			}
+			expires[string(v[:timeLength])] = true
+			return nil
+		})
+	})
+	if len(expires) < 2 {
+		t.Errorf("expected jitter to spread expiration times")
+	}
+	if ttl := ttlOf(s, "a"); ttl != time.Minute {
+		t.Errorf("expected the ttl itself to be kept, got %v", ttl)
+	}
+}
+
+func TestSweepBatchSize(t *testing.T) {
+	s := NewJSONStore(db, []byte("ttl-batch"), WithSweepBatchSize(3))
+	defer s.DeleteAll()
+
+	for i := 0; i < 10; i++ {
+		s.PutTTL(i, i, time.Millisecond)
+	}
+	s.Put("fresh", 0)
+	time.Sleep(5 * time.Millisecond)
+
+	if n, err := s.Sweep(); n != 10 || err != nil {
+		t.Errorf("expected 10 expired objects to be removed, got %d %v", n, err)
+	}
+	if keys, _ := s.Keys(); len(keys) != 1 {
+		t.Errorf("expected only the fresh object to be left: %q", keys)
+	}
+}
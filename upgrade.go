@@ -0,0 +1,136 @@
+package stow
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// ErrUpgradeMismatch indicates the file written by UpgradeFile didn't match the original.
+var ErrUpgradeMismatch = errors.New("upgraded file does not match original")
+
+// UpgradeFile rewrites the bolt database file at path with the bbolt version stow is built
+// with, so that files created by older bolt versions (or with other freelist settings) are
+// converted to the current on-disk format. opts are used to open the new file, for example
+// to choose its FreelistType, and may be nil. The rewritten file is checked for consistency
+// and compared against the original before it atomically replaces it, if anything fails the
+// original file is left untouched. The database must not be open while it is upgraded.
+func UpgradeFile(path string, opts *bolt.Options) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	src, err := bolt.Open(path, info.Mode(), &bolt.Options{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+
+	tmpPath := path + ".upgrade"
+	if err := os.Remove(tmpPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	dst, err := bolt.Open(tmpPath, info.Mode(), opts)
+	if err != nil {
+		src.Close()
+		return err
+	}
+	defer os.Remove(tmpPath)
+
+	err = upgradeCopy(src, dst)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := src.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// upgradeCopy copies src into dst, and then validates dst.
+func upgradeCopy(src, dst *bolt.DB) error {
+	err := src.View(func(srcTx *bolt.Tx) error {
+		return dst.Update(func(dstTx *bolt.Tx) error {
+			return srcTx.ForEach(func(name []byte, b *bolt.Bucket) error {
+				copied, err := dstTx.CreateBucket(name)
+				if err != nil {
+					return err
+				}
+				return copyBucket(boltBucket{copied}, boltBucket{b})
+			})
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	err = dst.View(func(tx *bolt.Tx) (err error) {
+		for checkErr := range tx.Check() {
+			if err == nil {
+				err = checkErr
+			}
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	srcDigest, err := digestDB(src)
+	if err != nil {
+		return err
+	}
+	dstDigest, err := digestDB(dst)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(srcDigest, dstDigest) {
+		return ErrUpgradeMismatch
+	}
+	return nil
+}
+
+// digestDB returns a hash of every bucket, key, value and sequence in db.
+func digestDB(db *bolt.DB) ([]byte, error) {
+	h := sha256.New()
+	w := &snapshotWriter{w: bufio.NewWriter(h), crc: crc32.NewIEEE()}
+	err := db.View(func(tx *bolt.Tx) error {
+		tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			w.bytes(name)
+			w.tree(boltBucket{b})
+			return w.err
+		})
+		return w.err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := w.w.Flush(); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	// Not all platforms support syncing a directory, the rename itself already succeeded.
+	d.Sync()
+	return nil
+}
@@ -0,0 +1,47 @@
+package stow
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestUpgradeFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "old.db")
+	old, err := bolt.Open(path, 0600, &bolt.Options{FreelistType: bolt.FreelistArrayType})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewJSONStore(old, []byte("people"))
+	s.Put("a", "1")
+	s.NewNestedStore([]byte("nested")).Put("b", "2")
+	old.Close()
+
+	if err := UpgradeFile(path, &bolt.Options{FreelistType: bolt.FreelistMapType}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path + ".upgrade"); !os.IsNotExist(err) {
+		t.Errorf("temporary upgrade file was left behind")
+	}
+
+	upgraded, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer upgraded.Close()
+
+	s = NewJSONStore(upgraded, []byte("people"))
+	var v string
+	if err := s.Get("a", &v); err != nil || v != "1" {
+		t.Errorf("unexpected value %s %v", v, err)
+	}
+	if err := s.NewNestedStore([]byte("nested")).Get("b", &v); err != nil || v != "2" {
+		t.Errorf("unexpected nested value %s %v", v, err)
+	}
+
+	if err := UpgradeFile(filepath.Join(t.TempDir(), "missing.db"), nil); !os.IsNotExist(err) {
+		t.Errorf("expected missing file error got %v", err)
+	}
+}
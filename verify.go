@@ -0,0 +1,179 @@
+package stow
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// VerifyOptions configures Verify.
+type VerifyOptions struct {
+	// Codec decodes the values, GobCodec if nil.
+	Codec Codec
+	// Options are the Options of the stores, like WithChecksums, which change how values
+	// are stored.
+	Options []Option
+	// Models maps bucket paths (nested buckets separated by /) to a value of the type the
+	// bucket's objects are decoded into. Values of other buckets are decoded into an
+	// interface{} or an empty struct, which checks their encoding but not their fields.
+	Models map[string]interface{}
+	// Buckets limits Verify to these bucket paths and the buckets nested in them, if set.
+	// Leave out buckets of values which don't use the Codec, like counters.
+	Buckets []string
+	// Quarantine moves the objects which can't be read to the quarantine of their store,
+	// see WithQuarantine, once the walk is done.
+	Quarantine bool
+}
+
+// VerifyReport reports what Verify found.
+type VerifyReport struct {
+	// Buckets and Objects are the numbers of buckets and objects checked.
+	Buckets, Objects int
+	// Problems holds the objects which couldn't be read, in the order they were found.
+	Problems []VerifyProblem
+}
+
+// OK reports whether every object could be read.
+func (r *VerifyReport) OK() bool { return len(r.Problems) == 0 }
+
+// VerifyProblem is an object Verify couldn't read.
+type VerifyProblem struct {
+	// Bucket is the path of the bucket, nested buckets separated by /.
+	Bucket string
+	Key    []byte
+	// Err is a *CorruptError for a checksum mismatch, or the error of the Codec.
+	Err error
+
+	store *Store
+	raw   []byte
+}
+
+// Verify checks that every object in every bucket of db can be read: it walks the buckets
+// (nested stores and hashes included, except stow's own), verifies checksums and decodes
+// each value, and reports the objects which fail. It reads the database in one read-only
+// transaction, and returns an error only if the walk itself fails. Use it after changing
+// codecs or schemas to find out how much of the data is still readable.
+func Verify(db *bolt.DB, opts VerifyOptions) (*VerifyReport, error) {
+	codec := opts.Codec
+	if codec == nil {
+		codec = GobCodec{}
+	}
+	report := &VerifyReport{}
+	err := boltBackend{db}.View(func(tx BackendTx) error {
+		return tx.ForEach(func(name []byte, _ BackendBucket) error {
+			if bytes.Equal(name, metaBucketName) {
+				return nil
+			}
+			s := NewCustomStore(db, name, codec, opts.Options...)
+			return verifyBucket(tx, s, string(name), opts, report)
+		})
+	})
+	if err != nil || !opts.Quarantine {
+		return report, err
+	}
+	for _, p := range report.Problems {
+		if !quarantinable(p.Err) {
+			continue
+		}
+		if err := p.store.quarantine([]QuarantinedEntry{newQuarantinedEntry(p.Key, p.raw, p.Err)}); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// verifyBucket checks the objects of s, the store of the bucket at path, and its nested buckets.
+func verifyBucket(tx BackendTx, s *Store, path string, opts VerifyOptions, report *VerifyReport) error {
+	if !verifiedBucket(path, opts.Buckets) {
+		return nil
+	}
+	b := s.bucket.get(tx)
+	checked := len(opts.Buckets) == 0 || coveredBucket(path, opts.Buckets)
+	if checked {
+		report.Buckets++
+	}
+
+	var typ reflect.Type
+	if m, ok := opts.Models[path]; ok {
+		if typ = reflect.TypeOf(m); typ.Kind() == reflect.Ptr {
+			typ = typ.Elem()
+		}
+	}
+
+	var nested [][]byte
+	err := b.ForEach(func(k, v []byte) error {
+		if v == nil {
+			nested = append(nested, k)
+			return nil
+		}
+		if !checked {
+			return nil
+		}
+		report.Objects++
+		if err := s.verifyValue(k, v, typ); err != nil {
+			report.Problems = append(report.Problems, VerifyProblem{
+				Bucket: path,
+				Key:    append([]byte(nil), k...),
+				Err:    err,
+				store:  s,
+				raw:    append([]byte(nil), v...),
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, name := range nested {
+		child := s.NewNestedStore(name)
+		if err := verifyBucket(tx, child, path+"/"+string(name), opts, report); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// verifyValue decodes data, the value of key, into a new value of typ, or without one into
+// an interface{} or an empty struct.
+func (s *Store) verifyValue(key, data []byte, typ reflect.Type) error {
+	if typ != nil {
+		return s.unmarshalValue(key, data, reflect.New(typ).Interface())
+	}
+	var v interface{}
+	err := s.unmarshalValue(key, data, &v)
+	if err == nil {
+		return nil
+	}
+	if _, corrupt := err.(*CorruptError); corrupt {
+		return err
+	}
+	if s.unmarshalValue(key, data, &struct{}{}) == nil {
+		return nil
+	}
+	return err
+}
+
+// verifiedBucket reports whether the bucket at path is, holds or is nested in one of buckets.
+func verifiedBucket(path string, buckets []string) bool {
+	if len(buckets) == 0 {
+		return true
+	}
+	for _, b := range buckets {
+		if coveredBucket(path, []string{b}) || strings.HasPrefix(b, path+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// coveredBucket reports whether the bucket at path is or is nested in one of buckets.
+func coveredBucket(path string, buckets []string) bool {
+	for _, b := range buckets {
+		if path == b || strings.HasPrefix(path, b+"/") {
+			return true
+		}
+	}
+	return false
+}
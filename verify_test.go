@@ -0,0 +1,66 @@
+package stow
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestVerify(t *testing.T) {
+	vdb, err := bolt.Open(filepath.Join(t.TempDir(), "verify.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer vdb.Close()
+
+	people := NewJSONStore(vdb, []byte("people"), WithChecksums())
+	people.Put("ann", MyType{FirstName: "Ann"})
+	people.Put("bob", MyType{FirstName: "Bob"})
+	people.NewNestedStore([]byte("friends")).Put("carl", MyType{FirstName: "Carl"})
+	vdb.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("people"))
+		data := append([]byte(nil), b.Get([]byte("bob"))...)
+		data[0] ^= 0xff
+		return b.Put([]byte("bob"), data)
+	})
+	NewJSONStore(vdb, []byte("other")).Put("x", MyType{FirstName: "X"})
+
+	report, err := Verify(vdb, VerifyOptions{
+		Codec:   JSONCodec{},
+		Options: []Option{WithChecksums()},
+		Buckets: []string{"people"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Buckets != 2 || report.Objects != 3 {
+		t.Errorf("unexpected counts %+v", report)
+	}
+	if report.OK() || len(report.Problems) != 1 {
+		t.Fatalf("unexpected problems %+v", report.Problems)
+	}
+	p := report.Problems[0]
+	if p.Bucket != "people" || string(p.Key) != "bob" || !errors.Is(p.Err, ErrCorrupt) {
+		t.Errorf("unexpected problem %+v", p)
+	}
+
+	// Without checksums and with a model of another type, the values don't decode.
+	report, err = Verify(vdb, VerifyOptions{
+		Codec:  JSONCodec{},
+		Models: map[string]interface{}{"other": 0},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var other int
+	for _, p := range report.Problems {
+		if p.Bucket == "other" {
+			other++
+		}
+	}
+	if report.Buckets != 3 || other != 1 {
+		t.Errorf("unexpected report %+v", report)
+	}
+}
@@ -0,0 +1,273 @@
+package stow
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+)
+
+// versionsBucket is the meta bucket which holds the previous versions of the values of a
+// VersionedStore, keyed by the length of their key, the key and a sequence number, so the
+// versions of a key sort oldest first and apart from those of keys it's a prefix of.
+var versionsBucket = []byte("\x00versions")
+
+// VersionedStore is a Store which keeps the last versions of each value: Put and Delete save
+// the value they replace, which can be read back with GetVersion and restored with Rollback.
+// Saving a version happens in the transaction of the write, so none is ever lost.
+//
+// Only writes made through the VersionedStore save versions. Versions are kept after their
+// key is deleted, so a deleted value can be restored, DeleteAll removes them with the values.
+type VersionedStore struct {
+	s    *Store
+	keep int
+}
+
+// Version describes a previous version of a value.
+type Version struct {
+	// N is the version's number for GetVersion and Rollback, 1 for the value replaced last.
+	N int
+	// Replaced is when the version was replaced by a newer value, or deleted.
+	Replaced time.Time
+}
+
+// NewVersionedStore returns a VersionedStore keeping the last keep versions of each value of
+// s, besides the current one. keep <= 0 means 10.
+func (s *Store) NewVersionedStore(keep int) *VersionedStore {
+	if keep <= 0 {
+		keep = 10
+	}
+	return &VersionedStore{s: s, keep: keep}
+}
+
+// Put stores b with key "key", and saves the value it replaces as a version. See Store.Put.
+func (vs *VersionedStore) Put(key interface{}, b interface{}) (err error) {
+	s := vs.s
+	keyBytes, err := s.toBytes(key)
+	if err != nil {
+		return err
+	}
+	if err := s.beforePut(keyBytes, b); err != nil {
+		return err
+	}
+	defer func() { s.afterPut(keyBytes, b, err) }()
+
+	data, err := s.marshalValue(b)
+	if err != nil {
+		return err
+	}
+
+	defer s.forget(keyBytes)
+	return s.write(func(tx BackendTx) error {
+		objects, err := s.bucket.createOrGet(tx)
+		if err != nil {
+			return err
+		}
+		if err := vs.save(tx, objects, keyBytes); err != nil {
+			return err
+		}
+		return s.writeKey(tx, objects, keyBytes, data, b, s.opts.ttl)
+	})
+}
+
+// Delete removes the object with key "key" and saves it as a version. See Store.Delete.
+func (vs *VersionedStore) Delete(key interface{}) error {
+	s := vs.s
+	keyBytes, err := s.toBytes(key)
+	if err != nil {
+		return err
+	}
+	if err := s.beforeDelete(keyBytes); err != nil {
+		return err
+	}
+	err = s.write(func(tx BackendTx) error {
+		objects := s.bucket.get(tx)
+		if objects == nil {
+			return nil
+		}
+		if err := vs.save(tx, objects, keyBytes); err != nil {
+			return err
+		}
+		return s.deleteKey(tx, objects, keyBytes)
+	})
+	s.afterDelete(keyBytes, err)
+	return err
+}
+
+// Get retrieves the current value with key "key" into b, see Store.Get.
+func (vs *VersionedStore) Get(key interface{}, b interface{}) error {
+	return vs.s.Get(key, b)
+}
+
+// GetVersion retrieves version n of the value with key "key" into b: 0 is the current value,
+// 1 the one it replaced and so on. It returns ErrNotFound if there's no such version.
+func (vs *VersionedStore) GetVersion(key interface{}, n int, b interface{}) error {
+	if n == 0 {
+		return vs.Get(key, b)
+	}
+	s := vs.s
+	keyBytes, err := s.toBytes(key)
+	if err != nil {
+		return err
+	}
+
+	buf := bytes.NewBuffer(nil)
+	err = s.db.View(func(tx BackendTx) error {
+		data, ok := vs.version(tx, keyBytes, n)
+		if !ok {
+			return ErrNotFound
+		}
+		if err := s.checkDecodeSize(data); err != nil {
+			return s.keyError(keyBytes, err)
+		}
+		buf.Write(data)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := s.unmarshalValue(keyBytes, buf.Bytes(), b); err != nil {
+		return s.decodeError(keyBytes, buf.Bytes(), err)
+	}
+	return nil
+}
+
+// History returns the versions kept for the value with key "key", newest first. It doesn't
+// include the current value.
+func (vs *VersionedStore) History(key interface{}) (versions []Version, err error) {
+	s := vs.s
+	keyBytes, err := s.toBytes(key)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.db.View(func(tx BackendTx) error {
+		return vs.forEachVersion(tx, keyBytes, func(k, v []byte) error {
+			versions = append(versions, Version{Replaced: decodeTime(v[:timeLength])})
+			return nil
+		})
+	})
+	// The versions are stored oldest first.
+	for i, j := 0, len(versions)-1; i < j; i, j = i+1, j-1 {
+		versions[i], versions[j] = versions[j], versions[i]
+	}
+	for i := range versions {
+		versions[i].N = i + 1
+	}
+	return versions, err
+}
+
+// Rollback restores version n (1 or more) of the value with key "key", and decodes it into b.
+// The value it replaces is saved as a version, so a Rollback can be rolled back too: version
+// 1 is then that value and the others move back by one. It returns ErrNotFound if there's no
+// such version. Rollback doesn't run Hooks.
+func (vs *VersionedStore) Rollback(key interface{}, n int, b interface{}) (err error) {
+	s := vs.s
+	keyBytes, err := s.toBytes(key)
+	if err != nil {
+		return err
+	}
+	if n < 1 {
+		return ErrNotFound
+	}
+
+	defer s.forget(keyBytes)
+	return s.update(func(tx BackendTx) error {
+		objects, err := s.bucket.createOrGet(tx)
+		if err != nil {
+			return err
+		}
+		data, ok := vs.version(tx, keyBytes, n)
+		if !ok {
+			return ErrNotFound
+		}
+		// data is only valid until the versions bucket is changed.
+		data = append([]byte(nil), data...)
+		if err := s.checkDecodeSize(data); err != nil {
+			return s.keyError(keyBytes, err)
+		}
+		if err := s.unmarshalValue(keyBytes, data, b); err != nil {
+			return s.decodeError(keyBytes, data, err)
+		}
+		if err := vs.save(tx, objects, keyBytes); err != nil {
+			return err
+		}
+		return s.writeKey(tx, objects, keyBytes, data, b, s.opts.ttl)
+	})
+}
+
+// save saves the current value of key in objects as its newest version, unless there's none,
+// and drops the oldest versions past vs.keep.
+func (vs *VersionedStore) save(tx BackendTx, objects BackendBucket, key []byte) error {
+	current := objects.Get(key)
+	if current == nil || vs.s.expiryCheck(tx)(key) {
+		return nil
+	}
+
+	versions, err := vs.s.bucket.meta().child(versionsBucket).createOrGetUntracked(tx)
+	if err != nil {
+		return err
+	}
+	seq, err := versions.NextSequence()
+	if err != nil {
+		return err
+	}
+	value := make([]byte, 0, timeLength+len(current))
+	value = append(value, encodeTime(time.Now())...)
+	value = append(value, current...)
+	if err := versions.Put(versionKey(key, seq), value); err != nil {
+		return err
+	}
+
+	var old [][]byte
+	err = vs.forEachVersion(tx, key, func(k, v []byte) error {
+		old = append(old, append([]byte(nil), k...))
+		return nil
+	})
+	if err != nil || len(old) <= vs.keep {
+		return err
+	}
+	for _, k := range old[:len(old)-vs.keep] {
+		if err := versions.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// version returns the encoded value of version n of key.
+func (vs *VersionedStore) version(tx BackendTx, key []byte, n int) (data []byte, ok bool) {
+	var values [][]byte
+	vs.forEachVersion(tx, key, func(k, v []byte) error {
+		values = append(values, v)
+		return nil
+	})
+	if n > len(values) {
+		return nil, false
+	}
+	return values[len(values)-n][timeLength:], true
+}
+
+// forEachVersion calls fn with the versions kept for key, oldest first.
+func (vs *VersionedStore) forEachVersion(tx BackendTx, key []byte, fn func(k, v []byte) error) error {
+	versions := vs.s.bucket.meta().child(versionsBucket).get(tx)
+	if versions == nil {
+		return nil
+	}
+	prefix := versionKey(key, 0)[:4+len(key)]
+	c := versions.Cursor()
+	for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		if err := fn(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// versionKey returns the key of the version of key with sequence number seq.
+func versionKey(key []byte, seq uint64) []byte {
+	k := make([]byte, 4+len(key)+8)
+	binary.BigEndian.PutUint32(k, uint32(len(key)))
+	copy(k[4:], key)
+	binary.BigEndian.PutUint64(k[4+len(key):], seq)
+	return k
+}
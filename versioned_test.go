@@ -0,0 +1,118 @@
+package stow
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestVersionedStore(t *testing.T) {
+	s := NewJSONStore(db, []byte("versioned"))
+	defer s.DeleteAll()
+	vs := s.NewVersionedStore(2)
+
+	for _, name := range []string{"a", "b", "c", "d"} {
+		if err := vs.Put("config", MyType{FirstName: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A key which is a prefix of another keeps its own versions.
+	vs.Put("conf", MyType{FirstName: "x"})
+	vs.Put("conf", MyType{FirstName: "y"})
+
+	history, err := vs.History("config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 || history[0].N != 1 || history[1].N != 2 {
+		t.Fatalf("expected the last 2 versions, got %v", history)
+	}
+	if history[0].Replaced.Before(history[1].Replaced) {
+		t.Errorf("expected the newest version first, got %v", history)
+	}
+
+	for n, want := range []string{"d", "c", "b"} {
+		var v MyType
+		if err := vs.GetVersion("config", n, &v); err != nil || v.FirstName != want {
+			t.Errorf("version %d: expected %q, got %v, %v", n, want, v, err)
+		}
+	}
+	var v MyType
+	if err := vs.GetVersion("config", 3, &v); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound for a dropped version, got %v", err)
+	}
+
+	if err := vs.Rollback("config", 2, &v); err != nil || v.FirstName != "b" {
+		t.Fatalf("expected to roll back to b, got %v, %v", v, err)
+	}
+	if err := vs.Get("config", &v); err != nil || v.FirstName != "b" {
+		t.Errorf("expected b after the rollback, got %v, %v", v, err)
+	}
+	if err := vs.GetVersion("config", 1, &v); err != nil || v.FirstName != "d" {
+		t.Errorf("expected the rolled back value as version 1, got %v, %v", v, err)
+	}
+	if err := vs.Rollback("config", 3, &v); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound rolling back to a missing version, got %v", err)
+	}
+}
+
+func TestVersionedStoreDelete(t *testing.T) {
+	s := NewJSONStore(db, []byte("versioned-delete"), WithChecksums())
+	defer s.DeleteAll()
+	vs := s.NewVersionedStore(0)
+
+	vs.Put("config", MyType{FirstName: "a"})
+	if err := vs.Delete("config"); err != nil {
+		t.Fatal(err)
+	}
+	var v MyType
+	if err := vs.Get("config", &v); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound after Delete, got %v", err)
+	}
+	if err := vs.Rollback("config", 1, &v); err != nil || v.FirstName != "a" {
+		t.Fatalf("expected to restore the deleted value, got %v, %v", v, err)
+	}
+	if history, _ := vs.History("config"); len(history) != 1 {
+		t.Errorf("expected the deleted value to stay a version, got %v", history)
+	}
+}
+
+func TestVersionedStoreReadOnly(t *testing.T) {
+	s := NewJSONStore(db, []byte("versioned-read-only"))
+	defer s.DeleteAll()
+	s.NewVersionedStore(0).Put("config", MyType{FirstName: "a"})
+	s.NewVersionedStore(0).Put("config", MyType{FirstName: "b"})
+
+	vs := NewJSONStore(db, []byte("versioned-read-only"), WithReadOnly()).NewVersionedStore(0)
+	var v MyType
+	if err := vs.Put("config", MyType{FirstName: "c"}); err != ErrReadOnly {
+		t.Errorf("expected ErrReadOnly from Put, got %v", err)
+	}
+	if err := vs.Delete("config"); err != ErrReadOnly {
+		t.Errorf("expected ErrReadOnly from Delete, got %v", err)
+	}
+	if err := vs.Rollback("config", 1, &v); err != ErrReadOnly {
+		t.Errorf("expected ErrReadOnly from Rollback, got %v", err)
+	}
+	if err := vs.GetVersion("config", 1, &v); err != nil || v.FirstName != "a" {
+		t.Errorf("expected reads to work, got %v %v", v, err)
+	}
+}
+
+func TestVersionedStoreTooLarge(t *testing.T) {
+	s := NewJSONStore(db, []byte("versioned-too-large"))
+	defer s.DeleteAll()
+	vs := s.NewVersionedStore(0)
+	vs.Put("config", strings.Repeat("x", 64))
+	vs.Put("config", "small")
+
+	limited := NewJSONStore(db, []byte("versioned-too-large"), WithMaxDecodeSize(32)).NewVersionedStore(0)
+	var v string
+	var keyErr *KeyError
+	if err := limited.GetVersion("config", 1, &v); !errors.Is(err, ErrTooLarge) || !errors.As(err, &keyErr) || string(keyErr.Key) != "config" {
+		t.Errorf("expected ErrTooLarge for config from GetVersion, got %v", err)
+	}
+	if err := limited.Rollback("config", 1, &v); !errors.Is(err, ErrTooLarge) || !errors.As(err, &keyErr) || string(keyErr.Key) != "config" {
+		t.Errorf("expected ErrTooLarge for config from Rollback, got %v", err)
+	}
+}
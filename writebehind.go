@@ -0,0 +1,242 @@
+package stow
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+	"time"
+)
+
+// WriteBehind is a write-behind cache in front of a Store: writes are applied in memory right
+// away, so Get sees them, and written to the Store by a flush every Interval. Writes of the
+// same key between flushes are coalesced, only the last one is written, which suits values
+// updated far more often than they need to be persisted, like counters.
+//
+// Each flush is one transaction, so a crash loses the writes since the last flush, but never
+// part of one. Flush and Close bound that window where it matters. Logging each write to bolt
+// to recover it would cost the commit per write a write-behind cache avoids.
+//
+// Writes run the Store's Hooks, the After hooks (and metrics) only for the writes which are
+// flushed. Reads of the Store itself see writes once they're flushed.
+type WriteBehind struct {
+	s      *Store
+	policy AsyncPolicy
+	full   chan struct{}
+	stop   chan struct{}
+	done   chan struct{}
+
+	mu     sync.Mutex
+	closed bool
+	// dirty holds the writes since the last flush, flushing those of the flush in progress.
+	dirty, flushing map[string]asyncWrite
+
+	// flushMu serializes flushes, and guards err.
+	flushMu sync.Mutex
+	err     error
+}
+
+// NewWriteBehind starts a WriteBehind flushing to s. policy works like for an AsyncWriter,
+// except that writes never block: MaxPending is the number of keys written since the last
+// flush which starts the next one early. It must be closed to flush the last writes and stop
+// its goroutine.
+func (s *Store) NewWriteBehind(policy AsyncPolicy) *WriteBehind {
+	if policy.Interval <= 0 {
+		policy.Interval = time.Second
+	}
+	if policy.MaxPending <= 0 {
+		policy.MaxPending = 1000
+	}
+	wb := &WriteBehind{
+		s:      s,
+		policy: policy,
+		full:   make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+		dirty:  make(map[string]asyncWrite),
+	}
+	go wb.run()
+	return wb
+}
+
+// Put stores b with key "key" in memory, to be flushed to the Store, see Store.Put.
+func (wb *WriteBehind) Put(key interface{}, b interface{}) error {
+	keyBytes, err := wb.s.toBytes(key)
+	if err != nil {
+		return err
+	}
+	if err := wb.s.beforePut(keyBytes, b); err != nil {
+		return err
+	}
+	write := asyncWrite{key: keyBytes, val: b, start: time.Now()}
+	write.data, err = wb.s.marshalValue(b)
+	if err == nil {
+		write.entries, err = wb.s.indexEntries(b)
+	}
+	if err == nil {
+		err = wb.set(write)
+	}
+	if err != nil {
+		wb.s.afterPut(keyBytes, b, err)
+		wb.s.observe(OpPut, write.start, len(write.data), err)
+	}
+	return err
+}
+
+// Delete removes the object with key "key" in memory, to be flushed to the Store, see
+// Store.Delete.
+func (wb *WriteBehind) Delete(key interface{}) error {
+	keyBytes, err := wb.s.toBytes(key)
+	if err != nil {
+		return err
+	}
+	if err := wb.s.beforeDelete(keyBytes); err != nil {
+		return err
+	}
+	write := asyncWrite{key: keyBytes, delete: true, start: time.Now()}
+	if err := wb.set(write); err != nil {
+		wb.s.afterDelete(keyBytes, err)
+		wb.s.observe(OpDelete, write.start, 0, err)
+		return err
+	}
+	return nil
+}
+
+// Get retrieves b with key "key", as it was last written to wb, or from the Store if it
+// wasn't written since the last flush. See Store.Get.
+func (wb *WriteBehind) Get(key interface{}, b interface{}) (err error) {
+	keyBytes, err := wb.s.toBytes(key)
+	if err != nil {
+		return err
+	}
+
+	wb.mu.Lock()
+	write, ok := wb.dirty[string(keyBytes)]
+	if !ok {
+		write, ok = wb.flushing[string(keyBytes)]
+	}
+	wb.mu.Unlock()
+	if !ok {
+		return wb.s.get(keyBytes, b)
+	}
+
+	start := time.Now()
+	defer func() {
+		wb.s.afterGet(keyBytes, b, err)
+		wb.s.observe(OpGet, start, len(write.data), err)
+	}()
+	if write.delete {
+		return ErrNotFound
+	}
+	if err := wb.s.unmarshalValue(keyBytes, write.data, b); err != nil {
+		return wb.s.decodeError(keyBytes, write.data, err)
+	}
+	return nil
+}
+
+// Flush writes the writes made so far to the Store, and returns the first error of the
+// writes which failed since the last Flush.
+func (wb *WriteBehind) Flush() error {
+	wb.flushMu.Lock()
+	defer wb.flushMu.Unlock()
+	wb.flush()
+	return wb.takeErr()
+}
+
+// Close flushes the writes made so far and stops wb, it returns the first error of the
+// writes which failed since the last Flush. Later writes fail with ErrWriterClosed.
+func (wb *WriteBehind) Close() error {
+	wb.mu.Lock()
+	if wb.closed {
+		wb.mu.Unlock()
+		<-wb.done
+		return nil
+	}
+	wb.closed = true
+	close(wb.stop)
+	wb.mu.Unlock()
+
+	<-wb.done
+	wb.flushMu.Lock()
+	defer wb.flushMu.Unlock()
+	return wb.takeErr()
+}
+
+// set records write as the last write of its key, unless wb is closed.
+func (wb *WriteBehind) set(write asyncWrite) error {
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+	if wb.closed {
+		return ErrWriterClosed
+	}
+	wb.dirty[string(write.key)] = write
+	if len(wb.dirty) >= wb.policy.MaxPending {
+		select {
+		case wb.full <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+func (wb *WriteBehind) run() {
+	defer close(wb.done)
+	ticker := time.NewTicker(wb.policy.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-wb.full:
+		case <-wb.stop:
+			wb.flushMu.Lock()
+			wb.flush()
+			wb.flushMu.Unlock()
+			return
+		}
+		wb.flushMu.Lock()
+		wb.flush()
+		wb.flushMu.Unlock()
+	}
+}
+
+// flush writes the dirty writes to the Store in one transaction. wb.flushMu must be held.
+func (wb *WriteBehind) flush() {
+	wb.mu.Lock()
+	if len(wb.dirty) == 0 {
+		wb.mu.Unlock()
+		return
+	}
+	wb.flushing, wb.dirty = wb.dirty, make(map[string]asyncWrite)
+	writes := make([]asyncWrite, 0, len(wb.flushing))
+	for _, write := range wb.flushing {
+		writes = append(writes, write)
+	}
+	wb.mu.Unlock()
+
+	// bolt writes keys in order faster.
+	sort.Slice(writes, func(i, j int) bool {
+		return bytes.Compare(writes[i].key, writes[j].key) < 0
+	})
+	wb.s.commitWrites(writes, wb.fail)
+
+	wb.mu.Lock()
+	wb.flushing = nil
+	wb.mu.Unlock()
+}
+
+// fail records err, the error of the write of key. wb.flushMu must be held.
+func (wb *WriteBehind) fail(key []byte, err error) {
+	if wb.err == nil {
+		wb.err = err
+	}
+	if wb.policy.OnError != nil {
+		wb.policy.OnError(key, err)
+	}
+}
+
+// takeErr returns the error recorded since it was last called. wb.flushMu must be held.
+func (wb *WriteBehind) takeErr() error {
+	err := wb.err
+	wb.err = nil
+	return err
+}
@@ -0,0 +1,87 @@
+package stow
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWriteBehind(t *testing.T) {
+	puts := 0
+	s := NewJSONStore(db, []byte("write_behind"), WithHooks(Hooks{
+		AfterPut: func(key []byte, val interface{}, err error) { puts++ },
+	}))
+	defer s.DeleteAll()
+	wb := s.NewWriteBehind(AsyncPolicy{Interval: time.Hour})
+
+	for i := 1; i <= 1000; i++ {
+		if err := wb.Put("counter", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var v int
+	if err := wb.Get("counter", &v); err != nil || v != 1000 {
+		t.Errorf("expected Get to see the last write, got %d %v", v, err)
+	}
+	if has, _ := s.Has("counter"); has {
+		t.Errorf("expected writes to wait for a flush")
+	}
+	if err := wb.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Get("counter", &v); err != nil || v != 1000 {
+		t.Errorf("expected the last write to be flushed, got %d %v", v, err)
+	}
+	if puts != 1 {
+		t.Errorf("expected the writes of a key to be coalesced, got %d puts", puts)
+	}
+
+	wb.Delete("counter")
+	if err := wb.Get("counter", &v); err != ErrNotFound {
+		t.Errorf("expected Get to see the delete, got %v", err)
+	}
+	if err := wb.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if has, _ := s.Has("counter"); has {
+		t.Errorf("expected Close to flush the delete")
+	}
+	if err := wb.Put("counter", 1); err != ErrWriterClosed {
+		t.Errorf("expected ErrWriterClosed, got %v", err)
+	}
+}
+
+func TestWriteBehindMaxPending(t *testing.T) {
+	s := NewJSONStore(db, []byte("write_behind_max_pending"))
+	defer s.DeleteAll()
+	wb := s.NewWriteBehind(AsyncPolicy{Interval: time.Hour, MaxPending: 10})
+	defer wb.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				wb.Put(fmt.Sprint(i, j), j)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		if keys, _ := s.Keys(); len(keys) >= 10 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected MaxPending keys to start a flush")
+		}
+	}
+	for i := 0; i < 4; i++ {
+		var v int
+		if err := wb.Get(fmt.Sprint(i, 4), &v); err != nil || v != 4 {
+			t.Errorf("unexpected value %d %v", v, err)
+		}
+	}
+}